@@ -0,0 +1,558 @@
+// Cisco style access control lists matched with wildcard masks
+// Andrew Alston
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ACLAction is what a rule does with a packet it matches
+type ACLAction int
+
+const (
+	Deny ACLAction = iota
+	Permit
+)
+
+// String returns the action as it is written in a rule
+func (a ACLAction) String() string {
+	if a == Permit {
+		return "permit"
+	}
+	return "deny"
+}
+
+// anyProto is the protocol number we use for ip, which matches every protocol
+const anyProto = -1
+
+// protocols maps the protocol names ACLs use to their IP protocol numbers
+var protocols = map[string]int{"ip": anyProto, "icmp": 1, "igmp": 2, "tcp": 6, "udp": 17, "gre": 47, "esp": 50,
+	"ahp": 51, "ospf": 89, "pim": 103}
+
+// ports maps the port names ACLs use to their numbers
+var ports = map[string]uint16{"ftp-data": 20, "ftp": 21, "ssh": 22, "telnet": 23, "smtp": 25, "domain": 53,
+	"tftp": 69, "www": 80, "http": 80, "pop3": 110, "ntp": 123, "snmp": 161, "bgp": 179, "https": 443,
+	"syslog": 514}
+
+// WildcardAddr is an IPv4 address and wildcard mask, where set bits in the wildcard are bits we don't
+// care about.  any is a wildcard of 255.255.255.255 and host is a wildcard of 0.0.0.0
+type WildcardAddr struct {
+	Addr, Wildcard uint32
+}
+
+// Matches returns true if addr matches in every bit the wildcard doesn't ignore
+func (w WildcardAddr) Matches(addr uint32) bool {
+	return WildcardContains(w.Addr, w.Wildcard, addr)
+}
+
+// Covers returns true if every address matched by o is also matched by w.  That is the case when every
+// bit w cares about is also a bit o cares about, and the two agree on the value of those bits
+func (w WildcardAddr) Covers(o WildcardAddr) bool {
+	return ^w.Wildcard&o.Wildcard == 0 && WildcardContains(w.Addr, w.Wildcard, o.Addr)
+}
+
+// String returns the address as written in a rule
+func (w WildcardAddr) String() string {
+	switch w.Wildcard {
+	case 0xFFFFFFFF:
+		return "any"
+	case 0:
+		return "host " + ipv4String(w.Addr)
+	}
+	return ipv4String(w.Addr) + " " + ipv4String(w.Wildcard)
+}
+
+// ipv4String returns a 32 bit number in dotted form
+func ipv4String(addr uint32) string {
+	return Uint128ToAddr(Uint128{Lo: uint64(addr)}, false).String()
+}
+
+// ipv4Binary returns a 32 bit number in binary split in to octets
+func ipv4Binary(addr uint32) string {
+	return Prefix{Addr: Uint128{Lo: uint64(addr)}, Bits: 32}.FormatBinary(Uint128{Lo: uint64(addr)})
+}
+
+// PortRange is an inclusive range of port numbers
+type PortRange struct {
+	Lo, Hi uint16
+}
+
+// PortMatch is the set of ports a rule matches, as sorted non overlapping ranges.  A nil PortMatch
+// matches any port
+type PortMatch []PortRange
+
+// Matches returns true if port is in one of the ranges
+func (pm PortMatch) Matches(port uint16) bool {
+	if pm == nil {
+		return true
+	}
+	for _, r := range pm {
+		if port >= r.Lo && port <= r.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers returns true if every port matched by o is also matched by pm.  The ranges never touch, so
+// each range of o has to fit inside a single range of pm
+func (pm PortMatch) Covers(o PortMatch) bool {
+	if pm == nil {
+		return true
+	}
+	if o == nil {
+		o = PortMatch{{0, 65535}}
+	}
+	for _, r := range o {
+		covered := false
+		for _, c := range pm {
+			if r.Lo >= c.Lo && r.Hi <= c.Hi {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// ACLRule is a single permit or deny line.  Proto is the IP protocol number, or anyProto for ip
+type ACLRule struct {
+	Line     int
+	Text     string
+	Action   ACLAction
+	Proto    int
+	Src, Dst WildcardAddr
+	SrcPorts PortMatch
+	DstPorts PortMatch
+}
+
+// ACL is an ordered list of rules.  A packet is handled by the first rule it matches, and a packet
+// matching no rule is denied
+type ACL struct {
+	Rules []ACLRule
+}
+
+// FiveTuple is the part of a packet ACL rules look at, the protocol, addresses and ports
+type FiveTuple struct {
+	Proto            uint8
+	Src, Dst         uint32
+	SrcPort, DstPort uint16
+}
+
+// hasPorts returns true for the protocols that carry port numbers
+func hasPorts(proto int) bool {
+	return proto == 6 || proto == 17
+}
+
+// String returns the flow in the form ParseFiveTuple reads
+func (f FiveTuple) String() string {
+	name := strconv.Itoa(int(f.Proto))
+	for n, p := range protocols {
+		if p == int(f.Proto) {
+			name = n
+		}
+	}
+	if hasPorts(int(f.Proto)) {
+		return fmt.Sprintf("%s %s %d %s %d", name, ipv4String(f.Src), f.SrcPort, ipv4String(f.Dst), f.DstPort)
+	}
+	return fmt.Sprintf("%s %s %s", name, ipv4String(f.Src), ipv4String(f.Dst))
+}
+
+// parseIPv4 parses a dotted IPv4 address in to a 32 bit number
+func parseIPv4(s string) (uint32, error) {
+	a, err := netip.ParseAddr(s)
+	if err != nil || !a.Is4() {
+		return 0, fmt.Errorf("invalid IPv4 address %q", s)
+	}
+	return uint32(AddrToUint128(a).Lo), nil
+}
+
+// parseProto parses a protocol name or number
+func parseProto(s string) (int, error) {
+	if p, ok := protocols[s]; ok {
+		return p, nil
+	}
+	p, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown protocol %q", s)
+	}
+	return int(p), nil
+}
+
+// parsePort parses a port name or number
+func parsePort(s string) (uint16, error) {
+	if p, ok := ports[s]; ok {
+		return p, nil
+	}
+	p, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown port %q", s)
+	}
+	return uint16(p), nil
+}
+
+// ParseFiveTuple parses a flow written as "tcp 10.1.2.3 1234 192.0.2.1 80", with the ports left out
+// for protocols that don't have them, such as "icmp 10.1.2.3 192.0.2.1"
+func ParseFiveTuple(s string) (FiveTuple, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return FiveTuple{}, fmt.Errorf("empty flow")
+	}
+	proto, err := parseProto(fields[0])
+	if err != nil || proto == anyProto {
+		return FiveTuple{}, fmt.Errorf("invalid flow %q, it needs a protocol such as tcp, udp or icmp", s)
+	}
+	want := 3
+	if hasPorts(proto) {
+		want = 5
+	}
+	if len(fields) != want {
+		return FiveTuple{}, fmt.Errorf("invalid flow %q, expected <proto> <src> [<port>] <dst> [<port>]", s)
+	}
+	f := FiveTuple{Proto: uint8(proto)}
+	addrs := []string{fields[1], fields[2]}
+	if hasPorts(proto) {
+		addrs[1] = fields[3]
+		if f.SrcPort, err = parsePort(fields[2]); err != nil {
+			return FiveTuple{}, err
+		}
+		if f.DstPort, err = parsePort(fields[4]); err != nil {
+			return FiveTuple{}, err
+		}
+	}
+	if f.Src, err = parseIPv4(addrs[0]); err != nil {
+		return FiveTuple{}, err
+	}
+	if f.Dst, err = parseIPv4(addrs[1]); err != nil {
+		return FiveTuple{}, err
+	}
+	return f, nil
+}
+
+// ruleParser walks through the words of a rule
+type ruleParser struct {
+	fields []string
+	pos    int
+}
+
+// next returns the next word, or an empty string at the end of the rule
+func (p *ruleParser) next() string {
+	if p.pos >= len(p.fields) {
+		return ""
+	}
+	p.pos++
+	return p.fields[p.pos-1]
+}
+
+// peek returns the next word without moving past it
+func (p *ruleParser) peek() string {
+	if p.pos >= len(p.fields) {
+		return ""
+	}
+	return p.fields[p.pos]
+}
+
+// addr parses any, host A.B.C.D, A.B.C.D/len or A.B.C.D W.W.W.W
+func (p *ruleParser) addr() (WildcardAddr, error) {
+	word := p.next()
+	switch {
+	case word == "any":
+		return WildcardAddr{Wildcard: 0xFFFFFFFF}, nil
+	case word == "host":
+		addr, err := parseIPv4(p.next())
+		return WildcardAddr{Addr: addr}, err
+	case strings.Contains(word, "/"):
+		prefix, err := ParsePrefix(word)
+		if err != nil || prefix.IPv6 {
+			return WildcardAddr{}, fmt.Errorf("invalid IPv4 prefix %q", word)
+		}
+		return WildcardAddr{Addr: uint32(prefix.Network().Lo), Wildcard: uint32(prefix.HostMask().Lo)}, nil
+	}
+	addr, err := parseIPv4(word)
+	if err != nil {
+		return WildcardAddr{}, err
+	}
+	wildcard, err := parseIPv4(p.next())
+	if err != nil {
+		return WildcardAddr{}, fmt.Errorf("invalid wildcard mask after %s: %v", word, err)
+	}
+	// Bits we don't care about are cleared from the address so equal rules compare equal
+	return WildcardAddr{Addr: addr &^ wildcard, Wildcard: wildcard}, nil
+}
+
+// ports parses an optional eq, neq, lt, gt or range port match
+func (p *ruleParser) ports() (PortMatch, error) {
+	op := p.peek()
+	if op != "eq" && op != "neq" && op != "lt" && op != "gt" && op != "range" {
+		return nil, nil
+	}
+	p.next()
+	n, err := parsePort(p.next())
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "eq":
+		return PortMatch{{n, n}}, nil
+	case "neq":
+		var pm PortMatch
+		if n > 0 {
+			pm = append(pm, PortRange{0, n - 1})
+		}
+		if n < 65535 {
+			pm = append(pm, PortRange{n + 1, 65535})
+		}
+		return pm, nil
+	case "lt":
+		if n == 0 {
+			return nil, fmt.Errorf("lt 0 matches no ports")
+		}
+		return PortMatch{{0, n - 1}}, nil
+	case "gt":
+		if n == 65535 {
+			return nil, fmt.Errorf("gt 65535 matches no ports")
+		}
+		return PortMatch{{n + 1, 65535}}, nil
+	}
+	hi, err := parsePort(p.next())
+	if err != nil {
+		return nil, err
+	}
+	if hi < n {
+		return nil, fmt.Errorf("port range %d %d is backwards", n, hi)
+	}
+	return PortMatch{{n, hi}}, nil
+}
+
+// ParseACLRule parses a rule such as "permit tcp 10.0.0.0 0.255.0.255 any eq 443".  The rule may start
+// with "access-list <name>" or a sequence number, and a trailing log keyword is ignored
+func ParseACLRule(text string) (ACLRule, error) {
+	p := &ruleParser{fields: strings.Fields(text)}
+	if p.peek() == "access-list" {
+		p.pos += 2
+	}
+	if _, err := strconv.Atoi(p.peek()); err == nil {
+		p.next()
+	}
+	rule := ACLRule{Text: strings.TrimSpace(text)}
+	switch action := p.next(); action {
+	case "permit":
+		rule.Action = Permit
+	case "deny":
+		rule.Action = Deny
+	default:
+		return ACLRule{}, fmt.Errorf("expected permit or deny, got %q", action)
+	}
+	var err error
+	if rule.Proto, err = parseProto(p.next()); err != nil {
+		return ACLRule{}, err
+	}
+	if rule.Src, err = p.addr(); err != nil {
+		return ACLRule{}, err
+	}
+	if hasPorts(rule.Proto) {
+		if rule.SrcPorts, err = p.ports(); err != nil {
+			return ACLRule{}, err
+		}
+	}
+	if rule.Dst, err = p.addr(); err != nil {
+		return ACLRule{}, err
+	}
+	if hasPorts(rule.Proto) {
+		if rule.DstPorts, err = p.ports(); err != nil {
+			return ACLRule{}, err
+		}
+	}
+	if p.peek() == "log" {
+		p.next()
+	}
+	if extra := p.next(); extra != "" {
+		return ACLRule{}, fmt.Errorf("unexpected %q", extra)
+	}
+	return rule, nil
+}
+
+// ParseACL reads rules one per line.  Blank lines, remarks and lines starting with ! are ignored
+func ParseACL(r io.Reader) (*ACL, error) {
+	acl := &ACL{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "!") || strings.Contains(" "+text+" ", " remark ") {
+			continue
+		}
+		rule, err := ParseACLRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rule.Line = line
+		acl.Rules = append(acl.Rules, rule)
+	}
+	return acl, scanner.Err()
+}
+
+// Matches returns true if the rule matches the flow.  Ports are only checked for TCP and UDP rules
+func (r ACLRule) Matches(f FiveTuple) bool {
+	if r.Proto != anyProto && r.Proto != int(f.Proto) {
+		return false
+	}
+	return r.Src.Matches(f.Src) && r.Dst.Matches(f.Dst) && r.SrcPorts.Matches(f.SrcPort) && r.DstPorts.Matches(f.DstPort)
+}
+
+// Covers returns true if every flow the rule o matches is also matched by r
+func (r ACLRule) Covers(o ACLRule) bool {
+	if r.Proto != anyProto && r.Proto != o.Proto {
+		return false
+	}
+	return r.Src.Covers(o.Src) && r.Dst.Covers(o.Dst) && r.SrcPorts.Covers(o.SrcPorts) && r.DstPorts.Covers(o.DstPorts)
+}
+
+// Evaluate returns the index of the first rule matching the flow and its action.  If no rule matches
+// the index is -1 and the action is the implicit deny at the end of every ACL
+func (acl *ACL) Evaluate(f FiveTuple) (int, ACLAction) {
+	for i, r := range acl.Rules {
+		if r.Matches(f) {
+			return i, r.Action
+		}
+	}
+	return -1, Deny
+}
+
+// ACLFinding reports a rule that can never have any effect because an earlier rule matches everything
+// it does.  The rule is shadowed if the earlier rule has the opposite action, which usually means the
+// ACL doesn't do what was intended, and redundant if the action is the same
+type ACLFinding struct {
+	Rule, By int
+	Shadowed bool
+}
+
+// Check returns the rules that are completely covered by an earlier rule.  A rule that is covered by
+// several earlier rules together, but by no single one of them, is not reported
+func (acl *ACL) Check() []ACLFinding {
+	var res []ACLFinding
+	for j, later := range acl.Rules {
+		for i, earlier := range acl.Rules[:j] {
+			if earlier.Covers(later) {
+				res = append(res, ACLFinding{Rule: j, By: i, Shadowed: earlier.Action != later.Action})
+				break
+			}
+		}
+	}
+	return res
+}
+
+// Describe returns the finding as a sentence quoting both rules
+func (acl *ACL) Describe(f ACLFinding) string {
+	kind := "made redundant"
+	if f.Shadowed {
+		kind = "shadowed"
+	}
+	later, earlier := acl.Rules[f.Rule], acl.Rules[f.By]
+	return fmt.Sprintf("line %d %q is %s by line %d %q", later.Line, later.Text, kind, earlier.Line, earlier.Text)
+}
+
+// addrRows returns the rows showing how an address was matched against a rule.  Differ holds the bits
+// that differ from the rule in places the wildcard cares about, so the address matches when it is zero
+func addrRows(name string, addr uint32, w WildcardAddr) []ExplainRow {
+	differ := (addr ^ w.Addr) &^ w.Wildcard
+	return []ExplainRow{
+		{Label: fmt.Sprintf("%-8s", name), Value: ipv4String(addr), Bits: ipv4Binary(addr)},
+		{Label: "Rule    ", Value: ipv4String(w.Addr), Bits: ipv4Binary(w.Addr)},
+		{Label: "Wildcard", Value: ipv4String(w.Wildcard), Bits: ipv4Binary(w.Wildcard)},
+		{Label: "Differ  ", Value: ipv4String(differ), Bits: ipv4Binary(differ)},
+	}
+}
+
+// portRow returns the row showing whether a port was matched by a rule
+func portRow(name string, port uint16, pm PortMatch) ExplainRow {
+	res := "no match"
+	if pm.Matches(port) {
+		res = "match"
+	}
+	return ExplainRow{Label: fmt.Sprintf("%-8s", name), Value: fmt.Sprint(port), Bits: fmt.Sprintf("%016b %s", port, res)}
+}
+
+// ExplainACLRule describes how a flow was matched against a rule, showing the flow's addresses against
+// the rule's address and wildcard mask in binary, along with the bits that stopped them matching
+func ExplainACLRule(r ACLRule, f FiveTuple) Explanation {
+	res := "does not match"
+	if r.Matches(f) {
+		res = "matches"
+	}
+	rows := addrRows("Source", f.Src, r.Src)
+	rows = append(rows, addrRows("Dest", f.Dst, r.Dst)...)
+	if hasPorts(r.Proto) {
+		rows = append(rows, portRow("Src port", f.SrcPort, r.SrcPorts), portRow("Dst port", f.DstPort, r.DstPorts))
+	}
+	if r.Proto != anyProto && r.Proto != int(f.Proto) {
+		rows = append(rows, ExplainRow{Label: "Protocol", Value: fmt.Sprintf("%d != %d", f.Proto, r.Proto)})
+	}
+	return Explanation{
+		Title: fmt.Sprintf("Line %d %q %s %s", r.Line, r.Text, res, f),
+		Width: 15,
+		Rows:  rows,
+	}
+}
+
+// runACL is the acl sub command.  It loads an ACL from a file, reports shadowed and redundant rules,
+// and for each flow given shows the rule that handles it and why that rule matched
+func runACL(args []string) error {
+	fs := flag.NewFlagSet("acl", flag.ContinueOnError)
+	file := fs.String("rules", "", "File holding the ACL, one rule per line")
+	format := fs.String("format", "text", "Output format: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf(`usage: acl -rules <file> ["tcp 10.1.2.3 1234 192.0.2.1 80" ...]`)
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	acl, err := ParseACL(f)
+	if err != nil {
+		return err
+	}
+	for _, finding := range acl.Check() {
+		if err := ex.Note("Warning: " + acl.Describe(finding)); err != nil {
+			return err
+		}
+	}
+	for _, arg := range fs.Args() {
+		flow, err := ParseFiveTuple(arg)
+		if err != nil {
+			return err
+		}
+		i, action := acl.Evaluate(flow)
+		if i < 0 {
+			if err := ex.Note(fmt.Sprintf("%s: %s by the implicit deny, no rule matched", flow, action)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := ex.Note(fmt.Sprintf("%s: %s", flow, action)); err != nil {
+			return err
+		}
+		if err := ex.Explain(ExplainACLRule(acl.Rules[i], flow)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
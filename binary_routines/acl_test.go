@@ -0,0 +1,206 @@
+// ACL test routines
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+const testACL = `! core edge filter
+access-list 101 remark allow the web servers
+10 permit tcp any 192.0.2.0 0.0.0.255 eq www
+20 permit udp 10.0.0.0 0.255.0.255 any range 1000 2000
+30 deny tcp 10.1.0.0 0.0.255.255 host 192.0.2.1 eq 80
+40 permit tcp any 192.0.2.0/24 eq 80 log
+50 deny icmp any any
+60 permit ip 10.0.0.0 0.255.0.255 any
+70 permit udp 10.5.0.0 0.0.255.255 any gt 1500
+`
+
+func mustACL(t *testing.T) *ACL {
+	t.Helper()
+	acl, err := ParseACL(strings.NewReader(testACL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acl
+}
+
+func TestParseACLRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"permit ip 10.0.0.0 0.255.0.255 any", "permit -1 10.0.0.0 0.255.0.255 [] any []", false},
+		{"access-list 1 deny tcp host 10.1.1.1 any neq 22", "deny 6 host 10.1.1.1 [] any [{0 21} {23 65535}]", false},
+		{"5 permit udp 10.0.0.0/8 lt 1024 any", "permit 17 10.0.0.0 0.255.255.255 [{0 1023}] any []", false},
+		{"permit tcp 10.1.2.3 0.0.0.255 any eq https", "permit 6 10.1.2.0 0.0.0.255 [] any [{443 443}]", false},
+		{"permit 47 any any log", "permit 47 any [] any []", false},
+		{"allow ip any any", "", true},
+		{"permit ip any", "", true},
+		{"permit ip 10.0.0.0 any", "", true},
+		{"permit tcp any any eq bogus", "", true},
+		{"permit tcp any any range 20 10", "", true},
+		{"permit tcp any any lt 0", "", true},
+		{"permit ip any any eq 80", "", true},
+		{"permit ip any 2001:db8::/32", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := ParseACLRule(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error state %v", err)
+			}
+			if err != nil {
+				return
+			}
+			got := fmt.Sprintf("%s %d %s %v %s %v", r.Action, r.Proto, r.Src, r.SrcPorts, r.Dst, r.DstPorts)
+			if got != tt.want {
+				t.Errorf("got %s instead of %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestACLEvaluate(t *testing.T) {
+	acl := mustACL(t)
+	tests := []struct {
+		flow   string
+		line   int
+		action ACLAction
+	}{
+		{"tcp 198.51.100.7 40000 192.0.2.1 80", 3, Permit},
+		{"tcp 10.1.4.4 40000 192.0.2.1 80", 3, Permit},
+		{"udp 10.9.0.9 53 192.0.2.1 1500", 4, Permit},
+		{"udp 10.9.1.9 53 192.0.2.1 1500", -1, Deny},
+		{"icmp 10.0.0.1 192.0.2.1", 7, Deny},
+		{"tcp 10.200.0.77 22 8.8.8.8 443", 8, Permit},
+		{"udp 10.5.1.1 53 8.8.8.8 2001", 9, Permit},
+		{"udp 10.5.1.1 53 8.8.8.8 1400", -1, Deny},
+		{"gre 198.51.100.7 192.0.2.1", -1, Deny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flow, func(t *testing.T) {
+			f, err := ParseFiveTuple(tt.flow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.String() != tt.flow {
+				t.Errorf("flow printed as %s", f)
+			}
+			i, action := acl.Evaluate(f)
+			line := -1
+			if i >= 0 {
+				line = acl.Rules[i].Line
+			}
+			if line != tt.line || action != tt.action {
+				t.Errorf("got line %d %s instead of line %d %s", line, action, tt.line, tt.action)
+			}
+		})
+	}
+}
+
+func TestACLCheck(t *testing.T) {
+	acl := mustACL(t)
+	var got []string
+	for _, f := range acl.Check() {
+		got = append(got, acl.Describe(f))
+	}
+	want := []string{
+		`line 5 "30 deny tcp 10.1.0.0 0.0.255.255 host 192.0.2.1 eq 80" is shadowed by line 3 "10 permit tcp any 192.0.2.0 0.0.0.255 eq www"`,
+		`line 6 "40 permit tcp any 192.0.2.0/24 eq 80 log" is made redundant by line 3 "10 permit tcp any 192.0.2.0 0.0.0.255 eq www"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\ninstead of\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestACLCovers checks Covers against Matches, if one rule covers another then every flow the second
+// matches must also be matched by the first
+func TestACLCovers(t *testing.T) {
+	r := rand.New(rand.NewPCG(40, 1))
+	randomRule := func() ACLRule {
+		protos := []string{"ip", "tcp", "udp", "icmp"}
+		portOps := []string{"", "eq 5", "neq 5", "lt 6", "gt 3", "range 2 6"}
+		text := fmt.Sprintf("permit %s 10.0.%d.0 0.0.%d.0", protos[r.IntN(len(protos))], r.IntN(8), r.IntN(8))
+		rule, err := ParseACLRule(text + " any")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasPorts(rule.Proto) {
+			rule, err = ParseACLRule(text + " any " + portOps[r.IntN(len(portOps))])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rule
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := randomRule(), randomRule()
+		if !a.Covers(b) {
+			continue
+		}
+		for third := uint32(0); third < 8; third++ {
+			for port := uint16(0); port < 8; port++ {
+				for _, proto := range []uint8{1, 6, 17} {
+					f := FiveTuple{Proto: proto, Src: 0x0A000000 | third<<8, Dst: 0x08080808, DstPort: port}
+					if b.Matches(f) && !a.Matches(f) {
+						t.Fatalf("%q covers %q but only the second matches %s", a.Text, b.Text, f)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestWildcardAddrCovers(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"any", "10.0.0.0 0.255.0.255", true},
+		{"10.0.0.0 0.255.0.255", "10.1.0.1 0.0.0.0", true},
+		{"10.0.0.0 0.255.0.255", "10.1.1.1 0.0.0.0", false},
+		{"10.0.0.0 0.255.0.255", "10.0.0.0 0.255.255.255", false},
+		{"10.0.0.0 0.255.255.255", "10.0.0.0 0.255.0.255", true},
+		{"host 10.0.0.1", "any", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			pa := &ruleParser{fields: strings.Fields(tt.a)}
+			pb := &ruleParser{fields: strings.Fields(tt.b)}
+			a, _ := pa.addr()
+			b, _ := pb.addr()
+			if got := a.Covers(b); got != tt.want {
+				t.Errorf("got %v instead of %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFiveTuple(t *testing.T) {
+	for _, bad := range []string{"", "ip 10.0.0.1 10.0.0.2", "tcp 10.0.0.1 10.0.0.2", "icmp 10.0.0.1 1 10.0.0.2 2",
+		"tcp 10.0.0.1 70000 10.0.0.2 80", "udp ::1 1 10.0.0.2 2"} {
+		if _, err := ParseFiveTuple(bad); err == nil {
+			t.Errorf("%q parsed without an error", bad)
+		}
+	}
+}
+
+func TestExplainACLRule(t *testing.T) {
+	acl := mustACL(t)
+	f, _ := ParseFiveTuple("tcp 10.1.4.4 40000 192.0.3.1 80")
+	e := ExplainACLRule(acl.Rules[0], f)
+	if !strings.Contains(e.Title, "does not match") {
+		t.Errorf("unexpected title %s", e.Title)
+	}
+	// The third octet of the destination is the only part that stops the rule matching
+	for _, row := range e.Rows {
+		if row.Label == "Differ  " && row.Value != "0.0.0.0" && row.Bits != "00000000.00000000.00000001.00000000" {
+			t.Errorf("unexpected differing bits %s", row.Bits)
+		}
+	}
+}
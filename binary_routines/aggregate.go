@@ -0,0 +1,274 @@
+// Prefix aggregation, splitting and set difference built on the 128 bit helper type
+// Andrew Alston
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// maxSplit limits how many subnets a split can produce, splitting a /32 IPv6 prefix into /64s would
+// otherwise try to print four billion lines
+const maxSplit = 1 << 16
+
+// addrRange is an inclusive range of addresses of one family.  Working with ranges rather than prefixes
+// makes merging and subtracting simple, and we turn the result back into prefixes at the end
+type addrRange struct {
+	start, end Uint128
+	ipv6       bool
+}
+
+// familyMax returns the highest address of a family, every bit of the address set
+func familyMax(ipv6 bool) Uint128 {
+	if ipv6 {
+		return Uint128Max
+	}
+	return Uint128{Lo: 0xFFFFFFFF}
+}
+
+// prefixRange returns the range of addresses covered by a prefix
+func prefixRange(p Prefix) addrRange {
+	return addrRange{start: p.Network(), end: p.Broadcast(), ipv6: p.IPv6}
+}
+
+// compareRanges orders ranges with IPv4 before IPv6, and then by start address
+func compareRanges(a, b addrRange) int {
+	if a.ipv6 != b.ipv6 {
+		if a.ipv6 {
+			return 1
+		}
+		return -1
+	}
+	return a.start.Cmp(b.start)
+}
+
+// mergeRanges sorts the ranges and merges any that overlap or sit next to each other.  Two ranges of
+// the same family merge when the second starts no later than one past the end of the first
+func mergeRanges(ranges []addrRange) []addrRange {
+	slices.SortFunc(ranges, compareRanges)
+	var res []addrRange
+	for _, r := range ranges {
+		if n := len(res); n > 0 {
+			last := &res[n-1]
+			if last.ipv6 == r.ipv6 && (last.end == familyMax(r.ipv6) || r.start.Cmp(last.end.Add(Uint128{Lo: 1})) <= 0) {
+				if r.end.Cmp(last.end) > 0 {
+					last.end = r.end
+				}
+				continue
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// rangePrefixes returns the fewest prefixes that exactly cover a range.  At each step the largest
+// prefix we can use starts at the current address, is aligned to it, which means its host bits all
+// fall within the trailing zeros of the address, and doesn't run past the end of the range
+func rangePrefixes(r addrRange) []Prefix {
+	width := 32
+	if r.ipv6 {
+		width = 128
+	}
+	var res []Prefix
+	start := r.start
+	for {
+		hostBits := min(start.TrailingZeros(), width)
+		for hostBits > 0 && start.Or(Uint128Max.Rsh(uint(128-hostBits))).Cmp(r.end) > 0 {
+			hostBits--
+		}
+		p := Prefix{Addr: start, Bits: width - hostBits, IPv6: r.ipv6}
+		res = append(res, p)
+		last := p.Broadcast()
+		if last.Cmp(r.end) >= 0 {
+			return res
+		}
+		start = last.Add(Uint128{Lo: 1})
+	}
+}
+
+// rangesPrefixes turns a sorted list of ranges into prefixes
+func rangesPrefixes(ranges []addrRange) []Prefix {
+	var res []Prefix
+	for _, r := range ranges {
+		res = append(res, rangePrefixes(r)...)
+	}
+	return res
+}
+
+// Aggregate returns the smallest list of prefixes that covers exactly the same addresses as the
+// prefixes given.  Prefixes contained in others are dropped, and overlapping or adjacent prefixes are
+// merged, so 10.0.0.0/25 and 10.0.0.128/25 become 10.0.0.0/24.  The result is sorted, IPv4 first
+func Aggregate(prefixes []Prefix) []Prefix {
+	ranges := make([]addrRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		ranges = append(ranges, prefixRange(p))
+	}
+	return rangesPrefixes(mergeRanges(ranges))
+}
+
+// SplitLength splits a prefix into every subnet of the given length, so 10.0.0.0/24 split to /26 gives
+// four prefixes.  Each subnet is the network address with a different count in the bits between the
+// two prefix lengths, so we step through them by adding one shifted up past the host bits
+func SplitLength(p Prefix, bits int) ([]Prefix, error) {
+	if bits < p.Bits || bits > p.Width() {
+		return nil, fmt.Errorf("can't split %s into /%d subnets, the length must be between %d and %d",
+			p, bits, p.Bits, p.Width())
+	}
+	if bits-p.Bits > 16 {
+		return nil, fmt.Errorf("splitting %s into /%d subnets gives more than %d subnets", p, bits, maxSplit)
+	}
+	count := 1 << (bits - p.Bits)
+	step := Uint128{Lo: 1}.Lsh(uint(p.Width() - bits))
+	res := make([]Prefix, 0, count)
+	addr := p.Network()
+	for i := 0; i < count; i++ {
+		res = append(res, Prefix{Addr: addr, Bits: bits, IPv6: p.IPv6})
+		addr = addr.Add(step)
+	}
+	return res, nil
+}
+
+// Split splits a prefix into at least n equal subnets.  Subnets always come in powers of two, so n is
+// rounded up to the next power of two, and asking for 3 subnets of a /24 gives four /26s
+func Split(p Prefix, n int) ([]Prefix, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("can't split %s into %d subnets", p, n)
+	}
+	return SplitLength(p, p.Bits+TrailingZeros(NextPowerOfTwo(uint64(n))))
+}
+
+// Difference returns the prefixes covering every address in a that is not in b.  Both lists are merged
+// into ranges first, then each range in b is cut out of the ranges in a, which may leave a piece on
+// either side of it
+func Difference(a, b []Prefix) []Prefix {
+	var aRanges, bRanges []addrRange
+	for _, p := range a {
+		aRanges = append(aRanges, prefixRange(p))
+	}
+	for _, p := range b {
+		bRanges = append(bRanges, prefixRange(p))
+	}
+	res := mergeRanges(aRanges)
+	for _, cut := range mergeRanges(bRanges) {
+		var next []addrRange
+		for _, r := range res {
+			if r.ipv6 != cut.ipv6 || cut.end.Cmp(r.start) < 0 || cut.start.Cmp(r.end) > 0 {
+				next = append(next, r)
+				continue
+			}
+			if cut.start.Cmp(r.start) > 0 {
+				next = append(next, addrRange{start: r.start, end: cut.start.Sub(Uint128{Lo: 1}), ipv6: r.ipv6})
+			}
+			if cut.end.Cmp(r.end) < 0 {
+				next = append(next, addrRange{start: cut.end.Add(Uint128{Lo: 1}), end: r.end, ipv6: r.ipv6})
+			}
+		}
+		res = next
+	}
+	return rangesPrefixes(res)
+}
+
+// ReadPrefixes reads prefixes one per line, ignoring blank lines and anything after a #
+func ReadPrefixes(r io.Reader) ([]Prefix, error) {
+	var res []Prefix
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		p, err := ParsePrefix(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		res = append(res, p)
+	}
+	return res, scanner.Err()
+}
+
+// parsePrefixArgs parses each argument as a prefix
+func parsePrefixArgs(args []string) ([]Prefix, error) {
+	var res []Prefix
+	for _, arg := range args {
+		p, err := ParsePrefix(arg)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// printPrefixes prints one prefix per line
+func printPrefixes(w io.Writer, prefixes []Prefix) {
+	for _, p := range prefixes {
+		fmt.Fprintln(w, p)
+	}
+}
+
+// runAggregate is the aggregate sub command, it prints the minimal covering set of the prefixes read
+// from standard input
+func runAggregate(args []string) error {
+	prefixes, err := ReadPrefixes(os.Stdin)
+	if err != nil {
+		return err
+	}
+	printPrefixes(os.Stdout, Aggregate(prefixes))
+	return nil
+}
+
+// runSplit is the split sub command, it splits each prefix given either into a number of subnets or
+// into subnets of a given length
+func runSplit(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ContinueOnError)
+	count := fs.Int("n", 0, "Number of subnets to split each prefix into")
+	length := fs.Int("len", 0, "Length of the subnets to split each prefix into")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || (*count == 0) == (*length == 0) {
+		return fmt.Errorf("usage: split -n <count> | -len <length> <prefix> [<prefix> ...]")
+	}
+	prefixes, err := parsePrefixArgs(fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, p := range prefixes {
+		var subnets []Prefix
+		if *count != 0 {
+			subnets, err = Split(p, *count)
+		} else {
+			subnets, err = SplitLength(p, *length)
+		}
+		if err != nil {
+			return err
+		}
+		printPrefixes(os.Stdout, subnets)
+	}
+	return nil
+}
+
+// runDifference is the difference sub command, it prints the prefixes read from standard input with
+// the prefixes given on the command line removed
+func runDifference(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: difference <prefix> [<prefix> ...] < prefixes")
+	}
+	exclude, err := parsePrefixArgs(args)
+	if err != nil {
+		return err
+	}
+	prefixes, err := ReadPrefixes(os.Stdin)
+	if err != nil {
+		return err
+	}
+	printPrefixes(os.Stdout, Difference(prefixes, exclude))
+	return nil
+}
@@ -0,0 +1,221 @@
+// Prefix aggregation test routines
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mustPrefixes parses a list of prefixes, failing the test on error
+func mustPrefixes(t *testing.T, in ...string) []Prefix {
+	t.Helper()
+	res, err := parsePrefixArgs(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// prefixStrings returns the prefixes in CIDR form joined by spaces
+func prefixStrings(prefixes []Prefix) string {
+	var res []string
+	for _, p := range prefixes {
+		res = append(res, p.String())
+	}
+	return strings.Join(res, " ")
+}
+
+// smallPrefixes returns every prefix within 10.0.0.0/28, which is small enough to test exhaustively
+func smallPrefixes() []Prefix {
+	var res []Prefix
+	for bits := 28; bits <= 32; bits++ {
+		for i := 0; i < 1<<(bits-28); i++ {
+			res = append(res, Prefix{Addr: Uint128{Lo: 0x0A000000 + uint64(i)<<(32-bits)}, Bits: bits})
+		}
+	}
+	return res
+}
+
+// coverage returns a bitmap of which of the 16 addresses in 10.0.0.0/28 the prefixes cover
+func coverage(prefixes []Prefix) uint16 {
+	var res uint16
+	for i := 0; i < 16; i++ {
+		for _, p := range prefixes {
+			if p.Contains(Uint128{Lo: 0x0A000000 + uint64(i)}) {
+				res |= 1 << i
+			}
+		}
+	}
+	return res
+}
+
+// checkMinimal fails the test if the prefixes are out of order, overlap, or contain two siblings that
+// should have been merged in to their parent
+func checkMinimal(t *testing.T, prefixes []Prefix) {
+	t.Helper()
+	for i := 1; i < len(prefixes); i++ {
+		a, b := prefixes[i-1], prefixes[i]
+		if a.IPv6 != b.IPv6 {
+			continue
+		}
+		if a.Broadcast().Cmp(b.Network()) >= 0 {
+			t.Errorf("%s and %s overlap or are out of order", a, b)
+		}
+		if a.Bits == b.Bits && a.Bits > 0 && a.Network().Xor(b.Network()) == PrefixMask(a.Bits, a.Width()).Xor(PrefixMask(a.Bits-1, a.Width())) {
+			t.Errorf("%s and %s should have been merged", a, b)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"10.0.0.0/25", "10.0.0.128/25"}, "10.0.0.0/24"},
+		{[]string{"10.0.0.0/24", "10.0.0.64/26", "10.0.0.7"}, "10.0.0.0/24"},
+		{[]string{"10.0.1.0/24", "10.0.2.0/24"}, "10.0.1.0/24 10.0.2.0/24"},
+		{[]string{"10.0.1.0/24", "10.0.2.0/23", "10.0.0.0/24"}, "10.0.0.0/22"},
+		{[]string{"192.168.0.1", "192.168.0.2"}, "192.168.0.1/32 192.168.0.2/32"},
+		{[]string{"192.168.0.1", "192.168.0.2", "192.168.0.3", "192.168.0.4"}, "192.168.0.1/32 192.168.0.2/31 192.168.0.4/32"},
+		{[]string{"0.0.0.0/1", "128.0.0.0/1"}, "0.0.0.0/0"},
+		{[]string{"255.255.255.255", "255.255.255.254", "0.0.0.0"}, "0.0.0.0/32 255.255.255.254/31"},
+		{[]string{"2001:db8::/33", "10.0.0.0/8", "2001:db8:8000::/33"}, "10.0.0.0/8 2001:db8::/32"},
+		{[]string{"::/1", "8000::/1", "::1"}, "::/0"},
+		{[]string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe"}, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.in, ","), func(t *testing.T) {
+			got := Aggregate(mustPrefixes(t, tt.in...))
+			if prefixStrings(got) != tt.want {
+				t.Errorf("got %s instead of %s", prefixStrings(got), tt.want)
+			}
+			checkMinimal(t, got)
+		})
+	}
+}
+
+// TestAggregateExhaustive aggregates every pair and every triple of prefixes within a /28 and checks the
+// result covers the same addresses and is minimal
+func TestAggregateExhaustive(t *testing.T) {
+	all := smallPrefixes()
+	for _, a := range all {
+		for _, b := range all {
+			for _, c := range all {
+				in := []Prefix{a, b, c}
+				got := Aggregate(in)
+				if coverage(got) != coverage(in) {
+					t.Fatalf("aggregating %s gave %s which covers different addresses", prefixStrings(in), prefixStrings(got))
+				}
+				checkMinimal(t, got)
+			}
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		in     string
+		n, len int
+		want   string
+		err    bool
+	}{
+		{"10.0.0.0/24", 4, 0, "10.0.0.0/26 10.0.0.64/26 10.0.0.128/26 10.0.0.192/26", false},
+		{"10.0.0.77/24", 3, 0, "10.0.0.0/26 10.0.0.64/26 10.0.0.128/26 10.0.0.192/26", false},
+		{"10.0.0.0/24", 1, 0, "10.0.0.0/24", false},
+		{"10.0.0.0/30", 0, 32, "10.0.0.0/32 10.0.0.1/32 10.0.0.2/32 10.0.0.3/32", false},
+		{"0.0.0.0/0", 0, 1, "0.0.0.0/1 128.0.0.0/1", false},
+		{"2001:db8::/32", 0, 34, "2001:db8::/34 2001:db8:4000::/34 2001:db8:8000::/34 2001:db8:c000::/34", false},
+		{"::/0", 2, 0, "::/1 8000::/1", false},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/126", 0, 127, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc/127 ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127", false},
+		{"10.0.0.0/24", 0, 23, "", true},
+		{"10.0.0.0/24", 0, 33, "", true},
+		{"10.0.0.0/24", 0, 0, "", true},
+		{"10.0.0.0/31", 4, 0, "", true},
+		{"2001:db8::/32", 0, 64, "", true},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%s n=%d len=%d", tt.in, tt.n, tt.len)
+		t.Run(testName, func(t *testing.T) {
+			p := mustPrefixes(t, tt.in)[0]
+			var got []Prefix
+			var err error
+			if tt.n != 0 || tt.len == 0 {
+				got, err = Split(p, tt.n)
+			} else {
+				got, err = SplitLength(p, tt.len)
+			}
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error state %v", err)
+			}
+			if prefixStrings(got) != tt.want {
+				t.Errorf("got %s instead of %s", prefixStrings(got), tt.want)
+			}
+			if err == nil && prefixStrings(Aggregate(got)) != p.String() {
+				t.Errorf("subnets don't aggregate back to %s", p)
+			}
+		})
+	}
+}
+
+func TestDifference(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want string
+	}{
+		{[]string{"10.0.0.0/24"}, []string{"10.0.0.0/25"}, "10.0.0.128/25"},
+		{[]string{"10.0.0.0/24"}, []string{"10.0.0.64/26"}, "10.0.0.0/26 10.0.0.128/25"},
+		{[]string{"10.0.0.0/24"}, []string{"10.0.0.0/16"}, ""},
+		{[]string{"10.0.0.0/24"}, []string{"10.0.1.0/24"}, "10.0.0.0/24"},
+		{[]string{"10.0.0.0/30"}, []string{"10.0.0.1"}, "10.0.0.0/32 10.0.0.2/31"},
+		{[]string{"0.0.0.0/0"}, []string{"0.0.0.0", "255.255.255.255"}, "0.0.0.1/32 0.0.0.2/31 0.0.0.4/30 0.0.0.8/29 " +
+			"0.0.0.16/28 0.0.0.32/27 0.0.0.64/26 0.0.0.128/25 0.0.1.0/24 0.0.2.0/23 0.0.4.0/22 0.0.8.0/21 0.0.16.0/20 " +
+			"0.0.32.0/19 0.0.64.0/18 0.0.128.0/17 0.1.0.0/16 0.2.0.0/15 0.4.0.0/14 0.8.0.0/13 0.16.0.0/12 0.32.0.0/11 " +
+			"0.64.0.0/10 0.128.0.0/9 1.0.0.0/8 2.0.0.0/7 4.0.0.0/6 8.0.0.0/5 16.0.0.0/4 32.0.0.0/3 64.0.0.0/2 128.0.0.0/2 " +
+			"192.0.0.0/3 224.0.0.0/4 240.0.0.0/5 248.0.0.0/6 252.0.0.0/7 254.0.0.0/8 255.0.0.0/9 255.128.0.0/10 " +
+			"255.192.0.0/11 255.224.0.0/12 255.240.0.0/13 255.248.0.0/14 255.252.0.0/15 255.254.0.0/16 255.255.0.0/17 " +
+			"255.255.128.0/18 255.255.192.0/19 255.255.224.0/20 255.255.240.0/21 255.255.248.0/22 255.255.252.0/23 " +
+			"255.255.254.0/24 255.255.255.0/25 255.255.255.128/26 255.255.255.192/27 255.255.255.224/28 " +
+			"255.255.255.240/29 255.255.255.248/30 255.255.255.252/31 255.255.255.254/32"},
+		{[]string{"2001:db8::/32", "10.0.0.0/8"}, []string{"10.0.0.0/8"}, "2001:db8::/32"},
+		{[]string{"2001:db8::/32"}, []string{"2001:db8:8000::/33", "10.0.0.0/8"}, "2001:db8::/33"},
+		{[]string{"::/0"}, []string{"::/1"}, "8000::/1"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.a, ",")+"-"+strings.Join(tt.b, ","), func(t *testing.T) {
+			got := Difference(mustPrefixes(t, tt.a...), mustPrefixes(t, tt.b...))
+			if prefixStrings(got) != tt.want {
+				t.Errorf("got %s instead of %s", prefixStrings(got), tt.want)
+			}
+			checkMinimal(t, got)
+		})
+	}
+}
+
+// TestDifferenceExhaustive removes every prefix within a /28 from every pair of prefixes within it
+func TestDifferenceExhaustive(t *testing.T) {
+	all := smallPrefixes()
+	for _, a := range all {
+		for _, b := range all {
+			for _, c := range all {
+				got := Difference([]Prefix{a, b}, []Prefix{c})
+				if want := coverage([]Prefix{a, b}) &^ coverage([]Prefix{c}); coverage(got) != want {
+					t.Fatalf("%s and %s without %s gave %s", a, b, c, prefixStrings(got))
+				}
+				checkMinimal(t, got)
+			}
+		}
+	}
+}
+
+func TestReadPrefixes(t *testing.T) {
+	got, err := ReadPrefixes(strings.NewReader("10.0.0.0/8\n\n# comment\n2001:db8::/32 # documentation\n"))
+	if err != nil || prefixStrings(got) != "10.0.0.0/8 2001:db8::/32" {
+		t.Errorf("got %s, %v", prefixStrings(got), err)
+	}
+	if _, err := ReadPrefixes(strings.NewReader("10.0.0.0/8\nbogus\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,1311 @@
+// BGP-4 messages (RFC 4271), path attributes and NLRI prefix encoding, with 4 byte AS numbers (RFC
+// 6793), communities (RFC 1997), large communities (RFC 8092) and the multiprotocol extensions that
+// carry IPv6 (RFC 4760)
+// Andrew Alston
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Message sizes.  Every message starts with a 16 byte marker of all ones, a length and a type
+const (
+	BGPHeaderLen     = 19
+	BGPMaxMessageLen = 4096
+	BGPVersion       = 4
+	bgpOpenLen       = 10
+)
+
+// BGP message types
+const (
+	BGPMsgOpen         = 1
+	BGPMsgUpdate       = 2
+	BGPMsgNotification = 3
+	BGPMsgKeepalive    = 4
+	BGPMsgRouteRefresh = 5
+)
+
+// bgpMessageNames are the names of the message types
+var bgpMessageNames = map[uint8]string{
+	BGPMsgOpen:         "OPEN",
+	BGPMsgUpdate:       "UPDATE",
+	BGPMsgNotification: "NOTIFICATION",
+	BGPMsgKeepalive:    "KEEPALIVE",
+	BGPMsgRouteRefresh: "ROUTE-REFRESH",
+}
+
+// Address families and subsequent address families, used by the multiprotocol extensions
+const (
+	AFIIPv4       = 1
+	AFIIPv6       = 2
+	SAFIUnicast   = 1
+	SAFIMulticast = 2
+)
+
+// ASTrans is the AS number a speaker with a 4 byte AS number puts in places that only have room for 2
+// bytes, such as the AS field of an OPEN
+const ASTrans = 23456
+
+// AppendNLRI appends the NLRI encoding of a prefix, its length in bits followed by only as many bytes
+// of the network address as the length needs, so 10.1.128.0/17 takes 3 bytes rather than 4.  We shift
+// the network up to the top of the 128 bits so the bytes we want are always the first ones, whichever
+// the family
+func AppendNLRI(b []byte, p Prefix) []byte {
+	addr := p.Network().Lsh(uint(128 - p.Width()))
+	b = append(b, byte(p.Bits))
+	for i := 0; i < (p.Bits+7)/8; i++ {
+		b = append(b, byte(addr.Rsh(uint(120-8*i)).Lo))
+	}
+	return b
+}
+
+// DecodeNLRI decodes a prefix from the start of b, returning it and the number of bytes it took.  Bits
+// after the prefix length in the last byte mean nothing, so they are masked off
+func DecodeNLRI(b []byte, ipv6 bool) (Prefix, int, error) {
+	p := Prefix{IPv6: ipv6}
+	if len(b) == 0 {
+		return Prefix{}, 0, errors.New("NLRI is truncated")
+	}
+	p.Bits = int(b[0])
+	if p.Bits > p.Width() {
+		return Prefix{}, 0, fmt.Errorf("NLRI length %d is longer than a %d bit address", p.Bits, p.Width())
+	}
+	n := (p.Bits + 7) / 8
+	if len(b) < 1+n {
+		return Prefix{}, 0, errors.New("NLRI is truncated")
+	}
+	var addr Uint128
+	for i, c := range b[1 : 1+n] {
+		addr = addr.Or(Uint128{Lo: uint64(c)}.Lsh(uint(120 - 8*i)))
+	}
+	p.Addr = addr.Rsh(uint(128 - p.Width())).And(p.Netmask())
+	return p, 1 + n, nil
+}
+
+// appendPrefixes appends the NLRI encoding of each prefix
+func appendPrefixes(b []byte, prefixes []Prefix) []byte {
+	for _, p := range prefixes {
+		b = AppendNLRI(b, p)
+	}
+	return b
+}
+
+// decodePrefixes decodes prefixes until b runs out
+func decodePrefixes(b []byte, ipv6 bool) ([]Prefix, error) {
+	var res []Prefix
+	for len(b) > 0 {
+		p, n, err := DecodeNLRI(b, ipv6)
+		if err != nil {
+			return res, err
+		}
+		res, b = append(res, p), b[n:]
+	}
+	return res, nil
+}
+
+// ExplainNLRI describes how a prefix is encoded, masking the address down to the network and keeping
+// only the bytes the length covers
+func ExplainNLRI(p Prefix) Explanation {
+	enc := AppendNLRI(nil, p)
+	octets := make([]string, len(enc)-1)
+	for i, c := range enc[1:] {
+		octets[i] = fmt.Sprintf("%08b", c)
+	}
+	rows := []ExplainRow{
+		{Label: "Address", Value: p.FormatAddr(p.Addr), Bits: p.FormatBinary(p.Addr)},
+		{Label: "Mask   ", Value: p.FormatAddr(p.Netmask()), Bits: p.FormatBinary(p.Netmask())},
+		{Label: "Network", Value: p.FormatAddr(p.Network()), Bits: p.FormatBinary(p.Network())},
+		{Label: "Length ", Value: strconv.Itoa(p.Bits), Bits: fmt.Sprintf("%08b", p.Bits)},
+		{Label: "Bytes  ", Value: fmt.Sprintf("% x", enc[1:]), Bits: strings.Join(octets, ".")},
+	}
+	return headerExplanation(fmt.Sprintf("NLRI encoding of %s, %s", p, byteCount(len(enc))), rows)
+}
+
+// Path attribute flags
+const (
+	AttrFlagOptional   = 0x80
+	AttrFlagTransitive = 0x40
+	AttrFlagPartial    = 0x20
+	AttrFlagExtended   = 0x10
+)
+
+// Path attribute type codes
+const (
+	AttrOrigin           = 1
+	AttrASPath           = 2
+	AttrNextHop          = 3
+	AttrMED              = 4
+	AttrLocalPref        = 5
+	AttrAtomicAggregate  = 6
+	AttrAggregator       = 7
+	AttrCommunities      = 8
+	AttrMPReach          = 14
+	AttrMPUnreach        = 15
+	AttrAS4Path          = 17
+	AttrAS4Aggregator    = 18
+	AttrLargeCommunities = 32
+)
+
+// attrNames are the names of the path attributes
+var attrNames = map[uint8]string{
+	AttrOrigin:           "ORIGIN",
+	AttrASPath:           "AS_PATH",
+	AttrNextHop:          "NEXT_HOP",
+	AttrMED:              "MULTI_EXIT_DISC",
+	AttrLocalPref:        "LOCAL_PREF",
+	AttrAtomicAggregate:  "ATOMIC_AGGREGATE",
+	AttrAggregator:       "AGGREGATOR",
+	AttrCommunities:      "COMMUNITIES",
+	AttrMPReach:          "MP_REACH_NLRI",
+	AttrMPUnreach:        "MP_UNREACH_NLRI",
+	AttrAS4Path:          "AS4_PATH",
+	AttrAS4Aggregator:    "AS4_AGGREGATOR",
+	AttrLargeCommunities: "LARGE_COMMUNITY",
+}
+
+// Values of the ORIGIN attribute
+const (
+	OriginIGP        = 0
+	OriginEGP        = 1
+	OriginIncomplete = 2
+)
+
+// originNames are the names of the origins, as bgpdump writes them
+var originNames = []string{"IGP", "EGP", "INCOMPLETE"}
+
+// PathAttribute is a path attribute as it is sent, its flags, type code and value.  The New functions
+// build the value of the attributes we know, and the methods of PathAttributes read them back
+type PathAttribute struct {
+	Flags uint8
+	Type  uint8
+	Value []byte
+}
+
+// PathAttributes are the path attributes of an UPDATE, or of a route in an MRT dump
+type PathAttributes []PathAttribute
+
+// appendAttributes appends each attribute, using a two byte length when the extended length flag is
+// set or the value needs it
+func appendAttributes(b []byte, attrs PathAttributes) ([]byte, error) {
+	for _, a := range attrs {
+		if len(a.Value) > 0xFFFF {
+			return nil, fmt.Errorf("%s attribute of %d bytes is too long", attrName(a.Type), len(a.Value))
+		}
+		flags := a.Flags
+		if len(a.Value) > 0xFF {
+			flags |= AttrFlagExtended
+		}
+		b = append(b, flags, a.Type)
+		if flags&AttrFlagExtended != 0 {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(a.Value)))
+		} else {
+			b = append(b, byte(len(a.Value)))
+		}
+		b = append(b, a.Value...)
+	}
+	return b, nil
+}
+
+// parseAttributes decodes path attributes until b runs out.  An attribute may only appear once
+func parseAttributes(b []byte) (PathAttributes, error) {
+	var attrs PathAttributes
+	var seen [256]bool
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return attrs, errors.New("path attribute header is truncated")
+		}
+		a := PathAttribute{Flags: b[0], Type: b[1]}
+		n, hdr := int(b[2]), 3
+		if a.Flags&AttrFlagExtended != 0 {
+			if len(b) < 4 {
+				return attrs, errors.New("path attribute header is truncated")
+			}
+			n, hdr = int(binary.BigEndian.Uint16(b[2:])), 4
+		}
+		if len(b) < hdr+n {
+			return attrs, fmt.Errorf("%s attribute is truncated", attrName(a.Type))
+		}
+		if seen[a.Type] {
+			return attrs, fmt.Errorf("%s attribute appears twice", attrName(a.Type))
+		}
+		seen[a.Type] = true
+		a.Value, b = b[hdr:hdr+n], b[hdr+n:]
+		attrs = append(attrs, a)
+	}
+	return attrs, nil
+}
+
+// attrName returns the name of an attribute type
+func attrName(t uint8) string {
+	if name, ok := attrNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("attribute %d", t)
+}
+
+// find returns the attribute of a type
+func (pa PathAttributes) find(t uint8) (PathAttribute, bool) {
+	for _, a := range pa {
+		if a.Type == t {
+			return a, true
+		}
+	}
+	return PathAttribute{}, false
+}
+
+// uint32Attribute reads an attribute whose value is a single 32 bit number
+func (pa PathAttributes) uint32Attribute(t uint8) (uint32, bool) {
+	a, ok := pa.find(t)
+	if !ok || len(a.Value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(a.Value), true
+}
+
+// NewOriginAttribute returns an ORIGIN attribute
+func NewOriginAttribute(origin uint8) PathAttribute {
+	return PathAttribute{Flags: AttrFlagTransitive, Type: AttrOrigin, Value: []byte{origin}}
+}
+
+// Origin returns the ORIGIN attribute
+func (pa PathAttributes) Origin() (uint8, bool) {
+	a, ok := pa.find(AttrOrigin)
+	if !ok || len(a.Value) != 1 {
+		return 0, false
+	}
+	return a.Value[0], true
+}
+
+// AS path segment types.  A sequence is the ASes a route passed through in order, a set is an unordered
+// group left by aggregation, and the confederation types are the same within a confederation
+const (
+	ASSet            = 1
+	ASSequence       = 2
+	ASConfedSequence = 3
+	ASConfedSet      = 4
+)
+
+// ASPathSegment is one segment of an AS path
+type ASPathSegment struct {
+	Type uint8
+	ASNs []uint32
+}
+
+// ASPath is an AS path, the segments in order from the neighbour to the origin
+type ASPath []ASPathSegment
+
+// String returns the path the way routers show it, sets in braces and confederation segments in
+// brackets
+func (p ASPath) String() string {
+	var segs []string
+	for _, s := range p {
+		asns := make([]string, len(s.ASNs))
+		for i, as := range s.ASNs {
+			asns[i] = strconv.FormatUint(uint64(as), 10)
+		}
+		switch s.Type {
+		case ASSet:
+			segs = append(segs, "{"+strings.Join(asns, ",")+"}")
+		case ASConfedSequence:
+			segs = append(segs, "("+strings.Join(asns, " ")+")")
+		case ASConfedSet:
+			segs = append(segs, "["+strings.Join(asns, ",")+"]")
+		default:
+			segs = append(segs, strings.Join(asns, " "))
+		}
+	}
+	return strings.Join(segs, " ")
+}
+
+// Length returns the length of the path as best path selection counts it, each AS of a sequence
+// counts one, a whole set counts one and confederation segments don't count
+func (p ASPath) Length() int {
+	n := 0
+	for _, s := range p {
+		switch s.Type {
+		case ASSequence:
+			n += len(s.ASNs)
+		case ASSet:
+			n++
+		}
+	}
+	return n
+}
+
+// OriginAS returns the AS that originated the route, the last AS of the path.  A path ending in a set
+// has no single origin
+func (p ASPath) OriginAS() (uint32, bool) {
+	if len(p) == 0 {
+		return 0, false
+	}
+	last := p[len(p)-1]
+	if last.Type != ASSequence || len(last.ASNs) == 0 {
+		return 0, false
+	}
+	return last.ASNs[len(last.ASNs)-1], true
+}
+
+// NewASPathAttribute returns an AS_PATH attribute, with 4 byte AS numbers when both speakers support
+// them and 2 byte ones otherwise
+func NewASPathAttribute(path ASPath, as4 bool) (PathAttribute, error) {
+	var b []byte
+	for _, s := range path {
+		if s.Type < ASSet || s.Type > ASConfedSet {
+			return PathAttribute{}, fmt.Errorf("unknown AS path segment type %d", s.Type)
+		}
+		if len(s.ASNs) == 0 || len(s.ASNs) > 255 {
+			return PathAttribute{}, fmt.Errorf("AS path segment of %d ASes, expected 1 to 255", len(s.ASNs))
+		}
+		b = append(b, s.Type, byte(len(s.ASNs)))
+		for _, as := range s.ASNs {
+			if as4 {
+				b = binary.BigEndian.AppendUint32(b, as)
+				continue
+			}
+			if as > 0xFFFF {
+				return PathAttribute{}, fmt.Errorf("AS %d needs 4 bytes", as)
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(as))
+		}
+	}
+	return PathAttribute{Flags: AttrFlagTransitive, Type: AttrASPath, Value: b}, nil
+}
+
+// decodeASPath decodes the value of an AS_PATH or AS4_PATH attribute
+func decodeASPath(b []byte, as4 bool) (ASPath, error) {
+	width := 2
+	if as4 {
+		width = 4
+	}
+	var path ASPath
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, errors.New("AS path segment header is truncated")
+		}
+		s := ASPathSegment{Type: b[0]}
+		n := int(b[1])
+		if s.Type < ASSet || s.Type > ASConfedSet {
+			return nil, fmt.Errorf("unknown AS path segment type %d", s.Type)
+		}
+		if len(b) < 2+n*width {
+			return nil, errors.New("AS path segment is truncated")
+		}
+		for i := 0; i < n; i++ {
+			if as4 {
+				s.ASNs = append(s.ASNs, binary.BigEndian.Uint32(b[2+4*i:]))
+			} else {
+				s.ASNs = append(s.ASNs, uint32(binary.BigEndian.Uint16(b[2+2*i:])))
+			}
+		}
+		path, b = append(path, s), b[2+n*width:]
+	}
+	return path, nil
+}
+
+// ASPath returns the AS_PATH attribute.  Whether it holds 2 or 4 byte AS numbers isn't in the
+// attribute, it depends on whether both speakers announced 4 byte AS support, so the caller has to say
+func (pa PathAttributes) ASPath(as4 bool) (ASPath, error) {
+	a, ok := pa.find(AttrASPath)
+	if !ok {
+		return nil, nil
+	}
+	return decodeASPath(a.Value, as4)
+}
+
+// NewNextHopAttribute returns a NEXT_HOP attribute, which is always IPv4.  IPv6 next hops go in the
+// MP_REACH_NLRI attribute
+func NewNextHopAttribute(addr netip.Addr) (PathAttribute, error) {
+	if !addr.Is4() {
+		return PathAttribute{}, fmt.Errorf("NEXT_HOP %s isn't IPv4", addr)
+	}
+	b := addr.As4()
+	return PathAttribute{Flags: AttrFlagTransitive, Type: AttrNextHop, Value: b[:]}, nil
+}
+
+// NextHop returns the NEXT_HOP attribute
+func (pa PathAttributes) NextHop() (netip.Addr, bool) {
+	a, ok := pa.find(AttrNextHop)
+	if !ok || len(a.Value) != 4 {
+		return netip.Addr{}, false
+	}
+	return netip.AddrFrom4([4]byte(a.Value)), true
+}
+
+// NewMEDAttribute returns a MULTI_EXIT_DISC attribute
+func NewMEDAttribute(med uint32) PathAttribute {
+	return PathAttribute{Flags: AttrFlagOptional, Type: AttrMED, Value: binary.BigEndian.AppendUint32(nil, med)}
+}
+
+// MED returns the MULTI_EXIT_DISC attribute
+func (pa PathAttributes) MED() (uint32, bool) {
+	return pa.uint32Attribute(AttrMED)
+}
+
+// NewLocalPrefAttribute returns a LOCAL_PREF attribute
+func NewLocalPrefAttribute(pref uint32) PathAttribute {
+	return PathAttribute{Flags: AttrFlagTransitive, Type: AttrLocalPref, Value: binary.BigEndian.AppendUint32(nil, pref)}
+}
+
+// LocalPref returns the LOCAL_PREF attribute
+func (pa PathAttributes) LocalPref() (uint32, bool) {
+	return pa.uint32Attribute(AttrLocalPref)
+}
+
+// Community is a standard community, an AS number in the top 16 bits and a value in the bottom 16
+type Community uint32
+
+// Well known communities
+const (
+	CommunityNoExport          Community = 0xFFFFFF01
+	CommunityNoAdvertise       Community = 0xFFFFFF02
+	CommunityNoExportSubconfed Community = 0xFFFFFF03
+	CommunityBlackhole         Community = 0xFFFF029A
+)
+
+// String returns the community in its AS:value form
+func (c Community) String() string {
+	return fmt.Sprintf("%d:%d", c>>16, c&0xFFFF)
+}
+
+// ParseCommunity parses a community in its AS:value form
+func ParseCommunity(s string) (Community, error) {
+	as, val, ok := strings.Cut(s, ":")
+	a, err1 := strconv.ParseUint(as, 10, 16)
+	v, err2 := strconv.ParseUint(val, 10, 16)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("invalid community %q, expected AS:value", s)
+	}
+	return Community(a<<16 | v), nil
+}
+
+// NewCommunitiesAttribute returns a COMMUNITIES attribute
+func NewCommunitiesAttribute(cs ...Community) PathAttribute {
+	var b []byte
+	for _, c := range cs {
+		b = binary.BigEndian.AppendUint32(b, uint32(c))
+	}
+	return PathAttribute{Flags: AttrFlagOptional | AttrFlagTransitive, Type: AttrCommunities, Value: b}
+}
+
+// Communities returns the COMMUNITIES attribute
+func (pa PathAttributes) Communities() ([]Community, error) {
+	a, ok := pa.find(AttrCommunities)
+	if !ok {
+		return nil, nil
+	}
+	if len(a.Value)%4 != 0 {
+		return nil, fmt.Errorf("COMMUNITIES length %d isn't a multiple of 4", len(a.Value))
+	}
+	var cs []Community
+	for b := a.Value; len(b) > 0; b = b[4:] {
+		cs = append(cs, Community(binary.BigEndian.Uint32(b)))
+	}
+	return cs, nil
+}
+
+// LargeCommunity is a large community, three 32 bit numbers, the first the AS that defines the other
+// two.  They exist because a 4 byte AS number won't fit in a standard community
+type LargeCommunity struct {
+	Global, Local1, Local2 uint32
+}
+
+// String returns the community in its global:local1:local2 form
+func (lc LargeCommunity) String() string {
+	return fmt.Sprintf("%d:%d:%d", lc.Global, lc.Local1, lc.Local2)
+}
+
+// NewLargeCommunitiesAttribute returns a LARGE_COMMUNITY attribute
+func NewLargeCommunitiesAttribute(lcs ...LargeCommunity) PathAttribute {
+	var b []byte
+	for _, lc := range lcs {
+		b = binary.BigEndian.AppendUint32(b, lc.Global)
+		b = binary.BigEndian.AppendUint32(b, lc.Local1)
+		b = binary.BigEndian.AppendUint32(b, lc.Local2)
+	}
+	return PathAttribute{Flags: AttrFlagOptional | AttrFlagTransitive, Type: AttrLargeCommunities, Value: b}
+}
+
+// LargeCommunities returns the LARGE_COMMUNITY attribute
+func (pa PathAttributes) LargeCommunities() ([]LargeCommunity, error) {
+	a, ok := pa.find(AttrLargeCommunities)
+	if !ok {
+		return nil, nil
+	}
+	if len(a.Value)%12 != 0 {
+		return nil, fmt.Errorf("LARGE_COMMUNITY length %d isn't a multiple of 12", len(a.Value))
+	}
+	var lcs []LargeCommunity
+	for b := a.Value; len(b) > 0; b = b[12:] {
+		lcs = append(lcs, LargeCommunity{binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:]), binary.BigEndian.Uint32(b[8:])})
+	}
+	return lcs, nil
+}
+
+// MPReach is the MP_REACH_NLRI attribute, which announces routes of any family along with their next
+// hops.  An IPv6 next hop may be a global address followed by a link local one
+type MPReach struct {
+	AFI      uint16
+	SAFI     uint8
+	NextHops []netip.Addr
+	NLRI     []Prefix
+}
+
+// MPUnreach is the MP_UNREACH_NLRI attribute, which withdraws routes of any family
+type MPUnreach struct {
+	AFI       uint16
+	SAFI      uint8
+	Withdrawn []Prefix
+}
+
+// familyIPv6 checks we can decode the prefixes of a family, returning whether they are IPv6.  Other
+// families, and SAFIs such as VPNs whose NLRI carry labels, aren't supported
+func familyIPv6(afi uint16, safi uint8) (bool, error) {
+	if (afi != AFIIPv4 && afi != AFIIPv6) || (safi != SAFIUnicast && safi != SAFIMulticast) {
+		return false, fmt.Errorf("AFI %d SAFI %d isn't supported", afi, safi)
+	}
+	return afi == AFIIPv6, nil
+}
+
+// NewMPReachAttribute returns an MP_REACH_NLRI attribute
+func NewMPReachAttribute(mp MPReach) (PathAttribute, error) {
+	if _, err := familyIPv6(mp.AFI, mp.SAFI); err != nil {
+		return PathAttribute{}, err
+	}
+	b := binary.BigEndian.AppendUint16(nil, mp.AFI)
+	b = append(b, mp.SAFI, 0)
+	for _, nh := range mp.NextHops {
+		b = append(b, nh.AsSlice()...)
+	}
+	if len(b)-4 > 0xFF {
+		return PathAttribute{}, errors.New("too many next hops")
+	}
+	b[3] = byte(len(b) - 4)
+	// A reserved byte, once the count of subnetwork points of attachment, comes before the NLRI
+	b = append(b, 0)
+	return PathAttribute{Flags: AttrFlagOptional, Type: AttrMPReach, Value: appendPrefixes(b, mp.NLRI)}, nil
+}
+
+// decodeNextHops splits a next hop field in to addresses, going by its length.  4 bytes is IPv4, 16
+// bytes IPv6 and 32 bytes an IPv6 global address followed by a link local one
+func decodeNextHops(b []byte) ([]netip.Addr, error) {
+	switch len(b) {
+	case 4:
+		return []netip.Addr{netip.AddrFrom4([4]byte(b))}, nil
+	case 16:
+		return []netip.Addr{netip.AddrFrom16([16]byte(b))}, nil
+	case 32:
+		return []netip.Addr{netip.AddrFrom16([16]byte(b)), netip.AddrFrom16([16]byte(b[16:]))}, nil
+	}
+	return nil, fmt.Errorf("next hop length %d isn't 4, 16 or 32", len(b))
+}
+
+// MPReach returns the MP_REACH_NLRI attribute
+func (pa PathAttributes) MPReach() (MPReach, bool, error) {
+	a, ok := pa.find(AttrMPReach)
+	if !ok {
+		return MPReach{}, false, nil
+	}
+	if len(a.Value) < 5 || len(a.Value) < 5+int(a.Value[3]) {
+		return MPReach{}, true, errors.New("MP_REACH_NLRI is truncated")
+	}
+	mp := MPReach{AFI: binary.BigEndian.Uint16(a.Value), SAFI: a.Value[2]}
+	ipv6, err := familyIPv6(mp.AFI, mp.SAFI)
+	if err != nil {
+		return mp, true, err
+	}
+	n := int(a.Value[3])
+	if mp.NextHops, err = decodeNextHops(a.Value[4 : 4+n]); err != nil {
+		return mp, true, err
+	}
+	mp.NLRI, err = decodePrefixes(a.Value[5+n:], ipv6)
+	return mp, true, err
+}
+
+// NewMPUnreachAttribute returns an MP_UNREACH_NLRI attribute
+func NewMPUnreachAttribute(mp MPUnreach) (PathAttribute, error) {
+	if _, err := familyIPv6(mp.AFI, mp.SAFI); err != nil {
+		return PathAttribute{}, err
+	}
+	b := binary.BigEndian.AppendUint16(nil, mp.AFI)
+	b = append(b, mp.SAFI)
+	return PathAttribute{Flags: AttrFlagOptional, Type: AttrMPUnreach, Value: appendPrefixes(b, mp.Withdrawn)}, nil
+}
+
+// MPUnreach returns the MP_UNREACH_NLRI attribute
+func (pa PathAttributes) MPUnreach() (MPUnreach, bool, error) {
+	a, ok := pa.find(AttrMPUnreach)
+	if !ok {
+		return MPUnreach{}, false, nil
+	}
+	if len(a.Value) < 3 {
+		return MPUnreach{}, true, errors.New("MP_UNREACH_NLRI is truncated")
+	}
+	mp := MPUnreach{AFI: binary.BigEndian.Uint16(a.Value), SAFI: a.Value[2]}
+	ipv6, err := familyIPv6(mp.AFI, mp.SAFI)
+	if err != nil {
+		return mp, true, err
+	}
+	mp.Withdrawn, err = decodePrefixes(a.Value[3:], ipv6)
+	return mp, true, err
+}
+
+// Aggregator returns the AS and router ID of the AGGREGATOR attribute, whose AS is 2 or 4 bytes for the
+// same reason as the AS path's
+func (pa PathAttributes) Aggregator(as4 bool) (uint32, netip.Addr, bool) {
+	a, ok := pa.find(AttrAggregator)
+	switch {
+	case !ok:
+	case as4 && len(a.Value) == 8:
+		return binary.BigEndian.Uint32(a.Value), netip.AddrFrom4([4]byte(a.Value[4:])), true
+	case !as4 && len(a.Value) == 6:
+		return uint32(binary.BigEndian.Uint16(a.Value)), netip.AddrFrom4([4]byte(a.Value[2:])), true
+	}
+	return 0, netip.Addr{}, false
+}
+
+// useAS4 reports whether AS4_PATH and AS4_AGGREGATOR are to be merged into a route from a 2 byte
+// speaker.  RFC 6793 says to ignore both when AGGREGATOR holds a real AS yet AS4_AGGREGATOR is there,
+// since a 2 byte speaker must have aggregated the route after the 4 byte one did
+func (pa PathAttributes) useAS4(as4 bool) bool {
+	if as4 {
+		return false
+	}
+	_, has4 := pa.find(AttrAS4Aggregator)
+	as, _, ok := pa.Aggregator(false)
+	return !has4 || !ok || as == ASTrans
+}
+
+// MergedASPath returns the AS path with the real AS numbers.  A 2 byte speaker's AS_PATH has AS_TRANS
+// in place of every 4 byte AS, and the real ones are in AS4_PATH, which covers the tail of the path as
+// far back as the last 4 byte speaker.  As RFC 6793 section 4.2.3 says we keep the leading ASes of
+// AS_PATH that AS4_PATH doesn't cover, and ignore an AS4_PATH longer than AS_PATH or one we can't decode
+func (pa PathAttributes) MergedASPath(as4 bool) (ASPath, error) {
+	path, err := pa.ASPath(as4)
+	if err != nil || !pa.useAS4(as4) {
+		return path, err
+	}
+	a, ok := pa.find(AttrAS4Path)
+	if !ok {
+		return path, nil
+	}
+	path4, err := decodeASPath(a.Value, true)
+	if err != nil {
+		return path, nil
+	}
+	// Confederation segments have no place in AS4_PATH
+	var tail ASPath
+	for _, s := range path4 {
+		if s.Type == ASSequence || s.Type == ASSet {
+			tail = append(tail, s)
+		}
+	}
+	keep := path.Length() - tail.Length()
+	if keep < 0 {
+		return path, nil
+	}
+	// Confederation segments count nothing so those before the cut stay with the leading ASes
+	var merged ASPath
+	for _, s := range path {
+		if keep == 0 && (s.Type == ASSequence || s.Type == ASSet) {
+			break
+		}
+		switch s.Type {
+		case ASSequence:
+			n := min(keep, len(s.ASNs))
+			merged = append(merged, ASPathSegment{Type: ASSequence, ASNs: append([]uint32(nil), s.ASNs[:n]...)})
+			keep -= n
+		case ASSet:
+			merged = append(merged, s)
+			keep--
+		default:
+			merged = append(merged, s)
+		}
+	}
+	for _, s := range tail {
+		if last := len(merged) - 1; last >= 0 && merged[last].Type == ASSequence && s.Type == ASSequence {
+			merged[last].ASNs = append(merged[last].ASNs, s.ASNs...)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged, nil
+}
+
+// MergedAggregator returns the aggregator with its real AS number, from AS4_AGGREGATOR when a 2 byte
+// speaker's AGGREGATOR holds AS_TRANS
+func (pa PathAttributes) MergedAggregator(as4 bool) (uint32, netip.Addr, bool) {
+	as, id, ok := pa.Aggregator(as4)
+	if !ok || as != ASTrans || !pa.useAS4(as4) {
+		return as, id, ok
+	}
+	if a, found := pa.find(AttrAS4Aggregator); found && len(a.Value) == 8 {
+		return binary.BigEndian.Uint32(a.Value), netip.AddrFrom4([4]byte(a.Value[4:])), true
+	}
+	return as, id, ok
+}
+
+// describe returns the value of an attribute in words, falling back to hex for attributes we don't know
+// or can't decode
+func (a PathAttribute) describe(as4 bool) string {
+	pa := PathAttributes{a}
+	switch a.Type {
+	case AttrOrigin:
+		if o, ok := pa.Origin(); ok && int(o) < len(originNames) {
+			return originNames[o]
+		}
+	case AttrASPath:
+		if path, err := pa.ASPath(as4); err == nil {
+			return path.String()
+		}
+	case AttrAS4Path:
+		if path, err := decodeASPath(a.Value, true); err == nil {
+			return path.String()
+		}
+	case AttrNextHop:
+		if nh, ok := pa.NextHop(); ok {
+			return nh.String()
+		}
+	case AttrMED, AttrLocalPref:
+		if v, ok := pa.uint32Attribute(a.Type); ok {
+			return strconv.FormatUint(uint64(v), 10)
+		}
+	case AttrAtomicAggregate:
+		return "set"
+	case AttrAggregator:
+		if as, id, ok := pa.Aggregator(as4); ok {
+			return fmt.Sprintf("AS%d %s", as, id)
+		}
+	case AttrCommunities:
+		if cs, err := pa.Communities(); err == nil {
+			return joinStrings(cs)
+		}
+	case AttrLargeCommunities:
+		if lcs, err := pa.LargeCommunities(); err == nil {
+			return joinStrings(lcs)
+		}
+	case AttrMPReach:
+		if mp, _, err := pa.MPReach(); err == nil {
+			return fmt.Sprintf("AFI %d SAFI %d next hop %s NLRI %s", mp.AFI, mp.SAFI, joinStrings(mp.NextHops), joinStrings(mp.NLRI))
+		}
+	case AttrMPUnreach:
+		if mp, _, err := pa.MPUnreach(); err == nil {
+			return fmt.Sprintf("AFI %d SAFI %d withdrawn %s", mp.AFI, mp.SAFI, joinStrings(mp.Withdrawn))
+		}
+	}
+	return fmt.Sprintf("[% x]", a.Value)
+}
+
+// joinStrings joins the String forms of values with spaces
+func joinStrings[T fmt.Stringer](vals []T) string {
+	s := make([]string, len(vals))
+	for i, v := range vals {
+		s[i] = v.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// BGPMessage is an OPEN, UPDATE, NOTIFICATION, KEEPALIVE or ROUTE-REFRESH message
+type BGPMessage interface {
+	MessageType() uint8
+	appendBody(b []byte) ([]byte, error)
+}
+
+// BGP capability codes
+const (
+	CapMultiprotocol = 1
+	CapRouteRefresh  = 2
+	CapAS4           = 65
+	CapAddPath       = 69
+)
+
+// BGPCapability is a capability advertised in an OPEN message
+type BGPCapability struct {
+	Code  uint8
+	Value []byte
+}
+
+// NewMPCapability returns the capability advertising support for an address family
+func NewMPCapability(afi uint16, safi uint8) BGPCapability {
+	return BGPCapability{Code: CapMultiprotocol, Value: []byte{byte(afi >> 8), byte(afi), 0, safi}}
+}
+
+// NewAS4Capability returns the capability advertising 4 byte AS number support, holding the speaker's
+// real AS number
+func NewAS4Capability(as uint32) BGPCapability {
+	return BGPCapability{Code: CapAS4, Value: binary.BigEndian.AppendUint32(nil, as)}
+}
+
+// String describes the capability
+func (c BGPCapability) String() string {
+	switch {
+	case c.Code == CapMultiprotocol && len(c.Value) == 4:
+		return fmt.Sprintf("multiprotocol AFI %d SAFI %d", binary.BigEndian.Uint16(c.Value), c.Value[3])
+	case c.Code == CapRouteRefresh && len(c.Value) == 0:
+		return "route refresh"
+	case c.Code == CapAS4 && len(c.Value) == 4:
+		return fmt.Sprintf("4 byte AS %d", binary.BigEndian.Uint32(c.Value))
+	case c.Code == CapAddPath:
+		return fmt.Sprintf("add path [% x]", c.Value)
+	}
+	return fmt.Sprintf("capability %d [% x]", c.Code, c.Value)
+}
+
+// BGPOpen is the OPEN message a speaker sends first to describe itself
+type BGPOpen struct {
+	Version      uint8
+	AS           uint16
+	HoldTime     uint16
+	BGPID        uint32
+	Capabilities []BGPCapability
+}
+
+// NewBGPOpen returns an OPEN for a speaker, advertising 4 byte AS support, route refresh and each of the
+// families given.  An AS too big for the AS field is sent as AS_TRANS, with the real one in the 4 byte
+// AS capability
+func NewBGPOpen(as uint32, holdTime uint16, bgpID uint32, families ...[2]int) *BGPOpen {
+	o := &BGPOpen{Version: BGPVersion, AS: ASTrans, HoldTime: holdTime, BGPID: bgpID}
+	if as <= 0xFFFF {
+		o.AS = uint16(as)
+	}
+	for _, f := range families {
+		o.Capabilities = append(o.Capabilities, NewMPCapability(uint16(f[0]), uint8(f[1])))
+	}
+	o.Capabilities = append(o.Capabilities, BGPCapability{Code: CapRouteRefresh}, NewAS4Capability(as))
+	return o
+}
+
+// MessageType returns BGPMsgOpen
+func (o *BGPOpen) MessageType() uint8 {
+	return BGPMsgOpen
+}
+
+// AS4 returns the speaker's AS number from the 4 byte AS capability, if it sent one
+func (o *BGPOpen) AS4() (uint32, bool) {
+	for _, c := range o.Capabilities {
+		if c.Code == CapAS4 && len(c.Value) == 4 {
+			return binary.BigEndian.Uint32(c.Value), true
+		}
+	}
+	return 0, false
+}
+
+// appendBody appends the fixed fields, then every capability in a single optional parameter
+func (o *BGPOpen) appendBody(b []byte) ([]byte, error) {
+	b = append(b, o.Version)
+	b = binary.BigEndian.AppendUint16(b, o.AS)
+	b = binary.BigEndian.AppendUint16(b, o.HoldTime)
+	b = binary.BigEndian.AppendUint32(b, o.BGPID)
+	var caps []byte
+	for _, c := range o.Capabilities {
+		if len(c.Value) > 0xFF {
+			return nil, fmt.Errorf("capability %d is too long", c.Code)
+		}
+		caps = append(caps, c.Code, byte(len(c.Value)))
+		caps = append(caps, c.Value...)
+	}
+	if len(caps) == 0 {
+		return append(b, 0), nil
+	}
+	if len(caps) > 0xFF-2 {
+		return nil, errors.New("capabilities are too long for an optional parameter")
+	}
+	b = append(b, byte(len(caps)+2), 2, byte(len(caps)))
+	return append(b, caps...), nil
+}
+
+// parseOpen decodes the body of an OPEN.  The only optional parameter still in use holds capabilities
+func parseOpen(b []byte) (*BGPOpen, error) {
+	if len(b) < bgpOpenLen || len(b) != bgpOpenLen+int(b[9]) {
+		return nil, errors.New("OPEN length doesn't match its optional parameters")
+	}
+	o := &BGPOpen{Version: b[0], AS: binary.BigEndian.Uint16(b[1:]), HoldTime: binary.BigEndian.Uint16(b[3:]),
+		BGPID: binary.BigEndian.Uint32(b[5:])}
+	if o.Version != BGPVersion {
+		return nil, fmt.Errorf("BGP version %d isn't supported", o.Version)
+	}
+	if o.HoldTime == 1 || o.HoldTime == 2 {
+		return nil, fmt.Errorf("hold time %d must be 0 or at least 3", o.HoldTime)
+	}
+	for params := b[bgpOpenLen:]; len(params) > 0; {
+		if len(params) < 2 || len(params) < 2+int(params[1]) {
+			return nil, errors.New("OPEN optional parameter is truncated")
+		}
+		if params[0] != 2 {
+			return nil, fmt.Errorf("OPEN optional parameter type %d isn't supported", params[0])
+		}
+		for caps := params[2 : 2+params[1]]; len(caps) > 0; {
+			if len(caps) < 2 || len(caps) < 2+int(caps[1]) {
+				return nil, errors.New("capability is truncated")
+			}
+			o.Capabilities = append(o.Capabilities, BGPCapability{Code: caps[0], Value: caps[2 : 2+caps[1]]})
+			caps = caps[2+caps[1]:]
+		}
+		params = params[2+params[1]:]
+	}
+	return o, nil
+}
+
+// BGPUpdate is an UPDATE message, which withdraws IPv4 routes and announces IPv4 routes sharing one set
+// of path attributes.  Other families are carried in the MP_REACH_NLRI and MP_UNREACH_NLRI attributes
+type BGPUpdate struct {
+	Withdrawn  []Prefix
+	Attributes PathAttributes
+	NLRI       []Prefix
+}
+
+// MessageType returns BGPMsgUpdate
+func (u *BGPUpdate) MessageType() uint8 {
+	return BGPMsgUpdate
+}
+
+// appendBody appends the withdrawn routes, the path attributes and the NLRI, the first two preceded by
+// their lengths
+func (u *BGPUpdate) appendBody(b []byte) ([]byte, error) {
+	for _, prefixes := range [][]Prefix{u.Withdrawn, u.NLRI} {
+		for _, p := range prefixes {
+			if p.IPv6 {
+				return nil, fmt.Errorf("IPv6 prefix %s belongs in MP_REACH_NLRI or MP_UNREACH_NLRI", p)
+			}
+		}
+	}
+	withdrawn := appendPrefixes(nil, u.Withdrawn)
+	attrs, err := appendAttributes(nil, u.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(withdrawn)))
+	b = append(b, withdrawn...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attrs)))
+	b = append(b, attrs...)
+	return appendPrefixes(b, u.NLRI), nil
+}
+
+// parseUpdate decodes the body of an UPDATE
+func parseUpdate(b []byte) (*BGPUpdate, error) {
+	if len(b) < 2 || len(b) < 4+int(binary.BigEndian.Uint16(b)) {
+		return nil, errors.New("UPDATE withdrawn routes are truncated")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	withdrawn, rest := b[2:2+n], b[2+n:]
+	if len(rest) < 2+int(binary.BigEndian.Uint16(rest)) {
+		return nil, errors.New("UPDATE path attributes are truncated")
+	}
+	n = int(binary.BigEndian.Uint16(rest))
+	attrs, nlri := rest[2:2+n], rest[2+n:]
+
+	u := &BGPUpdate{}
+	var err error
+	if u.Withdrawn, err = decodePrefixes(withdrawn, false); err != nil {
+		return nil, fmt.Errorf("withdrawn routes: %v", err)
+	}
+	if u.Attributes, err = parseAttributes(attrs); err != nil {
+		return nil, err
+	}
+	if u.NLRI, err = decodePrefixes(nlri, false); err != nil {
+		return nil, fmt.Errorf("NLRI: %v", err)
+	}
+	return u, nil
+}
+
+// NOTIFICATION error codes
+const (
+	NotifyHeaderError    = 1
+	NotifyOpenError      = 2
+	NotifyUpdateError    = 3
+	NotifyHoldExpired    = 4
+	NotifyFSMError       = 5
+	NotifyCease          = 6
+	CeaseAdminShutdown   = 2
+	CeaseAdminReset      = 4
+	notifyMaxShutdownMsg = 255
+)
+
+// notifyNames and ceaseNames are the names of the error codes and of the Cease subcodes (RFC 4486)
+var (
+	notifyNames = []string{"", "message header error", "OPEN message error", "UPDATE message error",
+		"hold timer expired", "finite state machine error", "cease"}
+	ceaseNames = []string{"", "maximum number of prefixes reached", "administrative shutdown",
+		"peer de-configured", "administrative reset", "connection rejected", "other configuration change",
+		"connection collision resolution", "out of resources"}
+)
+
+// BGPNotification is a NOTIFICATION message, sent to say why a session is being closed
+type BGPNotification struct {
+	Code, Subcode uint8
+	Data          []byte
+}
+
+// MessageType returns BGPMsgNotification
+func (n *BGPNotification) MessageType() uint8 {
+	return BGPMsgNotification
+}
+
+// appendBody appends the codes and data
+func (n *BGPNotification) appendBody(b []byte) ([]byte, error) {
+	return append(append(b, n.Code, n.Subcode), n.Data...), nil
+}
+
+// String describes the error.  An administrative shutdown or reset may carry a message from the
+// operator (RFC 9003), a length followed by UTF-8
+func (n *BGPNotification) String() string {
+	s := fmt.Sprintf("code %d", n.Code)
+	if int(n.Code) < len(notifyNames) && n.Code > 0 {
+		s = notifyNames[n.Code]
+	}
+	if n.Code == NotifyCease && int(n.Subcode) < len(ceaseNames) && n.Subcode > 0 {
+		s += ", " + ceaseNames[n.Subcode]
+	} else {
+		s += fmt.Sprintf(", subcode %d", n.Subcode)
+	}
+	if n.Code == NotifyCease && (n.Subcode == CeaseAdminShutdown || n.Subcode == CeaseAdminReset) &&
+		len(n.Data) > 0 && len(n.Data) == 1+int(n.Data[0]) {
+		return fmt.Sprintf("%s: %q", s, n.Data[1:])
+	}
+	if len(n.Data) > 0 {
+		s += fmt.Sprintf(" [% x]", n.Data)
+	}
+	return s
+}
+
+// BGPKeepalive is a KEEPALIVE message, which is only a header
+type BGPKeepalive struct{}
+
+// MessageType returns BGPMsgKeepalive
+func (k *BGPKeepalive) MessageType() uint8 {
+	return BGPMsgKeepalive
+}
+
+// appendBody appends nothing
+func (k *BGPKeepalive) appendBody(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+// BGPRouteRefresh is a ROUTE-REFRESH message (RFC 2918), asking a peer to send its routes of a family
+// again
+type BGPRouteRefresh struct {
+	AFI  uint16
+	SAFI uint8
+}
+
+// MessageType returns BGPMsgRouteRefresh
+func (r *BGPRouteRefresh) MessageType() uint8 {
+	return BGPMsgRouteRefresh
+}
+
+// appendBody appends the family, with a reserved byte between the AFI and SAFI
+func (r *BGPRouteRefresh) appendBody(b []byte) ([]byte, error) {
+	return append(binary.BigEndian.AppendUint16(b, r.AFI), 0, r.SAFI), nil
+}
+
+// MarshalBGP encodes a message with its header
+func MarshalBGP(m BGPMessage) ([]byte, error) {
+	b := bytes.Repeat([]byte{0xFF}, 16)
+	b = append(b, 0, 0, m.MessageType())
+	b, err := m.appendBody(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > BGPMaxMessageLen {
+		return nil, fmt.Errorf("message of %d bytes is longer than %d", len(b), BGPMaxMessageLen)
+	}
+	binary.BigEndian.PutUint16(b[16:], uint16(len(b)))
+	return b, nil
+}
+
+// ParseBGP decodes the message at the start of data, returning it and its length so a stream of
+// messages can be read one after another
+func ParseBGP(data []byte) (BGPMessage, int, error) {
+	if len(data) < BGPHeaderLen {
+		return nil, 0, errors.New("BGP header is truncated")
+	}
+	for _, c := range data[:16] {
+		if c != 0xFF {
+			return nil, 0, errors.New("BGP marker isn't all ones")
+		}
+	}
+	n := int(binary.BigEndian.Uint16(data[16:]))
+	if n < BGPHeaderLen || n > BGPMaxMessageLen {
+		return nil, 0, fmt.Errorf("BGP message length %d is invalid", n)
+	}
+	if n > len(data) {
+		return nil, 0, fmt.Errorf("BGP message of %d bytes is truncated to %d", n, len(data))
+	}
+	body := data[BGPHeaderLen:n]
+	var m BGPMessage
+	var err error
+	switch data[18] {
+	case BGPMsgOpen:
+		m, err = parseOpen(body)
+	case BGPMsgUpdate:
+		m, err = parseUpdate(body)
+	case BGPMsgNotification:
+		if len(body) < 2 {
+			return nil, 0, errors.New("NOTIFICATION is truncated")
+		}
+		m = &BGPNotification{Code: body[0], Subcode: body[1], Data: body[2:]}
+	case BGPMsgKeepalive:
+		if len(body) != 0 {
+			return nil, 0, errors.New("KEEPALIVE has a body")
+		}
+		m = &BGPKeepalive{}
+	case BGPMsgRouteRefresh:
+		if len(body) != 4 {
+			return nil, 0, errors.New("ROUTE-REFRESH length is wrong")
+		}
+		m = &BGPRouteRefresh{AFI: binary.BigEndian.Uint16(body), SAFI: body[3]}
+	default:
+		return nil, 0, fmt.Errorf("unknown BGP message type %d", data[18])
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return m, n, nil
+}
+
+// bgpHeader is the message header, for explaining
+type bgpHeader struct {
+	Marker [16]uint8
+	Length uint16
+	Type   uint8
+}
+
+// padLabels pads the labels of rows to the same width
+func padLabels(rows []ExplainRow) []ExplainRow {
+	width := 0
+	for _, r := range rows {
+		width = max(width, len(r.Label))
+	}
+	for i := range rows {
+		rows[i].Label = fmt.Sprintf("%-*s", width, rows[i].Label)
+	}
+	return rows
+}
+
+// ExplainBGP describes a message, its header bit by bit and then its contents, with the NLRI encoding
+// of every prefix an UPDATE carries.  wire is the message as received, which the header describes since
+// there is more than one way to encode a message, or nil to describe it as MarshalBGP encodes it.  as4
+// says whether AS numbers in the attributes take 4 bytes
+func ExplainBGP(m BGPMessage, wire []byte, as4 bool) ([]Explanation, error) {
+	if wire == nil {
+		var err error
+		if wire, err = MarshalBGP(m); err != nil {
+			return nil, err
+		}
+	}
+	if len(wire) < BGPHeaderLen {
+		return nil, errors.New("BGP header is truncated")
+	}
+	var hdr bgpHeader
+	if err := Unmarshal(wire, &hdr); err != nil {
+		return nil, err
+	}
+	name := bgpMessageNames[m.MessageType()]
+	rows, err := headerRows(&hdr, map[string]string{"Marker": "all ones", "Type": name})
+	if err != nil {
+		return nil, err
+	}
+	res := []Explanation{headerExplanation(fmt.Sprintf("BGP %s, %s", name, byteCount(len(wire))), rows)}
+
+	var body []ExplainRow
+	var prefixes []Prefix
+	switch m := m.(type) {
+	case *BGPOpen:
+		body = []ExplainRow{
+			{Label: "Version", Value: strconv.Itoa(int(m.Version))},
+			{Label: "AS", Value: strconv.Itoa(int(m.AS))},
+			{Label: "Hold time", Value: strconv.Itoa(int(m.HoldTime))},
+			{Label: "BGP ID", Value: ipv4String(m.BGPID)},
+		}
+		for _, c := range m.Capabilities {
+			body = append(body, ExplainRow{Label: "Capability", Value: c.String()})
+		}
+	case *BGPUpdate:
+		for _, p := range m.Withdrawn {
+			body = append(body, ExplainRow{Label: "Withdrawn", Value: p.String()})
+		}
+		for _, a := range m.Attributes {
+			body = append(body, ExplainRow{Label: attrName(a.Type), Value: a.describe(as4)})
+		}
+		for _, p := range m.NLRI {
+			body = append(body, ExplainRow{Label: "NLRI", Value: p.String()})
+		}
+		prefixes = append(append([]Prefix(nil), m.Withdrawn...), m.NLRI...)
+		if mp, ok, err := m.Attributes.MPReach(); ok && err == nil {
+			prefixes = append(prefixes, mp.NLRI...)
+		}
+		if mp, ok, err := m.Attributes.MPUnreach(); ok && err == nil {
+			prefixes = append(prefixes, mp.Withdrawn...)
+		}
+	case *BGPNotification:
+		body = []ExplainRow{{Label: "Error", Value: m.String()}}
+	case *BGPRouteRefresh:
+		body = []ExplainRow{{Label: "AFI", Value: strconv.Itoa(int(m.AFI))}, {Label: "SAFI", Value: strconv.Itoa(int(m.SAFI))}}
+	}
+	if len(body) > 0 {
+		res = append(res, headerExplanation(name+" contents", padLabels(body)))
+	}
+	for _, p := range prefixes {
+		res = append(res, ExplainNLRI(p))
+	}
+	return res, nil
+}
+
+// runBGP is the bgp sub command, it decodes BGP messages given as hex arguments, or read from standard
+// input as hex or a hexdump, explaining each one
+func runBGP(args []string) error {
+	fs := flag.NewFlagSet("bgp", flag.ContinueOnError)
+	as2 := fs.Bool("as2", false, "AS numbers in the attributes take 2 bytes rather than 4")
+	format := fs.String("format", "text", "Output format: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		inputs = []string{string(data)}
+	}
+	for _, in := range inputs {
+		data, err := parseHexBytes(in)
+		if err != nil {
+			var buf bytes.Buffer
+			if err := ReverseHexDump(&buf, strings.NewReader(in), DefaultDumpOptions()); err != nil {
+				return fmt.Errorf("input is neither hex nor a hexdump: %v", err)
+			}
+			data = buf.Bytes()
+		}
+		for len(data) > 0 {
+			m, n, err := ParseBGP(data)
+			if err != nil {
+				return err
+			}
+			explanations, err := ExplainBGP(m, data[:n], !*as2)
+			if err != nil {
+				return err
+			}
+			for _, e := range explanations {
+				if err := ex.Explain(e); err != nil {
+					return err
+				}
+			}
+			data = data[n:]
+		}
+	}
+	return nil
+}
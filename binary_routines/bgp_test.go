@@ -0,0 +1,311 @@
+// BGP codec test routines
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// keepalive is a KEEPALIVE message, only a header
+const keepalive = "ffffffffffffffffffffffffffffffff" + "0013" + "04"
+
+func TestNLRI(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"0.0.0.0/0", "00"},
+		{"10.0.0.0/8", "080a"},
+		{"10.1.128.0/17", "110a0180"},
+		{"192.0.2.1/32", "20c0000201"},
+		{"2001:db8::/32", "2020010db8"},
+		{"2001:db8:1:2::/63", "3f20010db800010002"},
+	}
+	for _, tt := range tests {
+		p, err := ParsePrefix(tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc := AppendNLRI(nil, p)
+		if !bytes.Equal(enc, mustHex(t, tt.want)) {
+			t.Errorf("%s encoded as % x, want %s", tt.prefix, enc, tt.want)
+		}
+		got, n, err := DecodeNLRI(enc, p.IPv6)
+		if err != nil || n != len(enc) || got != p {
+			t.Errorf("%s decoded as %v in %d bytes, %v", tt.prefix, got, n, err)
+		}
+	}
+
+	// Host bits are dropped when encoding, and stray bits past the length ignored when decoding
+	p, _ := ParsePrefix("10.1.255.255/17")
+	if enc := AppendNLRI(nil, p); !bytes.Equal(enc, mustHex(t, "110a0180")) {
+		t.Errorf("host bits encoded as % x", enc)
+	}
+	if got, _, _ := DecodeNLRI(mustHex(t, "110a01ff"), false); got.String() != "10.1.128.0/17" {
+		t.Errorf("stray bits decoded as %v", got)
+	}
+	for _, s := range []string{"", "21c0000201", "18c000", "81"} {
+		if _, _, err := DecodeNLRI(mustHex(t, s), false); err == nil {
+			t.Errorf("%q should be rejected", s)
+		}
+	}
+
+	var sb strings.Builder
+	_ = (&TextExplainer{W: &sb}).Explain(ExplainNLRI(p))
+	want := `NLRI encoding of 10.1.128.0/17, 4 bytes
+Address [ 10.1.255.255]: 00001010.00000001.1|1111111.11111111
+Mask    [255.255.128.0]: 11111111.11111111.1|0000000.00000000
+Network [   10.1.128.0]: 00001010.00000001.1|0000000.00000000
+Length  [           17]: 00010001
+Bytes   [     0a 01 80]: 00001010.00000001.10000000
+`
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+// sampleUpdate returns an UPDATE with an IPv4 route, an IPv6 route in MP_REACH_NLRI and every
+// attribute we know how to build
+func sampleUpdate(t *testing.T) *BGPUpdate {
+	t.Helper()
+	path, err := NewASPathAttribute(ASPath{{Type: ASSequence, ASNs: []uint32{64496, 4200000000}}, {Type: ASSet, ASNs: []uint32{64511, 65536}}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nh, _ := NewNextHopAttribute(netip.MustParseAddr("192.0.2.1"))
+	v6, _ := ParsePrefix("2001:db8::/32")
+	mp, err := NewMPReachAttribute(MPReach{AFI: AFIIPv6, SAFI: SAFIUnicast,
+		NextHops: []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("fe80::1")}, NLRI: []Prefix{v6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v4, _ := ParsePrefix("198.51.100.0/24")
+	old, _ := ParsePrefix("203.0.113.0/25")
+	return &BGPUpdate{
+		Withdrawn: []Prefix{old},
+		Attributes: PathAttributes{
+			NewOriginAttribute(OriginIGP), path, nh, NewMEDAttribute(10), NewLocalPrefAttribute(200),
+			NewCommunitiesAttribute(64496<<16|100, CommunityNoExport),
+			NewLargeCommunitiesAttribute(LargeCommunity{4200000000, 1, 2}), mp,
+		},
+		NLRI: []Prefix{v4},
+	}
+}
+
+func TestUpdateRoundTrip(t *testing.T) {
+	u := sampleUpdate(t)
+	b, err := MarshalBGP(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, n, err := ParseBGP(append(b, mustHex(t, keepalive)...))
+	if err != nil || n != len(b) {
+		t.Fatalf("parsed %d of %d bytes, %v", n, len(b), err)
+	}
+	got := m.(*BGPUpdate)
+	if !reflect.DeepEqual(got, u) {
+		t.Errorf("got %+v\nwant %+v", got, u)
+	}
+
+	attrs := got.Attributes
+	path, err := attrs.ASPath(true)
+	if err != nil || path.String() != "64496 4200000000 {64511,65536}" || path.Length() != 3 {
+		t.Errorf("AS path %v length %d, %v", path, path.Length(), err)
+	}
+	if _, ok := path.OriginAS(); ok {
+		t.Errorf("a path ending in a set has no origin AS")
+	}
+	if cs, err := attrs.Communities(); err != nil || joinStrings(cs) != "64496:100 65535:65281" {
+		t.Errorf("communities %v, %v", cs, err)
+	}
+	if lcs, err := attrs.LargeCommunities(); err != nil || joinStrings(lcs) != "4200000000:1:2" {
+		t.Errorf("large communities %v, %v", lcs, err)
+	}
+	if med, ok := attrs.MED(); !ok || med != 10 {
+		t.Errorf("MED %d", med)
+	}
+	mp, ok, err := attrs.MPReach()
+	if !ok || err != nil || len(mp.NextHops) != 2 || mp.NextHops[1].String() != "fe80::1" || mp.NLRI[0].String() != "2001:db8::/32" {
+		t.Errorf("MP_REACH_NLRI %+v, %v", mp, err)
+	}
+
+	// 4 byte AS numbers don't fit in a 2 byte path
+	if _, err := NewASPathAttribute(ASPath{{Type: ASSequence, ASNs: []uint32{4200000000}}}, false); err == nil {
+		t.Errorf("a 4 byte AS in a 2 byte path should be rejected")
+	}
+	two, _ := NewASPathAttribute(ASPath{{Type: ASConfedSequence, ASNs: []uint32{65001}}, {Type: ASSequence, ASNs: []uint32{64496, 64497}}}, false)
+	if path, _ := (PathAttributes{two}).ASPath(false); path.String() != "(65001) 64496 64497" || path.Length() != 2 {
+		t.Errorf("2 byte path read as %v", path)
+	}
+	// Neither encoding nor explaining writes past the end of the caller's withdrawn routes
+	withdrawn := make([]Prefix, 1, 4)
+	withdrawn[0] = u.Withdrawn[0]
+	aliased := &BGPUpdate{Withdrawn: withdrawn, Attributes: u.Attributes, NLRI: u.NLRI}
+	_, _ = MarshalBGP(aliased)
+	_, _ = ExplainBGP(aliased, nil, true)
+	if spare := withdrawn[:4]; spare[1] != (Prefix{}) || spare[2] != (Prefix{}) {
+		t.Errorf("the withdrawn routes' spare capacity was written to: %v", spare)
+	}
+	// IPv6 prefixes only go in MP_REACH_NLRI
+	u.NLRI = append(u.NLRI, mp.NLRI[0])
+	if _, err := MarshalBGP(u); err == nil {
+		t.Errorf("an IPv6 prefix in the NLRI should be rejected")
+	}
+
+	explanations, err := ExplainBGP(got, nil, true)
+	if err != nil || len(explanations) != 5 {
+		t.Fatalf("explained as %d explanations, %v", len(explanations), err)
+	}
+	if rows := explanations[0].Rows; rows[2].Value != "UPDATE" || rows[1].Value != "151" {
+		t.Errorf("header explained as %+v", rows)
+	}
+	if rows := explanations[1].Rows; strings.TrimSpace(rows[2].Label) != "AS_PATH" || rows[2].Value != "64496 4200000000 {64511,65536}" {
+		t.Errorf("contents explained as %+v", rows)
+	}
+}
+
+func TestMergedASPath(t *testing.T) {
+	seq := func(asns ...uint32) ASPathSegment { return ASPathSegment{Type: ASSequence, ASNs: asns} }
+	set := func(asns ...uint32) ASPathSegment { return ASPathSegment{Type: ASSet, ASNs: asns} }
+	confed := func(asns ...uint32) ASPathSegment { return ASPathSegment{Type: ASConfedSequence, ASNs: asns} }
+	as4Path := func(path ASPath) PathAttribute {
+		a, _ := NewASPathAttribute(path, true)
+		a.Flags, a.Type = AttrFlagOptional|AttrFlagTransitive, AttrAS4Path
+		return a
+	}
+	aggregator := func(typ uint8, value string) PathAttribute {
+		return PathAttribute{Flags: AttrFlagOptional | AttrFlagTransitive, Type: typ, Value: mustHex(t, value)}
+	}
+	tests := []struct {
+		path    ASPath
+		extra   PathAttributes
+		want    string
+		wantAgg uint32
+	}{
+		{ASPath{seq(64496, ASTrans)}, PathAttributes{as4Path(ASPath{seq(4200000000)})}, "64496 4200000000", 0},
+		{ASPath{seq(64496, ASTrans)}, nil, "64496 23456", 0},
+		// An AS4_PATH longer than AS_PATH is ignored
+		{ASPath{seq(ASTrans)}, PathAttributes{as4Path(ASPath{seq(4200000000, 4200000001)})}, "23456", 0},
+		// Confederation segments are dropped from AS4_PATH but kept from AS_PATH
+		{ASPath{seq(64496, ASTrans)}, PathAttributes{as4Path(ASPath{confed(4200000005), seq(4200000000)})}, "64496 4200000000", 0},
+		{ASPath{confed(65001), seq(64496, ASTrans, ASTrans)}, PathAttributes{as4Path(ASPath{seq(4200000000, 4200000001)})},
+			"(65001) 64496 4200000000 4200000001", 0},
+		{ASPath{seq(64496), set(ASTrans, 64497)}, PathAttributes{as4Path(ASPath{set(4200000000, 64497)})}, "64496 {4200000000,64497}", 0},
+		{ASPath{seq(64496, ASTrans)}, PathAttributes{as4Path(ASPath{seq(4200000000)}),
+			aggregator(AttrAggregator, "5ba0c0000209"), aggregator(AttrAS4Aggregator, "fa56ea00c0000209")}, "64496 4200000000", 4200000000},
+		// A 2 byte speaker aggregated the route after the 4 byte one did, so AS4_PATH is out of date
+		{ASPath{seq(64496, ASTrans)}, PathAttributes{as4Path(ASPath{seq(4200000000)}),
+			aggregator(AttrAggregator, "fbf0c0000209"), aggregator(AttrAS4Aggregator, "fa56ea00c0000209")}, "64496 23456", 64496},
+	}
+	for _, tt := range tests {
+		a, err := NewASPathAttribute(tt.path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs := append(PathAttributes{a}, tt.extra...)
+		if got, err := attrs.MergedASPath(false); err != nil || got.String() != tt.want {
+			t.Errorf("%v with %+v merged as %v, %v, want %s", tt.path, tt.extra, got, err, tt.want)
+		}
+		if as, id, ok := attrs.MergedAggregator(false); ok != (tt.wantAgg != 0) || as != tt.wantAgg || ok && id.String() != "192.0.2.9" {
+			t.Errorf("%v with %+v has aggregator %d %v, want %d", tt.path, tt.extra, as, id, tt.wantAgg)
+		}
+	}
+
+	// Between 4 byte speakers AS_PATH already has the real AS numbers
+	a, _ := NewASPathAttribute(ASPath{seq(64496, 4200000000)}, true)
+	if got, _ := (PathAttributes{a, as4Path(ASPath{seq(4200000001)})}).MergedASPath(true); got.String() != "64496 4200000000" {
+		t.Errorf("AS4_PATH merged into a 4 byte path as %v", got)
+	}
+}
+
+func TestOpenAndNotification(t *testing.T) {
+	o := NewBGPOpen(4200000000, 90, 0xC0000201, [2]int{AFIIPv4, SAFIUnicast}, [2]int{AFIIPv6, SAFIUnicast})
+	b, err := MarshalBGP(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := ParseBGP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.(*BGPOpen)
+	again, _ := MarshalBGP(got)
+	if as, ok := got.AS4(); got.AS != ASTrans || !ok || as != 4200000000 || len(got.Capabilities) != 4 || !bytes.Equal(again, b) {
+		t.Errorf("OPEN read as %+v", got)
+	}
+	if s := got.Capabilities[1].String(); s != "multiprotocol AFI 2 SAFI 1" {
+		t.Errorf("capability %s", s)
+	}
+
+	// Many routers send each capability in an optional parameter of its own, which MarshalBGP doesn't, so
+	// the header is explained from the bytes received
+	split := append([]byte(nil), b[:BGPHeaderLen+10]...)
+	for caps := b[BGPHeaderLen+12:]; len(caps) > 0; caps = caps[2+caps[1]:] {
+		split = append(append(split, 2, 2+caps[1]), caps[:2+caps[1]]...)
+	}
+	split[BGPHeaderLen+9] = byte(len(split) - BGPHeaderLen - 10)
+	binary.BigEndian.PutUint16(split[16:], uint16(len(split)))
+	m, n, err := ParseBGP(split)
+	if err != nil || len(m.(*BGPOpen).Capabilities) != 4 {
+		t.Fatalf("OPEN with a capability per parameter read as %+v, %v", m, err)
+	}
+	explanations, err := ExplainBGP(m, split[:n], true)
+	want := fmt.Sprintf("BGP OPEN, %d bytes", len(split))
+	if err != nil || len(split) == len(b) || explanations[0].Title != want || explanations[0].Rows[1].Value != strconv.Itoa(len(split)) {
+		t.Errorf("OPEN of %d bytes explained as %+v, %v", len(split), explanations[0], err)
+	}
+
+	tests := []struct {
+		n    BGPNotification
+		want string
+	}{
+		{BGPNotification{Code: NotifyHoldExpired}, "hold timer expired, subcode 0"},
+		{BGPNotification{Code: NotifyCease, Subcode: CeaseAdminShutdown, Data: append([]byte{11}, "maintenance"...)},
+			`cease, administrative shutdown: "maintenance"`},
+		{BGPNotification{Code: NotifyUpdateError, Subcode: 11, Data: []byte{1, 2}}, "UPDATE message error, subcode 11 [01 02]"},
+	}
+	for _, tt := range tests {
+		b, _ := MarshalBGP(&tt.n)
+		m, _, err := ParseBGP(b)
+		if err != nil || m.(*BGPNotification).String() != tt.want {
+			t.Errorf("%+v read as %v, %v, want %s", tt.n, m, err, tt.want)
+		}
+	}
+}
+
+func TestParseBGPErrors(t *testing.T) {
+	bad := []string{
+		// Truncated header
+		"ffffffff",
+		// Marker not all ones
+		"fffffffffffffffffffffffffffffffe" + "0013" + "04",
+		// Length shorter than a header
+		"ffffffffffffffffffffffffffffffff" + "0012" + "04",
+		// Length longer than the data
+		"ffffffffffffffffffffffffffffffff" + "0014" + "04",
+		// KEEPALIVE with a body
+		"ffffffffffffffffffffffffffffffff" + "0014" + "04" + "00",
+		// Unknown type
+		"ffffffffffffffffffffffffffffffff" + "0013" + "09",
+		// OPEN of version 3
+		"ffffffffffffffffffffffffffffffff" + "001d" + "01" + "03fdf0005ac000020100",
+		// UPDATE whose attribute is longer than the attributes
+		"ffffffffffffffffffffffffffffffff" + "001b" + "02" + "0000" + "0004" + "40010200",
+		// UPDATE with ORIGIN twice
+		"ffffffffffffffffffffffffffffffff" + "0021" + "02" + "0000" + "0008" + "40010100" + "40010100",
+	}
+	for _, s := range bad {
+		if _, _, err := ParseBGP(mustHex(t, s)); err == nil {
+			t.Errorf("%s should be rejected", s)
+		}
+	}
+	if m, n, err := ParseBGP(mustHex(t, keepalive)); err != nil || n != BGPHeaderLen || m.MessageType() != BGPMsgKeepalive {
+		t.Errorf("KEEPALIVE read as %v, %v", m, err)
+	}
+}
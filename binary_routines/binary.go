@@ -4,107 +4,261 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
 	"strings"
+	"unsafe"
 )
 
-// BitwiseAnd performs a bitwise AND between num1 and num2 and prints the results.
+// Integer is a type constraint that matches every signed and unsigned integer type, along with any
+// named types built on them (the ~ means "any type whose underlying type is").  Using this as the type
+// parameter for our functions lets one function handle every width without any type assertions
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// BitWidth returns the number of bits in the integer type T.  unsafe.Sizeof gives us the size in bytes
+// of a zero value of the type, which is known at compile time
+func BitWidth[T Integer]() int {
+	var zero T
+	return int(unsafe.Sizeof(zero)) * 8
+}
+
+// Binary renders num as a binary string at the native width of its type, so a uint8 gives 8 digits and
+// a uint64 gives 64.  Negative numbers are shown in their two's complement form rather than with a minus
+// sign, which we get by converting to a uint64 and masking off anything above the width of the type
+func Binary[T Integer](num T) string {
+	width := BitWidth[T]()
+	v := uint64(num)
+	if width < 64 {
+		v &= 1<<width - 1
+	}
+	return fmt.Sprintf("%0*b", width, v)
+}
+
+// BitwiseAnd performs a bitwise AND between num1 and num2 and returns the result.
 // A bitwise AND states that if two bits are set, the resultant bit will be set,
-// otherwise the resultant bit will be unset.  We use unsigned 16 bit integers here
-// purely to make printing and reading easier
-func BitwiseAnd(num1, num2 uint16) uint16 {
-	fmt.Printf("Performing Bitwise AND between %d and %d\n", num1, num2)
-	fmt.Printf("Binary [%2d]: %016b\n", num1, num1)
-	fmt.Printf("Binary [%2d]: %016b\n", num2, num2)
-	fmt.Printf("Result [%2d]: %016b\n", num1&num2, num1&num2)
+// otherwise the resultant bit will be unset.  This works on any integer type
+func BitwiseAnd[T Integer](num1, num2 T) T {
 	return num1 & num2
 }
 
-// ExclusiveOr performs a XOR (Exclusive Or) between two numbers and prints the results.
+// ExplainBitwiseAnd describes a bitwise AND step by step, with the binary output shown at the
+// width of the type (16 digits for a uint16, 64 for a uint64)
+func ExplainBitwiseAnd[T Integer](num1, num2 T) Explanation {
+	return binaryExplanation(fmt.Sprintf("Performing Bitwise AND between %d and %d", num1, num2), 2,
+		num1, num2, BitwiseAnd(num1, num2))
+}
+
+// ExclusiveOr performs a XOR (Exclusive Or) between two numbers and returns the result.
 // When an exclusive OR is performed, the result will be 0 if both bits are
 // the same (0 ^ 0 = 0, 1 ^ 1 = 0) or 1 if they weren't (0^1 = 1)
-func ExclusiveOr(num1, num2 uint16) uint16 {
-	fmt.Printf("Performing Exclusive OR between %d and %d\n", num1, num2)
-	fmt.Printf("Binary [%2d]: %016b\n", num1, num1)
-	fmt.Printf("Binary [%2d]: %016b\n", num2, num2)
-	fmt.Printf("Result [%2d]: %016b\n", num1^num2, num1^num2)
+func ExclusiveOr[T Integer](num1, num2 T) T {
 	return num1 ^ num2
 }
 
-// BitwiseOr performs a bitwise OR between two numbers and prints the results.
+// ExplainExclusiveOr describes an exclusive OR step by step
+func ExplainExclusiveOr[T Integer](num1, num2 T) Explanation {
+	return binaryExplanation(fmt.Sprintf("Performing Exclusive OR between %d and %d", num1, num2), 2,
+		num1, num2, ExclusiveOr(num1, num2))
+}
+
+// BitwiseOr performs a bitwise OR between two numbers and returns the result.
 // When a bitwise OR is performed, the result will be 1 if either of the bits
 // were 1, otherwise the result will be 0
-func BitwiseOr(num1, num2 uint16) uint16 {
-	fmt.Printf("Performing Logical OR between %d and %d\n", num1, num2)
-	fmt.Printf("Binary [%2d]: %016b\n", num1, num1)
-	fmt.Printf("Binary [%2d]: %016b\n", num2, num2)
-	fmt.Printf("Result [%2d]: %016b\n", num1|num2, num1|num2)
+func BitwiseOr[T Integer](num1, num2 T) T {
 	return num1 | num2
 }
 
+// ExplainBitwiseOr describes a bitwise OR step by step
+func ExplainBitwiseOr[T Integer](num1, num2 T) Explanation {
+	return binaryExplanation(fmt.Sprintf("Performing Logical OR between %d and %d", num1, num2), 2,
+		num1, num2, BitwiseOr(num1, num2))
+}
+
+// binaryExplanation builds the explanation shared by the two operand operators, showing both inputs
+// and the result in binary
+func binaryExplanation[T Integer](title string, width int, num1, num2, res T) Explanation {
+	return Explanation{
+		Title: title,
+		Width: width,
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(num1), Bits: Binary(num1)},
+			{Label: "Binary", Value: fmt.Sprint(num2), Bits: Binary(num2)},
+			{Label: "Result", Value: fmt.Sprint(res), Bits: Binary(res)},
+		},
+	}
+}
+
 // LeftShift shifts num1 left by the number of places specified in num2
 // A Left Shift shifts all bits to the left by pushing zeros in from the right
 // and dropping the left most bits.  This effectively doubles the num in question
-// for each bit shifted left
-func LeftShift(num1, num2 uint16) uint16 {
-	fmt.Printf("Left shifting %d by %d bits\n", num1, num2)
-	fmt.Printf("Binary [%3d]: %016b\n", num1, num1)
-	fmt.Printf("Result [%3d]: %016b\n", num1<<num2, num1<<num2)
+// for each bit shifted left.  The count is unsigned, as Go only shifts by counts of 0 or more
+func LeftShift[T Integer](num1 T, num2 uint) T {
 	return num1 << num2
 }
 
+// ExplainLeftShift describes a left shift step by step
+func ExplainLeftShift[T Integer](num1 T, num2 uint) Explanation {
+	return shiftExplanation(fmt.Sprintf("Left shifting %d by %d bits", num1, num2), 3, num1, LeftShift(num1, num2))
+}
+
 // RightShift shifts num1 right by the number of places specified in num2
 // A Right Shift shifts all bits to the right by pushing zeros in from the left
-// and dropping the right most bits.
-func RightShift(num1, num2 uint16) uint16 {
-	fmt.Printf("Right shifting %d by %d bits\n", num1, num2)
-	fmt.Printf("Binary [%2d]: %016b\n", num1, num1)
-	fmt.Printf("Result [%2d]: %016b\n", num1>>num2, num1>>num2)
+// and dropping the right most bits.  Note that for signed types Go performs an
+// arithmetic shift, which pushes in copies of the sign bit rather than zeros
+func RightShift[T Integer](num1 T, num2 uint) T {
 	return num1 >> num2
 }
 
-// TestBit sets to see if a binary bit is set in the given number.  It assumes
-// that numbers are numbered from the right starting at 0 and returns true
-// if the bit is set or false if the bit is unset.  This works by shifting the
-// given number to the right by x places, setting all but the right most to zero
-// and then verifying that the result is 1.  For this, to cater for different
-// size integers we use an interface type and do some assertion
-func TestBit(num1 interface{}, bit uint8) (bool, error) {
-	switch num1.(type) {
-	case uint8:
-		fmt.Printf("Binary [%3d] [%08b] >> %d [bit %d] == [%3d] %08b [%v]\n",
-			num1, num1, bit, 7-bit, num1.(uint8)>>(7-bit), num1.(uint8)>>(7-bit), (num1.(uint8)>>(7-bit))&1 == 1)
-		return (num1.(uint8)>>(7-bit))&1 == 1, nil
-	case uint16:
-		fmt.Printf("Binary [%3d] [%08b] >> %d [bit %d]== [%3d] %08b [%v]\n",
-			num1, num1, bit, 15-bit, num1.(uint16)>>(15-bit), num1.(uint16)>>(15-bit), (num1.(uint16)>>(15-bit))&1 == 1)
-		return (num1.(uint16)>>uint16(15-bit))&1 == 1, nil
-	case uint32:
-		fmt.Printf("Binary [%3d] [%08b] >> %d [bit %d] == [%3d] %08b [%v]\n",
-			num1, num1, bit, 31-bit, num1.(uint32)>>(31-bit), num1.(uint32)>>(31-bit), (num1.(uint32)>>(31-bit))&1 == 1)
-		return (num1.(uint32)>>uint32(31-bit))&1 == 1, nil
-	case uint64:
-		fmt.Printf("Binary [%3d] [%08b] >> %d [bit %d] == [%3d] %08b [%v]\n",
-			num1, num1, bit, 63-bit, num1.(uint64)>>(63-bit), num1.(uint64)>>(63-bit), (num1.(uint64)>>(63-bit))&1 == 1)
-		return (num1.(uint64)>>uint64(61-bit))&1 == 1, nil
-	default:
-		return false, fmt.Errorf("error, unrecognized type")
+// ExplainRightShift describes a right shift step by step
+func ExplainRightShift[T Integer](num1 T, num2 uint) Explanation {
+	return shiftExplanation(fmt.Sprintf("Right shifting %d by %d bits", num1, num2), 2, num1, RightShift(num1, num2))
+}
+
+// shiftExplanation builds the explanation shared by the shift operators, showing the input and result
+func shiftExplanation[T Integer](title string, width int, num1, res T) Explanation {
+	return Explanation{
+		Title: title,
+		Width: width,
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(num1), Bits: Binary(num1)},
+			{Label: "Result", Value: fmt.Sprint(res), Bits: Binary(res)},
+		},
+	}
+}
+
+// BitOrder says how bits are numbered within an integer.  With LSB0 bit 0 is the right most (least
+// significant) bit, which is how most hardware documentation numbers bits.  With MSB0 bit 0 is the left
+// most (most significant) bit, which is how most network protocol RFCs number bits in their diagrams
+type BitOrder int
+
+const (
+	LSB0 BitOrder = iota
+	MSB0
+)
+
+// String returns the name of the bit order
+func (bo BitOrder) String() string {
+	if bo == MSB0 {
+		return "MSB0"
+	}
+	return "LSB0"
+}
+
+// bitShift returns how far we need to shift a 1 to the left to reach the given bit of a T, or an
+// error if the bit is beyond the width of the type.  Without this check a shift past the width of
+// the type would silently produce zero
+func bitShift[T Integer](bit uint8, order BitOrder) (int, error) {
+	width := BitWidth[T]()
+	if int(bit) >= width {
+		return 0, fmt.Errorf("error, bit %d out of range for a %d bit integer", bit, width)
+	}
+	if order == MSB0 {
+		return width - 1 - int(bit), nil
+	}
+	return int(bit), nil
+}
+
+// BitMask returns a T with only the given bit set
+func BitMask[T Integer](bit uint8, order BitOrder) (T, error) {
+	shift, err := bitShift[T](bit, order)
+	if err != nil {
+		return 0, err
+	}
+	return T(1) << shift, nil
+}
+
+// TestBit tests to see if a binary bit is set in the given number, numbering the bits according to
+// order.  It returns true if the bit is set or false if the bit is unset.  This works by shifting the
+// given number to the right until the bit we want is the right most bit, setting all but the right most
+// to zero and then verifying that the result is 1.  Since the type parameter tells us the width of the
+// number, we no longer need an interface type and assertions to cater for different size integers.
+// An error is returned if the bit is beyond the width of the type
+func TestBit[T Integer](num1 T, bit uint8, order BitOrder) (bool, error) {
+	shift, err := bitShift[T](bit, order)
+	if err != nil {
+		return false, err
+	}
+	return (num1>>shift)&1 == 1, nil
+}
+
+// SetBit returns num1 with the given bit set, by ORing it with a mask containing only that bit
+func SetBit[T Integer](num1 T, bit uint8, order BitOrder) (T, error) {
+	mask, err := BitMask[T](bit, order)
+	if err != nil {
+		return num1, err
+	}
+	return num1 | mask, nil
+}
+
+// ClearBit returns num1 with the given bit cleared, by ANDing it with the inverse of a mask containing
+// only that bit.  Go has the &^ (AND NOT) operator to do this in one step
+func ClearBit[T Integer](num1 T, bit uint8, order BitOrder) (T, error) {
+	mask, err := BitMask[T](bit, order)
+	if err != nil {
+		return num1, err
+	}
+	return num1 &^ mask, nil
+}
+
+// ToggleBit returns num1 with the given bit flipped, by XORing it with a mask containing only that bit
+func ToggleBit[T Integer](num1 T, bit uint8, order BitOrder) (T, error) {
+	mask, err := BitMask[T](bit, order)
+	if err != nil {
+		return num1, err
+	}
+	return num1 ^ mask, nil
+}
+
+// ExplainTestBit describes a bit test step by step, showing the shift that moves the bit we
+// are interested in to the right most position
+func ExplainTestBit[T Integer](num1 T, bit uint8, order BitOrder) (Explanation, error) {
+	res, err := TestBit(num1, bit, order)
+	if err != nil {
+		return Explanation{}, err
 	}
+	shift, _ := bitShift[T](bit, order)
+	return Explanation{
+		Title: fmt.Sprintf("Testing bit %d (%v) of %d", bit, order, num1),
+		Line: fmt.Sprintf("Binary [%3d] [%s] >> %d [bit %d] == [%3d] %s [%v]",
+			num1, Binary(num1), shift, bit, num1>>shift, Binary(num1>>shift), res),
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(num1), Bits: Binary(num1)},
+			{Label: fmt.Sprintf(">> %d", shift), Value: fmt.Sprint(num1 >> shift), Bits: Binary(num1 >> shift)},
+			{Label: "Bit set", Value: fmt.Sprint(res)},
+		},
+	}, nil
 }
 
 // Variadic OR takes a variadic argument of a number of integers, performs a logical OR against all
 // of those integers and returns the result
 func VariadicOr(input ...int) int {
 	var res int
+	for _, in := range input {
+		res = res | in
+	}
+	return res
+}
+
+// ExplainVariadicOr describes a variadic OR, showing every input and the result in binary
+func ExplainVariadicOr(input ...int) Explanation {
+	res := VariadicOr(input...)
 	// strSlice exists just so we can make a nice output string
 	var strSlice = make([]string, len(input))
+	var rows []ExplainRow
 	for i, in := range input {
 		// We put our input integers into our string slice so we can print them nicely with a join
 		strSlice[i] = fmt.Sprintf("%d", in)
-		res = res | in
+		rows = append(rows, ExplainRow{Label: "Binary", Value: strSlice[i], Bits: Binary(in)})
+	}
+	rows = append(rows, ExplainRow{Label: "Result", Value: fmt.Sprint(res), Bits: Binary(res)})
+	return Explanation{
+		Title: fmt.Sprintf("Performing %s", strings.Join(strSlice, "|")),
+		Line:  fmt.Sprintf("Performing %s = %d", strings.Join(strSlice, "|"), res),
+		Rows:  rows,
 	}
-	fmt.Printf("Performing %s = %d\n", strings.Join(strSlice, "|"), res)
-	return res
 }
 
 // CombinedContains tests if a number is logically part of another number at a binary level. As an example:
@@ -112,12 +266,54 @@ func VariadicOr(input ...int) int {
 // left 4 most bits set.  If we then do a logical AND of any of those against the result, we will have a
 // non-zero return.
 func CombinedContains(num1, num2 int) bool {
-	fmt.Printf("Input [%d] [%08b] & [%d] [%08b] == [%d] [%08b] [%v]\n",
-		num1, num1, num2, num2, num1&num2, num1&num2, !(num1&num2 == 0))
 	return !(num1&num2 == 0)
 }
 
-// TestByStaticMask tests a uint8 by performing an AND against a constant.
+// ExplainCombinedContains describes a combined contains test, showing the AND of the two numbers
+func ExplainCombinedContains(num1, num2 int) Explanation {
+	res := CombinedContains(num1, num2)
+	return Explanation{
+		Title: fmt.Sprintf("Testing if %d contains %d", num1, num2),
+		Line: fmt.Sprintf("Input [%d] [%08b] & [%d] [%08b] == [%d] [%08b] [%v]",
+			num1, num1, num2, num2, num1&num2, num1&num2, res),
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(num1), Bits: Binary(num1)},
+			{Label: "Binary", Value: fmt.Sprint(num2), Bits: Binary(num2)},
+			{Label: "AND", Value: fmt.Sprint(num1 & num2), Bits: Binary(num1 & num2)},
+			{Label: "Contains", Value: fmt.Sprint(res)},
+		},
+	}
+}
+
+// WildcardContains takes CombinedContains a step further and tests if num matches base in every bit
+// that the wildcard mask leaves clear.  This is how network ACLs match addresses, where a set bit in the
+// wildcard (inverse) mask means we don't care about that bit.  XORing num with base leaves only the bits
+// that differ, and AND NOT with the wildcard drops the differences we don't care about, so num matches
+// if nothing is left.  Unlike a netmask the wildcard doesn't need to be contiguous, 0.255.0.255 matches
+// on the first and third octets only
+func WildcardContains[T Integer](base, wildcard, num T) bool {
+	return (num^base)&^wildcard == 0
+}
+
+// ExplainWildcardContains describes a wildcard match, showing the bits that differ and which of those
+// the wildcard mask ignores
+func ExplainWildcardContains[T Integer](base, wildcard, num T) Explanation {
+	res := WildcardContains(base, wildcard, num)
+	return Explanation{
+		Title: fmt.Sprintf("Testing if %d matches %d with wildcard %d", num, base, wildcard),
+		Width: 4,
+		Rows: []ExplainRow{
+			{Label: "Binary  ", Value: fmt.Sprint(num), Bits: Binary(num)},
+			{Label: "Base    ", Value: fmt.Sprint(base), Bits: Binary(base)},
+			{Label: "Wildcard", Value: fmt.Sprint(wildcard), Bits: Binary(wildcard)},
+			{Label: "Differ  ", Value: fmt.Sprint((num ^ base) &^ wildcard), Bits: Binary((num ^ base) &^ wildcard)},
+			{Label: "Matches ", Value: fmt.Sprint(res)},
+		},
+	}
+}
+
+// TestByStaticMask tests a uint8 by performing an AND against a constant.  The bits are numbered
+// from the left, the same as TestBit with MSB0, so bit 0 is the 128 bit.
 // In this particular case we generate the constants using iota, which effectively
 // acts as an incrementer for a shift in the constant declarations
 func TestByStaticMask(num1, bit uint8) bool {
@@ -156,32 +352,99 @@ func TestByStaticMask(num1, bit uint8) bool {
 	}
 }
 
+// commands are the tools built on these routines, run by giving their name as the first argument.
+// With no command we run the demonstration of each routine
+var commands = map[string]func(args []string) error{
+	"acl":        runACL,
+	"aggregate":  runAggregate,
+	"alu":        runALU,
+	"bgp":        runBGP,
+	"bitcalc":    runBitcalc,
+	"crc":        runCRC,
+	"decode":     runDecode,
+	"difference": runDifference,
+	"hexdump":    runHexdump,
+	"lpm":        runLPM,
+	"mrt":        runMRT,
+	"pcapfilter": runPcapFilter,
+	"pcapstat":   runPcapStat,
+	"split":      runSplit,
+	"subnet":     runSubnet,
+	"varint":     runVarint,
+}
+
 func main() {
-	fmt.Printf("Logical AND:\n")
-	BitwiseAnd(50, 60)
-	fmt.Printf("\nExclusive OR:\n")
-	ExclusiveOr(50, 60)
-	fmt.Printf("\nLogical OR:\n")
-	BitwiseOr(50, 60)
-	fmt.Printf("\nLeft Shift:\n")
-	LeftShift(50, 4)
-	fmt.Printf("\nRight Shift:\n")
-	RightShift(50, 2)
-	fmt.Printf("\nBit Testing:\n")
-	_, _ = TestBit(uint8(50), 7)
-	_, _ = TestBit(uint8(50), 6)
-	_, _ = TestBit(uint8(50), 5)
-	_, _ = TestBit(uint8(50), 4)
-	fmt.Printf("\nVaradic OR:\n")
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	// Everything we print goes through the text explainer, the -format flag lets us swap it for a
+	// Markdown or HTML table instead
+	Format := flag.String("format", "text", "Explanation output format: text, markdown or html")
+	flag.Parse()
+	ex, err := NewExplainer(*Format, os.Stdout)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		flag.PrintDefaults()
+		return
+	}
+	// explain prints a note and then the explanation, ignoring write errors since we are on stdout
+	explain := func(note string, e Explanation) {
+		_ = ex.Note(note)
+		_ = ex.Explain(e)
+	}
+
+	explain("Logical AND:", ExplainBitwiseAnd[uint16](50, 60))
+	explain("\nExclusive OR:", ExplainExclusiveOr[uint16](50, 60))
+	explain("\nLogical OR:", ExplainBitwiseOr[uint16](50, 60))
+	explain("\nLeft Shift:", ExplainLeftShift[uint16](50, 4))
+	explain("\nRight Shift:", ExplainRightShift[uint16](50, 2))
+	explain("\nNative widths:", ExplainBitwiseAnd[uint8](50, 60))
+	_ = ex.Explain(ExplainBitwiseAnd[int32](-50, 60))
+	_ = ex.Note("\nBit Testing:")
+	for bit := uint8(7); bit >= 4; bit-- {
+		e, _ := ExplainTestBit(uint8(50), bit, MSB0)
+		_ = ex.Explain(e)
+	}
+	explain("\nVaradic OR:", ExplainVariadicOr(1, 2, 4, 8, 16, 32))
 	res := VariadicOr(1, 2, 4, 8, 16, 32)
-	fmt.Printf("%d\n", res)
-	fmt.Printf("\nCombinedContains [Match]:\n")
-	CombinedContains(res, 4)
-	fmt.Printf("\nCombinedContains [No Match]:\n")
-	CombinedContains(res, 64)
-	fmt.Printf("\nStatic Bitmask testing\n")
+	_ = ex.Note(fmt.Sprintf("%d", res))
+	explain("\nCombinedContains [Match]:", ExplainCombinedContains(res, 4))
+	explain("\nCombinedContains [No Match]:", ExplainCombinedContains(res, 64))
+	explain("\nWildcardContains:", ExplainWildcardContains[uint8](0b10100000, 0b00001111, 0b10100110))
+	_ = ex.Note("\nStatic Bitmask testing")
 	x := uint8(193)
 	for i := uint8(0); i <= 7; i++ {
-		fmt.Printf("Testing for bit %d in number [%3d] [%08b]: [%v]\n", i, x, x, TestByStaticMask(x, i))
+		_ = ex.Note(fmt.Sprintf("Testing for bit %d in number [%3d] [%08b]: [%v]", i, x, x, TestByStaticMask(x, i)))
+	}
+
+	explain("\nIntrinsics:", ExplainPopCount(uint8(50)))
+	_ = ex.Explain(ExplainRotateLeft(uint8(50), 3))
+	_ = ex.Explain(ExplainReverseBits(uint8(50)))
+	_ = ex.Explain(ExplainByteSwap(uint16(50)))
+	_ = ex.Explain(ExplainGrayEncode(uint8(50)))
+
+	// A Bitset carries bits from one 64 bit word into the next when shifting
+	_ = ex.Note("\nBitset shifting across a word boundary:")
+	bs := BitsetFromUint64(50, 72)
+	_ = ex.Note(fmt.Sprintf("Binary [%2d]: %s", 50, bs))
+	_ = ex.Note(fmt.Sprintf("Shift  [%2d]: %s", 40, bs.ShiftLeft(40)))
+
+	// Struct tags describe where each field goes, instead of shifting and masking by hand
+	_ = ex.Note("\nStruct packing:")
+	type header struct {
+		Version uint8 `bits:"4"`
+		Flags   [3]bool
+		_       bool
+		Length  uint16 `bits:"12"`
+	}
+	if e, err := ExplainMarshal(header{Version: 6, Flags: [3]bool{true, false, true}, Length: 1500}); err == nil {
+		_ = ex.Explain(e)
 	}
 }
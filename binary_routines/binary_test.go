@@ -4,6 +4,7 @@ package main
 import (
 	"fmt"
 	"testing"
+	"testing/quick"
 )
 
 func TestBitwiseAnd(t *testing.T) {
@@ -47,7 +48,8 @@ func TestExclusiveOr(t *testing.T) {
 
 func TestLeftShift(t *testing.T) {
 	tp := struct {
-		a, b uint16
+		a    uint16
+		b    uint
 		want uint16
 	}{8, 2, 32}
 	t.Run(fmt.Sprintf("%d,%d", tp.a, tp.b), func(*testing.T) {
@@ -60,7 +62,8 @@ func TestLeftShift(t *testing.T) {
 
 func TestRightShift(t *testing.T) {
 	tp := struct {
-		a, b uint16
+		a    uint16
+		b    uint
 		want uint16
 	}{8, 2, 2}
 	t.Run(fmt.Sprintf("%d,%d", tp.a, tp.b), func(*testing.T) {
@@ -76,35 +79,222 @@ func TestTestBit(t *testing.T) {
 	testName16 := fmt.Sprintf("%d,%d", 0xFFFE, 1)
 	testName32 := fmt.Sprintf("%d,%d", 0xFFFFFFFE, 6)
 	testName64 := fmt.Sprintf("%d,%d", 1, 64)
-	testNameErr := fmt.Sprintf("%.2f,1", 1.0)
+	testNameErr := fmt.Sprintf("%d,%d", 1, 8)
 	t.Run(testName8, func(*testing.T) {
-		ans, err := TestBit(uint8(193), 0)
+		ans, err := TestBit(uint8(193), 0, MSB0)
 		if ans != true && err != nil {
 			t.Errorf("Expected true with nil error, got %v [%v]", ans, err)
 		}
 	})
 	t.Run(testName16, func(*testing.T) {
-		ans, err := TestBit(uint16(0xFFFE), 1)
+		ans, err := TestBit(uint16(0xFFFE), 1, MSB0)
 		if ans != true && err != nil {
 			t.Errorf("Expected true with nil error, got %v [%v]", ans, err)
 		}
 	})
 	t.Run(testName32, func(*testing.T) {
-		ans, err := TestBit(uint32(0xFFFFFFFE), 6)
+		ans, err := TestBit(uint32(0xFFFFFFFE), 6, MSB0)
 		if ans != true && err != nil {
 			t.Errorf("Expected true with nil error, got %v [%v]", ans, err)
 		}
 	})
 	t.Run(testName64, func(*testing.T) {
-		ans, err := TestBit(uint64(1), 0)
+		ans, err := TestBit(uint64(1), 0, MSB0)
 		if ans != true && err != nil {
 			t.Errorf("Expected true with nil error, got %v [%v]", ans, err)
 		}
 	})
 	t.Run(testNameErr, func(*testing.T) {
-		ans, err := TestBit(1.0, 0)
+		ans, err := TestBit(uint8(1), 8, MSB0)
 		if ans != false || err == nil {
 			t.Errorf("Expected false with error, got %v [%v]", ans, err)
 		}
 	})
 }
+
+// flags is a named type used to check that the generic routines accept types derived from an integer
+type flags uint8
+
+func TestBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"uint8", Binary(uint8(5)), "00000101"},
+		{"int8", Binary(int8(-1)), "11111111"},
+		{"uint16", Binary(uint16(5)), "0000000000000101"},
+		{"int16", Binary(int16(-2)), "1111111111111110"},
+		{"uint32", Binary(uint32(1 << 31)), "10000000000000000000000000000000"},
+		{"int64", Binary(int64(-1)), "1111111111111111111111111111111111111111111111111111111111111111"},
+		{"flags", Binary(flags(0x81)), "10000001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %s instead of %s", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBitWidth(t *testing.T) {
+	if BitWidth[uint8]() != 8 || BitWidth[int16]() != 16 || BitWidth[uint32]() != 32 ||
+		BitWidth[int64]() != 64 || BitWidth[flags]() != 8 {
+		t.Errorf("unexpected bit widths")
+	}
+}
+
+func TestGenericWidths(t *testing.T) {
+	if ans := BitwiseAnd(uint8(0xF0), uint8(0x3C)); ans != 0x30 {
+		t.Errorf("uint8 AND gave %08b", ans)
+	}
+	if ans := BitwiseOr(int32(-16), int32(15)); ans != -1 {
+		t.Errorf("int32 OR gave %d", ans)
+	}
+	if ans := ExclusiveOr(uint64(1<<63), uint64(1)); ans != 1<<63|1 {
+		t.Errorf("uint64 XOR gave %064b", ans)
+	}
+	if ans := LeftShift(uint8(0x81), 1); ans != 0x02 {
+		t.Errorf("uint8 left shift should drop the top bit, gave %08b", ans)
+	}
+	if ans := RightShift(int8(-128), 7); ans != -1 {
+		t.Errorf("int8 right shift should be arithmetic, gave %d", ans)
+	}
+	// A negative count can only reach the shifts as a huge unsigned one, which shifts every bit out
+	n := -1
+	if ans := LeftShift(int8(1), uint(n)); ans != 0 {
+		t.Errorf("int8 left shift by a negative count gave %d", ans)
+	}
+	if ans := RightShift(int8(-128), uint(n)); ans != -1 {
+		t.Errorf("int8 right shift by a negative count gave %d", ans)
+	}
+	if ans := BitwiseAnd(flags(0x0F), flags(0x03)); ans != 0x03 {
+		t.Errorf("named type AND gave %08b", ans)
+	}
+	ans, err := TestBit(uint64(1), 63, MSB0)
+	if !ans || err != nil {
+		t.Errorf("expected the right most bit of a uint64 to be set, got %v [%v]", ans, err)
+	}
+}
+
+// checkBitProperties uses testing/quick to check, for random values of T and every bit position, that
+// both bit orders agree with each other and with a plain shift of the value, and that SetBit, ClearBit
+// and ToggleBit only ever change the bit they are asked to
+func checkBitProperties[T Integer](t *testing.T) {
+	width := BitWidth[T]()
+	prop := func(num T) bool {
+		for bit := 0; bit < width; bit++ {
+			lsb, err1 := TestBit(num, uint8(bit), LSB0)
+			msb, err2 := TestBit(num, uint8(width-1-bit), MSB0)
+			if err1 != nil || err2 != nil || lsb != msb || lsb != ((uint64(num)>>bit)&1 == 1) {
+				return false
+			}
+			set, _ := SetBit(num, uint8(bit), LSB0)
+			cleared, _ := ClearBit(num, uint8(bit), LSB0)
+			toggled, _ := ToggleBit(num, uint8(bit), LSB0)
+			back, _ := ToggleBit(toggled, uint8(bit), LSB0)
+			mask := T(1) << bit
+			if set&^mask != num&^mask || cleared&^mask != num&^mask || back != num {
+				return false
+			}
+			if s, _ := TestBit(set, uint8(bit), LSB0); !s {
+				return false
+			}
+			if c, _ := TestBit(cleared, uint8(bit), LSB0); c {
+				return false
+			}
+			if tb, _ := TestBit(toggled, uint8(bit), LSB0); tb == lsb {
+				return false
+			}
+		}
+		// One past the width must always be an error in both orders
+		_, err1 := TestBit(num, uint8(width), LSB0)
+		_, err2 := SetBit(num, uint8(width), MSB0)
+		return err1 != nil && err2 != nil
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Errorf("%d bit property failed: %v", width, err)
+	}
+}
+
+func TestBitProperties(t *testing.T) {
+	t.Run("uint8", checkBitProperties[uint8])
+	t.Run("uint16", checkBitProperties[uint16])
+	t.Run("uint32", checkBitProperties[uint32])
+	t.Run("uint64", checkBitProperties[uint64])
+	t.Run("int8", checkBitProperties[int8])
+	t.Run("int16", checkBitProperties[int16])
+	t.Run("int32", checkBitProperties[int32])
+	t.Run("int64", checkBitProperties[int64])
+	t.Run("flags", checkBitProperties[flags])
+}
+
+func TestBitWidthsAgree(t *testing.T) {
+	// The same small value must report the same bits whatever width it is stored in, and the
+	// static mask test must agree with TestBit in MSB0 order
+	for v := 0; v < 256; v++ {
+		for bit := uint8(0); bit < 8; bit++ {
+			b8, _ := TestBit(uint8(v), bit, LSB0)
+			b16, _ := TestBit(uint16(v), bit, LSB0)
+			b32, _ := TestBit(uint32(v), bit, LSB0)
+			b64, _ := TestBit(uint64(v), bit, LSB0)
+			if b8 != b16 || b8 != b32 || b8 != b64 {
+				t.Errorf("widths disagree on bit %d of %d", bit, v)
+			}
+			msb, _ := TestBit(uint8(v), bit, MSB0)
+			if TestByStaticMask(uint8(v), bit) != msb {
+				t.Errorf("static mask disagrees with MSB0 on bit %d of %d", bit, v)
+			}
+		}
+	}
+}
+
+func TestSetClearToggle(t *testing.T) {
+	tests := []struct {
+		name  string
+		fn    func(uint16, uint8, BitOrder) (uint16, error)
+		num   uint16
+		bit   uint8
+		order BitOrder
+		want  uint16
+		err   bool
+	}{
+		{"set lsb0", SetBit[uint16], 0, 0, LSB0, 0x0001, false},
+		{"set msb0", SetBit[uint16], 0, 0, MSB0, 0x8000, false},
+		{"clear lsb0", ClearBit[uint16], 0xFFFF, 4, LSB0, 0xFFEF, false},
+		{"clear msb0", ClearBit[uint16], 0xFFFF, 4, MSB0, 0xF7FF, false},
+		{"toggle lsb0", ToggleBit[uint16], 0x00F0, 4, LSB0, 0x00E0, false},
+		{"toggle msb0", ToggleBit[uint16], 0x00F0, 15, MSB0, 0x00F1, false},
+		{"out of range", SetBit[uint16], 0x1234, 16, LSB0, 0x1234, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ans, err := tt.fn(tt.num, tt.bit, tt.order)
+			if ans != tt.want || (err != nil) != tt.err {
+				t.Errorf("got %016b [%v] instead of %016b", ans, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWildcardContains(t *testing.T) {
+	tests := []struct {
+		base, wildcard, num uint32
+		want                bool
+	}{
+		{0x0A000000, 0x00FF00FF, 0x0A000000, true},
+		{0x0A000000, 0x00FF00FF, 0x0A370011, true},
+		{0x0A000000, 0x00FF00FF, 0x0A370111, false},
+		{0x0A000000, 0xFFFFFFFF, 0xDEADBEEF, true},
+		{0x0A000001, 0, 0x0A000001, true},
+		{0x0A000001, 0, 0x0A000003, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%08x/%08x,%08x", tt.base, tt.wildcard, tt.num), func(t *testing.T) {
+			if got := WildcardContains(tt.base, tt.wildcard, tt.num); got != tt.want {
+				t.Errorf("got %v instead of %v", got, tt.want)
+			}
+		})
+	}
+}
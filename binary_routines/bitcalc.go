@@ -0,0 +1,537 @@
+// Bit expression calculator, evaluates C style expressions and explains each step in binary
+// Andrew Alston
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tokenKind says what sort of token the tokenizer found
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+// token is a single piece of an expression, such as a number, a variable name or an operator.  pos is
+// the offset in the input where the token started, which we use in error messages
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators lists every operator we recognise, longest first so that << is matched before <
+var operators = []string{"<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"|", "^", "&", "+", "-", "*", "/", "%", "~", "!", "<", ">", "(", ")", "="}
+
+// tokenize splits an expression into tokens.  Numbers may be decimal, or hex, binary or octal with a
+// 0x, 0b or 0o prefix, and may contain underscores to group digits, the same as Go number literals
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(input) && (isIdentChar(input[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: input[start:i], pos: start})
+		case isIdentChar(c):
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[start:i], pos: start})
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(input[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+// isIdentChar returns true for characters that can appear in a variable name or number
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// exprNode is a node in the parsed expression tree.  Exactly one of the groups of fields is used
+// depending on the kind of node: a number, a variable, a unary operator on x, a binary operator on x
+// and y, or an assignment of x to the variable name
+type exprNode struct {
+	kind  string
+	op    string
+	value uint64
+	name  string
+	x, y  *exprNode
+}
+
+// bindingPowers gives the precedence of each binary operator, higher binds tighter.  These follow C,
+// so for example a & b == c parses as a & (b == c), which catches people out in C as well
+var bindingPowers = map[string]int{
+	"=":  1,
+	"||": 2,
+	"&&": 3,
+	"|":  4,
+	"^":  5,
+	"&":  6,
+	"==": 7, "!=": 7,
+	"<": 8, "<=": 8, ">": 8, ">=": 8,
+	"<<": 9, ">>": 9,
+	"+": 10, "-": 10,
+	"*": 11, "/": 11, "%": 11,
+}
+
+// unaryPower is the binding power of the prefix operators, which bind tighter than any binary operator
+const unaryPower = 12
+
+// parser is a Pratt parser.  Rather than writing a function for every precedence level, a Pratt parser
+// parses a prefix (a number, variable, bracket or unary operator) and then keeps absorbing binary
+// operators for as long as they bind more tightly than the operator that called it
+type parser struct {
+	tokens []token
+	pos    int
+	width  int
+}
+
+// parseExpression parses a whole expression and makes sure nothing is left over
+func parseExpression(input string, width int) (*exprNode, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, width: width}
+	n, err := p.parse(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return n, nil
+}
+
+// peek returns the next token without consuming it
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// parse parses an expression whose operators all bind more tightly than minPower
+func (p *parser) parse(minPower int) (*exprNode, error) {
+	left, err := p.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		power, ok := bindingPowers[t.text]
+		if t.kind != tokenOperator || !ok || power <= minPower {
+			return left, nil
+		}
+		p.next()
+		if t.text == "=" {
+			// Assignment is right associative, so a = b = 1 sets both, which we get by parsing the right
+			// hand side with a lower minimum power than our own
+			if left.kind != "var" {
+				return nil, fmt.Errorf("can only assign to a variable at position %d", t.pos)
+			}
+			right, err := p.parse(power - 1)
+			if err != nil {
+				return nil, err
+			}
+			left = &exprNode{kind: "assign", name: left.name, x: right}
+			continue
+		}
+		right, err := p.parse(power)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{kind: "binary", op: t.text, x: left, y: right}
+	}
+}
+
+// parsePrefix parses a number, variable, bracketed expression or unary operator
+func (p *parser) parsePrefix() (*exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		v, err := strconv.ParseUint(t.text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		if p.width < 64 && v>>p.width != 0 {
+			return nil, fmt.Errorf("%s does not fit in %d bits", t.text, p.width)
+		}
+		return &exprNode{kind: "number", value: v}, nil
+	case tokenIdent:
+		return &exprNode{kind: "var", name: t.text}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			if c := p.next(); c.text != ")" {
+				return nil, fmt.Errorf("expected ) at position %d", c.pos)
+			}
+			return n, nil
+		case "-", "~", "!":
+			x, err := p.parse(unaryPower)
+			if err != nil {
+				return nil, err
+			}
+			return &exprNode{kind: "unary", op: t.text, x: x}, nil
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// Calculator evaluates expressions at a fixed width, either signed or unsigned, and keeps the values
+// of any variables assigned along the way.  Values are stored as the raw bits in a uint64, and
+// everything above Width is always kept at zero
+type Calculator struct {
+	Width  int
+	Signed bool
+	Vars   map[string]uint64
+}
+
+// NewCalculator returns an unsigned 16 bit calculator, the same width the original routines used
+func NewCalculator() *Calculator {
+	return &Calculator{Width: 16, Vars: make(map[string]uint64)}
+}
+
+// mask clears any bits above the width of the calculator
+func (c *Calculator) mask(v uint64) uint64 {
+	if c.Width == 64 {
+		return v
+	}
+	return v & (1<<c.Width - 1)
+}
+
+// signed returns v as a signed number by sign extending it from the width of the calculator.  Shifting
+// left puts our sign bit at the top of an int64, and the arithmetic shift back copies it down
+func (c *Calculator) signed(v uint64) int64 {
+	shift := 64 - c.Width
+	return int64(v<<shift) >> shift
+}
+
+// format returns v in decimal, as a signed number if the calculator is in signed mode
+func (c *Calculator) format(v uint64) string {
+	if c.Signed {
+		return strconv.FormatInt(c.signed(v), 10)
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// binary returns v in binary at the width of the calculator
+func (c *Calculator) binary(v uint64) string {
+	return fmt.Sprintf("%0*b", c.Width, v)
+}
+
+// opTitles are the titles used in the explanation of each operator, in the same words the routines
+// in binary.go use where there is one
+var opTitles = map[string]string{
+	"&": "Performing Bitwise AND between %s and %s", "|": "Performing Logical OR between %s and %s",
+	"^": "Performing Exclusive OR between %s and %s", "<<": "Left shifting %s by %s bits",
+	">>": "Right shifting %s by %s bits", "+": "Adding %s and %s", "-": "Subtracting %[2]s from %[1]s",
+	"*": "Multiplying %s by %s", "/": "Dividing %s by %s", "%": "Taking %s modulo %s",
+	"==": "Testing if %s equals %s", "!=": "Testing if %s does not equal %s",
+	"<": "Testing if %s is less than %s", "<=": "Testing if %s is less than or equal to %s",
+	">": "Testing if %s is greater than %s", ">=": "Testing if %s is greater than or equal to %s",
+	"&&": "Performing logical AND between %s and %s", "||": "Performing logical OR between %s and %s",
+}
+
+// Eval evaluates an expression, returning the result and an explanation of every step taken along the
+// way, in the order they were performed
+func (c *Calculator) Eval(input string) (uint64, []Explanation, error) {
+	n, err := parseExpression(input, c.Width)
+	if err != nil {
+		return 0, nil, err
+	}
+	var steps []Explanation
+	res, err := c.eval(n, &steps)
+	return res, steps, err
+}
+
+// eval evaluates a node of the expression tree, appending an explanation for every operator
+func (c *Calculator) eval(n *exprNode, steps *[]Explanation) (uint64, error) {
+	switch n.kind {
+	case "number":
+		return n.value, nil
+	case "var":
+		v, ok := c.Vars[n.name]
+		if !ok {
+			return 0, fmt.Errorf("unknown variable %q", n.name)
+		}
+		return v, nil
+	case "assign":
+		v, err := c.eval(n.x, steps)
+		if err != nil {
+			return 0, err
+		}
+		c.Vars[n.name] = v
+		return v, nil
+	case "unary":
+		x, err := c.eval(n.x, steps)
+		if err != nil {
+			return 0, err
+		}
+		var res uint64
+		var title string
+		switch n.op {
+		case "-":
+			res, title = c.mask(-x), "Negating %s"
+		case "~":
+			res, title = c.mask(^x), "Inverting %s"
+		case "!":
+			res, title = boolValue(x == 0), "Performing logical NOT of %s"
+		}
+		*steps = append(*steps, c.explain(fmt.Sprintf(title, c.format(x)),
+			ExplainRow{Label: "Binary", Value: c.format(x), Bits: c.binary(x)},
+			ExplainRow{Label: "Result", Value: c.format(res), Bits: c.binary(res)}))
+		return res, nil
+	}
+
+	x, err := c.eval(n.x, steps)
+	if err != nil {
+		return 0, err
+	}
+	y, err := c.eval(n.y, steps)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.apply(n.op, x, y)
+	if err != nil {
+		return 0, err
+	}
+	rows := []ExplainRow{{Label: "Binary", Value: c.format(x), Bits: c.binary(x)}}
+	// Shifts show the input and the result, in the same way LeftShift and RightShift do
+	if n.op != "<<" && n.op != ">>" {
+		rows = append(rows, ExplainRow{Label: "Binary", Value: c.format(y), Bits: c.binary(y)})
+	}
+	rows = append(rows, ExplainRow{Label: "Result", Value: c.format(res), Bits: c.binary(res)})
+	*steps = append(*steps, c.explain(fmt.Sprintf(opTitles[n.op], c.format(x), c.format(y)), rows...))
+	return res, nil
+}
+
+// apply performs a single binary operator on x and y at the width and signedness of the calculator
+func (c *Calculator) apply(op string, x, y uint64) (uint64, error) {
+	sx, sy := c.signed(x), c.signed(y)
+	// Comparisons and division behave differently for signed numbers, so we pick the right one
+	less := func(a, b uint64) bool {
+		if c.Signed {
+			return c.signed(a) < c.signed(b)
+		}
+		return a < b
+	}
+	switch op {
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "<<":
+		return c.mask(x << y), nil
+	case ">>":
+		if c.Signed {
+			// An arithmetic shift copies the sign bit down, shifting by the width or more leaves only
+			// copies of the sign bit
+			return c.mask(uint64(sx >> min(y, 63))), nil
+		}
+		return x >> y, nil
+	case "+":
+		return c.mask(x + y), nil
+	case "-":
+		return c.mask(x - y), nil
+	case "*":
+		return c.mask(x * y), nil
+	case "/", "%":
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if c.Signed {
+			if op == "/" {
+				return c.mask(uint64(sx / sy)), nil
+			}
+			return c.mask(uint64(sx % sy)), nil
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "==":
+		return boolValue(x == y), nil
+	case "!=":
+		return boolValue(x != y), nil
+	case "<":
+		return boolValue(less(x, y)), nil
+	case "<=":
+		return boolValue(!less(y, x)), nil
+	case ">":
+		return boolValue(less(y, x)), nil
+	case ">=":
+		return boolValue(!less(x, y)), nil
+	case "&&":
+		return boolValue(x != 0 && y != 0), nil
+	case "||":
+		return boolValue(x != 0 || y != 0), nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", op)
+}
+
+// boolValue turns a boolean into 1 or 0, the same as C does for comparison results
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// explain builds an explanation with the value column wide enough for every row
+func (c *Calculator) explain(title string, rows ...ExplainRow) Explanation {
+	width := 2
+	for _, r := range rows {
+		width = max(width, len(r.Value))
+	}
+	return Explanation{Title: title, Width: width, Rows: rows}
+}
+
+// bitcalcHelp is printed in response to :help
+const bitcalcHelp = `Enter an expression such as (0x32 & 60) << 2 ^ ~0b1010, or one of:
+	:w 8|16|32|64   set the width of the calculator
+	:s              signed mode
+	:u              unsigned mode
+	:vars           list variables
+	:q              quit
+Variables are assigned with name = expression`
+
+// Execute runs a single line of input, which is either a command starting with : or an expression.  The
+// steps and the result are written through the explainer
+func (c *Calculator) Execute(line string, ex Explainer) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, ":") {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case ":w":
+			if len(fields) != 2 {
+				return fmt.Errorf("usage: :w 8|16|32|64")
+			}
+			w, err := strconv.Atoi(fields[1])
+			if err != nil || (w != 8 && w != 16 && w != 32 && w != 64) {
+				return fmt.Errorf("width must be 8, 16, 32 or 64")
+			}
+			c.Width = w
+			// Truncate any variables to the new width
+			for k, v := range c.Vars {
+				c.Vars[k] = c.mask(v)
+			}
+			return ex.Note(fmt.Sprintf("Width set to %d bits", w))
+		case ":s":
+			c.Signed = true
+			return ex.Note("Signed mode")
+		case ":u":
+			c.Signed = false
+			return ex.Note("Unsigned mode")
+		case ":vars":
+			names := make([]string, 0, len(c.Vars))
+			for k := range c.Vars {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				if err := ex.Note(fmt.Sprintf("%s = %s [%s]", k, c.format(c.Vars[k]), c.binary(c.Vars[k]))); err != nil {
+					return err
+				}
+			}
+			return nil
+		case ":help":
+			return ex.Note(bitcalcHelp)
+		default:
+			return fmt.Errorf("unknown command %s, try :help", fields[0])
+		}
+	}
+
+	res, steps, err := c.Eval(line)
+	if err != nil {
+		return err
+	}
+	for _, s := range steps {
+		if err := ex.Explain(s); err != nil {
+			return err
+		}
+	}
+	return ex.Note(fmt.Sprintf("= %s [0x%X] [%s]", c.format(res), res, c.binary(res)))
+}
+
+// RunBitcalc reads lines from r and executes them until the input ends or :q is entered.  Errors in an
+// expression are reported and the calculator carries on, so a typo doesn't lose the variables
+func RunBitcalc(r io.Reader, w io.Writer, prompt bool) error {
+	c := NewCalculator()
+	ex := &TextExplainer{W: w}
+	scanner := bufio.NewScanner(r)
+	for {
+		if prompt {
+			fmt.Fprintf(w, "bitcalc[%d%s]> ", c.Width, map[bool]string{true: "s", false: "u"}[c.Signed])
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line := scanner.Text()
+		if strings.TrimSpace(line) == ":q" {
+			return nil
+		}
+		if err := c.Execute(line, ex); err != nil {
+			fmt.Fprintf(w, "Error: %v\n", err)
+		}
+	}
+}
+
+// runBitcalc is the bitcalc sub command.  With arguments it evaluates them as a single expression,
+// otherwise it reads expressions from standard input
+func runBitcalc(args []string) error {
+	if len(args) > 0 {
+		return NewCalculator().Execute(strings.Join(args, " "), &TextExplainer{W: os.Stdout})
+	}
+	return RunBitcalc(os.Stdin, os.Stdout, true)
+}
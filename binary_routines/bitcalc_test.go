@@ -0,0 +1,124 @@
+// Bit expression calculator test routines
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize("(0x32 & 60) << 2 ^ ~0b1010 >= x_1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var texts []string
+	for _, tk := range tokens[:len(tokens)-1] {
+		texts = append(texts, tk.text)
+	}
+	want := "( 0x32 & 60 ) << 2 ^ ~ 0b1010 >= x_1"
+	if strings.Join(texts, " ") != want {
+		t.Errorf("got %v instead of %s", texts, want)
+	}
+	if _, err := tokenize("1 $ 2"); err == nil {
+		t.Errorf("expected an error for an unknown character")
+	}
+}
+
+func TestCalculatorEval(t *testing.T) {
+	tests := []struct {
+		expr   string
+		width  int
+		signed bool
+		want   uint64
+		steps  int
+	}{
+		{"(0x32 & 60) << 2 ^ ~0b1010", 16, false, ((0x32&60)<<2 ^ 0xFFF5) & 0xFFFF, 4},
+		{"50 & 60", 16, false, 48, 1},
+		{"1 + 2 * 3", 16, false, 7, 2},
+		{"(1 + 2) * 3", 16, false, 9, 2},
+		{"1 << 2 + 1", 16, false, 8, 2},
+		{"6 & 3 == 3", 16, false, 0, 2},
+		{"1 | 2 ^ 3 & 4", 8, false, 3, 3},
+		{"0xFF + 1", 8, false, 0, 1},
+		{"0x80 >> 7", 8, false, 1, 1},
+		{"0x80 >> 7", 8, true, 0xFF, 1},
+		{"-1", 32, false, 0xFFFFFFFF, 1},
+		{"-7 / 2", 16, true, 0xFFFD, 2},
+		{"-7 % 2", 16, true, 0xFFFF, 2},
+		{"0xFFFF > 1", 16, false, 1, 1},
+		{"0xFFFF > 1", 16, true, 0, 1},
+		{"!0 && 5 || 0", 16, false, 1, 3},
+		{"1 << 70", 64, false, 0, 1},
+		{"0x8000000000000000 >> 63", 64, true, 0xFFFFFFFFFFFFFFFF, 1},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%s [%d signed %v]", tt.expr, tt.width, tt.signed)
+		t.Run(testName, func(t *testing.T) {
+			c := NewCalculator()
+			c.Width, c.Signed = tt.width, tt.signed
+			ans, steps, err := c.Eval(tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if ans != tt.want || len(steps) != tt.steps {
+				t.Errorf("got %d with %d steps instead of %d with %d steps", ans, len(steps), tt.want, tt.steps)
+			}
+		})
+	}
+}
+
+func TestCalculatorErrors(t *testing.T) {
+	tests := []string{"1 +", "(1 + 2", "1 2", "y + 1", "1 / 0", "5 % 0", "0x10000", "3 = 4", "0xZZ", ")"}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, _, err := NewCalculator().Eval(expr); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestCalculatorVariables(t *testing.T) {
+	c := NewCalculator()
+	if _, _, err := c.Eval("a = b = 0x0F"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ans, _, err := c.Eval("a << 4 | b")
+	if err != nil || ans != 0xFF || c.Vars["a"] != 0x0F {
+		t.Errorf("got %d [%v] instead of 255", ans, err)
+	}
+}
+
+func TestCalculatorSteps(t *testing.T) {
+	// The steps must be rendered in the same table format the routines in binary.go use
+	var buf bytes.Buffer
+	c := NewCalculator()
+	if err := c.Execute("50 & 60", &TextExplainer{W: &buf}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var want bytes.Buffer
+	_ = (&TextExplainer{W: &want}).Explain(ExplainBitwiseAnd[uint16](50, 60))
+	want.WriteString("= 48 [0x30] [0000000000110000]\n")
+	if buf.String() != want.String() {
+		t.Errorf("got %q instead of %q", buf.String(), want.String())
+	}
+}
+
+func TestRunBitcalc(t *testing.T) {
+	input := ":w 8\n:s\nx = 0x80\nx >> 1\n1 / 0\n:vars\n:w 12\n:q\n7 + 7\n"
+	var out bytes.Buffer
+	if err := RunBitcalc(strings.NewReader(input), &out, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, want := range []string{"Width set to 8 bits", "Signed mode", "= -64 [0xC0] [11000000]",
+		"Error: division by zero", "x = -128 [10000000]", "Error: width must be 8, 16, 32 or 64"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output %q does not contain %q", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "= 14") {
+		t.Errorf("input after :q should be ignored")
+	}
+}
@@ -0,0 +1,366 @@
+// Declarative bit field packing of structs using struct tags
+// Andrew Alston
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The bits struct tag says how many bits a field takes when packed, so a protocol header can be written
+// as a struct rather than by hand with shifts and masks the way TestByStaticMask does:
+//
+//	type header struct {
+//		Version uint8  `bits:"4"`
+//		Flags   [3]bool
+//		_       uint8  `bits:"1"`
+//		Length  uint16 `bits:"13,be"`
+//		Port    uint16 `bits:"16,le"`
+//	}
+//
+// Fields are packed in order, most significant bit first, with no padding between them.  Without a tag
+// a field takes the full width of its type and a bool takes one bit.  The options after the width are
+// be, big endian which is the default, and le, little endian, which swaps the bytes of the field and so
+// needs a whole number of bytes.  Fields named _ are reserved, they are written as zeros and skipped
+// when unpacking.  A tag of "-" leaves a field out, as do unexported fields.  Nested structs are packed
+// in place, and a tag on an array applies to each element
+
+// bitField describes one packed value, a leaf of the struct after nested structs and arrays have been
+// flattened out
+type bitField struct {
+	path     string
+	offset   int
+	bits     int
+	little   bool
+	reserved bool
+}
+
+// name returns the path of the field, or Reserved for padding
+func (f bitField) name() string {
+	if f.reserved {
+		return "Reserved"
+	}
+	return f.path
+}
+
+// bitTag is a parsed bits tag
+type bitTag struct {
+	bits   int
+	little bool
+}
+
+// parseBitTag parses a bits tag, returning a width of zero when there is no tag
+func parseBitTag(tag string) (bitTag, error) {
+	if tag == "" {
+		return bitTag{}, nil
+	}
+	parts := strings.Split(tag, ",")
+	bits, err := strconv.Atoi(parts[0])
+	if err != nil || bits < 1 || bits > 64 {
+		return bitTag{}, fmt.Errorf("invalid width %q, expected 1 to 64 bits", parts[0])
+	}
+	res := bitTag{bits: bits}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "be":
+			res.little = false
+		case "le":
+			res.little = true
+		default:
+			return bitTag{}, fmt.Errorf("unknown option %q, expected be or le", opt)
+		}
+	}
+	if res.little && bits%8 != 0 {
+		return bitTag{}, fmt.Errorf("a little endian field needs a whole number of bytes, not %d bits", bits)
+	}
+	return res, nil
+}
+
+// fieldWalker flattens a struct in to its packed fields, calling visit for each leaf value in order
+type fieldWalker struct {
+	offset int
+	visit  func(f bitField, v reflect.Value) error
+}
+
+// walkStruct visits every packed field of a struct value
+func (w *fieldWalker) walkStruct(v reflect.Value, path string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("bits")
+		reserved := sf.Name == "_"
+		if tag == "-" || (!sf.IsExported() && !reserved) {
+			continue
+		}
+		bt, err := parseBitTag(tag)
+		if err != nil {
+			return fmt.Errorf("field %s%s: %v", path, sf.Name, err)
+		}
+		if err := w.walkValue(v.Field(i), path+sf.Name, bt, reserved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkValue visits a single field, which may be a struct or array holding more fields
+func (w *fieldWalker) walkValue(v reflect.Value, path string, bt bitTag, reserved bool) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		return w.walkStruct(v, path+".")
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := w.walkValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), bt, reserved); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Bool:
+		if bt.bits == 0 {
+			bt.bits = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		width := v.Type().Bits()
+		if bt.bits == 0 {
+			bt.bits = width
+		}
+		if bt.bits > width {
+			return fmt.Errorf("field %s: %d bits won't fit in a %s", path, bt.bits, v.Type())
+		}
+	default:
+		return fmt.Errorf("field %s: can't pack a %s", path, v.Type())
+	}
+	if bt.little && bt.bits%8 != 0 {
+		return fmt.Errorf("field %s: a little endian field needs a whole number of bytes", path)
+	}
+	f := bitField{path: path, offset: w.offset, bits: bt.bits, little: bt.little, reserved: reserved}
+	w.offset += bt.bits
+	return w.visit(f, v)
+}
+
+// walkFields checks v is a struct, or a pointer to one, and walks its fields
+func walkFields(v reflect.Value, visit func(f bitField, v reflect.Value) error) (int, error) {
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return 0, fmt.Errorf("can only pack structs, not nil")
+	}
+	if v.Kind() != reflect.Struct {
+		return 0, fmt.Errorf("can only pack structs, not %s", v.Type())
+	}
+	w := &fieldWalker{visit: visit}
+	err := w.walkStruct(v, "")
+	return w.offset, err
+}
+
+// swapFieldBytes reverses the bytes of a field of the given width, used for little endian fields
+func swapFieldBytes(u uint64, bits int) uint64 {
+	return ByteSwap(u) >> (64 - bits)
+}
+
+// fieldBits returns the value of a field as the bits to pack, checking it fits in the width.  Signed
+// values are packed in two's complement, so a 4 bit field holds -8 to 7
+func fieldBits(f bitField, v reflect.Value) (uint64, error) {
+	if f.reserved {
+		return 0, nil
+	}
+	var u uint64
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			u = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		lo, hi := int64(-1)<<(f.bits-1), int64(1)<<(f.bits-1)-1
+		if i < lo || i > hi {
+			return 0, fmt.Errorf("field %s: %d doesn't fit in %d signed bits (%d to %d)", f.path, i, f.bits, lo, hi)
+		}
+		u = uint64(i)
+		if f.bits < 64 {
+			u &= 1<<f.bits - 1
+		}
+	default:
+		u = v.Uint()
+		if f.bits < 64 && u>>f.bits != 0 {
+			return 0, fmt.Errorf("field %s: %d doesn't fit in %d bits (0 to %d)", f.path, u, f.bits, uint64(1)<<f.bits-1)
+		}
+	}
+	if f.little {
+		u = swapFieldBytes(u, f.bits)
+	}
+	return u, nil
+}
+
+// setFieldBits stores unpacked bits in a field, sign extending signed values
+func setFieldBits(f bitField, v reflect.Value, u uint64) {
+	if f.reserved || !v.CanSet() {
+		return
+	}
+	if f.little {
+		u = swapFieldBytes(u, f.bits)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(u != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// If the top bit of the field is set the value is negative, so set every bit above it too
+		if f.bits < 64 && u>>(f.bits-1)&1 == 1 {
+			u |= ^uint64(0) << f.bits
+		}
+		v.SetInt(int64(u))
+	default:
+		v.SetUint(u)
+	}
+}
+
+// Marshal packs a struct in to bytes according to its bits tags.  If the fields don't add up to a whole
+// number of bytes the last byte is padded with zeros.  An error is returned if a value doesn't fit in
+// its field
+func Marshal(s any) ([]byte, error) {
+	var buf bytes.Buffer
+	bw := NewBitWriter(&buf, MSB0)
+	_, err := walkFields(reflect.ValueOf(s), func(f bitField, v reflect.Value) error {
+		u, err := fieldBits(f, v)
+		if err != nil {
+			return err
+		}
+		return bw.WriteBits(u, f.bits)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal unpacks bytes in to the struct s points to, according to its bits tags.  It is an error for
+// data to be too short to hold every field, extra bytes are ignored
+func Unmarshal(data []byte, s any) error {
+	v := reflect.ValueOf(s)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("can only unpack in to a pointer to a struct, not %T", s)
+	}
+	br := NewBitReader(bytes.NewReader(data), MSB0)
+	_, err := walkFields(v, func(f bitField, v reflect.Value) error {
+		u, err := br.ReadBits(f.bits)
+		if err != nil {
+			return fmt.Errorf("field %s: %d bytes is too short", f.path, len(data))
+		}
+		setFieldBits(f, v, u)
+		return nil
+	})
+	return err
+}
+
+// bitLayout returns the fields of a struct with the bit offset and width of each, in packing order
+func bitLayout(s any) ([]bitField, int, error) {
+	var fields []bitField
+	total, err := walkFields(reflect.ValueOf(s), func(f bitField, v reflect.Value) error {
+		fields = append(fields, f)
+		return nil
+	})
+	return fields, total, err
+}
+
+// diagramCell returns a field name centred in width characters, cut short if it doesn't fit
+func diagramCell(name string, width int) string {
+	if len(name) > width {
+		name = name[:width]
+	}
+	left := (width - len(name)) / 2
+	return strings.Repeat(" ", left) + name + strings.Repeat(" ", width-len(name)-left)
+}
+
+// Diagram draws the packed layout of a struct in the style of the header diagrams in RFCs, 32 bits to a
+// row with each bit two characters wide.  A field running past the end of a row carries on in the next
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|Version|  IHL  |    DSCP   |ECN|          TotalLength          |
+//	+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+func Diagram(s any) (string, error) {
+	fields, total, err := bitLayout(s)
+	if err != nil {
+		return "", err
+	}
+	var sb, tens, units strings.Builder
+	for i := 0; i < 32; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&tens, " %d", i/10)
+		} else {
+			tens.WriteString("  ")
+		}
+		fmt.Fprintf(&units, " %d", i%10)
+	}
+	sb.WriteString(strings.TrimRight(tens.String(), " ") + "\n" + units.String() + "\n")
+	border := func(bits int) {
+		sb.WriteString(strings.Repeat("+-", bits) + "+\n")
+	}
+	prevBits := 0
+	for row := 0; row < total; row += 32 {
+		// The border above a row needs to reach the end of the longer of the two rows it separates
+		rowBits := min(32, total-row)
+		border(max(prevBits, rowBits))
+		prevBits = rowBits
+		sb.WriteString("|")
+		for _, f := range fields {
+			start, end := max(f.offset, row), min(f.offset+f.bits, row+rowBits)
+			if start >= end {
+				continue
+			}
+			sb.WriteString(diagramCell(f.name(), 2*(end-start)-1) + "|")
+		}
+		sb.WriteString("\n")
+	}
+	border(prevBits)
+	return sb.String(), nil
+}
+
+// ExplainMarshal describes how a struct is packed.  Each field gets a row showing the packed bits of
+// the whole struct with every bit outside that field replaced by a dot, so you can see where each
+// value lands
+func ExplainMarshal(s any) (Explanation, error) {
+	data, err := Marshal(s)
+	if err != nil {
+		return Explanation{}, err
+	}
+	fields, total, err := bitLayout(s)
+	if err != nil {
+		return Explanation{}, err
+	}
+	var packed strings.Builder
+	for _, b := range data {
+		packed.WriteString(Binary(b))
+	}
+	all := packed.String()[:total]
+	labelWidth, valueWidth := len("Packed"), 0
+	for _, f := range fields {
+		labelWidth = max(labelWidth, len(f.name()))
+		valueWidth = max(valueWidth, len(fmt.Sprintf("bits %d-%d", f.offset, f.offset+f.bits-1)))
+	}
+	var rows []ExplainRow
+	for _, f := range fields {
+		bits := strings.Repeat(".", f.offset) + all[f.offset:f.offset+f.bits] + strings.Repeat(".", total-f.offset-f.bits)
+		rows = append(rows, ExplainRow{
+			Label: fmt.Sprintf("%-*s", labelWidth, f.name()),
+			Value: fmt.Sprintf("bits %d-%d", f.offset, f.offset+f.bits-1),
+			Bits:  bits,
+		})
+	}
+	rows = append(rows, ExplainRow{Label: fmt.Sprintf("%-*s", labelWidth, "Packed"), Value: fmt.Sprintf("%d bytes", len(data)), Bits: all})
+	return Explanation{
+		Title: fmt.Sprintf("Packing %T in to %d bits [% x]", s, total, data),
+		Width: valueWidth,
+		Rows:  rows,
+	}, nil
+}
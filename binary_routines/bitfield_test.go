@@ -0,0 +1,184 @@
+// Bit field packing test routines
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// ipv4Start is the first 64 bits of an IPv4 header
+type ipv4Start struct {
+	Version     uint8 `bits:"4"`
+	IHL         uint8 `bits:"4"`
+	DSCP        uint8 `bits:"6"`
+	ECN         uint8 `bits:"2"`
+	TotalLength uint16
+	ID          uint16
+	Flags       struct {
+		_  bool
+		DF bool
+		MF bool
+	}
+	FragOffset uint16 `bits:"13,be"`
+}
+
+// mixed has one of everything the packer handles
+type mixed struct {
+	Small  int8 `bits:"4"`
+	Flags  [3]bool
+	_      uint8    `bits:"1"`
+	Port   uint16   `bits:"16,le"`
+	Counts [2]uint8 `bits:"3"`
+	Skip   string   `bits:"-"`
+	hidden int
+	Wide   int64
+	Tail   uint8 `bits:"2"`
+}
+
+func TestMarshalIPv4(t *testing.T) {
+	h := ipv4Start{Version: 4, IHL: 5, DSCP: 46, ECN: 1, TotalLength: 1500, ID: 0xBEEF, FragOffset: 185}
+	h.Flags.DF = true
+	got, err := Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x45, 0xB9, 0x05, 0xDC, 0xBE, 0xEF, 0x40, 0xB9}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % x instead of % x", got, want)
+	}
+	var back ipv4Start
+	if err := Unmarshal(got, &back); err != nil || back != h {
+		t.Errorf("unmarshalled %+v, %v instead of %+v", back, err, h)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	tests := []mixed{
+		{Small: -8, Flags: [3]bool{true, false, true}, Port: 0x1234, Counts: [2]uint8{7, 1}, Wide: -1, Tail: 3},
+		{Small: 7, Port: 443, Wide: 1 << 62},
+		{},
+	}
+	for _, m := range tests {
+		data, err := Marshal(&m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 4 + 3 + 1 + 16 + 6 + 64 + 2 bits is 96 bits
+		if len(data) != 12 {
+			t.Errorf("packed in to %d bytes instead of 12", len(data))
+		}
+		m.Skip, m.hidden = "ignored", 5
+		var back mixed
+		if err := Unmarshal(data, &back); err != nil {
+			t.Fatal(err)
+		}
+		m.Skip, m.hidden = "", 0
+		if back != m {
+			t.Errorf("got %+v instead of %+v", back, m)
+		}
+	}
+	// The little endian port lands with its low byte first, after the first byte of small fields
+	data, _ := Marshal(mixed{Port: 0x1234})
+	if data[1] != 0x34 || data[2] != 0x12 {
+		t.Errorf("little endian port packed as % x", data[1:3])
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{"overflow", struct {
+			A uint8 `bits:"3"`
+		}{8}, "field A: 8 doesn't fit in 3 bits (0 to 7)"},
+		{"signed overflow", struct {
+			B [2]int8 `bits:"4"`
+		}{[2]int8{1, -9}}, "field B[1]: -9 doesn't fit in 4 signed bits (-8 to 7)"},
+		{"nested overflow", struct {
+			Inner struct {
+				C uint16 `bits:"9"`
+			}
+		}{struct {
+			C uint16 `bits:"9"`
+		}{512}}, "field Inner.C: 512 doesn't fit in 9 bits (0 to 511)"},
+		{"too wide", struct {
+			D uint8 `bits:"9"`
+		}{}, "field D: 9 bits won't fit in a uint8"},
+		{"bad tag", struct {
+			E uint8 `bits:"x"`
+		}{}, `field E: invalid width "x", expected 1 to 64 bits`},
+		{"odd little endian", struct {
+			F uint16 `bits:"12,le"`
+		}{}, "field F: a little endian field needs a whole number of bytes, not 12 bits"},
+		{"unsupported", struct{ G float64 }{}, "field G: can't pack a float64"},
+		{"not a struct", 5, "can only pack structs, not int"},
+		{"nil", nil, "can only pack structs, not nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Marshal(tt.in)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v instead of %s", err, tt.want)
+			}
+		})
+	}
+
+	var h ipv4Start
+	if err := Unmarshal([]byte{0x45, 0}, &h); err == nil || !strings.HasPrefix(err.Error(), "field TotalLength") {
+		t.Errorf("expected a short data error, got %v", err)
+	}
+	if err := Unmarshal([]byte{0x45}, h); err == nil {
+		t.Errorf("expected an error unmarshalling in to a non pointer")
+	}
+}
+
+func TestDiagram(t *testing.T) {
+	got, err := Diagram(ipv4Start{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ` 0                   1                   2                   3
+ 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|Version|  IHL  |   DSCP    |ECN|          TotalLength          |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|              ID               |R|F|F|       FragOffset        |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+`
+	if got != want {
+		t.Errorf("got\n%s\ninstead of\n%s", got, want)
+	}
+
+	// A field crossing a row boundary is drawn in both rows, and a short last row gets a short border
+	got, _ = Diagram(struct {
+		A uint32 `bits:"24"`
+		B uint16
+		C uint8 `bits:"4"`
+	}{})
+	if !strings.HasSuffix(got, "+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+\n"+
+		"|       B       |   C   |\n+-+-+-+-+-+-+-+-+-+-+-+-+\n") {
+		t.Errorf("unexpected diagram\n%s", got)
+	}
+}
+
+func TestExplainMarshal(t *testing.T) {
+	e, err := ExplainMarshal(struct {
+		A uint8 `bits:"3"`
+		B uint8 `bits:"5"`
+	}{5, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_ = (&TextExplainer{W: &buf}).Explain(e)
+	want := "Packing struct { A uint8 \"bits:\\\"3\\\"\"; B uint8 \"bits:\\\"5\\\"\" } in to 8 bits [a3]\n" +
+		"A      [bits 0-2]: 101.....\n" +
+		"B      [bits 3-7]: ...00011\n" +
+		"Packed [ 1 bytes]: 10100011\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\ninstead of\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,283 @@
+// Arbitrary length bit set for when 64 bits isn't enough
+// Andrew Alston
+
+package main
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// Bitset is a set of bits of any length, stored 64 at a time in a slice of uint64 words.  Bit i lives
+// in word i/64 at position i%64 counting from the right, so bit 0 is the right most bit of the first
+// word.  Any bits in the last word beyond the length of the set are always kept at zero, which means
+// we never need to mask them off when counting or comparing
+type Bitset struct {
+	length int
+	words  []uint64
+}
+
+// NewBitset returns an empty bit set holding length bits
+func NewBitset(length int) *Bitset {
+	if length < 0 {
+		length = 0
+	}
+	return &Bitset{length: length, words: make([]uint64, (length+63)/64)}
+}
+
+// BitsetFromUint64 returns a bit set of the given length holding the bits of v
+func BitsetFromUint64(v uint64, length int) *Bitset {
+	b := NewBitset(length)
+	if len(b.words) > 0 {
+		b.words[0] = v
+		b.trim()
+	}
+	return b
+}
+
+// Len returns the number of bits in the set
+func (b *Bitset) Len() int {
+	return b.length
+}
+
+// grow extends the set to hold at least length bits
+func (b *Bitset) grow(length int) {
+	if length <= b.length {
+		return
+	}
+	if need := (length + 63) / 64; need > len(b.words) {
+		b.words = append(b.words, make([]uint64, need-len(b.words))...)
+	}
+	b.length = length
+}
+
+// trim clears any bits in the last word that are beyond the length of the set
+func (b *Bitset) trim() {
+	if extra := b.length % 64; extra != 0 {
+		b.words[len(b.words)-1] &= 1<<extra - 1
+	}
+}
+
+// Set sets bit i, growing the set if i is beyond its current length.  There are no negative bits, so
+// nothing happens for a negative i
+func (b *Bitset) Set(i int) *Bitset {
+	if i < 0 {
+		return b
+	}
+	b.grow(i + 1)
+	b.words[i/64] |= 1 << (i % 64)
+	return b
+}
+
+// Clear clears bit i.  Bits beyond the length of the set are already clear, so nothing happens
+func (b *Bitset) Clear(i int) *Bitset {
+	if i >= 0 && i < b.length {
+		b.words[i/64] &^= 1 << (i % 64)
+	}
+	return b
+}
+
+// Flip flips bit i, growing the set if i is beyond its current length.  As with Set, nothing happens for
+// a negative i
+func (b *Bitset) Flip(i int) *Bitset {
+	if i < 0 {
+		return b
+	}
+	b.grow(i + 1)
+	b.words[i/64] ^= 1 << (i % 64)
+	return b
+}
+
+// Test returns true if bit i is set.  Bits beyond the length of the set are always clear
+func (b *Bitset) Test(i int) bool {
+	if i < 0 || i >= b.length {
+		return false
+	}
+	return b.words[i/64]&(1<<(i%64)) != 0
+}
+
+// Clone returns a copy of the set
+func (b *Bitset) Clone() *Bitset {
+	return &Bitset{length: b.length, words: append([]uint64(nil), b.words...)}
+}
+
+// Equal returns true if both sets have the same length and the same bits set
+func (b *Bitset) Equal(o *Bitset) bool {
+	if b.length != o.length {
+		return false
+	}
+	for i := range b.words {
+		if b.words[i] != o.words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// combine applies op word by word with o, treating any words o doesn't have as zero.  The set grows to
+// the longer of the two lengths
+func (b *Bitset) combine(o *Bitset, op func(x, y uint64) uint64) *Bitset {
+	b.grow(o.length)
+	for i := range b.words {
+		var y uint64
+		if i < len(o.words) {
+			y = o.words[i]
+		}
+		b.words[i] = op(b.words[i], y)
+	}
+	b.trim()
+	return b
+}
+
+// And sets b to the bitwise AND of b and o, in place
+func (b *Bitset) And(o *Bitset) *Bitset {
+	return b.combine(o, func(x, y uint64) uint64 { return x & y })
+}
+
+// Or sets b to the bitwise OR of b and o, in place
+func (b *Bitset) Or(o *Bitset) *Bitset {
+	return b.combine(o, func(x, y uint64) uint64 { return x | y })
+}
+
+// Xor sets b to the exclusive OR of b and o, in place
+func (b *Bitset) Xor(o *Bitset) *Bitset {
+	return b.combine(o, func(x, y uint64) uint64 { return x ^ y })
+}
+
+// AndNot clears every bit in b that is set in o, in place
+func (b *Bitset) AndNot(o *Bitset) *Bitset {
+	return b.combine(o, func(x, y uint64) uint64 { return x &^ y })
+}
+
+// Intersection returns a new set holding the bitwise AND of b and o, leaving both unchanged
+func (b *Bitset) Intersection(o *Bitset) *Bitset {
+	return b.Clone().And(o)
+}
+
+// Union returns a new set holding the bitwise OR of b and o, leaving both unchanged
+func (b *Bitset) Union(o *Bitset) *Bitset {
+	return b.Clone().Or(o)
+}
+
+// SymmetricDifference returns a new set holding the exclusive OR of b and o, leaving both unchanged
+func (b *Bitset) SymmetricDifference(o *Bitset) *Bitset {
+	return b.Clone().Xor(o)
+}
+
+// Difference returns a new set holding the bits of b that are not set in o, leaving both unchanged
+func (b *Bitset) Difference(o *Bitset) *Bitset {
+	return b.Clone().AndNot(o)
+}
+
+// Count returns the number of bits set, using the population count of each word
+func (b *Bitset) Count() int {
+	var res int
+	for _, w := range b.words {
+		res += bits.OnesCount64(w)
+	}
+	return res
+}
+
+// NextSet returns the first set bit at or after i, and false if there isn't one.  Rather than testing
+// every bit we mask off the bits below i in the first word, then skip whole words that are zero, and
+// finally count the trailing zeros of the first non-zero word to find the bit
+func (b *Bitset) NextSet(i int) (int, bool) {
+	if i < 0 {
+		i = 0
+	}
+	if i >= b.length {
+		return 0, false
+	}
+	w := i / 64
+	word := b.words[w] &^ (1<<(i%64) - 1)
+	for word == 0 {
+		w++
+		if w == len(b.words) {
+			return 0, false
+		}
+		word = b.words[w]
+	}
+	return w*64 + bits.TrailingZeros64(word), true
+}
+
+// NextClear returns the first clear bit at or after i, and false if there isn't one within the length
+// of the set.  This works like NextSet on the inverse of each word
+func (b *Bitset) NextClear(i int) (int, bool) {
+	if i < 0 {
+		i = 0
+	}
+	if i >= b.length {
+		return 0, false
+	}
+	w := i / 64
+	word := ^b.words[w] &^ (1<<(i%64) - 1)
+	for word == 0 {
+		w++
+		if w == len(b.words) {
+			return 0, false
+		}
+		word = ^b.words[w]
+	}
+	if res := w*64 + bits.TrailingZeros64(word); res < b.length {
+		return res, true
+	}
+	return 0, false
+}
+
+// ShiftLeft moves every bit n places towards the top of the set, in the same way as << on an integer.
+// Bits moved past the length of the set are dropped and zeros come in at the bottom.  A shift of n is
+// a shift of n/64 whole words followed by n%64 bits, where the bits pushed out of the top of each word
+// are carried into the bottom of the next word up
+func (b *Bitset) ShiftLeft(n int) *Bitset {
+	if n <= 0 {
+		return b
+	}
+	words, shift := n/64, n%64
+	for i := len(b.words) - 1; i >= 0; i-- {
+		var w uint64
+		if src := i - words; src >= 0 {
+			w = b.words[src] << shift
+			if shift != 0 && src > 0 {
+				w |= b.words[src-1] >> (64 - shift)
+			}
+		}
+		b.words[i] = w
+	}
+	b.trim()
+	return b
+}
+
+// ShiftRight moves every bit n places towards the bottom of the set, in the same way as >> on an
+// unsigned integer.  Bits moved below zero are dropped and zeros come in at the top
+func (b *Bitset) ShiftRight(n int) *Bitset {
+	if n <= 0 {
+		return b
+	}
+	words, shift := n/64, n%64
+	for i := range b.words {
+		var w uint64
+		if src := i + words; src < len(b.words) {
+			w = b.words[src] >> shift
+			if shift != 0 && src+1 < len(b.words) {
+				w |= b.words[src+1] << (64 - shift)
+			}
+		}
+		b.words[i] = w
+	}
+	return b
+}
+
+// String renders the set in binary with the highest bit on the left, zero padded to the length of the
+// set, so a 16 bit set prints the same as a uint16 does with %016b
+func (b *Bitset) String() string {
+	var sb strings.Builder
+	sb.Grow(b.length)
+	for i := b.length - 1; i >= 0; i-- {
+		if b.Test(i) {
+			sb.WriteByte('1')
+		} else {
+			sb.WriteByte('0')
+		}
+	}
+	return sb.String()
+}
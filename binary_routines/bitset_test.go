@@ -0,0 +1,191 @@
+// Bitset test routines
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomBitset returns a bit set of the given length with random bits, along with a big.Int holding
+// the same bits, which we use as a reference implementation
+func randomBitset(r *rand.Rand, length int) (*Bitset, *big.Int) {
+	b := NewBitset(length)
+	ref := new(big.Int)
+	for i := 0; i < length; i++ {
+		if r.IntN(2) == 1 {
+			b.Set(i)
+			ref.SetBit(ref, i, 1)
+		}
+	}
+	return b, ref
+}
+
+// matches returns true if b holds exactly the bits in ref
+func matches(b *Bitset, ref *big.Int) bool {
+	if ref.BitLen() > b.Len() {
+		return false
+	}
+	for i := 0; i < b.Len(); i++ {
+		if b.Test(i) != (ref.Bit(i) == 1) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBitsetString(t *testing.T) {
+	tests := []struct {
+		v      uint64
+		length int
+	}{
+		{50, 16},
+		{60, 16},
+		{0xFF, 8},
+		{1, 1},
+		{0xDEADBEEF, 32},
+		{0x8000000000000001, 64},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d,%d", tt.v, tt.length)
+		t.Run(testName, func(t *testing.T) {
+			got := BitsetFromUint64(tt.v, tt.length).String()
+			want := fmt.Sprintf("%0*b", tt.length, tt.v)
+			if got != want {
+				t.Errorf("got %s instead of %s", got, want)
+			}
+		})
+	}
+	if s := BitsetFromUint64(0xFF, 4).String(); s != "1111" {
+		t.Errorf("bits beyond the length should be dropped, got %s", s)
+	}
+}
+
+func TestBitsetSetClearFlip(t *testing.T) {
+	b := NewBitset(10)
+	b.Set(1).Set(3).Flip(5).Flip(3)
+	if b.String() != "0000100010" || b.Count() != 2 {
+		t.Errorf("got %s with %d bits set", b, b.Count())
+	}
+	b.Clear(1).Clear(500)
+	if b.Test(1) || !b.Test(5) || b.Test(500) || b.Test(-1) {
+		t.Errorf("unexpected bits after clearing: %s", b)
+	}
+	b.Set(130)
+	if b.Len() != 131 || !b.Test(130) || b.Count() != 2 {
+		t.Errorf("set should grow the bit set, got length %d", b.Len())
+	}
+	b.Set(-1).Flip(-64)
+	if b.Len() != 131 || b.Count() != 2 {
+		t.Errorf("negative bits should be ignored, got %s", b)
+	}
+}
+
+func TestBitsetOperations(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, length := range []int{1, 63, 64, 65, 128, 200, 1000} {
+		testName := fmt.Sprintf("Length: %d", length)
+		t.Run(testName, func(t *testing.T) {
+			a, refA := randomBitset(r, length)
+			b, refB := randomBitset(r, length)
+			checks := []struct {
+				name string
+				got  *Bitset
+				want *big.Int
+			}{
+				{"and", a.Intersection(b), new(big.Int).And(refA, refB)},
+				{"or", a.Union(b), new(big.Int).Or(refA, refB)},
+				{"xor", a.SymmetricDifference(b), new(big.Int).Xor(refA, refB)},
+				{"andnot", a.Difference(b), new(big.Int).AndNot(refA, refB)},
+			}
+			for _, c := range checks {
+				if !matches(c.got, c.want) {
+					t.Errorf("%s gave %s", c.name, c.got)
+				}
+			}
+			// The non-destructive versions must leave the inputs alone, the in place versions must not
+			if !matches(a, refA) || !matches(b, refB) {
+				t.Errorf("non-destructive operation changed its input")
+			}
+			a.Xor(b)
+			if !matches(a, new(big.Int).Xor(refA, refB)) {
+				t.Errorf("in place xor gave %s", a)
+			}
+			count := 0
+			for _, w := range new(big.Int).Xor(refA, refB).Bits() {
+				count += popcount(uint64(w))
+			}
+			if a.Count() != count {
+				t.Errorf("count gave %d instead of %d", a.Count(), count)
+			}
+		})
+	}
+}
+
+// popcount counts set bits one at a time, as a simple reference for Count
+func popcount(v uint64) int {
+	var res int
+	for ; v != 0; v >>= 1 {
+		res += int(v & 1)
+	}
+	return res
+}
+
+func TestBitsetShift(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	for _, length := range []int{8, 64, 100, 256} {
+		for _, n := range []int{0, 1, 7, 63, 64, 65, 130, 300} {
+			testName := fmt.Sprintf("Length: %d Shift: %d", length, n)
+			t.Run(testName, func(t *testing.T) {
+				b, ref := randomBitset(r, length)
+				// The reference has to drop anything shifted beyond the length of the set
+				limit := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(length)), big.NewInt(1))
+				left := new(big.Int).And(new(big.Int).Lsh(ref, uint(n)), limit)
+				if got := b.Clone().ShiftLeft(n); !matches(got, left) {
+					t.Errorf("left shift gave %s", got)
+				}
+				if got := b.Clone().ShiftRight(n); !matches(got, new(big.Int).Rsh(ref, uint(n))) {
+					t.Errorf("right shift gave %s", got)
+				}
+			})
+		}
+	}
+}
+
+func TestBitsetNext(t *testing.T) {
+	b := NewBitset(200)
+	b.Set(3).Set(64).Set(150)
+	var set []int
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		set = append(set, i)
+	}
+	if fmt.Sprint(set) != "[3 64 150]" {
+		t.Errorf("iterating set bits gave %v", set)
+	}
+
+	full := NewBitset(130)
+	for i := 0; i < 130; i++ {
+		full.Set(i)
+	}
+	if _, ok := full.NextClear(0); ok {
+		t.Errorf("a full set should have no clear bits")
+	}
+	full.Clear(70)
+	if i, ok := full.NextClear(5); i != 70 || !ok {
+		t.Errorf("NextClear gave %d [%v] instead of 70", i, ok)
+	}
+	if i, ok := b.NextClear(3); i != 4 || !ok {
+		t.Errorf("NextClear gave %d [%v] instead of 4", i, ok)
+	}
+	if _, ok := b.NextSet(151); ok {
+		t.Errorf("NextSet past the last bit should fail")
+	}
+}
+
+func TestBitsetEqual(t *testing.T) {
+	a := BitsetFromUint64(0xF0, 8)
+	if !a.Equal(BitsetFromUint64(0xF0, 8)) || a.Equal(BitsetFromUint64(0xF0, 9)) || a.Equal(BitsetFromUint64(0xF1, 8)) {
+		t.Errorf("unexpected equality results")
+	}
+}
@@ -0,0 +1,243 @@
+// Bit level stream reader and writer for fields that aren't byte aligned
+// Andrew Alston
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrBitCount is returned when asked to read or write more than 64 bits at once
+var ErrBitCount = errors.New("bit count must be between 0 and 64")
+
+// BitReader reads fields of any number of bits from an io.Reader.  The order says which end of each
+// byte we start from.  With MSB0 the first bit read is the left most bit of the byte and fields are
+// read most significant bit first, which is how network protocols lay out their headers.  With LSB0
+// the first bit read is the right most bit and fields are read least significant bit first, which is
+// how formats such as DEFLATE pack their codes.  Once an error occurs every later read returns it
+type BitReader struct {
+	r         io.Reader
+	order     BitOrder
+	lookahead []byte
+	bitPos    int
+	read      int64
+	err       error
+}
+
+// NewBitReader returns a reader taking bits from r in the given order
+func NewBitReader(r io.Reader, order BitOrder) *BitReader {
+	return &BitReader{r: r, order: order}
+}
+
+// fill makes sure at least n bits are buffered, reading more bytes if we need them.  If the stream ends
+// first we return io.EOF when no bits were left at all, and io.ErrUnexpectedEOF when it ended part
+// way through the field
+func (br *BitReader) fill(n int) error {
+	have := len(br.lookahead)*8 - br.bitPos
+	if have >= n {
+		return nil
+	}
+	need := (n - have + 7) / 8
+	start := len(br.lookahead)
+	br.lookahead = append(br.lookahead, make([]byte, need)...)
+	got, err := io.ReadFull(br.r, br.lookahead[start:])
+	br.lookahead = br.lookahead[:start+got]
+	switch {
+	case err == io.EOF && have == 0:
+		return io.EOF
+	case err == io.EOF:
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// bit returns buffered bit i, counting from the next unread bit.  TestBit picks the bit out of its
+// byte, numbering the bits in the byte from the end the order says we start at
+func (br *BitReader) bit(i int) uint64 {
+	pos := br.bitPos + i
+	if set, _ := TestBit(br.lookahead[pos/8], uint8(pos%8), br.order); set {
+		return 1
+	}
+	return 0
+}
+
+// PeekBits returns the next n bits without consuming them
+func (br *BitReader) PeekBits(n int) (uint64, error) {
+	if br.err != nil {
+		return 0, br.err
+	}
+	if n < 0 || n > 64 {
+		return 0, ErrBitCount
+	}
+	if err := br.fill(n); err != nil {
+		// A short peek doesn't consume anything, so it doesn't stop later reads of fewer bits
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			br.err = err
+		}
+		return 0, err
+	}
+	var v uint64
+	for i := 0; i < n; i++ {
+		if br.order == MSB0 {
+			// Each new bit is less significant than the ones before, so shift them up to make room
+			v = v<<1 | br.bit(i)
+		} else {
+			// Each new bit is more significant than the ones before, so it goes in above them
+			v |= br.bit(i) << i
+		}
+	}
+	return v, nil
+}
+
+// skip consumes n buffered bits, dropping any bytes we have finished with
+func (br *BitReader) skip(n int) {
+	br.bitPos += n
+	br.read += int64(n)
+	br.lookahead = br.lookahead[br.bitPos/8:]
+	br.bitPos %= 8
+}
+
+// ReadBits reads an n bit field, up to 64 bits
+func (br *BitReader) ReadBits(n int) (uint64, error) {
+	v, err := br.PeekBits(n)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			br.err = err
+		}
+		return 0, err
+	}
+	br.skip(n)
+	return v, nil
+}
+
+// ReadBit reads a single bit as a bool
+func (br *BitReader) ReadBit() (bool, error) {
+	v, err := br.ReadBits(1)
+	return v == 1, err
+}
+
+// Align skips to the start of the next byte, returning the number of bits skipped.  Fields that follow
+// a run of odd sized fields often start on a byte boundary
+func (br *BitReader) Align() int {
+	// Part of the current byte has been read, so it is still in the lookahead buffer
+	skipped := (8 - br.bitPos) % 8
+	br.skip(skipped)
+	return skipped
+}
+
+// Aligned returns true if the next bit read is the first bit of a byte
+func (br *BitReader) Aligned() bool {
+	return br.bitPos == 0
+}
+
+// BitsRead returns the number of bits consumed so far
+func (br *BitReader) BitsRead() int64 {
+	return br.read
+}
+
+// Err returns the error that stopped the reader, if any
+func (br *BitReader) Err() error {
+	return br.err
+}
+
+// BitWriter writes fields of any number of bits to an io.Writer, in the same orders as BitReader.
+// Bytes are buffered until Flush, which pads the last byte with zeros.  Once an error occurs every
+// later write returns it
+type BitWriter struct {
+	w       io.Writer
+	order   BitOrder
+	buf     []byte
+	cur     byte
+	nbits   int
+	written int64
+	err     error
+}
+
+// NewBitWriter returns a writer putting bits to w in the given order
+func NewBitWriter(w io.Writer, order BitOrder) *BitWriter {
+	return &BitWriter{w: w, order: order}
+}
+
+// writeBit adds one bit to the current byte, using SetBit to put it in place, and moves the byte to the
+// buffer once all 8 bits are filled
+func (bw *BitWriter) writeBit(b uint64) {
+	if b == 1 {
+		bw.cur, _ = SetBit(bw.cur, uint8(bw.nbits), bw.order)
+	}
+	bw.nbits++
+	bw.written++
+	if bw.nbits == 8 {
+		bw.buf = append(bw.buf, bw.cur)
+		bw.cur, bw.nbits = 0, 0
+	}
+}
+
+// WriteBits writes the bottom n bits of v as an n bit field.  It is an error for v to have bits set
+// above the bottom n, since they would be silently lost
+func (bw *BitWriter) WriteBits(v uint64, n int) error {
+	if bw.err != nil {
+		return bw.err
+	}
+	if n < 0 || n > 64 {
+		return ErrBitCount
+	}
+	if n < 64 && v>>n != 0 {
+		return fmt.Errorf("value %d doesn't fit in %d bits", v, n)
+	}
+	for i := 0; i < n; i++ {
+		if bw.order == MSB0 {
+			bw.writeBit(v >> (n - 1 - i) & 1)
+		} else {
+			bw.writeBit(v >> i & 1)
+		}
+	}
+	// Don't let the buffer grow without limit on long streams
+	if len(bw.buf) >= 4096 {
+		return bw.flushBuf()
+	}
+	return nil
+}
+
+// WriteBit writes a single bit from a bool
+func (bw *BitWriter) WriteBit(b bool) error {
+	var v uint64
+	if b {
+		v = 1
+	}
+	return bw.WriteBits(v, 1)
+}
+
+// Align pads the current byte with zero bits so the next field starts on a byte boundary, returning
+// the number of bits added
+func (bw *BitWriter) Align() (int, error) {
+	pad := (8 - bw.nbits) % 8
+	return pad, bw.WriteBits(0, pad)
+}
+
+// BitsWritten returns the number of bits written so far, including padding added by Align
+func (bw *BitWriter) BitsWritten() int64 {
+	return bw.written
+}
+
+// flushBuf writes out the completed bytes
+func (bw *BitWriter) flushBuf() error {
+	if len(bw.buf) == 0 {
+		return nil
+	}
+	if _, err := bw.w.Write(bw.buf); err != nil {
+		bw.err = err
+		return err
+	}
+	bw.buf = bw.buf[:0]
+	return nil
+}
+
+// Flush pads the last byte with zeros and writes everything buffered to the underlying writer
+func (bw *BitWriter) Flush() error {
+	if _, err := bw.Align(); err != nil {
+		return err
+	}
+	return bw.flushBuf()
+}
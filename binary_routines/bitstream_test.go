@@ -0,0 +1,231 @@
+// Bit stream test routines
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// field is one value written to or read from a bit stream
+type field struct {
+	v uint64
+	n int
+}
+
+func TestBitWriterLayout(t *testing.T) {
+	fields := []field{{0b101, 3}, {0b00011, 5}, {0x1ABC, 13}, {1, 1}}
+	tests := []struct {
+		order BitOrder
+		want  []byte
+	}{
+		// 101 00011 | 1101010111100 1 and two bits of padding
+		{MSB0, []byte{0xA3, 0xD5, 0xE4}},
+		// Each field goes in from the right of the byte, least significant bit first
+		{LSB0, []byte{0x1D, 0xBC, 0x3A}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			bw := NewBitWriter(&buf, tt.order)
+			for _, f := range fields {
+				if err := bw.WriteBits(f.v, f.n); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if bw.BitsWritten() != 22 {
+				t.Errorf("wrote %d bits instead of 22", bw.BitsWritten())
+			}
+			if err := bw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("got % x instead of % x", buf.Bytes(), tt.want)
+			}
+
+			br := NewBitReader(bytes.NewReader(buf.Bytes()), tt.order)
+			for _, f := range fields {
+				if v, err := br.ReadBits(f.n); err != nil || v != f.v {
+					t.Errorf("read %d, %v instead of %d", v, err, f.v)
+				}
+			}
+		})
+	}
+}
+
+func TestBitReader(t *testing.T) {
+	br := NewBitReader(bytes.NewReader([]byte{0xA3, 0xFF, 0x01}), MSB0)
+	if v, _ := br.PeekBits(4); v != 0xA {
+		t.Errorf("peek gave %x instead of a", v)
+	}
+	if v, _ := br.PeekBits(12); v != 0xA3F {
+		t.Errorf("peek gave %x instead of a3f", v)
+	}
+	if b, _ := br.ReadBit(); !b {
+		t.Errorf("first bit should be set")
+	}
+	if skipped := br.Align(); skipped != 7 || !br.Aligned() || br.BitsRead() != 8 {
+		t.Errorf("align skipped %d bits, read %d", skipped, br.BitsRead())
+	}
+	if skipped := br.Align(); skipped != 0 {
+		t.Errorf("align on a boundary skipped %d bits", skipped)
+	}
+	if v, _ := br.ReadBits(16); v != 0xFF01 {
+		t.Errorf("read %x instead of ff01", v)
+	}
+	if _, err := br.ReadBits(1); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+	if _, err := br.ReadBits(65); err != ErrBitCount {
+		t.Errorf("expected ErrBitCount, got %v", err)
+	}
+
+	// Peeking past the end doesn't stop us reading what is there, but a short read does
+	br = NewBitReader(bytes.NewReader([]byte{0xF0}), LSB0)
+	if _, err := br.PeekBits(9); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if v, err := br.ReadBits(5); err != nil || v != 0x10 {
+		t.Errorf("read %x, %v instead of 10", v, err)
+	}
+	if _, err := br.ReadBits(4); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err := br.ReadBits(1); err != io.ErrUnexpectedEOF || br.Err() != io.ErrUnexpectedEOF {
+		t.Errorf("the error should stick, got %v", err)
+	}
+}
+
+// failWriter fails every write
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestBitWriterErrors(t *testing.T) {
+	bw := NewBitWriter(io.Discard, MSB0)
+	if err := bw.WriteBits(8, 3); err == nil {
+		t.Errorf("8 shouldn't fit in 3 bits")
+	}
+	if err := bw.WriteBits(0, 65); err != ErrBitCount {
+		t.Errorf("expected ErrBitCount, got %v", err)
+	}
+	if err := bw.WriteBits(^uint64(0), 64); err != nil {
+		t.Errorf("64 bits should fit, got %v", err)
+	}
+
+	bw = NewBitWriter(failWriter{}, MSB0)
+	_ = bw.WriteBits(5, 3)
+	if err := bw.Flush(); err == nil {
+		t.Fatalf("expected the write error")
+	}
+	if err := bw.WriteBit(true); err == nil || err.Error() != "disk full" {
+		t.Errorf("the error should stick, got %v", err)
+	}
+}
+
+// fieldsFromBytes turns fuzz input into a list of fields, each taking a width from one byte and a value
+// from the next eight, masked to the width
+func fieldsFromBytes(data []byte) []field {
+	var fields []field
+	for len(data) >= 9 {
+		n := int(data[0] % 65)
+		v := binary.LittleEndian.Uint64(data[1:9])
+		if n < 64 {
+			v &= 1<<n - 1
+		}
+		fields = append(fields, field{v, n})
+		data = data[9:]
+	}
+	return fields
+}
+
+// FuzzBitRoundTrip writes a random sequence of fields in both orders, with a random alignment between
+// them, and checks that reading them back, with a peek before each read, gives the same values
+func FuzzBitRoundTrip(f *testing.F) {
+	f.Add([]byte{3, 5, 0, 0, 0, 0, 0, 0, 0, 13, 0xBC, 0x1A, 0, 0, 0, 0, 0, 0}, uint8(0))
+	f.Add(bytes.Repeat([]byte{64, 0xFF, 1, 2, 3, 4, 5, 6, 7, 1, 1, 0, 0, 0, 0, 0, 0, 0}, 4), uint8(0xAA))
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 7, 0xFF, 0, 0, 0, 0, 0, 0, 0}, uint8(0xFF))
+	f.Fuzz(func(t *testing.T, data []byte, aligns uint8) {
+		fields := fieldsFromBytes(data)
+		for _, order := range []BitOrder{MSB0, LSB0} {
+			var buf bytes.Buffer
+			bw := NewBitWriter(&buf, order)
+			for i, fld := range fields {
+				if err := bw.WriteBits(fld.v, fld.n); err != nil {
+					t.Fatal(err)
+				}
+				if aligns>>(i%8)&1 == 1 {
+					if _, err := bw.Align(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := bw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if int64(buf.Len())*8 != (bw.BitsWritten()+7)/8*8 {
+				t.Fatalf("wrote %d bytes for %d bits", buf.Len(), bw.BitsWritten())
+			}
+
+			br := NewBitReader(&buf, order)
+			for i, fld := range fields {
+				peek, err := br.PeekBits(fld.n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				v, err := br.ReadBits(fld.n)
+				if err != nil || v != fld.v || peek != v {
+					t.Fatalf("%s field %d: read %d (peeked %d), %v instead of %d", order, i, v, peek, err, fld.v)
+				}
+				if aligns>>(i%8)&1 == 1 {
+					br.Align()
+				}
+			}
+			br.Align()
+			if _, err := br.ReadBits(1); err != io.EOF {
+				t.Fatalf("%s: expected io.EOF after the last field, got %v", order, err)
+			}
+		}
+	})
+}
+
+// FuzzBitReader reads arbitrary input with arbitrary field widths, checking the reader never panics and
+// that the bits it returns match the bytes underneath
+func FuzzBitReader(f *testing.F) {
+	f.Add([]byte{0xA3, 0xFF, 0x01}, []byte{1, 7, 16})
+	f.Add([]byte{}, []byte{0})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, []byte{64, 9, 3})
+	f.Fuzz(func(t *testing.T, data, widths []byte) {
+		if len(widths) == 0 {
+			return
+		}
+		br := NewBitReader(bytes.NewReader(data), MSB0)
+		total := int64(len(data)) * 8
+		for i := 0; ; i++ {
+			n := int(widths[i%len(widths)] % 65)
+			start := br.BitsRead()
+			v, err := br.ReadBits(n)
+			if err != nil {
+				if start+int64(n) <= total {
+					t.Fatalf("reading %d bits at %d of %d failed: %v", n, start, total, err)
+				}
+				return
+			}
+			// Work out the same field a bit at a time straight from the input
+			var want uint64
+			for b := start; b < start+int64(n); b++ {
+				want = want<<1 | uint64(data[b/8]>>(7-b%8)&1)
+			}
+			if v != want {
+				t.Fatalf("read %x at bit %d instead of %x", v, start, want)
+			}
+			if n == 0 && i > 10*len(widths) {
+				return
+			}
+		}
+	})
+}
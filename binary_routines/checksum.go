@@ -0,0 +1,93 @@
+// The one's complement Internet checksum used by IPv4, TCP, UDP and ICMP
+// Andrew Alston
+
+package main
+
+import "fmt"
+
+// checksumSum adds data to a running sum as big endian 16 bit words, padding an odd last byte with a
+// zero.  We keep the carries out of the bottom 16 bits in the upper bits and add them back in when
+// folding, which is cheaper than adding each carry back as it happens.  A 64 bit sum can't overflow
+// however much data we add
+func checksumSum(sum uint64, data []byte) uint64 {
+	for len(data) >= 2 {
+		sum += uint64(data[0])<<8 | uint64(data[1])
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		sum += uint64(data[0]) << 8
+	}
+	return sum
+}
+
+// checksumFold folds the carries in the upper bits of the sum back in to the bottom 16 bits.  This is the
+// end around carry of one's complement addition, and folding can carry again so we repeat until it stops
+func checksumFold(sum uint64) uint16 {
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return uint16(sum)
+}
+
+// InternetChecksum returns the RFC 1071 checksum of data, the one's complement of the one's complement
+// sum of its 16 bit words.  To fill in a header checksum, zero the checksum field, checksum the header
+// and store the result in the field
+func InternetChecksum(data []byte) uint16 {
+	return ^checksumFold(checksumSum(0, data))
+}
+
+// VerifyInternetChecksum returns true if data, including its checksum field, has a valid checksum.
+// Summing data with a correct checksum in it gives all ones, so the checksum of the whole is zero
+func VerifyInternetChecksum(data []byte) bool {
+	return InternetChecksum(data) == 0
+}
+
+// PseudoHeaderChecksum returns the checksum of a TCP or UDP segment including the IPv4 pseudo header of
+// the source and destination addresses, protocol and length that those checksums also cover
+func PseudoHeaderChecksum(src, dst uint32, proto uint8, segment []byte) uint16 {
+	return ^checksumFold(checksumSum(ipv4PseudoSum(src, dst, proto, len(segment)), segment))
+}
+
+// ipv4PseudoSum returns the sum of the IPv4 pseudo header words
+func ipv4PseudoSum(src, dst uint32, proto uint8, length int) uint64 {
+	return uint64(src>>16) + uint64(src&0xFFFF) + uint64(dst>>16) + uint64(dst&0xFFFF) + uint64(proto) + uint64(length)
+}
+
+// UpdateChecksum returns the new checksum after a 16 bit word covered by the checksum changes from
+// oldWord to newWord, without summing the whole packet again.  This is equation 3 of RFC 1624,
+// HC' = ~(~HC + ~m + m'), which routers use to fix the IPv4 checksum when they decrement the TTL.  The
+// older equation from RFC 1141 gives the wrong answer, 0xFFFF rather than 0, in one corner case
+func UpdateChecksum(checksum, oldWord, newWord uint16) uint16 {
+	sum := uint64(^checksum) + uint64(^oldWord) + uint64(newWord)
+	return ^checksumFold(sum)
+}
+
+// UpdateChecksum32 updates a checksum after a 32 bit field changes, such as an address rewritten by
+// NAT, by updating it for each half in turn
+func UpdateChecksum32(checksum uint16, oldValue, newValue uint32) uint16 {
+	checksum = UpdateChecksum(checksum, uint16(oldValue>>16), uint16(newValue>>16))
+	return UpdateChecksum(checksum, uint16(oldValue), uint16(newValue))
+}
+
+// ExplainInternetChecksum describes an Internet checksum, showing each 16 bit word, the running sum
+// with its carries, the fold back in to 16 bits and the final complement
+func ExplainInternetChecksum(data []byte) Explanation {
+	var rows []ExplainRow
+	var sum uint64
+	for i := 0; i < len(data); i += 2 {
+		word := checksumSum(0, data[i:min(i+2, len(data))])
+		sum += word
+		rows = append(rows, ExplainRow{Label: "Word  ", Value: fmt.Sprintf("%04x", word), Bits: fmt.Sprintf("%020b", word)})
+	}
+	folded := checksumFold(sum)
+	rows = append(rows,
+		ExplainRow{Label: "Sum   ", Value: fmt.Sprintf("%x", sum), Bits: fmt.Sprintf("%020b", sum)},
+		ExplainRow{Label: "Folded", Value: fmt.Sprintf("%04x", folded), Bits: fmt.Sprintf("%020b", folded)},
+		ExplainRow{Label: "Result", Value: fmt.Sprintf("%04x", ^folded), Bits: fmt.Sprintf("%020b", ^folded)},
+	)
+	return Explanation{
+		Title: fmt.Sprintf("Internet checksum of %d bytes", len(data)),
+		Width: 5,
+		Rows:  rows,
+	}
+}
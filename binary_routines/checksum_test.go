@@ -0,0 +1,106 @@
+// Internet checksum test routines
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+// sampleIPv4Header is an IPv4 header with its checksum of b861 at bytes 10 and 11
+var sampleIPv4Header = []byte{0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0xb8, 0x61,
+	0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7}
+
+func TestInternetChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		// The example from section 3 of RFC 1071, whose sum is ddf2
+		{"rfc1071", []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}, ^uint16(0xddf2)},
+		{"empty", nil, 0xFFFF},
+		{"odd length", []byte{0x01, 0x02, 0x03}, ^uint16(0x0402)},
+		{"carries", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x02}, ^uint16(0x0002)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InternetChecksum(tt.data); got != tt.want {
+				t.Errorf("got %04x instead of %04x", got, tt.want)
+			}
+		})
+	}
+
+	header := bytes.Clone(sampleIPv4Header)
+	if !VerifyInternetChecksum(header) {
+		t.Errorf("the sample header should verify")
+	}
+	header[10], header[11] = 0, 0
+	if got := InternetChecksum(header); got != 0xb861 {
+		t.Errorf("header checksum was %04x instead of b861", got)
+	}
+}
+
+// TestUpdateChecksum changes random words in random packets and checks the incremental update gives the
+// same checksum as starting again, including the RFC 1624 corner case
+func TestUpdateChecksum(t *testing.T) {
+	r := rand.New(rand.NewPCG(1624, 1))
+	for i := 0; i < 5000; i++ {
+		data := make([]byte, 2+2*r.IntN(20))
+		for j := range data {
+			data[j] = byte(r.Uint32())
+		}
+		before := InternetChecksum(data)
+		pos := 2 * r.IntN(len(data)/2)
+		oldWord := uint16(data[pos])<<8 | uint16(data[pos+1])
+		newWord := uint16(r.Uint32())
+		data[pos], data[pos+1] = byte(newWord>>8), byte(newWord)
+		if got, want := UpdateChecksum(before, oldWord, newWord), InternetChecksum(data); got != want {
+			t.Fatalf("updating %04x to %04x gave %04x instead of %04x", oldWord, newWord, got, want)
+		}
+	}
+
+	// The words aaaa 5555 0000 sum to all ones so their checksum is zero, the case RFC 1624 section 4
+	// shows the older RFC 1141 equation getting wrong
+	if got := UpdateChecksum(0x0000, 0x5555, 0xAAAA); got != InternetChecksum([]byte{0xAA, 0xAA, 0xAA, 0xAA, 0x00, 0x00}) {
+		t.Errorf("corner case gave %04x", got)
+	}
+
+	// Decrementing the TTL of the sample header
+	header := bytes.Clone(sampleIPv4Header)
+	old := uint16(header[8])<<8 | uint16(header[9])
+	header[8]--
+	checksum := UpdateChecksum(0xb861, old, uint16(header[8])<<8|uint16(header[9]))
+	header[10], header[11] = byte(checksum>>8), byte(checksum)
+	if !VerifyInternetChecksum(header) {
+		t.Errorf("header with decremented TTL doesn't verify, checksum %04x", checksum)
+	}
+
+	// Rewriting the source address as NAT does
+	header = bytes.Clone(sampleIPv4Header)
+	checksum = UpdateChecksum32(0xb861, 0xc0a80001, 0x0a000001)
+	copy(header[12:], []byte{0x0a, 0x00, 0x00, 0x01})
+	header[10], header[11] = byte(checksum>>8), byte(checksum)
+	if !VerifyInternetChecksum(header) {
+		t.Errorf("header with a new source address doesn't verify, checksum %04x", checksum)
+	}
+}
+
+func TestPseudoHeaderChecksum(t *testing.T) {
+	// A UDP segment from 10.0.0.1:53 to 10.0.0.2:1234 carrying "hi", with its checksum field zeroed
+	segment := []byte{0x00, 0x35, 0x04, 0xd2, 0x00, 0x0a, 0x00, 0x00, 'h', 'i'}
+	checksum := PseudoHeaderChecksum(0x0a000001, 0x0a000002, 17, segment)
+	segment[6], segment[7] = byte(checksum>>8), byte(checksum)
+	pseudo := append([]byte{10, 0, 0, 1, 10, 0, 0, 2, 0, 17, 0, byte(len(segment))}, segment...)
+	if !VerifyInternetChecksum(pseudo) {
+		t.Errorf("checksum %04x doesn't verify with the pseudo header", checksum)
+	}
+}
+
+func TestExplainInternetChecksum(t *testing.T) {
+	e := ExplainInternetChecksum([]byte{0xFF, 0xFF, 0x00, 0x02, 0x01})
+	last := e.Rows[len(e.Rows)-1]
+	if len(e.Rows) != 6 || last.Value != "fefd" {
+		t.Errorf("unexpected explanation %+v", e)
+	}
+}
@@ -0,0 +1,262 @@
+// Cyclic redundancy checks, from a bit at a time up to slicing-by-8, driven by a generic parameterised
+// engine so one implementation covers every CRC in common use
+// Andrew Alston
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// CRCParams describes a CRC in the Rocksoft model used by most CRC catalogues.  Width is the size of the
+// CRC in bits and Poly its generator polynomial without the top bit.  Init is loaded in to the register
+// before the data, RefIn says each input byte is processed least significant bit first, RefOut says
+// the register is reversed at the end, and XorOut is XORed with the result.  Check is the CRC of the
+// nine ASCII bytes "123456789", which catalogues list so implementations can be checked against them
+type CRCParams struct {
+	Name   string
+	Width  int
+	Poly   uint64
+	Init   uint64
+	RefIn  bool
+	RefOut bool
+	XorOut uint64
+	Check  uint64
+}
+
+// The CRCs we use day to day
+var (
+	CRC8        = CRCParams{Name: "CRC-8", Width: 8, Poly: 0x07, Check: 0xF4}
+	CRC16CCITT  = CRCParams{Name: "CRC-16/CCITT-FALSE", Width: 16, Poly: 0x1021, Init: 0xFFFF, Check: 0x29B1}
+	CRC16Kermit = CRCParams{Name: "CRC-16/KERMIT", Width: 16, Poly: 0x1021, RefIn: true, RefOut: true, Check: 0x2189}
+	CRC16XModem = CRCParams{Name: "CRC-16/XMODEM", Width: 16, Poly: 0x1021, Check: 0x31C3}
+	CRC16IBM    = CRCParams{Name: "CRC-16/IBM", Width: 16, Poly: 0x8005, RefIn: true, RefOut: true, Check: 0xBB3D}
+	CRC32IEEE   = CRCParams{Name: "CRC-32/IEEE", Width: 32, Poly: 0x04C11DB7, Init: 0xFFFFFFFF, RefIn: true, RefOut: true,
+		XorOut: 0xFFFFFFFF, Check: 0xCBF43926}
+	CRC32Castagnoli = CRCParams{Name: "CRC-32C", Width: 32, Poly: 0x1EDC6F41, Init: 0xFFFFFFFF, RefIn: true, RefOut: true,
+		XorOut: 0xFFFFFFFF, Check: 0xE3069283}
+	CRC64ECMA = CRCParams{Name: "CRC-64/ECMA-182", Width: 64, Poly: 0x42F0E1EBA9EA3693, Check: 0x6C40DF5F0B497347}
+	CRC64XZ   = CRCParams{Name: "CRC-64/XZ", Width: 64, Poly: 0x42F0E1EBA9EA3693, Init: ^uint64(0), RefIn: true, RefOut: true,
+		XorOut: ^uint64(0), Check: 0x995DC9BBDF1939FA}
+	CRC64ISO = CRCParams{Name: "CRC-64/GO-ISO", Width: 64, Poly: 0x1B, Init: ^uint64(0), RefIn: true, RefOut: true,
+		XorOut: ^uint64(0), Check: 0xB90956C775A41001}
+)
+
+// CRCCatalogue lists the predefined CRCs
+var CRCCatalogue = []CRCParams{CRC8, CRC16CCITT, CRC16Kermit, CRC16XModem, CRC16IBM, CRC32IEEE, CRC32Castagnoli,
+	CRC64ECMA, CRC64XZ, CRC64ISO}
+
+// CRC calculates a CRC with the given parameters.  Every CRC is worked out in one of two forms in a 64
+// bit register.  When RefIn is set the input bits arrive least significant first, so rather than
+// reversing every byte we reverse the polynomial and the register instead and shift right, keeping the
+// CRC in the bottom Width bits.  Otherwise we shift left with the CRC in the top Width bits, which lets
+// CRCs of every width share the same code, since the top bit of the CRC is always bit 63
+type CRC struct {
+	CRCParams
+	tables [8][256]uint64
+}
+
+// widthMask returns a mask of the bottom width bits
+func widthMask(width int) uint64 {
+	if width == 64 {
+		return ^uint64(0)
+	}
+	return 1<<width - 1
+}
+
+// reflectBits reverses the bottom width bits of v
+func reflectBits(v uint64, width int) uint64 {
+	return ReverseBits(v) >> (64 - width)
+}
+
+// NewCRC builds the lookup tables for a CRC.  Table 0 holds the effect on the register of each possible
+// byte, which lets us process a byte at a time.  Table k holds the effect of a byte followed by k zero
+// bytes, which is what slicing-by-8 needs to process eight bytes at once
+func NewCRC(p CRCParams) *CRC {
+	c := &CRC{CRCParams: p}
+	for i := 0; i < 256; i++ {
+		if p.RefIn {
+			crc := uint64(i)
+			poly := reflectBits(p.Poly, p.Width)
+			for bit := 0; bit < 8; bit++ {
+				if crc&1 == 1 {
+					crc = crc>>1 ^ poly
+				} else {
+					crc >>= 1
+				}
+			}
+			c.tables[0][i] = crc
+		} else {
+			crc := uint64(i) << 56
+			poly := p.Poly << (64 - p.Width)
+			for bit := 0; bit < 8; bit++ {
+				if crc>>63 == 1 {
+					crc = crc<<1 ^ poly
+				} else {
+					crc <<= 1
+				}
+			}
+			c.tables[0][i] = crc
+		}
+	}
+	for k := 1; k < 8; k++ {
+		for i := 0; i < 256; i++ {
+			prev := c.tables[k-1][i]
+			if p.RefIn {
+				c.tables[k][i] = prev>>8 ^ c.tables[0][prev&0xFF]
+			} else {
+				c.tables[k][i] = prev<<8 ^ c.tables[0][prev>>56]
+			}
+		}
+	}
+	return c
+}
+
+// Start returns the register loaded with Init, ready to pass to Update
+func (c *CRC) Start() uint64 {
+	if c.RefIn {
+		return reflectBits(c.Init, c.Width)
+	}
+	return c.Init << (64 - c.Width)
+}
+
+// Finish turns the register after the last Update in to the CRC
+func (c *CRC) Finish(reg uint64) uint64 {
+	var crc uint64
+	if c.RefIn {
+		// The register already holds the CRC reversed, so it only needs reversing back if RefOut is clear
+		crc = reg
+		if !c.RefOut {
+			crc = reflectBits(crc, c.Width)
+		}
+	} else {
+		crc = reg >> (64 - c.Width)
+		if c.RefOut {
+			crc = reflectBits(crc, c.Width)
+		}
+	}
+	return (crc ^ c.XorOut) & widthMask(c.Width)
+}
+
+// UpdateTable runs data through the register a byte at a time using table 0.  The byte is XORed in to
+// the end of the register the next bits come out of, and the table gives the effect of shifting those
+// 8 bits out
+func (c *CRC) UpdateTable(reg uint64, data []byte) uint64 {
+	for _, b := range data {
+		if c.RefIn {
+			reg = c.tables[0][byte(reg)^b] ^ reg>>8
+		} else {
+			reg = c.tables[0][byte(reg>>56)^b] ^ reg<<8
+		}
+	}
+	return reg
+}
+
+// Update runs data through the register eight bytes at a time using slicing-by-8.  XORing eight bytes
+// in to the register at once and looking up each byte in the table for its distance from the end gives
+// the same result as eight single byte steps, with the lookups independent of each other, and finishes
+// any remaining bytes one at a time
+func (c *CRC) Update(reg uint64, data []byte) uint64 {
+	t := &c.tables
+	for len(data) >= 8 {
+		if c.RefIn {
+			reg ^= binary.LittleEndian.Uint64(data)
+			reg = t[7][reg&0xFF] ^ t[6][reg>>8&0xFF] ^ t[5][reg>>16&0xFF] ^ t[4][reg>>24&0xFF] ^
+				t[3][reg>>32&0xFF] ^ t[2][reg>>40&0xFF] ^ t[1][reg>>48&0xFF] ^ t[0][reg>>56]
+		} else {
+			reg ^= binary.BigEndian.Uint64(data)
+			reg = t[7][reg>>56] ^ t[6][reg>>48&0xFF] ^ t[5][reg>>40&0xFF] ^ t[4][reg>>32&0xFF] ^
+				t[3][reg>>24&0xFF] ^ t[2][reg>>16&0xFF] ^ t[1][reg>>8&0xFF] ^ t[0][reg&0xFF]
+		}
+		data = data[8:]
+	}
+	return c.UpdateTable(reg, data)
+}
+
+// Checksum returns the CRC of data using slicing-by-8
+func (c *CRC) Checksum(data []byte) uint64 {
+	return c.Finish(c.Update(c.Start(), data))
+}
+
+// ChecksumTable returns the CRC of data a byte at a time
+func (c *CRC) ChecksumTable(data []byte) uint64 {
+	return c.Finish(c.UpdateTable(c.Start(), data))
+}
+
+// ChecksumBitwise returns the CRC of data a bit at a time, straight from the definition with no tables.
+// The register is the remainder of a polynomial division, for each bit we shift it left, bringing in
+// the next data bit XORed with the bit shifted out, and when that is 1 we subtract (XOR) the polynomial.
+// It is slow but simple, and the faster versions are tested against it
+func (c *CRC) ChecksumBitwise(data []byte) uint64 {
+	mask := widthMask(c.Width)
+	top := uint64(1) << (c.Width - 1)
+	reg := c.Init & mask
+	for _, b := range data {
+		if c.RefIn {
+			b = ReverseBits(b)
+		}
+		for bit := 7; bit >= 0; bit-- {
+			in := uint64(b>>bit) & 1
+			out := reg & top
+			reg = reg << 1 & mask
+			if (out != 0) != (in == 1) {
+				reg ^= c.Poly
+			}
+		}
+	}
+	if c.RefOut {
+		reg = reflectBits(reg, c.Width)
+	}
+	return (reg ^ c.XorOut) & mask
+}
+
+// crcDigits returns the number of hex digits needed to print a CRC of the given width
+func crcDigits(width int) int {
+	return (width + 3) / 4
+}
+
+// runCRC is the crc sub command, it prints the Internet checksum and every catalogued CRC of its
+// arguments, or of standard input when there are none
+func runCRC(args []string) error {
+	fs := flag.NewFlagSet("crc", flag.ContinueOnError)
+	hexInput := fs.Bool("hex", false, "Treat the input as hex digits rather than text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var data []byte
+	if fs.NArg() > 0 {
+		data = []byte(strings.Join(fs.Args(), " "))
+	} else {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+	if *hexInput {
+		var err error
+		if data, err = parseHexBytes(string(data)); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%-20s %04x\n", "Internet checksum", InternetChecksum(data))
+	for _, p := range CRCCatalogue {
+		fmt.Printf("%-20s %0*x\n", p.Name, crcDigits(p.Width), NewCRC(p).Checksum(data))
+	}
+	return nil
+}
+
+// parseHexBytes parses hex digits in to bytes, ignoring white space and colons between them
+func parseHexBytes(s string) ([]byte, error) {
+	s = strings.NewReplacer(" ", "", "\n", "", "\r", "", "\t", "", ":", "").Replace(s)
+	res, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,125 @@
+// CRC test routines
+package main
+
+import (
+	"hash/crc32"
+	"hash/crc64"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestCRCCheckValues(t *testing.T) {
+	check := []byte("123456789")
+	for _, p := range CRCCatalogue {
+		t.Run(p.Name, func(t *testing.T) {
+			c := NewCRC(p)
+			for name, got := range map[string]uint64{
+				"bitwise": c.ChecksumBitwise(check),
+				"table":   c.ChecksumTable(check),
+				"slicing": c.Checksum(check),
+			} {
+				if got != p.Check {
+					t.Errorf("%s gave %x instead of %x", name, got, p.Check)
+				}
+			}
+		})
+	}
+}
+
+// TestCRCVariantsAgree checks the three implementations agree on random data of every length up to a
+// few slices, for the catalogue and for parameters with RefIn and RefOut differing and odd widths
+func TestCRCVariantsAgree(t *testing.T) {
+	r := rand.New(rand.NewPCG(43, 1))
+	params := append([]CRCParams{
+		{Name: "CRC-5/USB", Width: 5, Poly: 0x05, Init: 0x1F, RefIn: true, RefOut: true, XorOut: 0x1F, Check: 0x19},
+		{Name: "CRC-3/GSM", Width: 3, Poly: 0x3, XorOut: 0x7, Check: 0x4},
+		{Name: "CRC-12/UMTS", Width: 12, Poly: 0x80F, RefOut: true, Check: 0xDAF},
+		{Name: "CRC-24/OPENPGP", Width: 24, Poly: 0x864CFB, Init: 0xB704CE, Check: 0x21CF02},
+		{Name: "refin only", Width: 16, Poly: 0x1021, Init: 0x1234, RefIn: true, XorOut: 0xFF},
+	}, CRCCatalogue...)
+	for _, p := range params {
+		c := NewCRC(p)
+		if p.Check != 0 && c.Checksum([]byte("123456789")) != p.Check {
+			t.Errorf("%s check value was %x instead of %x", p.Name, c.Checksum([]byte("123456789")), p.Check)
+		}
+		for n := 0; n < 40; n++ {
+			data := make([]byte, n)
+			for i := range data {
+				data[i] = byte(r.Uint32())
+			}
+			bitwise, table, slicing := c.ChecksumBitwise(data), c.ChecksumTable(data), c.Checksum(data)
+			if bitwise != table || bitwise != slicing {
+				t.Fatalf("%s of %d bytes: bitwise %x, table %x, slicing %x", p.Name, n, bitwise, table, slicing)
+			}
+		}
+	}
+}
+
+// TestCRCStandardLibrary compares against the CRCs in the standard library
+func TestCRCStandardLibrary(t *testing.T) {
+	r := rand.New(rand.NewPCG(43, 2))
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	ieee, castagnoli, iso := NewCRC(CRC32IEEE), NewCRC(CRC32Castagnoli), NewCRC(CRC64ISO)
+	if got, want := ieee.Checksum(data), uint64(crc32.ChecksumIEEE(data)); got != want {
+		t.Errorf("IEEE gave %x instead of %x", got, want)
+	}
+	if got, want := castagnoli.Checksum(data), uint64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))); got != want {
+		t.Errorf("Castagnoli gave %x instead of %x", got, want)
+	}
+	if got, want := iso.Checksum(data), crc64.Checksum(data, crc64.MakeTable(crc64.ISO)); got != want {
+		t.Errorf("ISO gave %x instead of %x", got, want)
+	}
+}
+
+// TestCRCIncremental checks that feeding data through Update in pieces gives the same CRC as all at once
+func TestCRCIncremental(t *testing.T) {
+	c := NewCRC(CRC64ECMA)
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	reg := c.Start()
+	for i := 0; i < len(data); i += 5 {
+		reg = c.Update(reg, data[i:min(i+5, len(data))])
+	}
+	if c.Finish(reg) != c.Checksum(data) {
+		t.Errorf("incremental CRC %x differs from %x", c.Finish(reg), c.Checksum(data))
+	}
+}
+
+func TestParseHexBytes(t *testing.T) {
+	got, err := parseHexBytes("0x45 00:00 1c\n")
+	if err != nil || string(got) != "\x45\x00\x00\x1c" {
+		t.Errorf("got % x, %v", got, err)
+	}
+	if _, err := parseHexBytes("abc"); err == nil {
+		t.Errorf("expected an error for odd hex")
+	}
+}
+
+// crcBenchData is 64KB of data to checksum in the benchmarks
+var crcBenchData = make([]byte, 64*1024)
+
+func BenchmarkCRC32Bitwise(b *testing.B) {
+	c := NewCRC(CRC32IEEE)
+	b.SetBytes(int64(len(crcBenchData)))
+	for i := 0; i < b.N; i++ {
+		c.ChecksumBitwise(crcBenchData)
+	}
+}
+
+func BenchmarkCRC32Table(b *testing.B) {
+	c := NewCRC(CRC32IEEE)
+	b.SetBytes(int64(len(crcBenchData)))
+	for i := 0; i < b.N; i++ {
+		c.ChecksumTable(crcBenchData)
+	}
+}
+
+func BenchmarkCRC32Slicing8(b *testing.B) {
+	c := NewCRC(CRC32IEEE)
+	b.SetBytes(int64(len(crcBenchData)))
+	for i := 0; i < b.N; i++ {
+		c.Checksum(crcBenchData)
+	}
+}
@@ -0,0 +1,148 @@
+// Explanation rendering for the binary level routines
+// Andrew Alston
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// ExplainRow is a single line of an explanation, a label such as "Binary" or "Result", the decimal
+// value and its binary representation.  Bits may be empty for rows that aren't numbers, such as the
+// true or false result of a bit test
+type ExplainRow struct {
+	Label string
+	Value string
+	Bits  string
+}
+
+// Explanation describes how a result was calculated, step by step.  The routines in binary.go build
+// these without printing anything, and an Explainer decides how they are presented.  Width is the
+// minimum width of the value column in text form, and Line, when set, is how the text form prints the
+// whole explanation on a single line instead of as a title followed by rows
+type Explanation struct {
+	Title string
+	Width int
+	Line  string
+	Rows  []ExplainRow
+}
+
+// Explainer renders explanations to an output.  Note writes a line of free text, such as a heading
+// between explanations
+type Explainer interface {
+	Explain(e Explanation) error
+	Note(text string) error
+}
+
+// NewExplainer returns an explainer writing to w in the given format, which is text, markdown or html
+func NewExplainer(format string, w io.Writer) (Explainer, error) {
+	switch format {
+	case "text":
+		return &TextExplainer{W: w}, nil
+	case "markdown":
+		return &MarkdownExplainer{W: w}, nil
+	case "html":
+		return &HTMLExplainer{W: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown explanation format %q, expected text, markdown or html", format)
+	}
+}
+
+// TextExplainer writes explanations as plain text in the same layout the routines have always used
+// on the console, for example:
+//
+//	Performing Bitwise AND between 50 and 60
+//	Binary [50]: 0000000000110010
+//	Binary [60]: 0000000000111100
+//	Result [48]: 0000000000110000
+type TextExplainer struct {
+	W io.Writer
+}
+
+// Explain writes e as text
+func (te *TextExplainer) Explain(e Explanation) error {
+	if e.Line != "" {
+		_, err := fmt.Fprintf(te.W, "%s\n", e.Line)
+		return err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n", e.Title)
+	for _, r := range e.Rows {
+		if r.Bits == "" {
+			fmt.Fprintf(&sb, "%s [%*s]\n", r.Label, e.Width, r.Value)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s [%*s]: %s\n", r.Label, e.Width, r.Value, r.Bits)
+	}
+	_, err := io.WriteString(te.W, sb.String())
+	return err
+}
+
+// Note writes text followed by a new line
+func (te *TextExplainer) Note(text string) error {
+	_, err := fmt.Fprintf(te.W, "%s\n", text)
+	return err
+}
+
+// MarkdownExplainer writes explanations as Markdown tables, with the title in bold above the table and
+// the binary column in code formatting so the digits line up
+type MarkdownExplainer struct {
+	W io.Writer
+}
+
+// Explain writes e as a Markdown table
+func (me *MarkdownExplainer) Explain(e Explanation) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%s**\n\n", markdownEscape(e.Title))
+	sb.WriteString("| Step | Decimal | Binary |\n| --- | ---: | --- |\n")
+	for _, r := range e.Rows {
+		bits := ""
+		if r.Bits != "" {
+			bits = "`" + r.Bits + "`"
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %s |\n", markdownEscape(r.Label), markdownEscape(r.Value), bits)
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(me.W, sb.String())
+	return err
+}
+
+// Note writes text as a Markdown paragraph
+func (me *MarkdownExplainer) Note(text string) error {
+	_, err := fmt.Fprintf(me.W, "%s\n\n", markdownEscape(strings.TrimSpace(text)))
+	return err
+}
+
+// markdownEscape escapes the characters that would otherwise break a Markdown table or be read as
+// formatting, such as the | in the variadic OR title
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`").Replace(s)
+}
+
+// HTMLExplainer writes explanations as HTML tables, with the title as the table caption
+type HTMLExplainer struct {
+	W io.Writer
+}
+
+// Explain writes e as an HTML table
+func (he *HTMLExplainer) Explain(e Explanation) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<table>\n<caption>%s</caption>\n", html.EscapeString(e.Title))
+	sb.WriteString("<tr><th>Step</th><th>Decimal</th><th>Binary</th></tr>\n")
+	for _, r := range e.Rows {
+		fmt.Fprintf(&sb, "<tr><td>%s</td><td>%s</td><td><code>%s</code></td></tr>\n",
+			html.EscapeString(r.Label), html.EscapeString(r.Value), html.EscapeString(r.Bits))
+	}
+	sb.WriteString("</table>\n")
+	_, err := io.WriteString(he.W, sb.String())
+	return err
+}
+
+// Note writes text as an HTML paragraph
+func (he *HTMLExplainer) Note(text string) error {
+	_, err := fmt.Fprintf(he.W, "<p>%s</p>\n", html.EscapeString(strings.TrimSpace(text)))
+	return err
+}
@@ -0,0 +1,85 @@
+// Explanation rendering test routines
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTextExplainer(t *testing.T) {
+	bitTest, _ := ExplainTestBit(uint8(50), 2, MSB0)
+	tests := []struct {
+		name string
+		e    Explanation
+		want string
+	}{
+		{"and", ExplainBitwiseAnd[uint16](50, 60),
+			"Performing Bitwise AND between 50 and 60\n" +
+				fmt.Sprintf("Binary [%2d]: %016b\n", 50, 50) +
+				fmt.Sprintf("Binary [%2d]: %016b\n", 60, 60) +
+				fmt.Sprintf("Result [%2d]: %016b\n", 48, 48)},
+		{"xor", ExplainExclusiveOr[uint16](5, 6),
+			"Performing Exclusive OR between 5 and 6\nBinary [ 5]: 0000000000000101\n" +
+				"Binary [ 6]: 0000000000000110\nResult [ 3]: 0000000000000011\n"},
+		{"or", ExplainBitwiseOr[uint8](5, 6),
+			"Performing Logical OR between 5 and 6\nBinary [ 5]: 00000101\nBinary [ 6]: 00000110\nResult [ 7]: 00000111\n"},
+		{"left", ExplainLeftShift[uint16](50, 4),
+			"Left shifting 50 by 4 bits\nBinary [ 50]: 0000000000110010\nResult [800]: 0000001100100000\n"},
+		{"right", ExplainRightShift[uint16](50, 2),
+			"Right shifting 50 by 2 bits\nBinary [50]: 0000000000110010\nResult [12]: 0000000000001100\n"},
+		{"testbit", bitTest, "Binary [ 50] [00110010] >> 5 [bit 2] == [  1] 00000001 [true]\n"},
+		{"variadic", ExplainVariadicOr(1, 2, 4), "Performing 1|2|4 = 7\n"},
+		{"contains", ExplainCombinedContains(63, 4),
+			"Input [63] [00111111] & [4] [00000100] == [4] [00000100] [true]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ex := &TextExplainer{W: &buf}
+			if err := ex.Explain(tt.e); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q instead of %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownExplainer(t *testing.T) {
+	var buf bytes.Buffer
+	ex, _ := NewExplainer("markdown", &buf)
+	_ = ex.Explain(ExplainVariadicOr(1, 2))
+	_ = ex.Note("\nDone")
+	out := buf.String()
+	for _, want := range []string{"**Performing 1\\|2**", "| --- | ---: | --- |", "| Result | 3 | `", "\nDone\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestHTMLExplainer(t *testing.T) {
+	var buf bytes.Buffer
+	ex, _ := NewExplainer("html", &buf)
+	e, _ := ExplainTestBit(uint8(1), 7, MSB0)
+	_ = ex.Explain(e)
+	_ = ex.Note("a < b")
+	out := buf.String()
+	for _, want := range []string{"<caption>Testing bit 7 (MSB0) of 1</caption>", "<td>&gt;&gt; 0</td>", "<td>true</td>", "<p>a &lt; b</p>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestNewExplainer(t *testing.T) {
+	if _, err := NewExplainer("pdf", nil); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+	if _, err := ExplainTestBit(uint8(1), 8, LSB0); err == nil {
+		t.Errorf("expected an error explaining an out of range bit")
+	}
+}
@@ -0,0 +1,811 @@
+// IPv4, IPv6, UDP and TCP headers, packed and unpacked with the bits struct tags from bitfield.go
+// Andrew Alston
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// IP protocol numbers for the transport headers we decode
+const (
+	ProtoTCP = 6
+	ProtoUDP = 17
+)
+
+// Header lengths without options
+const (
+	IPv4HeaderLen = 20
+	IPv6HeaderLen = 40
+	UDPHeaderLen  = 8
+	TCPHeaderLen  = 20
+)
+
+// PseudoHeader is an IP header that can give the pseudo header TCP and UDP checksums cover, the addresses,
+// protocol and length of the segment, so the checksum catches a segment delivered to the wrong place
+type PseudoHeader interface {
+	pseudoHeaderSum(proto uint8, length int) uint64
+}
+
+// transportChecksum returns the checksum of a TCP or UDP segment carried in ip
+func transportChecksum(ip PseudoHeader, proto uint8, segment []byte) uint16 {
+	return ^checksumFold(checksumSum(ip.pseudoHeaderSum(proto, len(segment)), segment))
+}
+
+// IPv4Header is an IPv4 header from RFC 791.  The struct tags give the width of each field, so Marshal
+// lays it out exactly as the RFC diagram does, which Diagram will draw for us
+type IPv4Header struct {
+	Version     uint8 `bits:"4"`
+	IHL         uint8 `bits:"4"`
+	DSCP        uint8 `bits:"6"`
+	ECN         uint8 `bits:"2"`
+	TotalLength uint16
+	ID          uint16
+	Flags       uint8  `bits:"3"`
+	FragOffset  uint16 `bits:"13"`
+	TTL         uint8
+	Protocol    uint8
+	Checksum    uint16
+	Src         uint32
+	Dst         uint32
+	Options     []byte `bits:"-"`
+}
+
+// IPv4 flags, numbered from the right of the 3 bit field
+const (
+	IPv4MoreFragments = 0
+	IPv4DontFragment  = 1
+)
+
+// ipv4FlagNames are the names of the IPv4 flags, by bit number from the right.  Bit 2 is reserved, but
+// RFC 3514 gave it a name
+var ipv4FlagNames = []string{"MF", "DF", "evil"}
+
+// Len returns the length of the header in bytes including options, from the IHL field
+func (h *IPv4Header) Len() int {
+	return int(h.IHL) * 4
+}
+
+// MarshalBinary packs the header in to bytes.  The version and IHL are filled in for us, the rest of the
+// fields are written as they are, so call SetChecksum last
+func (h *IPv4Header) MarshalBinary() ([]byte, error) {
+	if len(h.Options)%4 != 0 || len(h.Options) > 40 {
+		return nil, fmt.Errorf("IPv4 options must be a multiple of 4 bytes up to 40, not %d", len(h.Options))
+	}
+	h.Version, h.IHL = 4, uint8(IPv4HeaderLen+len(h.Options))/4
+	b, err := Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, h.Options...), nil
+}
+
+// UnmarshalBinary unpacks a header from the start of data, anything after the header is ignored
+func (h *IPv4Header) UnmarshalBinary(data []byte) error {
+	if len(data) < IPv4HeaderLen {
+		return fmt.Errorf("IPv4 header needs %d bytes, got %d", IPv4HeaderLen, len(data))
+	}
+	if err := Unmarshal(data, h); err != nil {
+		return err
+	}
+	switch {
+	case h.Version != 4:
+		return fmt.Errorf("IPv4 header has version %d", h.Version)
+	case h.Len() < IPv4HeaderLen:
+		return fmt.Errorf("IPv4 header length %d is too short", h.Len())
+	case h.Len() > len(data):
+		return fmt.Errorf("IPv4 header length %d is longer than the %d bytes we have", h.Len(), len(data))
+	}
+	h.Options = bytes.Clone(data[IPv4HeaderLen:h.Len()])
+	return nil
+}
+
+// SetChecksum fills in the header checksum, worked out with the checksum field zeroed
+func (h *IPv4Header) SetChecksum() error {
+	h.Checksum = 0
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	h.Checksum = InternetChecksum(b)
+	return nil
+}
+
+// VerifyChecksum returns true if the checksum in the header is correct
+func (h *IPv4Header) VerifyChecksum() bool {
+	b, err := h.MarshalBinary()
+	return err == nil && VerifyInternetChecksum(b)
+}
+
+// pseudoHeaderSum sums the IPv4 pseudo header of RFC 768 and RFC 9293
+func (h *IPv4Header) pseudoHeaderSum(proto uint8, length int) uint64 {
+	return ipv4PseudoSum(h.Src, h.Dst, proto, length)
+}
+
+// IPv6Header is the fixed IPv6 header from RFC 8200.  The addresses are Uint128s, which Marshal packs as
+// their high and then low halves, so they come out big endian the way they are sent
+type IPv6Header struct {
+	Version       uint8 `bits:"4"`
+	TrafficClass  uint8
+	FlowLabel     uint32 `bits:"20"`
+	PayloadLength uint16
+	NextHeader    uint8
+	HopLimit      uint8
+	Src           Uint128
+	Dst           Uint128
+}
+
+// Len returns the length of the fixed header
+func (h *IPv6Header) Len() int {
+	return IPv6HeaderLen
+}
+
+// MarshalBinary packs the header in to bytes, filling in the version
+func (h *IPv6Header) MarshalBinary() ([]byte, error) {
+	h.Version = 6
+	return Marshal(h)
+}
+
+// UnmarshalBinary unpacks a header from the start of data, anything after the header is ignored
+func (h *IPv6Header) UnmarshalBinary(data []byte) error {
+	if len(data) < IPv6HeaderLen {
+		return fmt.Errorf("IPv6 header needs %d bytes, got %d", IPv6HeaderLen, len(data))
+	}
+	if err := Unmarshal(data, h); err != nil {
+		return err
+	}
+	if h.Version != 6 {
+		return fmt.Errorf("IPv6 header has version %d", h.Version)
+	}
+	return nil
+}
+
+// pseudoHeaderSum sums the IPv6 pseudo header of RFC 8200 section 8.1, which has a 32 bit length
+func (h *IPv6Header) pseudoHeaderSum(proto uint8, length int) uint64 {
+	var sum uint64
+	for i := 0; i < 64; i += 16 {
+		sum += h.Src.Hi>>i&0xFFFF + h.Src.Lo>>i&0xFFFF + h.Dst.Hi>>i&0xFFFF + h.Dst.Lo>>i&0xFFFF
+	}
+	return sum + uint64(length>>16) + uint64(length&0xFFFF) + uint64(proto)
+}
+
+// UDPHeader is a UDP header from RFC 768
+type UDPHeader struct {
+	SrcPort  uint16
+	DstPort  uint16
+	Length   uint16
+	Checksum uint16
+}
+
+// Len returns the length of the header
+func (h *UDPHeader) Len() int {
+	return UDPHeaderLen
+}
+
+// MarshalBinary packs the header in to bytes
+func (h *UDPHeader) MarshalBinary() ([]byte, error) {
+	return Marshal(h)
+}
+
+// UnmarshalBinary unpacks a header from the start of data, anything after the header is ignored
+func (h *UDPHeader) UnmarshalBinary(data []byte) error {
+	if len(data) < UDPHeaderLen {
+		return fmt.Errorf("UDP header needs %d bytes, got %d", UDPHeaderLen, len(data))
+	}
+	return Unmarshal(data, h)
+}
+
+// udpChecksum returns the checksum of the header and payload
+func (h *UDPHeader) udpChecksum(ip PseudoHeader, payload []byte) uint16 {
+	b, _ := h.MarshalBinary()
+	return transportChecksum(ip, ProtoUDP, append(b, payload...))
+}
+
+// SetChecksum fills in the length and checksum for a payload carried in ip.  A checksum that works out
+// as zero is sent as all ones, since zero means the sender didn't calculate one
+func (h *UDPHeader) SetChecksum(ip PseudoHeader, payload []byte) error {
+	if UDPHeaderLen+len(payload) > 0xFFFF {
+		return fmt.Errorf("UDP payload of %d bytes is too long", len(payload))
+	}
+	h.Length, h.Checksum = uint16(UDPHeaderLen+len(payload)), 0
+	if h.Checksum = h.udpChecksum(ip, payload); h.Checksum == 0 {
+		h.Checksum = 0xFFFF
+	}
+	return nil
+}
+
+// VerifyChecksum returns true if the checksum is correct for a payload carried in ip.  Over IPv4 a zero
+// checksum means there isn't one, which we accept, while IPv6 requires one
+func (h *UDPHeader) VerifyChecksum(ip PseudoHeader, payload []byte) bool {
+	if h.Checksum == 0 {
+		_, v4 := ip.(*IPv4Header)
+		return v4
+	}
+	return h.udpChecksum(ip, payload) == 0
+}
+
+// TCP flags, numbered from the right of the flags byte
+const (
+	TCPFin = iota
+	TCPSyn
+	TCPRst
+	TCPPsh
+	TCPAck
+	TCPUrg
+	TCPEce
+	TCPCwr
+)
+
+// tcpFlagNames are the names of the TCP flags, by bit number from the right
+var tcpFlagNames = []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR"}
+
+// flagNames returns the names of the bits set in flags, using TestBit to check each one
+func flagNames(flags uint8, names []string) string {
+	var set []string
+	for i, name := range names {
+		if ok, _ := TestBit(flags, uint8(i), LSB0); ok {
+			set = append(set, name)
+		}
+	}
+	if len(set) == 0 {
+		return "none"
+	}
+	return strings.Join(set, ",")
+}
+
+// TCPFlagString returns the names of the TCP flags set, such as SYN,ACK
+func TCPFlagString(flags uint8) string {
+	return flagNames(flags, tcpFlagNames)
+}
+
+// TCP option kinds
+const (
+	TCPOptEOL           = 0
+	TCPOptNOP           = 1
+	TCPOptMSS           = 2
+	TCPOptWindowScale   = 3
+	TCPOptSACKPermitted = 4
+	TCPOptSACK          = 5
+	TCPOptTimestamps    = 8
+)
+
+// TCPOption is a TCP option, its kind and the data after the kind and length bytes
+type TCPOption struct {
+	Kind uint8
+	Data []byte
+}
+
+// SACKBlock is a block of data received out of order, from Left up to but not including Right
+type SACKBlock struct {
+	Left  uint32
+	Right uint32
+}
+
+// NewMSSOption returns a maximum segment size option
+func NewMSSOption(mss uint16) TCPOption {
+	return TCPOption{Kind: TCPOptMSS, Data: binary.BigEndian.AppendUint16(nil, mss)}
+}
+
+// NewWindowScaleOption returns a window scale option, the window is shifted left by shift bits
+func NewWindowScaleOption(shift uint8) TCPOption {
+	return TCPOption{Kind: TCPOptWindowScale, Data: []byte{shift}}
+}
+
+// NewSACKPermittedOption returns the option a SYN carries to say selective acknowledgements may be used
+func NewSACKPermittedOption() TCPOption {
+	return TCPOption{Kind: TCPOptSACKPermitted}
+}
+
+// NewSACKOption returns a selective acknowledgement option listing the blocks received
+func NewSACKOption(blocks ...SACKBlock) TCPOption {
+	var data []byte
+	for _, b := range blocks {
+		data = binary.BigEndian.AppendUint32(data, b.Left)
+		data = binary.BigEndian.AppendUint32(data, b.Right)
+	}
+	return TCPOption{Kind: TCPOptSACK, Data: data}
+}
+
+// NewTimestampsOption returns a timestamps option, our timestamp and the one we are echoing back
+func NewTimestampsOption(val, echo uint32) TCPOption {
+	return TCPOption{Kind: TCPOptTimestamps, Data: binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, val), echo)}
+}
+
+// NewNOPOption returns a no-operation option, used to pad the next option on to a 4 byte boundary
+func NewNOPOption() TCPOption {
+	return TCPOption{Kind: TCPOptNOP}
+}
+
+// single reports whether the option is a single kind byte with no length
+func (o TCPOption) single() bool {
+	return o.Kind == TCPOptEOL || o.Kind == TCPOptNOP
+}
+
+// String describes the option the way tcpdump does
+func (o TCPOption) String() string {
+	switch {
+	case o.Kind == TCPOptEOL:
+		return "eol"
+	case o.Kind == TCPOptNOP:
+		return "nop"
+	case o.Kind == TCPOptMSS && len(o.Data) == 2:
+		return fmt.Sprintf("mss %d", binary.BigEndian.Uint16(o.Data))
+	case o.Kind == TCPOptWindowScale && len(o.Data) == 1:
+		return fmt.Sprintf("wscale %d", o.Data[0])
+	case o.Kind == TCPOptSACKPermitted && len(o.Data) == 0:
+		return "sackOK"
+	case o.Kind == TCPOptSACK && len(o.Data)%8 == 0:
+		var blocks []string
+		for i := 0; i < len(o.Data); i += 8 {
+			blocks = append(blocks, fmt.Sprintf("{%d:%d}", binary.BigEndian.Uint32(o.Data[i:]), binary.BigEndian.Uint32(o.Data[i+4:])))
+		}
+		return "sack " + strings.Join(blocks, "")
+	case o.Kind == TCPOptTimestamps && len(o.Data) == 8:
+		return fmt.Sprintf("TS val %d ecr %d", binary.BigEndian.Uint32(o.Data), binary.BigEndian.Uint32(o.Data[4:]))
+	}
+	return fmt.Sprintf("option %d [% x]", o.Kind, o.Data)
+}
+
+// ParseTCPOptions parses the options area of a TCP header.  Parsing stops at an end of options, since
+// anything after it is padding
+func ParseTCPOptions(b []byte) ([]TCPOption, error) {
+	var opts []TCPOption
+	for len(b) > 0 {
+		o := TCPOption{Kind: b[0]}
+		if o.Kind == TCPOptEOL {
+			break
+		}
+		if o.single() {
+			opts = append(opts, o)
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			return nil, fmt.Errorf("TCP option %d runs past the end of the header", o.Kind)
+		}
+		o.Data = bytes.Clone(b[2:b[1]])
+		opts = append(opts, o)
+		b = b[b[1]:]
+	}
+	return opts, nil
+}
+
+// appendTCPOptions packs options, padding them with zeros, end of options, to a multiple of 4 bytes
+func appendTCPOptions(dst []byte, opts []TCPOption) ([]byte, error) {
+	start := len(dst)
+	for _, o := range opts {
+		if o.single() {
+			dst = append(dst, o.Kind)
+			continue
+		}
+		if len(o.Data) > 253 {
+			return nil, fmt.Errorf("TCP option %d is too long", o.Kind)
+		}
+		dst = append(append(dst, o.Kind, byte(len(o.Data)+2)), o.Data...)
+	}
+	for (len(dst)-start)%4 != 0 {
+		dst = append(dst, TCPOptEOL)
+	}
+	if len(dst)-start > 40 {
+		return nil, fmt.Errorf("TCP options take %d bytes, more than the 40 there is room for", len(dst)-start)
+	}
+	return dst, nil
+}
+
+// TCPHeader is a TCP header from RFC 9293.  Options aren't packed by Marshal, MarshalBinary adds them
+type TCPHeader struct {
+	SrcPort    uint16
+	DstPort    uint16
+	Seq        uint32
+	Ack        uint32
+	DataOffset uint8 `bits:"4"`
+	_          uint8 `bits:"4"`
+	Flags      uint8
+	Window     uint16
+	Checksum   uint16
+	Urgent     uint16
+	Options    []TCPOption `bits:"-"`
+	// received holds the options as they came off the wire, padding and all, for checking the checksum
+	received []byte
+}
+
+// Len returns the length of the header in bytes including options, from the data offset
+func (h *TCPHeader) Len() int {
+	return int(h.DataOffset) * 4
+}
+
+// HasFlag returns true if the given flag, such as TCPSyn, is set
+func (h *TCPHeader) HasFlag(flag uint8) bool {
+	set, _ := TestBit(h.Flags, flag, LSB0)
+	return set
+}
+
+// option returns the first option of the given kind whose data is the given length
+func (h *TCPHeader) option(kind uint8, length int) ([]byte, bool) {
+	for _, o := range h.Options {
+		if o.Kind == kind && (length < 0 || len(o.Data) == length) {
+			return o.Data, true
+		}
+	}
+	return nil, false
+}
+
+// MSS returns the maximum segment size option, if there is one
+func (h *TCPHeader) MSS() (uint16, bool) {
+	d, ok := h.option(TCPOptMSS, 2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(d), true
+}
+
+// WindowScale returns the window scale option, if there is one
+func (h *TCPHeader) WindowScale() (uint8, bool) {
+	d, ok := h.option(TCPOptWindowScale, 1)
+	if !ok {
+		return 0, false
+	}
+	return d[0], true
+}
+
+// SACKPermitted returns true if the header carries the SACK permitted option
+func (h *TCPHeader) SACKPermitted() bool {
+	_, ok := h.option(TCPOptSACKPermitted, 0)
+	return ok
+}
+
+// SACKBlocks returns the blocks of the selective acknowledgement option, if there is one
+func (h *TCPHeader) SACKBlocks() []SACKBlock {
+	d, _ := h.option(TCPOptSACK, -1)
+	var blocks []SACKBlock
+	for ; len(d) >= 8; d = d[8:] {
+		blocks = append(blocks, SACKBlock{binary.BigEndian.Uint32(d), binary.BigEndian.Uint32(d[4:])})
+	}
+	return blocks
+}
+
+// Timestamps returns the timestamp value and echo reply of the timestamps option, if there is one
+func (h *TCPHeader) Timestamps() (uint32, uint32, bool) {
+	d, ok := h.option(TCPOptTimestamps, 8)
+	if !ok {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(d), binary.BigEndian.Uint32(d[4:]), true
+}
+
+// MarshalBinary packs the header and its options in to bytes, filling in the data offset
+func (h *TCPHeader) MarshalBinary() ([]byte, error) {
+	opts, err := appendTCPOptions(nil, h.Options)
+	if err != nil {
+		return nil, err
+	}
+	h.DataOffset = uint8(TCPHeaderLen+len(opts)) / 4
+	b, err := Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, opts...), nil
+}
+
+// UnmarshalBinary unpacks a header and its options from the start of data, anything after the header is
+// ignored
+func (h *TCPHeader) UnmarshalBinary(data []byte) error {
+	if len(data) < TCPHeaderLen {
+		return fmt.Errorf("TCP header needs %d bytes, got %d", TCPHeaderLen, len(data))
+	}
+	if err := Unmarshal(data, h); err != nil {
+		return err
+	}
+	switch {
+	case h.Len() < TCPHeaderLen:
+		return fmt.Errorf("TCP data offset %d is too short", h.DataOffset)
+	case h.Len() > len(data):
+		return fmt.Errorf("TCP header length %d is longer than the %d bytes we have", h.Len(), len(data))
+	}
+	var err error
+	h.received = bytes.Clone(data[TCPHeaderLen:h.Len()])
+	h.Options, err = ParseTCPOptions(h.received)
+	return err
+}
+
+// tcpChecksum returns the checksum of the header with the given option bytes and the payload.  It works
+// on a copy of the header so the data offset is only changed in the bytes summed
+func (h *TCPHeader) tcpChecksum(ip PseudoHeader, opts, payload []byte) (uint16, error) {
+	c := *h
+	c.DataOffset = uint8(TCPHeaderLen+len(opts)) / 4
+	b, err := Marshal(&c)
+	if err != nil {
+		return 0, err
+	}
+	b = append(append(b, opts...), payload...)
+	return transportChecksum(ip, ProtoTCP, b), nil
+}
+
+// SetChecksum fills in the checksum for a payload carried in ip, over the options as MarshalBinary
+// will write them
+func (h *TCPHeader) SetChecksum(ip PseudoHeader, payload []byte) error {
+	opts, err := appendTCPOptions(nil, h.Options)
+	if err != nil {
+		return err
+	}
+	h.Checksum = 0
+	h.Checksum, err = h.tcpChecksum(ip, opts, payload)
+	return err
+}
+
+// VerifyChecksum returns true if the checksum is correct for a payload carried in ip.  An unmarshalled
+// header is checked over its options as received, since encoding them again can change the padding
+func (h *TCPHeader) VerifyChecksum(ip PseudoHeader, payload []byte) bool {
+	opts := h.received
+	if opts == nil {
+		var err error
+		if opts, err = appendTCPOptions(nil, h.Options); err != nil {
+			return false
+		}
+	}
+	sum, err := h.tcpChecksum(ip, opts, payload)
+	return err == nil && sum == 0
+}
+
+// headerRows returns a row for each field of a header, the value of the field and its bits.  The fields
+// of a nested struct, such as the halves of a Uint128 address, are joined in to one row.  values gives
+// what to show as the value of a field where its number isn't the clearest way to show it
+func headerRows(h any, values map[string]string) ([]ExplainRow, error) {
+	var rows []ExplainRow
+	var names []string
+	_, err := walkFields(reflect.ValueOf(h), func(f bitField, v reflect.Value) error {
+		u, err := fieldBits(f, v)
+		if err != nil {
+			return err
+		}
+		// Fields of a nested struct or elements of an array, such as the bytes of an address, share a row
+		name, nested := f.name(), false
+		if i := strings.IndexAny(name, ".["); i >= 0 {
+			name, nested = name[:i], true
+		}
+		bits := fmt.Sprintf("%0*b", f.bits, u)
+		if nested && len(names) > 0 && names[len(names)-1] == name {
+			rows[len(rows)-1].Bits += bits
+			return nil
+		}
+		value, ok := values[name]
+		if !ok {
+			value = fmt.Sprint(u)
+		}
+		names = append(names, name)
+		rows = append(rows, ExplainRow{Value: value, Bits: bits})
+		return nil
+	})
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+	for i := range rows {
+		rows[i].Label = fmt.Sprintf("%-*s", width, names[i])
+	}
+	return rows, err
+}
+
+// headerExplanation builds the explanation of a header from its rows
+func headerExplanation(title string, rows []ExplainRow) Explanation {
+	width := 0
+	for _, r := range rows {
+		width = max(width, len(r.Value))
+	}
+	return Explanation{Title: title, Width: width, Rows: rows}
+}
+
+// checksumState describes whether a checksum is correct
+func checksumState(ok bool) string {
+	if ok {
+		return "correct"
+	}
+	return "incorrect"
+}
+
+// Explain describes each field of the header
+func (h *IPv4Header) Explain() (Explanation, error) {
+	rows, err := headerRows(h, map[string]string{
+		"Flags":    flagNames(h.Flags, ipv4FlagNames),
+		"Protocol": protocolName(h.Protocol),
+		"Checksum": fmt.Sprintf("%04x", h.Checksum),
+		"Src":      ipv4String(h.Src),
+		"Dst":      ipv4String(h.Dst),
+	})
+	if len(h.Options) > 0 {
+		rows = append(rows, ExplainRow{Label: fmt.Sprintf("%-*s", len(rows[0].Label), "Options"), Value: fmt.Sprintf("% x", h.Options)})
+	}
+	return headerExplanation(fmt.Sprintf("IPv4 header, %d bytes, checksum %s", h.Len(), checksumState(h.VerifyChecksum())), rows), err
+}
+
+// Explain describes each field of the header
+func (h *IPv6Header) Explain() (Explanation, error) {
+	rows, err := headerRows(h, map[string]string{
+		"NextHeader": protocolName(h.NextHeader),
+		"Src":        Uint128ToAddr(h.Src, true).String(),
+		"Dst":        Uint128ToAddr(h.Dst, true).String(),
+	})
+	return headerExplanation(fmt.Sprintf("IPv6 header, %d bytes", h.Len()), rows), err
+}
+
+// Explain describes each field of the header, and whether the checksum is right for the payload
+func (h *UDPHeader) Explain(ip PseudoHeader, payload []byte) (Explanation, error) {
+	rows, err := headerRows(h, map[string]string{"Checksum": fmt.Sprintf("%04x", h.Checksum)})
+	title := fmt.Sprintf("UDP header, %d bytes, checksum %s", h.Len(), checksumState(h.VerifyChecksum(ip, payload)))
+	return headerExplanation(title, rows), err
+}
+
+// Explain describes each field of the header and its options, and whether the checksum is right for the
+// payload
+func (h *TCPHeader) Explain(ip PseudoHeader, payload []byte) (Explanation, error) {
+	rows, err := headerRows(h, map[string]string{
+		"Flags":    TCPFlagString(h.Flags),
+		"Checksum": fmt.Sprintf("%04x", h.Checksum),
+	})
+	for _, o := range h.Options {
+		rows = append(rows, ExplainRow{Label: fmt.Sprintf("%-*s", len(rows[0].Label), "Option"), Value: o.String()})
+	}
+	title := fmt.Sprintf("TCP header, %d bytes, checksum %s", h.Len(), checksumState(h.VerifyChecksum(ip, payload)))
+	return headerExplanation(title, rows), err
+}
+
+// protocolName returns the name of an IP protocol from the names ACLs use, or its number
+func protocolName(proto uint8) string {
+	for name, p := range protocols {
+		if p == int(proto) {
+			return name
+		}
+	}
+	if proto == 58 {
+		return "ipv6-icmp"
+	}
+	return fmt.Sprint(proto)
+}
+
+// DecodeIP decodes an IPv4 or IPv6 packet and the TCP or UDP header it carries, returning an
+// explanation of each header.  Decoding stops at the first header we don't know, and a truncated packet
+// gives the explanations decoded so far along with the error
+func DecodeIP(data []byte) ([]Explanation, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty packet")
+	}
+	var ip PseudoHeader
+	var proto uint8
+	var payload []byte
+	var e Explanation
+	var err error
+	switch data[0] >> 4 {
+	case 4:
+		h := &IPv4Header{}
+		if err := h.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		if int(h.TotalLength) < h.Len() || int(h.TotalLength) > len(data) {
+			return nil, fmt.Errorf("IPv4 total length %d doesn't fit the %d bytes we have", h.TotalLength, len(data))
+		}
+		ip, proto, payload = h, h.Protocol, data[h.Len():h.TotalLength]
+		if h.FragOffset != 0 {
+			// Only the first fragment holds the transport header
+			proto = 0
+		}
+		e, err = h.Explain()
+	case 6:
+		h := &IPv6Header{}
+		if err := h.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		if IPv6HeaderLen+int(h.PayloadLength) > len(data) {
+			return nil, fmt.Errorf("IPv6 payload length %d doesn't fit the %d bytes we have", h.PayloadLength, len(data))
+		}
+		ip, proto, payload = h, h.NextHeader, data[IPv6HeaderLen:IPv6HeaderLen+int(h.PayloadLength)]
+		e, err = h.Explain()
+	default:
+		return nil, fmt.Errorf("unknown IP version %d", data[0]>>4)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := []Explanation{e}
+
+	switch proto {
+	case ProtoUDP:
+		h := &UDPHeader{}
+		if err := h.UnmarshalBinary(payload); err != nil {
+			return res, err
+		}
+		e, err = h.Explain(ip, payload[UDPHeaderLen:])
+	case ProtoTCP:
+		h := &TCPHeader{}
+		if err := h.UnmarshalBinary(payload); err != nil {
+			return res, err
+		}
+		e, err = h.Explain(ip, payload[h.Len():])
+	default:
+		return res, nil
+	}
+	return append(res, e), err
+}
+
+// runDecode is the decode sub command, it reads a pcap file, or hex, either as plain digits or as a
+// hexdump, from a file or standard input, and explains every field of each packet's headers.  Hex is
+// taken as a raw IP packet unless -link gives another link type
+func runDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	linkType := fs.Uint("link", LinkTypeRaw, "Link type of hex input, 1 for Ethernet, 101 for raw IP")
+	format := fs.String("format", "text", "Output format: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	in := io.Reader(os.Stdin)
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	var packets []CapturedPacket
+	if isPcap(data) {
+		pr, err := NewPcapReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		for {
+			p, err := pr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("packet %d: %v", len(packets)+1, err)
+			}
+			packets = append(packets, p)
+		}
+	} else {
+		packet, err := parseHexBytes(string(data))
+		if err != nil {
+			// Not plain hex digits, so try it as a hexdump
+			var buf bytes.Buffer
+			if err := ReverseHexDump(&buf, bytes.NewReader(data), DefaultDumpOptions()); err != nil {
+				return fmt.Errorf("input is neither pcap, hex nor a hexdump: %v", err)
+			}
+			packet = buf.Bytes()
+		}
+		packets = append(packets, CapturedPacket{Length: len(packet), LinkType: uint32(*linkType), Data: packet})
+	}
+
+	for i, p := range packets {
+		if len(packets) > 1 {
+			if err := ex.Note(fmt.Sprintf("Packet %d, %d bytes", i+1, len(p.Data))); err != nil {
+				return err
+			}
+		}
+		explanations, err := DecodeFrame(p.Data, p.LinkType)
+		for _, e := range explanations {
+			if err := ex.Explain(e); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("packet %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+// Packet header test routines
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// synPacket is an IPv4 TCP SYN with MSS, SACK permitted, timestamps and window scale options, whose
+// checksums were checked with an independent implementation
+const synPacket = "4500003c1c4640004006323fc0000201c6336402c73801bb000003e800000000a002ffff5eb40000" +
+	"020405b40402080a000030390000000001030307"
+
+// referenceChecksum works out a TCP or UDP checksum the long way, building the pseudo header as bytes in
+// front of the segment and checksumming the lot
+func referenceChecksum(src, dst []byte, proto uint8, segment []byte) uint16 {
+	pseudo := append(bytes.Clone(src), dst...)
+	if len(src) == 4 {
+		pseudo = append(pseudo, 0, proto)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(segment)))
+		pseudo = append(pseudo, 0, 0, 0, proto)
+	}
+	return InternetChecksum(append(pseudo, segment...))
+}
+
+func TestIPv4Header(t *testing.T) {
+	// The header from the checksum tests
+	raw := mustHex(t, "45000073000040004011b861c0a80001c0a800c7")
+	var h IPv4Header
+	if err := h.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if h.TotalLength != 0x73 || h.TTL != 64 || h.Protocol != ProtoUDP || ipv4String(h.Src) != "192.168.0.1" ||
+		ipv4String(h.Dst) != "192.168.0.199" || h.Flags != 1<<IPv4DontFragment || h.Len() != 20 {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if !h.VerifyChecksum() {
+		t.Errorf("checksum should be correct")
+	}
+	if b, err := h.MarshalBinary(); err != nil || !bytes.Equal(b, raw) {
+		t.Errorf("marshalled as % x, %v", b, err)
+	}
+	h.TTL--
+	if h.VerifyChecksum() {
+		t.Errorf("checksum should be wrong after changing the TTL")
+	}
+	if err := h.SetChecksum(); err != nil || h.Checksum != UpdateChecksum(0xB861, 0x4011, 0x3F11) {
+		t.Errorf("checksum %04x, %v", h.Checksum, err)
+	}
+
+	// Options are kept, and set the header length
+	h.Options = []byte{0x94, 0x04, 0, 0}
+	b, err := h.MarshalBinary()
+	if err != nil || len(b) != 24 || b[0] != 0x46 {
+		t.Fatalf("marshalled with options as % x, %v", b, err)
+	}
+	var h2 IPv4Header
+	if err := h2.UnmarshalBinary(b); err != nil || !bytes.Equal(h2.Options, h.Options) {
+		t.Errorf("options unmarshalled as % x, %v", h2.Options, err)
+	}
+	h.Options = []byte{1, 2, 3}
+	if _, err := h.MarshalBinary(); err == nil {
+		t.Errorf("options that aren't a multiple of 4 bytes should be rejected")
+	}
+
+	bad := []string{
+		"4500",
+		"65000073000040004011b861c0a80001c0a800c7",
+		"44000073000040004011b861c0a80001c0a800c7",
+		"46000073000040004011b861c0a80001c0a800c7",
+	}
+	for _, s := range bad {
+		if err := h.UnmarshalBinary(mustHex(t, s)); err == nil {
+			t.Errorf("%s should be rejected", s)
+		}
+	}
+}
+
+func TestTCPHeader(t *testing.T) {
+	raw := mustHex(t, synPacket)
+	var ip IPv4Header
+	var h TCPHeader
+	if err := ip.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.UnmarshalBinary(raw[ip.Len():]); err != nil {
+		t.Fatal(err)
+	}
+	if h.SrcPort != 51000 || h.DstPort != 443 || h.Seq != 1000 || h.Len() != 40 || !h.HasFlag(TCPSyn) || h.HasFlag(TCPAck) {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if mss, ok := h.MSS(); !ok || mss != 1460 {
+		t.Errorf("MSS %d, %v", mss, ok)
+	}
+	if shift, ok := h.WindowScale(); !ok || shift != 7 {
+		t.Errorf("window scale %d, %v", shift, ok)
+	}
+	if val, echo, ok := h.Timestamps(); !ok || val != 12345 || echo != 0 {
+		t.Errorf("timestamps %d %d, %v", val, echo, ok)
+	}
+	if !h.SACKPermitted() || h.SACKBlocks() != nil {
+		t.Errorf("SACK should be permitted with no blocks")
+	}
+	if !h.VerifyChecksum(&ip, nil) {
+		t.Errorf("checksum should be correct")
+	}
+	if h.VerifyChecksum(&ip, []byte{0}) {
+		t.Errorf("checksum should be wrong with a payload")
+	}
+	if b, err := h.MarshalBinary(); err != nil || !bytes.Equal(b, raw[ip.Len():]) {
+		t.Errorf("marshalled as % x, %v", b, err)
+	}
+
+	// An ACK carrying SACK blocks and a payload, checked against the long way round
+	payload := []byte("hello")
+	h = TCPHeader{SrcPort: 443, DstPort: 51000, Seq: 5000, Ack: 1001, Flags: 1<<TCPAck | 1<<TCPPsh, Window: 512,
+		Options: []TCPOption{NewNOPOption(), NewNOPOption(), NewSACKOption(SACKBlock{2000, 3000}, SACKBlock{4000, 5000})}}
+	if err := h.SetChecksum(&ip, payload); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := h.MarshalBinary()
+	if sum := referenceChecksum(raw[12:16], raw[16:20], ProtoTCP, append(b, payload...)); sum != 0 {
+		t.Errorf("checksum %04x doesn't verify the long way, got %04x", h.Checksum, sum)
+	}
+	var h2 TCPHeader
+	if err := h2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if blocks := h2.SACKBlocks(); len(blocks) != 2 || blocks[1] != (SACKBlock{4000, 5000}) {
+		t.Errorf("SACK blocks %v", blocks)
+	}
+	if TCPFlagString(h2.Flags) != "PSH,ACK" || TCPFlagString(0) != "none" {
+		t.Errorf("flags %s", TCPFlagString(h2.Flags))
+	}
+
+	// Padding past the end of the option list to the next 4 byte boundary is kept when checking the checksum,
+	// and neither checking it nor explaining the header changes the data offset
+	padded := mustHex(t, "c73801bb000003e800000000"+"7002ffff"+"00000000"+"020405b4"+"00000000")
+	binary.BigEndian.PutUint16(padded[16:], referenceChecksum(raw[12:16], raw[16:20], ProtoTCP, padded))
+	var h3 TCPHeader
+	if err := h3.UnmarshalBinary(padded); err != nil {
+		t.Fatal(err)
+	}
+	if !h3.VerifyChecksum(&ip, nil) || h3.DataOffset != 7 {
+		t.Errorf("padded options gave checksum %v and data offset %d", h3.VerifyChecksum(&ip, nil), h3.DataOffset)
+	}
+	if e, _ := h3.Explain(&ip, nil); e.Title != "TCP header, 28 bytes, checksum correct" || h3.DataOffset != 7 {
+		t.Errorf("padded options explained as %q with data offset %d", e.Title, h3.DataOffset)
+	}
+
+	h.Options = []TCPOption{{Kind: 254, Data: make([]byte, 40)}}
+	if _, err := h.MarshalBinary(); err == nil {
+		t.Errorf("options over 40 bytes should be rejected")
+	}
+	if err := h.UnmarshalBinary(mustHex(t, "01bbc738000013880000000410")); err == nil {
+		t.Errorf("a short header should be rejected")
+	}
+}
+
+func TestTCPOptions(t *testing.T) {
+	opts, err := ParseTCPOptions(mustHex(t, "0101050a000007d0000007d8fe03aa00ffff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, o := range opts {
+		names = append(names, o.String())
+	}
+	if got := strings.Join(names, "; "); got != "nop; nop; sack {2000:2008}; option 254 [aa]" {
+		t.Errorf("options %s", got)
+	}
+	for _, s := range []string{"02", "0201", "020505b4"} {
+		if _, err := ParseTCPOptions(mustHex(t, s)); err == nil {
+			t.Errorf("%s should be rejected", s)
+		}
+	}
+}
+
+func TestUDPOverIPv6(t *testing.T) {
+	src, dst := netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8:ffff::2")
+	ip := &IPv6Header{TrafficClass: 0xB8, FlowLabel: 0x12345, NextHeader: ProtoUDP, HopLimit: 64,
+		Src: AddrToUint128(src), Dst: AddrToUint128(dst)}
+	payload := []byte("a UDP payload with an odd length")
+	udp := &UDPHeader{SrcPort: 5353, DstPort: 53}
+	if err := udp.SetChecksum(ip, payload); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := udp.MarshalBinary()
+	s16, d16 := src.As16(), dst.As16()
+	if sum := referenceChecksum(s16[:], d16[:], ProtoUDP, append(b, payload...)); sum != 0 {
+		t.Errorf("checksum %04x doesn't verify the long way", udp.Checksum)
+	}
+	if !udp.VerifyChecksum(ip, payload) || udp.Length != uint16(8+len(payload)) {
+		t.Errorf("checksum or length wrong %+v", udp)
+	}
+
+	ip.PayloadLength = udp.Length
+	ib, err := ip.MarshalBinary()
+	if err != nil || len(ib) != 40 || ib[0] != 0x6B || ib[1] != 0x81 {
+		t.Fatalf("marshalled as % x, %v", ib, err)
+	}
+	var ip2 IPv6Header
+	if err := ip2.UnmarshalBinary(ib); err != nil || ip2 != *ip {
+		t.Errorf("unmarshalled as %+v, %v", ip2, err)
+	}
+
+	// A zero checksum means none over IPv4, but isn't allowed over IPv6
+	udp.Checksum = 0
+	if udp.VerifyChecksum(ip, payload) || !udp.VerifyChecksum(&IPv4Header{}, payload) {
+		t.Errorf("zero checksum handled wrongly")
+	}
+}
+
+func TestDecodeIP(t *testing.T) {
+	explanations, err := DecodeIP(mustHex(t, synPacket))
+	if err != nil || len(explanations) != 2 {
+		t.Fatalf("decoded %d headers, %v", len(explanations), err)
+	}
+	var sb strings.Builder
+	te := &TextExplainer{W: &sb}
+	_ = te.Explain(explanations[0])
+	want := `IPv4 header, 20 bytes, checksum correct
+Version     [           4]: 0100
+IHL         [           5]: 0101
+DSCP        [           0]: 000000
+ECN         [           0]: 00
+TotalLength [          60]: 0000000000111100
+ID          [        7238]: 0001110001000110
+Flags       [          DF]: 010
+FragOffset  [           0]: 0000000000000
+TTL         [          64]: 01000000
+Protocol    [         tcp]: 00000110
+Checksum    [        323f]: 0011001000111111
+Src         [   192.0.2.1]: 11000000000000000000001000000001
+Dst         [198.51.100.2]: 11000110001100110110010000000010
+`
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+	tcp := explanations[1]
+	if tcp.Title != "TCP header, 40 bytes, checksum correct" || tcp.Rows[6].Value != "SYN" || tcp.Rows[10].Value != "mss 1460" {
+		t.Errorf("unexpected TCP explanation %+v", tcp)
+	}
+
+	// The IPv6 header's addresses are joined back together
+	ip := &IPv6Header{NextHeader: 59, Src: AddrToUint128(netip.MustParseAddr("2001:db8::1")), Dst: AddrToUint128(netip.MustParseAddr("::1"))}
+	b, _ := ip.MarshalBinary()
+	explanations, err = DecodeIP(b)
+	if err != nil || len(explanations) != 1 || explanations[0].Rows[6].Value != "2001:db8::1" || len(explanations[0].Rows[6].Bits) != 128 {
+		t.Errorf("IPv6 decoded as %+v, %v", explanations, err)
+	}
+
+	bad := []string{
+		"",
+		"5500",
+		// Total length longer than the packet
+		"4500003c1c4640004006323fc0000201c6336402",
+		// TCP header cut short
+		"450000201c4640004006323fc0000201c6336402c73801bb",
+	}
+	for _, s := range bad {
+		if _, err := DecodeIP(mustHex(t, s)); err == nil {
+			t.Errorf("%s should be rejected", s)
+		}
+	}
+}
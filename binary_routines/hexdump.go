@@ -0,0 +1,273 @@
+// Hex and binary dumps of data, and turning a dump back in to the data
+// Andrew Alston
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DumpOptions controls the layout of a dump.  Width is the number of bytes on each line and Group the
+// number of bytes shown together as one word, which must be 1, 2, 4 or 8 and divide Width.  Little
+// shows each word little endian, the last byte first, the way it would be loaded in to a register on
+// x86.  Binary shows each byte as 8 binary digits instead of 2 hex digits.  Offset is where the dump
+// starts in the data and Length the number of bytes to dump, or -1 for all of them
+type DumpOptions struct {
+	Width  int
+	Group  int
+	Little bool
+	Binary bool
+	Offset int64
+	Length int64
+}
+
+// DefaultDumpOptions returns the canonical hexdump layout, 16 bytes a line shown one at a time
+func DefaultDumpOptions() DumpOptions {
+	return DumpOptions{Width: 16, Group: 1, Length: -1}
+}
+
+// check fills in a default width and makes sure the options make sense
+func (o *DumpOptions) check() error {
+	if o.Width == 0 {
+		// Binary digits take four times the room, so we show fewer bytes a line
+		o.Width = 16
+		if o.Binary {
+			o.Width = 6
+		}
+	}
+	if o.Group == 0 {
+		o.Group = 1
+	}
+	switch {
+	case o.Group != 1 && o.Group != 2 && o.Group != 4 && o.Group != 8:
+		return fmt.Errorf("group size %d must be 1, 2, 4 or 8", o.Group)
+	case o.Width < 0 || o.Width%o.Group != 0:
+		return fmt.Errorf("width %d must be a multiple of the group size %d", o.Width, o.Group)
+	case o.Offset < 0:
+		return fmt.Errorf("negative offset %d", o.Offset)
+	}
+	return nil
+}
+
+// digits returns the number of digits shown for each byte
+func (o DumpOptions) digits() int {
+	if o.Binary {
+		return 8
+	}
+	return 2
+}
+
+// split reports whether the line has an extra space down the middle, which the canonical layout of one
+// byte groups uses to make the columns easier to count
+func (o DumpOptions) split() bool {
+	return !o.Binary && o.Group == 1 && o.Width%2 == 0
+}
+
+// formatGroup formats one word of the dump.  A little endian word shows its bytes in reverse, so when
+// the last word of the data is short it is padded on the left, keeping each byte in the column it would
+// be in if the word were whole
+func (o DumpOptions) formatGroup(g []byte) string {
+	var sb strings.Builder
+	if o.Little {
+		sb.WriteString(strings.Repeat(" ", (o.Group-len(g))*o.digits()))
+	}
+	for i := range g {
+		b := g[i]
+		if o.Little {
+			b = g[len(g)-1-i]
+		}
+		if o.Binary {
+			fmt.Fprintf(&sb, "%08b", b)
+		} else {
+			fmt.Fprintf(&sb, "%02x", b)
+		}
+	}
+	return sb.String()
+}
+
+// dumpLine formats one line of the dump, the offset, the words, padded out to a full line so the text
+// column always lines up, and the printable characters between bars
+func (o DumpOptions) dumpLine(offset int64, line []byte) string {
+	var words []string
+	for i := 0; i < len(line); i += o.Group {
+		if o.split() && i == o.Width/2 {
+			words = append(words, "")
+		}
+		words = append(words, o.formatGroup(line[i:min(i+o.Group, len(line))]))
+	}
+	full := o.Width*o.digits() + o.Width/o.Group - 1
+	if o.split() {
+		full++
+	}
+	text := make([]byte, len(line))
+	for i, b := range line {
+		text[i] = '.'
+		if b >= 0x20 && b < 0x7F {
+			text[i] = b
+		}
+	}
+	return fmt.Sprintf("%08x  %-*s  |%s|", offset, full, strings.Join(words, " "), text)
+}
+
+// HexDump writes a dump of r to w, one line per Width bytes, followed by a line holding only the offset
+// just past the end the way hexdump -C does.  With the default options the output matches hexdump -C
+// without its squeezing of repeated lines in to a *
+func HexDump(w io.Writer, r io.Reader, opts DumpOptions) error {
+	if err := opts.check(); err != nil {
+		return err
+	}
+	if opts.Offset > 0 {
+		// Seek past the start when we can, otherwise read and throw it away.  os.Stdin always has a Seek
+		// method, but it fails when standard input is a pipe
+		seeked := false
+		if s, ok := r.(io.Seeker); ok {
+			_, err := s.Seek(opts.Offset, io.SeekCurrent)
+			seeked = err == nil
+		}
+		if !seeked {
+			if _, err := io.CopyN(io.Discard, r, opts.Offset); err != nil && err != io.EOF {
+				return err
+			}
+		}
+	}
+	if opts.Length >= 0 {
+		r = io.LimitReader(r, opts.Length)
+	}
+	bw := bufio.NewWriter(w)
+	line := make([]byte, opts.Width)
+	offset := opts.Offset
+	for {
+		n, err := io.ReadFull(r, line)
+		if n > 0 {
+			if _, err := fmt.Fprintln(bw, opts.dumpLine(offset, line[:n])); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(bw, "%08x\n", offset)
+	return bw.Flush()
+}
+
+// parseDumpWord parses one word of a dump back in to its bytes, in the order they appear in the data
+func (o DumpOptions) parseDumpWord(word string) ([]byte, error) {
+	d := o.digits()
+	if len(word)%d != 0 {
+		return nil, fmt.Errorf("word %q isn't a whole number of bytes", word)
+	}
+	base := 16
+	if o.Binary {
+		base = 2
+	}
+	res := make([]byte, len(word)/d)
+	for i := range res {
+		v, err := strconv.ParseUint(word[i*d:(i+1)*d], base, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte %q", word[i*d:(i+1)*d])
+		}
+		res[i] = byte(v)
+	}
+	if o.Little {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+	return res, nil
+}
+
+// ReverseHexDump parses a dump back in to the data it came from, like xxd -r, writing it to w.  The
+// options must say whether the dump is binary and whether its words are little endian, the group size
+// and width come from the dump itself.  Each line is written at the offset it gives less opts.Offset,
+// so a dump that started part way through its data can be turned back in to just the part dumped, and
+// gaps between lines are filled with zeros.  The offset may end in a colon, and a line with nothing but
+// an offset is the end marker HexDump writes, so it is skipped
+func ReverseHexDump(w io.Writer, r io.Reader, opts DumpOptions) error {
+	if err := opts.check(); err != nil {
+		return err
+	}
+	var data []byte
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		offset, err := strconv.ParseInt(strings.TrimSuffix(fields[0], ":"), 16, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid offset %q", lineNo, fields[0])
+		}
+		if offset -= opts.Offset; offset < 0 {
+			return fmt.Errorf("line %d: offset %x is before the start of the dump", lineNo, offset+opts.Offset)
+		}
+		var line []byte
+		for _, word := range fields[1:] {
+			// The text column starts with a bar, which can never be part of a word
+			if strings.HasPrefix(word, "|") {
+				break
+			}
+			b, err := opts.parseDumpWord(word)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			line = append(line, b...)
+		}
+		if end := offset + int64(len(line)); end > int64(len(data)) {
+			data = append(data, make([]byte, end-int64(len(data)))...)
+		}
+		copy(data[offset:], line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// runHexdump is the hexdump sub command, it dumps a file, or standard input when no file is given, or
+// with -r turns a dump back in to the data
+func runHexdump(args []string) error {
+	fs := flag.NewFlagSet("hexdump", flag.ContinueOnError)
+	opts := DefaultDumpOptions()
+	fs.IntVar(&opts.Width, "w", 0, "Bytes per line, 16 by default or 6 with -b")
+	fs.IntVar(&opts.Group, "g", 1, "Bytes per word: 1, 2, 4 or 8")
+	fs.BoolVar(&opts.Little, "e", false, "Show words little endian")
+	fs.BoolVar(&opts.Binary, "b", false, "Show bytes in binary rather than hex")
+	skip := fs.String("s", "0", "Offset to start the dump at, in decimal or 0x hex")
+	length := fs.String("n", "-1", "Number of bytes to dump, -1 for all")
+	reverse := fs.Bool("r", false, "Turn a dump back in to the data")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if opts.Offset, err = strconv.ParseInt(*skip, 0, 64); err != nil {
+		return fmt.Errorf("invalid offset %q", *skip)
+	}
+	if opts.Length, err = strconv.ParseInt(*length, 0, 64); err != nil {
+		return fmt.Errorf("invalid length %q", *length)
+	}
+	var in io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if *reverse {
+		return ReverseHexDump(os.Stdout, in, opts)
+	}
+	return HexDump(os.Stdout, in, opts)
+}
@@ -0,0 +1,166 @@
+// Hex dump test routines
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// sampleDump is the data the layout tests dump
+var sampleDump = []byte("Hello, world!\nThe quick brown fox\x00\x01\xff")
+
+// dumpString dumps data with opts, failing the test on an error
+func dumpString(t *testing.T, data []byte, opts DumpOptions) string {
+	t.Helper()
+	var sb strings.Builder
+	if err := HexDump(&sb, bytes.NewReader(data), opts); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestHexDumpLayout(t *testing.T) {
+	opts := DefaultDumpOptions()
+	little := DumpOptions{Group: 4, Little: true, Length: -1}
+	binary := DumpOptions{Binary: true, Offset: 3, Length: 10}
+	words := DumpOptions{Width: 8, Group: 2, Length: -1}
+	tests := []struct {
+		name string
+		opts DumpOptions
+		want string
+	}{
+		{"canonical", opts, `00000000  48 65 6c 6c 6f 2c 20 77  6f 72 6c 64 21 0a 54 68  |Hello, world!.Th|
+00000010  65 20 71 75 69 63 6b 20  62 72 6f 77 6e 20 66 6f  |e quick brown fo|
+00000020  78 00 01 ff                                       |x...|
+00000024
+`},
+		{"little endian", little, `00000000  6c6c6548 77202c6f 646c726f 68540a21  |Hello, world!.Th|
+00000010  75712065 206b6369 776f7262 6f66206e  |e quick brown fo|
+00000020  ff010078                             |x...|
+00000024
+`},
+		{"binary range", binary, `00000003  01101100 01101111 00101100 00100000 01110111 01101111  |lo, wo|
+00000009  01110010 01101100 01100100 00100001                    |rld!|
+0000000d
+`},
+		// The last word is short, so it is shown as far as it goes
+		{"words", words, `00000000  4865 6c6c 6f2c 2077  |Hello, w|
+00000008  6f72 6c64 210a 5468  |orld!.Th|
+00000010  6520 7175 6963 6b20  |e quick |
+00000018  6272 6f77 6e20 666f  |brown fo|
+00000020  7800 01ff            |x...|
+00000024
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dumpString(t, sampleDump, tt.opts); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	// A short little endian word is padded on the left so its bytes stay in their columns
+	got := dumpString(t, []byte{1, 2, 3, 4, 5, 6}, DumpOptions{Width: 8, Group: 4, Little: true, Length: -1})
+	if want := "00000000  04030201     0605  |......|\n00000006\n"; got != want {
+		t.Errorf("got %q instead of %q", got, want)
+	}
+	if got := dumpString(t, nil, opts); got != "00000000\n" {
+		t.Errorf("empty dump gave %q", got)
+	}
+}
+
+// pipeReader is a reader with a Seek method that always fails, the way os.Stdin behaves when it is a pipe
+type pipeReader struct {
+	io.Reader
+}
+
+// Seek fails as seeking a pipe does
+func (pipeReader) Seek(int64, int) (int64, error) {
+	return 0, errors.New("illegal seek")
+}
+
+func TestHexDumpOptions(t *testing.T) {
+	bad := []DumpOptions{
+		{Group: 3},
+		{Width: 10, Group: 4},
+		{Width: -1},
+		{Offset: -1},
+	}
+	for _, opts := range bad {
+		if err := HexDump(io.Discard, bytes.NewReader(sampleDump), opts); err == nil {
+			t.Errorf("%+v should be rejected", opts)
+		}
+	}
+	// Wrapping the reader hides its Seek method, so the start is skipped by reading it
+	var sb strings.Builder
+	if err := HexDump(&sb, io.MultiReader(bytes.NewReader(sampleDump)), DumpOptions{Offset: 0x20, Length: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "00000020  78 00 01 ff                                       |x...|\n00000024\n"; sb.String() != want {
+		t.Errorf("got %q instead of %q", sb.String(), want)
+	}
+	// A reader whose Seek fails is skipped by reading it too
+	var piped strings.Builder
+	if err := HexDump(&piped, pipeReader{bytes.NewReader(sampleDump)}, DumpOptions{Offset: 0x20, Length: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if piped.String() != sb.String() {
+		t.Errorf("got %q instead of %q", piped.String(), sb.String())
+	}
+}
+
+func TestReverseHexDump(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		data := make([]byte, r.Intn(100))
+		r.Read(data)
+		group := 1 << r.Intn(4)
+		opts := DumpOptions{
+			Width:  group * (1 + r.Intn(4)),
+			Group:  group,
+			Little: r.Intn(2) == 1,
+			Binary: r.Intn(2) == 1,
+			Offset: int64(r.Intn(10)),
+			Length: -1,
+		}
+		dump := dumpString(t, data, opts)
+		var out bytes.Buffer
+		if err := ReverseHexDump(&out, strings.NewReader(dump), opts); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if want := data[min(int(opts.Offset), len(data)):]; !bytes.Equal(out.Bytes(), want) {
+			t.Fatalf("%+v: got % x instead of % x from\n%s", opts, out.Bytes(), want, dump)
+		}
+	}
+
+	// Lines are placed by their offsets, with gaps filled in with zeros, and the offset may have a colon
+	var out bytes.Buffer
+	dump := "00000004: 0506 |..|\n00000000  0102\n"
+	if err := ReverseHexDump(&out, strings.NewReader(dump), DefaultDumpOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 0, 0, 5, 6}; !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("got % x instead of % x", out.Bytes(), want)
+	}
+
+	bad := []struct {
+		dump string
+		opts DumpOptions
+	}{
+		{"zz  01 02\n", DefaultDumpOptions()},
+		{"00000000  012\n", DefaultDumpOptions()},
+		{"00000000  0g\n", DefaultDumpOptions()},
+		{"00000000  01020304\n", DumpOptions{Binary: true}},
+		{"00000000  01\n", DumpOptions{Offset: 1}},
+	}
+	for _, tt := range bad {
+		if err := ReverseHexDump(io.Discard, strings.NewReader(tt.dump), tt.opts); err == nil {
+			t.Errorf("%q should be rejected", tt.dump)
+		}
+	}
+}
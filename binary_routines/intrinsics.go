@@ -0,0 +1,261 @@
+// Bit manipulation intrinsics, the operations CPUs often provide as single instructions, implemented
+// by hand so we can see how they work
+// Andrew Alston
+
+package main
+
+import "fmt"
+
+// unsignedBits returns the bits of num as a uint64, with anything above the width of T cleared.  For
+// signed types this gives us the two's complement bits, so the routines below can treat every type
+// as an unsigned pattern of bits of the right width
+func unsignedBits[T Integer](num T) uint64 {
+	width := BitWidth[T]()
+	if width == 64 {
+		return uint64(num)
+	}
+	return uint64(num) & (1<<width - 1)
+}
+
+// PopCount returns the number of set bits in num.  Each time around the loop v & (v-1) clears the
+// lowest set bit, so we go around the loop once per set bit rather than once per bit
+func PopCount[T Integer](num T) int {
+	var res int
+	for v := unsignedBits(num); v != 0; v &= v - 1 {
+		res++
+	}
+	return res
+}
+
+// LeadingZeros returns the number of zero bits above the highest set bit of num.  We check the top half
+// of the remaining bits each time, and if it is empty count it as zeros and shift it out of the way,
+// which finds the answer in log2(width) steps
+func LeadingZeros[T Integer](num T) int {
+	width := BitWidth[T]()
+	v := unsignedBits(num)
+	if v == 0 {
+		return width
+	}
+	// Line the number up with the top of a uint64 so the same steps work for every width
+	v <<= 64 - width
+	var res int
+	for step := 32; step > 0; step /= 2 {
+		if v>>(64-step) == 0 {
+			res += step
+			v <<= step
+		}
+	}
+	return res
+}
+
+// TrailingZeros returns the number of zero bits below the lowest set bit of num.  Isolating the lowest
+// set bit leaves a power of two, and the number of trailing zeros is then the number of set bits in
+// that power of two minus one
+func TrailingZeros[T Integer](num T) int {
+	v := unsignedBits(num)
+	if v == 0 {
+		return BitWidth[T]()
+	}
+	return PopCount((v & -v) - 1)
+}
+
+// RotateLeft rotates num left by k bits.  Unlike a shift, the bits pushed off the left come back in on
+// the right, which we do by ORing the left shift with a right shift of the remaining width.  A negative
+// k rotates right, the same as math/bits
+func RotateLeft[T Integer](num T, k int) T {
+	width := BitWidth[T]()
+	k %= width
+	if k < 0 {
+		k += width
+	}
+	v := unsignedBits(num)
+	return T(v<<k | v>>(width-k))
+}
+
+// RotateRight rotates num right by k bits
+func RotateRight[T Integer](num T, k int) T {
+	return RotateLeft(num, -k)
+}
+
+// ReverseBits returns num with the order of its bits reversed.  Rather than moving one bit at a time,
+// we swap neighbouring bits, then neighbouring pairs, then nibbles and so on, with each step using a
+// mask to pick out alternating groups.  We do this on all 64 bits and then shift the result back down
+func ReverseBits[T Integer](num T) T {
+	v := unsignedBits(num)
+	v = (v>>1)&0x5555555555555555 | (v&0x5555555555555555)<<1
+	v = (v>>2)&0x3333333333333333 | (v&0x3333333333333333)<<2
+	v = (v>>4)&0x0F0F0F0F0F0F0F0F | (v&0x0F0F0F0F0F0F0F0F)<<4
+	v = byteSwap64(v)
+	return T(v >> (64 - BitWidth[T]()))
+}
+
+// byteSwap64 reverses the bytes of v, swapping neighbouring bytes, then pairs of bytes, then halves
+func byteSwap64(v uint64) uint64 {
+	v = (v>>8)&0x00FF00FF00FF00FF | (v&0x00FF00FF00FF00FF)<<8
+	v = (v>>16)&0x0000FFFF0000FFFF | (v&0x0000FFFF0000FFFF)<<16
+	return v>>32 | v<<32
+}
+
+// ByteSwap returns num with the order of its bytes reversed, which converts between big and little
+// endian.  A single byte is returned unchanged
+func ByteSwap[T Integer](num T) T {
+	return T(byteSwap64(unsignedBits(num)) >> (64 - BitWidth[T]()))
+}
+
+// NextPowerOfTwo returns the smallest power of two that is greater than or equal to num, or 0 if that
+// doesn't fit in the type.  A signed type can't use its sign bit, so int8(100) gives 0 rather than -128,
+// and anything below 1 gives 1.  Subtracting one and then ORing the number with itself shifted right by
+// 1, 2, 4 and so on copies the highest set bit into every bit below it, and adding one back carries all
+// the way up to the next power of two
+func NextPowerOfTwo[T Integer](num T) T {
+	if num <= 1 {
+		return 1
+	}
+	width := BitWidth[T]()
+	if IsSigned[T]() {
+		width--
+	}
+	v := unsignedBits(num)
+	v--
+	for shift := 1; shift < width; shift *= 2 {
+		v |= v >> shift
+	}
+	v++
+	if width < 64 {
+		v &= 1<<width - 1
+	}
+	return T(v)
+}
+
+// IsolateLowestSetBit returns num with every bit except its lowest set bit cleared.  In two's complement
+// -v is ^v + 1, which flips every bit above the lowest set bit while leaving it and the zeros below it
+// alone, so v & -v keeps only that bit
+func IsolateLowestSetBit[T Integer](num T) T {
+	v := unsignedBits(num)
+	return T(v & -v)
+}
+
+// ClearLowestSetBit returns num with its lowest set bit cleared.  Subtracting one flips the lowest set
+// bit and every zero below it, so v & (v-1) clears just that bit
+func ClearLowestSetBit[T Integer](num T) T {
+	v := unsignedBits(num)
+	return T(v & (v - 1))
+}
+
+// Parity returns 1 if num has an odd number of set bits and 0 if it has an even number.  We fold the
+// number in half with an XOR again and again, each fold keeps the parity of the bits it combines, until
+// the parity of the whole number ends up in the right most bit
+func Parity[T Integer](num T) int {
+	v := unsignedBits(num)
+	for shift := 32; shift > 0; shift /= 2 {
+		v ^= v >> shift
+	}
+	return int(v & 1)
+}
+
+// GrayEncode returns the reflected binary Gray code of num.  Consecutive numbers in Gray code differ by
+// exactly one bit, and the code is each bit XORed with the bit to its left
+func GrayEncode[T Integer](num T) T {
+	v := unsignedBits(num)
+	return T(v ^ v>>1)
+}
+
+// GrayDecode turns a Gray code back into a number.  Each bit of the result is the XOR of every bit at or
+// above it in the code, which we build up by XORing in shifts of 1, 2, 4 and so on
+func GrayDecode[T Integer](num T) T {
+	v := unsignedBits(num)
+	for shift := 1; shift < 64; shift *= 2 {
+		v ^= v >> shift
+	}
+	return T(v)
+}
+
+// unaryExplanation builds the explanation for an intrinsic that turns one number into another
+func unaryExplanation[T Integer](title string, num, res T) Explanation {
+	return Explanation{
+		Title: title,
+		Width: 2,
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(num), Bits: Binary(num)},
+			{Label: "Result", Value: fmt.Sprint(res), Bits: Binary(res)},
+		},
+	}
+}
+
+// countExplanation builds the explanation for an intrinsic that counts bits, the result is a count
+// rather than a number we can show in binary, so we show the input and the count
+func countExplanation[T Integer](title string, num T, count int) Explanation {
+	return Explanation{
+		Title: title,
+		Width: 2,
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(num), Bits: Binary(num)},
+			{Label: "Result", Value: fmt.Sprint(count)},
+		},
+	}
+}
+
+// ExplainPopCount describes a population count
+func ExplainPopCount[T Integer](num T) Explanation {
+	return countExplanation(fmt.Sprintf("Counting the set bits in %d", num), num, PopCount(num))
+}
+
+// ExplainLeadingZeros describes a leading zero count
+func ExplainLeadingZeros[T Integer](num T) Explanation {
+	return countExplanation(fmt.Sprintf("Counting the leading zeros in %d", num), num, LeadingZeros(num))
+}
+
+// ExplainTrailingZeros describes a trailing zero count
+func ExplainTrailingZeros[T Integer](num T) Explanation {
+	return countExplanation(fmt.Sprintf("Counting the trailing zeros in %d", num), num, TrailingZeros(num))
+}
+
+// ExplainParity describes a parity calculation
+func ExplainParity[T Integer](num T) Explanation {
+	return countExplanation(fmt.Sprintf("Calculating the parity of %d", num), num, Parity(num))
+}
+
+// ExplainRotateLeft describes a left rotation
+func ExplainRotateLeft[T Integer](num T, k int) Explanation {
+	return unaryExplanation(fmt.Sprintf("Rotating %d left by %d bits", num, k), num, RotateLeft(num, k))
+}
+
+// ExplainRotateRight describes a right rotation
+func ExplainRotateRight[T Integer](num T, k int) Explanation {
+	return unaryExplanation(fmt.Sprintf("Rotating %d right by %d bits", num, k), num, RotateRight(num, k))
+}
+
+// ExplainReverseBits describes a bit reversal
+func ExplainReverseBits[T Integer](num T) Explanation {
+	return unaryExplanation(fmt.Sprintf("Reversing the bits of %d", num), num, ReverseBits(num))
+}
+
+// ExplainByteSwap describes a byte swap
+func ExplainByteSwap[T Integer](num T) Explanation {
+	return unaryExplanation(fmt.Sprintf("Swapping the bytes of %d", num), num, ByteSwap(num))
+}
+
+// ExplainNextPowerOfTwo describes rounding up to a power of two
+func ExplainNextPowerOfTwo[T Integer](num T) Explanation {
+	return unaryExplanation(fmt.Sprintf("Rounding %d up to a power of two", num), num, NextPowerOfTwo(num))
+}
+
+// ExplainIsolateLowestSetBit describes isolating the lowest set bit
+func ExplainIsolateLowestSetBit[T Integer](num T) Explanation {
+	return unaryExplanation(fmt.Sprintf("Isolating the lowest set bit of %d", num), num, IsolateLowestSetBit(num))
+}
+
+// ExplainClearLowestSetBit describes clearing the lowest set bit
+func ExplainClearLowestSetBit[T Integer](num T) Explanation {
+	return unaryExplanation(fmt.Sprintf("Clearing the lowest set bit of %d", num), num, ClearLowestSetBit(num))
+}
+
+// ExplainGrayEncode describes encoding a number as Gray code
+func ExplainGrayEncode[T Integer](num T) Explanation {
+	return unaryExplanation(fmt.Sprintf("Gray encoding %d", num), num, GrayEncode(num))
+}
+
+// ExplainGrayDecode describes decoding a Gray code back into a number
+func ExplainGrayDecode[T Integer](num T) Explanation {
+	return unaryExplanation(fmt.Sprintf("Gray decoding %d", num), num, GrayDecode(num))
+}
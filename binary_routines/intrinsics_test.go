@@ -0,0 +1,179 @@
+// Bit manipulation intrinsics test routines
+package main
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand/v2"
+	"testing"
+)
+
+// intrinsicRefs holds the math/bits functions for one width, wrapped to take and return uint64 so we
+// can use the same checks for every width
+type intrinsicRefs struct {
+	ones    func(uint64) int
+	leading func(uint64) int
+	trail   func(uint64) int
+	rotate  func(uint64, int) uint64
+	reverse func(uint64) uint64
+	swap    func(uint64) uint64
+	len     func(uint64) int
+}
+
+var refs8 = intrinsicRefs{
+	ones:    func(v uint64) int { return bits.OnesCount8(uint8(v)) },
+	leading: func(v uint64) int { return bits.LeadingZeros8(uint8(v)) },
+	trail:   func(v uint64) int { return bits.TrailingZeros8(uint8(v)) },
+	rotate:  func(v uint64, k int) uint64 { return uint64(bits.RotateLeft8(uint8(v), k)) },
+	reverse: func(v uint64) uint64 { return uint64(bits.Reverse8(uint8(v))) },
+	swap:    func(v uint64) uint64 { return v },
+	len:     func(v uint64) int { return bits.Len8(uint8(v)) },
+}
+
+var refs16 = intrinsicRefs{
+	ones:    func(v uint64) int { return bits.OnesCount16(uint16(v)) },
+	leading: func(v uint64) int { return bits.LeadingZeros16(uint16(v)) },
+	trail:   func(v uint64) int { return bits.TrailingZeros16(uint16(v)) },
+	rotate:  func(v uint64, k int) uint64 { return uint64(bits.RotateLeft16(uint16(v), k)) },
+	reverse: func(v uint64) uint64 { return uint64(bits.Reverse16(uint16(v))) },
+	swap:    func(v uint64) uint64 { return uint64(bits.ReverseBytes16(uint16(v))) },
+	len:     func(v uint64) int { return bits.Len16(uint16(v)) },
+}
+
+var refs32 = intrinsicRefs{
+	ones:    func(v uint64) int { return bits.OnesCount32(uint32(v)) },
+	leading: func(v uint64) int { return bits.LeadingZeros32(uint32(v)) },
+	trail:   func(v uint64) int { return bits.TrailingZeros32(uint32(v)) },
+	rotate:  func(v uint64, k int) uint64 { return uint64(bits.RotateLeft32(uint32(v), k)) },
+	reverse: func(v uint64) uint64 { return uint64(bits.Reverse32(uint32(v))) },
+	swap:    func(v uint64) uint64 { return uint64(bits.ReverseBytes32(uint32(v))) },
+	len:     func(v uint64) int { return bits.Len32(uint32(v)) },
+}
+
+var refs64 = intrinsicRefs{
+	ones:    bits.OnesCount64,
+	leading: bits.LeadingZeros64,
+	trail:   bits.TrailingZeros64,
+	rotate:  bits.RotateLeft64,
+	reverse: bits.Reverse64,
+	swap:    bits.ReverseBytes64,
+	len:     bits.Len64,
+}
+
+// checkIntrinsics compares every intrinsic for type T against math/bits, using edge cases and a
+// batch of random values
+func checkIntrinsics[T Integer](ref intrinsicRefs) func(t *testing.T) {
+	return func(t *testing.T) {
+		width := BitWidth[T]()
+		r := rand.New(rand.NewPCG(uint64(width), 35))
+		values := []uint64{0, 1, 2, 3, 1 << (width - 1), 1<<(width-1) + 1, 1<<(width-1) - 1, ^uint64(0)}
+		for i := 0; i < 500; i++ {
+			values = append(values, r.Uint64())
+		}
+		for _, raw := range values {
+			num := T(raw)
+			v := unsignedBits(num)
+			if got, want := PopCount(num), ref.ones(v); got != want {
+				t.Errorf("PopCount(%d) gave %d instead of %d", num, got, want)
+			}
+			if got, want := LeadingZeros(num), ref.leading(v); got != want {
+				t.Errorf("LeadingZeros(%d) gave %d instead of %d", num, got, want)
+			}
+			if got, want := TrailingZeros(num), ref.trail(v); got != want {
+				t.Errorf("TrailingZeros(%d) gave %d instead of %d", num, got, want)
+			}
+			for _, k := range []int{0, 1, 3, width - 1, width, width + 5, -1, -7} {
+				if got, want := unsignedBits(RotateLeft(num, k)), ref.rotate(v, k); got != want {
+					t.Errorf("RotateLeft(%d, %d) gave %d instead of %d", num, k, got, want)
+				}
+				if got, want := unsignedBits(RotateRight(num, k)), ref.rotate(v, -k); got != want {
+					t.Errorf("RotateRight(%d, %d) gave %d instead of %d", num, k, got, want)
+				}
+			}
+			if got, want := unsignedBits(ReverseBits(num)), ref.reverse(v); got != want {
+				t.Errorf("ReverseBits(%d) gave %d instead of %d", num, got, want)
+			}
+			if got, want := unsignedBits(ByteSwap(num)), ref.swap(v); got != want {
+				t.Errorf("ByteSwap(%d) gave %d instead of %d", num, got, want)
+			}
+			// The next power of two is 1 << Len(v-1), or 0 if that doesn't fit without using the sign bit
+			want, limit := uint64(1), width
+			if IsSigned[T]() {
+				limit--
+			}
+			if num > 1 {
+				want = 0
+				if l := ref.len(v - 1); l < limit {
+					want = 1 << l
+				}
+			}
+			if got := unsignedBits(NextPowerOfTwo(num)); got != want {
+				t.Errorf("NextPowerOfTwo(%d) gave %d instead of %d", num, got, want)
+			}
+			lowest := uint64(0)
+			if v != 0 {
+				lowest = 1 << ref.trail(v)
+			}
+			if got := unsignedBits(IsolateLowestSetBit(num)); got != lowest {
+				t.Errorf("IsolateLowestSetBit(%d) gave %d instead of %d", num, got, lowest)
+			}
+			if got := unsignedBits(ClearLowestSetBit(num)); got != v&^lowest {
+				t.Errorf("ClearLowestSetBit(%d) gave %d instead of %d", num, got, v&^lowest)
+			}
+			if got, want := Parity(num), ref.ones(v)&1; got != want {
+				t.Errorf("Parity(%d) gave %d instead of %d", num, got, want)
+			}
+			gray := GrayEncode(num)
+			if GrayDecode(gray) != num {
+				t.Errorf("Gray code of %d did not round trip", num)
+			}
+			// Consecutive numbers must differ by a single bit in Gray code
+			if got := ref.ones(unsignedBits(gray) ^ unsignedBits(GrayEncode(num+1))); got != 1 {
+				t.Errorf("Gray codes of %d and %d differ by %d bits", num, num+1, got)
+			}
+		}
+	}
+}
+
+func TestIntrinsics(t *testing.T) {
+	t.Run("uint8", checkIntrinsics[uint8](refs8))
+	t.Run("int8", checkIntrinsics[int8](refs8))
+	t.Run("uint16", checkIntrinsics[uint16](refs16))
+	t.Run("int16", checkIntrinsics[int16](refs16))
+	t.Run("uint32", checkIntrinsics[uint32](refs32))
+	t.Run("int32", checkIntrinsics[int32](refs32))
+	t.Run("uint64", checkIntrinsics[uint64](refs64))
+	t.Run("int64", checkIntrinsics[int64](refs64))
+	t.Run("flags", checkIntrinsics[flags](refs8))
+
+	// Signed types never round up in to the sign bit
+	if got := NextPowerOfTwo(int8(100)); got != 0 {
+		t.Errorf("NextPowerOfTwo(int8(100)) gave %d instead of 0", got)
+	}
+	if got := NextPowerOfTwo(int8(63)); got != 64 {
+		t.Errorf("NextPowerOfTwo(int8(63)) gave %d instead of 64", got)
+	}
+	if got := NextPowerOfTwo(int16(-300)); got != 1 {
+		t.Errorf("NextPowerOfTwo(int16(-300)) gave %d instead of 1", got)
+	}
+}
+
+func TestExplainIntrinsics(t *testing.T) {
+	tests := []struct {
+		e    Explanation
+		want string
+	}{
+		{ExplainPopCount(uint8(50)), "Counting the set bits in 50\nBinary [50]: 00110010\nResult [ 3]\n"},
+		{ExplainRotateLeft(uint8(0x81), 1), "Rotating 129 left by 1 bits\nBinary [129]: 10000001\nResult [ 3]: 00000011\n"},
+		{ExplainGrayEncode(uint8(5)), "Gray encoding 5\nBinary [ 5]: 00000101\nResult [ 7]: 00000111\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.e.Title, func(t *testing.T) {
+			var buf bytes.Buffer
+			_ = (&TextExplainer{W: &buf}).Explain(tt.e)
+			if buf.String() != tt.want {
+				t.Errorf("got %q instead of %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,608 @@
+// Layer 2 decoding, Ethernet II and 802.3 frames, 802.1Q and 802.1ad VLAN tags, MPLS label stacks and
+// ARP, decoded straight from a byte slice in to a layered Packet without allocating
+// Andrew Alston
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// EtherTypes we decode, or know the name of
+const (
+	EtherTypeIPv4          = 0x0800
+	EtherTypeARP           = 0x0806
+	EtherTypeVLAN          = 0x8100
+	EtherTypeIPv6          = 0x86DD
+	EtherTypeMPLS          = 0x8847
+	EtherTypeMPLSMulticast = 0x8848
+	EtherTypeQinQ          = 0x88A8
+	EtherTypeLLDP          = 0x88CC
+)
+
+// etherTypeNames are the names decode and pcapstat show for EtherTypes
+var etherTypeNames = map[uint16]string{
+	EtherTypeIPv4:          "ipv4",
+	EtherTypeARP:           "arp",
+	EtherTypeVLAN:          "802.1q",
+	EtherTypeIPv6:          "ipv6",
+	EtherTypeMPLS:          "mpls",
+	EtherTypeMPLSMulticast: "mpls-multicast",
+	EtherTypeQinQ:          "802.1ad",
+	EtherTypeLLDP:          "lldp",
+}
+
+// etherTypeName returns the name of an EtherType, or its number in hex
+func etherTypeName(t uint16) string {
+	if name, ok := etherTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("%04x", t)
+}
+
+// Header lengths.  Frames whose EtherType field is at most MaxEthernetLength are 802.3 frames, and the
+// field is the length of the payload, which starts with an LLC header.  Values from 1536 up are
+// EtherTypes, and anything in between is invalid
+const (
+	EthernetHeaderLen = 14
+	VLANTagLen        = 4
+	MPLSLabelLen      = 4
+	ARPLen            = 28
+	LLCLen            = 3
+	SNAPLen           = 5
+	MaxEthernetLength = 1500
+	minEtherType      = 0x0600
+)
+
+// The most VLAN tags and MPLS labels a Packet holds.  Real networks rarely go past two tags or four
+// labels, and fixed arrays keep decoding free of allocations
+const (
+	maxVLANTags   = 4
+	maxMPLSLabels = 8
+	maxLayers     = 2 + maxVLANTags + maxMPLSLabels + 1
+)
+
+// MACAddr is an Ethernet address.  It is an array rather than a net.HardwareAddr so decoding one doesn't
+// allocate
+type MACAddr [6]byte
+
+// String returns the address in the usual colon separated form
+func (m MACAddr) String() string {
+	return net.HardwareAddr(m[:]).String()
+}
+
+// IsMulticast reports whether the I/G bit, the lowest bit of the first byte and so the first bit sent
+// on the wire, is set.  The broadcast address is a multicast address with every bit set
+func (m MACAddr) IsMulticast() bool {
+	ok, _ := TestBit(m[0], 0, LSB0)
+	return ok
+}
+
+// IsLocal reports whether the U/L bit is set, meaning the address was assigned locally rather than
+// from the vendor's OUI
+func (m MACAddr) IsLocal() bool {
+	ok, _ := TestBit(m[0], 1, LSB0)
+	return ok
+}
+
+// IsBroadcast reports whether every bit of the address is set
+func (m MACAddr) IsBroadcast() bool {
+	return m == MACAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+}
+
+// Ethernet is the header of an Ethernet II or 802.3 frame.  In an 802.3 frame EtherType holds the
+// length of the payload rather than its type
+type Ethernet struct {
+	Dst, Src  MACAddr
+	EtherType uint16
+}
+
+// IsLength reports whether this is an 802.3 frame, whose EtherType field is a length
+func (e *Ethernet) IsLength() bool {
+	return e.EtherType <= MaxEthernetLength
+}
+
+// Decode reads the header from the start of data, returning the rest of the frame
+func (e *Ethernet) Decode(data []byte) ([]byte, error) {
+	if len(data) < EthernetHeaderLen {
+		return nil, errors.New("Ethernet header is truncated")
+	}
+	copy(e.Dst[:], data)
+	copy(e.Src[:], data[6:])
+	e.EtherType = binary.BigEndian.Uint16(data[12:])
+	if e.EtherType > MaxEthernetLength && e.EtherType < minEtherType {
+		return nil, fmt.Errorf("EtherType %04x is neither a length nor a type", e.EtherType)
+	}
+	return data[EthernetHeaderLen:], nil
+}
+
+// LLC is the 802.2 logical link control header that starts the payload of an 802.3 frame.  We only
+// decode unnumbered frames, with their one byte control field, which covers STP, CDP and SNAP
+type LLC struct {
+	DSAP, SSAP uint8
+	Control    uint8
+}
+
+// Decode reads the header from the start of data, returning the rest
+func (l *LLC) Decode(data []byte) ([]byte, error) {
+	if len(data) < LLCLen {
+		return nil, errors.New("LLC header is truncated")
+	}
+	l.DSAP, l.SSAP, l.Control = data[0], data[1], data[2]
+	if l.Control&3 != 3 {
+		return nil, fmt.Errorf("LLC control %02x isn't an unnumbered frame", l.Control)
+	}
+	return data[LLCLen:], nil
+}
+
+// IsSNAP reports whether a SNAP header follows, which is how 802.3 frames carry an EtherType
+func (l *LLC) IsSNAP() bool {
+	return l.DSAP == 0xAA && l.SSAP == 0xAA && l.Control == 3
+}
+
+// SNAP is the subnetwork access protocol header, an organisation code and a protocol number which is an
+// EtherType when the organisation code is zero
+type SNAP struct {
+	OUI       uint32 `bits:"24"`
+	EtherType uint16
+}
+
+// Decode reads the header from the start of data, returning the rest
+func (s *SNAP) Decode(data []byte) ([]byte, error) {
+	if len(data) < SNAPLen {
+		return nil, errors.New("SNAP header is truncated")
+	}
+	s.OUI = uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
+	s.EtherType = binary.BigEndian.Uint16(data[3:])
+	return data[SNAPLen:], nil
+}
+
+// VLANTag is an 802.1Q or 802.1ad tag, the two bytes after the tag's EtherType.  The priority code
+// point, drop eligible indicator and VLAN ID share the first two bytes, and the EtherType of whatever
+// follows takes the other two.  TPID is the EtherType that introduced the tag, 8100 for 802.1Q and 88a8
+// for an 802.1ad service tag
+type VLANTag struct {
+	PCP       uint8 `bits:"3"`
+	DEI       bool
+	VID       uint16 `bits:"12"`
+	EtherType uint16
+	TPID      uint16 `bits:"-"`
+}
+
+// Decode reads the tag from the start of data, returning the rest
+func (v *VLANTag) Decode(data []byte) ([]byte, error) {
+	if len(data) < VLANTagLen {
+		return nil, errors.New("VLAN tag is truncated")
+	}
+	tci := binary.BigEndian.Uint16(data)
+	v.PCP, v.DEI, v.VID = uint8(tci>>13), tci&(1<<12) != 0, tci&0x0FFF
+	v.EtherType = binary.BigEndian.Uint16(data[2:])
+	return data[VLANTagLen:], nil
+}
+
+// MPLSLabel is one entry of an MPLS label stack.  Bottom is the S bit, set on the last label, after which
+// comes the packet itself
+type MPLSLabel struct {
+	Label  uint32 `bits:"20"`
+	TC     uint8  `bits:"3"`
+	Bottom bool
+	TTL    uint8
+}
+
+// mplsReserved are the names of the reserved labels, 0 to 15
+var mplsReserved = map[uint32]string{
+	0:  "IPv4 explicit null",
+	1:  "router alert",
+	2:  "IPv6 explicit null",
+	3:  "implicit null",
+	7:  "entropy label indicator",
+	13: "generic associated channel",
+	14: "OAM alert",
+	15: "extension",
+}
+
+// Decode reads the label from the start of data, returning the rest
+func (m *MPLSLabel) Decode(data []byte) ([]byte, error) {
+	if len(data) < MPLSLabelLen {
+		return nil, errors.New("MPLS label is truncated")
+	}
+	v := binary.BigEndian.Uint32(data)
+	m.Label, m.TC, m.Bottom, m.TTL = v>>12, uint8(v>>9)&7, v&(1<<8) != 0, uint8(v)
+	return data[MPLSLabelLen:], nil
+}
+
+// ARP is an ARP packet resolving an IPv4 address to an Ethernet address, the only kind in common use
+type ARP struct {
+	HardwareType uint16
+	ProtocolType uint16
+	HardwareLen  uint8
+	ProtocolLen  uint8
+	Op           uint16
+	SenderMAC    MACAddr
+	SenderIP     uint32
+	TargetMAC    MACAddr
+	TargetIP     uint32
+}
+
+// ARP operations
+const (
+	ARPRequest  = 1
+	ARPReply    = 2
+	RARPRequest = 3
+	RARPReply   = 4
+)
+
+// arpOps are the names of the ARP operations
+var arpOps = map[uint16]string{ARPRequest: "request", ARPReply: "reply", RARPRequest: "RARP request", RARPReply: "RARP reply"}
+
+// Decode reads the packet from the start of data, returning anything after it, which is usually
+// padding
+func (a *ARP) Decode(data []byte) ([]byte, error) {
+	if len(data) < ARPLen {
+		return nil, errors.New("ARP packet is truncated")
+	}
+	a.HardwareType, a.ProtocolType = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+	a.HardwareLen, a.ProtocolLen = data[4], data[5]
+	if a.HardwareType != 1 || a.ProtocolType != EtherTypeIPv4 || a.HardwareLen != 6 || a.ProtocolLen != 4 {
+		return nil, fmt.Errorf("ARP for hardware type %d and protocol %04x isn't Ethernet and IPv4", a.HardwareType, a.ProtocolType)
+	}
+	a.Op = binary.BigEndian.Uint16(data[6:])
+	copy(a.SenderMAC[:], data[8:])
+	a.SenderIP = binary.BigEndian.Uint32(data[14:])
+	copy(a.TargetMAC[:], data[18:])
+	a.TargetIP = binary.BigEndian.Uint32(data[24:])
+	return data[ARPLen:], nil
+}
+
+// Layer is a header decoded in to a Packet
+type Layer uint8
+
+// The layers of a Packet
+const (
+	LayerLoopback Layer = iota
+	LayerEthernet
+	LayerLLC
+	LayerSNAP
+	LayerVLAN
+	LayerMPLS
+	LayerARP
+)
+
+// String returns the name of the layer
+func (l Layer) String() string {
+	names := [...]string{"Loopback", "Ethernet", "LLC", "SNAP", "VLAN", "MPLS", "ARP"}
+	if int(l) < len(names) {
+		return names[l]
+	}
+	return fmt.Sprintf("Layer(%d)", l)
+}
+
+// Packet is a frame decoded layer by layer, from the link layer down to the network layer.  Layers lists
+// the headers found in order, VLAN tags and MPLS labels once each, and the header fields hold each
+// one's values.  Payload is what follows the last header we know, and NetworkType is its EtherType, or
+// zero if we can't tell what it is.  A Packet can be reused, decoding a frame in to it doesn't allocate
+// and Payload points in to the frame
+type Packet struct {
+	Loopback    uint32
+	Ethernet    Ethernet
+	LLC         LLC
+	SNAP        SNAP
+	ARP         ARP
+	NetworkType uint16
+	Payload     []byte
+
+	vlans      [maxVLANTags]VLANTag
+	labels     [maxMPLSLabels]MPLSLabel
+	layers     [maxLayers]Layer
+	vlanCount  int
+	labelCount int
+	layerCount int
+}
+
+// Layers returns the layers decoded, in order
+func (p *Packet) Layers() []Layer {
+	return p.layers[:p.layerCount]
+}
+
+// Has reports whether the packet has a layer
+func (p *Packet) Has(l Layer) bool {
+	for _, pl := range p.Layers() {
+		if pl == l {
+			return true
+		}
+	}
+	return false
+}
+
+// VLANTags returns the VLAN tags, outermost first
+func (p *Packet) VLANTags() []VLANTag {
+	return p.vlans[:p.vlanCount]
+}
+
+// MPLSLabels returns the label stack, top label first
+func (p *Packet) MPLSLabels() []MPLSLabel {
+	return p.labels[:p.labelCount]
+}
+
+// add records a layer
+func (p *Packet) add(l Layer) error {
+	if p.layerCount == maxLayers {
+		return errors.New("too many layers")
+	}
+	p.layers[p.layerCount] = l
+	p.layerCount++
+	return nil
+}
+
+// Decode decodes a captured frame of the given link type.  Decoding stops at the first EtherType we
+// don't know, or at the network layer, leaving the rest in Payload
+func (p *Packet) Decode(frame []byte, linkType uint32) error {
+	*p = Packet{}
+	switch linkType {
+	case LinkTypeNull:
+		// BSD loopback, a 4 byte address family in the byte order of the capturing machine.  IPv4 is 2
+		// everywhere, IPv6 is 24, 28 or 30 depending on the BSD
+		if len(frame) < 4 {
+			return errors.New("loopback header is truncated")
+		}
+		p.Loopback = binary.LittleEndian.Uint32(frame)
+		if p.Loopback > 0xFFFF {
+			p.Loopback = binary.BigEndian.Uint32(frame)
+		}
+		p.Payload = frame[4:]
+		switch p.Loopback {
+		case 2:
+			p.NetworkType = EtherTypeIPv4
+		case 24, 28, 30:
+			p.NetworkType = EtherTypeIPv6
+		}
+		return p.add(LayerLoopback)
+	case LinkTypeEthernet:
+		return p.decodeEthernet(frame)
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		p.Payload = frame
+		p.NetworkType = ipEtherType(frame)
+		return nil
+	}
+	return fmt.Errorf("unknown link type %d", linkType)
+}
+
+// ipEtherType returns the EtherType for an IP packet from its version, or zero if it isn't IP
+func ipEtherType(data []byte) uint16 {
+	if len(data) > 0 {
+		switch data[0] >> 4 {
+		case 4:
+			return EtherTypeIPv4
+		case 6:
+			return EtherTypeIPv6
+		}
+	}
+	return 0
+}
+
+// decodeEthernet decodes an Ethernet frame and every header we know inside it
+func (p *Packet) decodeEthernet(frame []byte) error {
+	rest, err := p.Ethernet.Decode(frame)
+	if err != nil {
+		return err
+	}
+	if err := p.add(LayerEthernet); err != nil {
+		return err
+	}
+	etherType := p.Ethernet.EtherType
+	if p.Ethernet.IsLength() {
+		if int(etherType) > len(rest) {
+			return fmt.Errorf("802.3 length %d is longer than the %d bytes we have", etherType, len(rest))
+		}
+		// The length lets us drop any padding on short frames
+		if rest, err = p.LLC.Decode(rest[:etherType]); err != nil {
+			return err
+		}
+		if err := p.add(LayerLLC); err != nil {
+			return err
+		}
+		if !p.LLC.IsSNAP() {
+			p.Payload = rest
+			return nil
+		}
+		if rest, err = p.SNAP.Decode(rest); err != nil {
+			return err
+		}
+		if err := p.add(LayerSNAP); err != nil {
+			return err
+		}
+		if p.SNAP.OUI != 0 {
+			p.Payload = rest
+			return nil
+		}
+		etherType = p.SNAP.EtherType
+	}
+
+	for {
+		switch etherType {
+		case EtherTypeVLAN, EtherTypeQinQ:
+			if p.vlanCount == maxVLANTags {
+				return fmt.Errorf("more than %d VLAN tags", maxVLANTags)
+			}
+			tag := &p.vlans[p.vlanCount]
+			if rest, err = tag.Decode(rest); err != nil {
+				return err
+			}
+			tag.TPID = etherType
+			p.vlanCount++
+			etherType = tag.EtherType
+			if err := p.add(LayerVLAN); err != nil {
+				return err
+			}
+		case EtherTypeMPLS, EtherTypeMPLSMulticast:
+			return p.decodeMPLS(rest)
+		case EtherTypeARP:
+			if rest, err = p.ARP.Decode(rest); err != nil {
+				return err
+			}
+			p.Payload = rest
+			return p.add(LayerARP)
+		default:
+			p.Payload, p.NetworkType = rest, etherType
+			return nil
+		}
+	}
+}
+
+// decodeMPLS decodes a label stack.  Nothing in MPLS says what follows the bottom label, so like most
+// routers we look at the first nibble, taking 4 as IPv4 and 6 as IPv6
+func (p *Packet) decodeMPLS(rest []byte) error {
+	for {
+		if p.labelCount == maxMPLSLabels {
+			return fmt.Errorf("more than %d MPLS labels", maxMPLSLabels)
+		}
+		label := &p.labels[p.labelCount]
+		var err error
+		if rest, err = label.Decode(rest); err != nil {
+			return err
+		}
+		p.labelCount++
+		if err := p.add(LayerMPLS); err != nil {
+			return err
+		}
+		if label.Bottom {
+			p.Payload, p.NetworkType = rest, ipEtherType(rest)
+			return nil
+		}
+	}
+}
+
+// Explain describes each field of the header, and what the address bits say about each address
+func (e *Ethernet) Explain() (Explanation, error) {
+	kind := etherTypeName(e.EtherType)
+	title := "Ethernet II header"
+	if e.IsLength() {
+		kind, title = fmt.Sprintf("length %d", e.EtherType), "802.3 header"
+	}
+	rows, err := headerRows(e, map[string]string{
+		"Dst":       macDescription(e.Dst),
+		"Src":       macDescription(e.Src),
+		"EtherType": kind,
+	})
+	return headerExplanation(fmt.Sprintf("%s, %d bytes", title, EthernetHeaderLen), rows), err
+}
+
+// macDescription returns an address along with whether it is unicast, multicast or broadcast
+func macDescription(m MACAddr) string {
+	kind := "unicast"
+	switch {
+	case m.IsBroadcast():
+		kind = "broadcast"
+	case m.IsMulticast():
+		kind = "multicast"
+	}
+	// Every bit of the broadcast address is set, the U/L bit included, but it isn't a local address
+	if m.IsLocal() && !m.IsBroadcast() {
+		kind += " local"
+	}
+	return fmt.Sprintf("%s %s", m, kind)
+}
+
+// Explain describes each field of the header
+func (l *LLC) Explain() (Explanation, error) {
+	rows, err := headerRows(l, map[string]string{
+		"DSAP":    fmt.Sprintf("%02x", l.DSAP),
+		"SSAP":    fmt.Sprintf("%02x", l.SSAP),
+		"Control": fmt.Sprintf("%02x", l.Control),
+	})
+	return headerExplanation(fmt.Sprintf("LLC header, %d bytes", LLCLen), rows), err
+}
+
+// Explain describes each field of the header
+func (s *SNAP) Explain() (Explanation, error) {
+	rows, err := headerRows(s, map[string]string{
+		"OUI":       fmt.Sprintf("%06x", s.OUI),
+		"EtherType": etherTypeName(s.EtherType),
+	})
+	return headerExplanation(fmt.Sprintf("SNAP header, %d bytes", SNAPLen), rows), err
+}
+
+// Explain describes each field of the tag
+func (v *VLANTag) Explain() (Explanation, error) {
+	rows, err := headerRows(v, map[string]string{"EtherType": etherTypeName(v.EtherType)})
+	return headerExplanation(fmt.Sprintf("%s VLAN tag, %d bytes", etherTypeName(v.TPID), VLANTagLen), rows), err
+}
+
+// Explain describes each field of the label, naming the reserved labels
+func (m *MPLSLabel) Explain() (Explanation, error) {
+	values := map[string]string{}
+	if name, ok := mplsReserved[m.Label]; ok {
+		values["Label"] = fmt.Sprintf("%d %s", m.Label, name)
+	}
+	rows, err := headerRows(m, values)
+	return headerExplanation(fmt.Sprintf("MPLS label, %d bytes", MPLSLabelLen), rows), err
+}
+
+// Explain describes each field of the packet
+func (a *ARP) Explain() (Explanation, error) {
+	op, ok := arpOps[a.Op]
+	if !ok {
+		op = fmt.Sprint(a.Op)
+	}
+	rows, err := headerRows(a, map[string]string{
+		"ProtocolType": etherTypeName(a.ProtocolType),
+		"Op":           op,
+		"SenderMAC":    a.SenderMAC.String(),
+		"SenderIP":     ipv4String(a.SenderIP),
+		"TargetMAC":    a.TargetMAC.String(),
+		"TargetIP":     ipv4String(a.TargetIP),
+	})
+	return headerExplanation(fmt.Sprintf("ARP %s, %d bytes", op, ARPLen), rows), err
+}
+
+// Explain describes each layer of the packet, then the IP headers inside it if it holds IP.  As with
+// DecodeIP, an error comes with the explanations made so far
+func (p *Packet) Explain() ([]Explanation, error) {
+	var res []Explanation
+	vlan, label := 0, 0
+	for _, l := range p.Layers() {
+		var e Explanation
+		var err error
+		switch l {
+		case LayerLoopback:
+			e = Explanation{Title: fmt.Sprintf("Loopback header, address family %d", p.Loopback)}
+		case LayerEthernet:
+			e, err = p.Ethernet.Explain()
+		case LayerLLC:
+			e, err = p.LLC.Explain()
+		case LayerSNAP:
+			e, err = p.SNAP.Explain()
+		case LayerVLAN:
+			e, err = p.vlans[vlan].Explain()
+			vlan++
+		case LayerMPLS:
+			e, err = p.labels[label].Explain()
+			label++
+		case LayerARP:
+			e, err = p.ARP.Explain()
+		}
+		if err != nil {
+			return res, err
+		}
+		res = append(res, e)
+	}
+	if p.NetworkType == EtherTypeIPv4 || p.NetworkType == EtherTypeIPv6 {
+		ip, err := DecodeIP(p.Payload)
+		return append(res, ip...), err
+	}
+	return res, nil
+}
+
+// DecodeFrame decodes a captured frame of the given link type and explains every header in it
+func DecodeFrame(frame []byte, linkType uint32) ([]Explanation, error) {
+	var p Packet
+	err := p.Decode(frame, linkType)
+	res, xerr := p.Explain()
+	if err != nil {
+		return res, err
+	}
+	return res, xerr
+}
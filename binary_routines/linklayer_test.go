@@ -0,0 +1,205 @@
+// Layer 2 decoding test routines
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// arpRequest is an Ethernet broadcast asking who has 192.0.2.2
+const arpRequest = "ffffffffffff00005e0053020806" + "0001080006040001" + "00005e005302c0000201" + "000000000000c0000202"
+
+// Ethernet headers to put in front of the SYN from the header tests
+const (
+	etherPrefix = "00005e00530102005e005302"
+	qinqPrefix  = etherPrefix + "88a8" + "b064" + "8100" + "00c8" + "0800"
+	mplsPrefix  = etherPrefix + "8847" + "03e80a40" + "0000213f"
+)
+
+func TestVLANTags(t *testing.T) {
+	syn := mustHex(t, synPacket)
+	frame := append(mustHex(t, qinqPrefix), syn...)
+	var p Packet
+	if err := p.Decode(frame, LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	tags := p.VLANTags()
+	want := []VLANTag{
+		{PCP: 5, DEI: true, VID: 100, EtherType: EtherTypeVLAN, TPID: EtherTypeQinQ},
+		{VID: 200, EtherType: EtherTypeIPv4, TPID: EtherTypeVLAN},
+	}
+	if len(tags) != 2 || tags[0] != want[0] || tags[1] != want[1] {
+		t.Errorf("tags %+v", tags)
+	}
+	if layers := p.Layers(); len(layers) != 3 || layers[0] != LayerEthernet || layers[2] != LayerVLAN {
+		t.Errorf("layers %v", layers)
+	}
+	if p.NetworkType != EtherTypeIPv4 || !bytes.Equal(p.Payload, syn) || p.Has(LayerMPLS) {
+		t.Errorf("payload %04x % x", p.NetworkType, p.Payload)
+	}
+
+	explanations, err := DecodeFrame(frame, LinkTypeEthernet)
+	if err != nil || len(explanations) != 5 {
+		t.Fatalf("decoded %d headers, %v", len(explanations), err)
+	}
+	var sb strings.Builder
+	te := &TextExplainer{W: &sb}
+	_ = te.Explain(explanations[0])
+	_ = te.Explain(explanations[1])
+	wantText := `Ethernet II header, 14 bytes
+Dst       [      00:00:5e:00:53:01 unicast]: 000000000000000001011110000000000101001100000001
+Src       [02:00:5e:00:53:02 unicast local]: 000000100000000001011110000000000101001100000010
+EtherType [                        802.1ad]: 1000100010101000
+802.1ad VLAN tag, 4 bytes
+PCP       [     5]: 101
+DEI       [     1]: 1
+VID       [   100]: 000001100100
+EtherType [802.1q]: 1000000100000000
+`
+	if sb.String() != wantText {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), wantText)
+	}
+}
+
+func TestMPLS(t *testing.T) {
+	syn := mustHex(t, synPacket)
+	var p Packet
+	if err := p.Decode(append(mustHex(t, mplsPrefix), syn...), LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	labels := p.MPLSLabels()
+	if len(labels) != 2 || labels[0] != (MPLSLabel{Label: 16000, TC: 5, TTL: 64}) ||
+		labels[1] != (MPLSLabel{Label: 2, Bottom: true, TTL: 63}) {
+		t.Errorf("labels %+v", labels)
+	}
+	if p.NetworkType != EtherTypeIPv4 || !bytes.Equal(p.Payload, syn) {
+		t.Errorf("payload %04x % x", p.NetworkType, p.Payload)
+	}
+	e, err := labels[1].Explain()
+	if err != nil || e.Rows[0].Value != "2 IPv6 explicit null" || e.Rows[0].Bits != "00000000000000000010" {
+		t.Errorf("label explained as %+v, %v", e, err)
+	}
+
+	// A stack that never reaches the bottom runs out of frame
+	if err := p.Decode(mustHex(t, etherPrefix+"8847"+"03e80a40"), LinkTypeEthernet); err == nil {
+		t.Errorf("a stack without a bottom label should be rejected")
+	}
+	var stack strings.Builder
+	for i := 0; i <= maxMPLSLabels; i++ {
+		stack.WriteString("03e80a40")
+	}
+	if err := p.Decode(mustHex(t, etherPrefix+"8847"+stack.String()), LinkTypeEthernet); err == nil {
+		t.Errorf("too many labels should be rejected")
+	}
+}
+
+func TestARP(t *testing.T) {
+	frame := append(mustHex(t, arpRequest), make([]byte, 18)...)
+	var p Packet
+	if err := p.Decode(frame, LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	a := p.ARP
+	if !p.Has(LayerARP) || a.Op != ARPRequest || ipv4String(a.SenderIP) != "192.0.2.1" || ipv4String(a.TargetIP) != "192.0.2.2" ||
+		a.SenderMAC.String() != "00:00:5e:00:53:02" || len(p.Payload) != 18 || p.NetworkType != 0 {
+		t.Errorf("unexpected ARP %+v", a)
+	}
+	if !p.Ethernet.Dst.IsBroadcast() || !p.Ethernet.Dst.IsMulticast() || p.Ethernet.Src.IsMulticast() {
+		t.Errorf("address bits wrong")
+	}
+	e, err := a.Explain()
+	if err != nil || e.Title != "ARP request, 28 bytes" || e.Rows[5].Value != "00:00:5e:00:53:02" || len(e.Rows[5].Bits) != 48 {
+		t.Errorf("explained as %+v, %v", e, err)
+	}
+
+	// Only Ethernet and IPv4 are decoded
+	frame[15] = 6
+	if err := p.Decode(frame, LinkTypeEthernet); err == nil {
+		t.Errorf("ARP for another hardware type should be rejected")
+	}
+}
+
+func TestIEEE8023(t *testing.T) {
+	var p Packet
+	// A spanning tree BPDU, padded to the minimum frame size
+	stp := mustHex(t, "0180c200000000005e005302"+"0026"+"424203"+"0000000000")
+	stp = append(stp, make([]byte, 60-len(stp))...)
+	if err := p.Decode(stp, LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Ethernet.IsLength() || p.LLC != (LLC{DSAP: 0x42, SSAP: 0x42, Control: 3}) || len(p.Payload) != 0x26-LLCLen || p.Has(LayerSNAP) {
+		t.Errorf("STP decoded as %+v", p)
+	}
+
+	// SNAP with a zero OUI carries an EtherType
+	syn := mustHex(t, synPacket)
+	frame := mustHex(t, etherPrefix+"0044"+"aaaa03"+"000000"+"0800")
+	frame = append(frame, syn...)
+	if err := p.Decode(frame, LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	if p.SNAP != (SNAP{EtherType: EtherTypeIPv4}) || p.NetworkType != EtherTypeIPv4 || !bytes.Equal(p.Payload, syn) {
+		t.Errorf("SNAP decoded as %+v", p)
+	}
+	explanations, err := DecodeFrame(frame, LinkTypeEthernet)
+	if err != nil || len(explanations) != 5 || explanations[0].Title != "802.3 header, 14 bytes" {
+		t.Errorf("decoded %+v, %v", explanations, err)
+	}
+
+	bad := []string{
+		// Length longer than the frame
+		etherPrefix + "0100" + "424203",
+		// Neither a length nor an EtherType
+		etherPrefix + "05f0" + "424203",
+		// An I frame's control field
+		etherPrefix + "0003" + "424200",
+	}
+	for _, s := range bad {
+		if err := p.Decode(mustHex(t, s), LinkTypeEthernet); err == nil {
+			t.Errorf("%s should be rejected", s)
+		}
+	}
+}
+
+func TestLinkTypes(t *testing.T) {
+	syn := mustHex(t, synPacket)
+	var p Packet
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		frame := append(order.AppendUint32(nil, 30), syn...)
+		if err := p.Decode(frame, LinkTypeNull); err != nil || p.Loopback != 30 || p.NetworkType != EtherTypeIPv6 {
+			t.Errorf("%v loopback decoded as family %d type %04x, %v", order, p.Loopback, p.NetworkType, err)
+		}
+	}
+	if err := p.Decode(syn, LinkTypeRaw); err != nil || p.NetworkType != EtherTypeIPv4 || len(p.Layers()) != 0 {
+		t.Errorf("raw IP decoded as %+v, %v", p, err)
+	}
+	if err := p.Decode(syn, 147); err == nil {
+		t.Errorf("an unknown link type should be rejected")
+	}
+	if err := p.Decode(syn[:10], LinkTypeEthernet); err == nil {
+		t.Errorf("a truncated frame should be rejected")
+	}
+	var tags strings.Builder
+	for i := 0; i <= maxVLANTags; i++ {
+		tags.WriteString("8100" + "0064")
+	}
+	if err := p.Decode(mustHex(t, etherPrefix+tags.String()+"0800"), LinkTypeEthernet); err == nil {
+		t.Errorf("too many VLAN tags should be rejected")
+	}
+}
+
+func TestPacketDecodeAllocs(t *testing.T) {
+	frames := [][]byte{
+		append(mustHex(t, qinqPrefix), mustHex(t, synPacket)...),
+		append(mustHex(t, mplsPrefix), mustHex(t, synPacket)...),
+		mustHex(t, arpRequest),
+	}
+	var p Packet
+	for _, frame := range frames {
+		if allocs := testing.AllocsPerRun(100, func() { _ = p.Decode(frame, LinkTypeEthernet) }); allocs != 0 {
+			t.Errorf("decoding % x allocated %v times", frame[:16], allocs)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// MRT (RFC 6396) table dump reading, so RIB dumps from route collectors can be analysed offline, with a
+// summary of the table and the one line per route format bgpdump -m writes
+// Andrew Alston
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MRT record types.  The _ET types add a microsecond field to the header
+const (
+	MRTTableDump   = 12
+	MRTTableDumpV2 = 13
+	MRTBGP4MP      = 16
+	MRTBGP4MPET    = 17
+)
+
+// TABLE_DUMP_V2 subtypes
+const (
+	MRTPeerIndexTable    = 1
+	MRTRIBIPv4Unicast    = 2
+	MRTRIBIPv4Multicast  = 3
+	MRTRIBIPv6Unicast    = 4
+	MRTRIBIPv6Multicast  = 5
+	MRTRIBGeneric        = 6
+	mrtHeaderLen         = 12
+	mrtMaxRecord         = 16 << 20
+	mrtPeerIPv6          = 0x01
+	mrtPeerAS4           = 0x02
+	mrtTableDumpEntryLen = 14
+)
+
+// MRTRecord is an MRT record, its header and the body the type and subtype say how to decode
+type MRTRecord struct {
+	Time    time.Time
+	Type    uint16
+	Subtype uint16
+	Data    []byte
+}
+
+// MRTPeer is a BGP peer of the collector that made a dump
+type MRTPeer struct {
+	BGPID uint32
+	Addr  netip.Addr
+	AS    uint32
+}
+
+// RIB is a prefix from a table dump, with the route each peer had for it.  Routes from a TABLE_DUMP_V2
+// dump have 4 byte AS numbers, those from the older TABLE_DUMP have 2 byte ones
+type RIB struct {
+	Sequence uint32
+	Prefix   Prefix
+	AS4      bool
+	Entries  []RIBEntry
+}
+
+// RIBEntry is a peer's route for a prefix
+type RIBEntry struct {
+	Peer       MRTPeer
+	Originated time.Time
+	Attributes PathAttributes
+}
+
+// MRTReader reads MRT records, from a file that may be compressed with gzip or bzip2 as collectors
+// publish them
+type MRTReader struct {
+	r     *bufio.Reader
+	peers []MRTPeer
+}
+
+// NewMRTReader returns a reader for an MRT file, uncompressing it if it starts like gzip or bzip2
+func NewMRTReader(r io.Reader) (*MRTReader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	case bytes.Equal(magic, []byte("BZh")):
+		br = bufio.NewReader(bzip2.NewReader(br))
+	}
+	return &MRTReader{r: br}, nil
+}
+
+// Peers returns the peers of the last peer index table read
+func (mr *MRTReader) Peers() []MRTPeer {
+	return mr.peers
+}
+
+// Next returns the next record, io.EOF at the end of the file and io.ErrUnexpectedEOF if the file is
+// cut short
+func (mr *MRTReader) Next() (MRTRecord, error) {
+	var hdr [mrtHeaderLen]byte
+	if _, err := io.ReadFull(mr.r, hdr[:]); err != nil {
+		return MRTRecord{}, err
+	}
+	rec := MRTRecord{
+		Time:    time.Unix(int64(binary.BigEndian.Uint32(hdr[:])), 0),
+		Type:    binary.BigEndian.Uint16(hdr[4:]),
+		Subtype: binary.BigEndian.Uint16(hdr[6:]),
+	}
+	n := binary.BigEndian.Uint32(hdr[8:])
+	if n > mrtMaxRecord {
+		return MRTRecord{}, fmt.Errorf("MRT record of %d bytes is too long", n)
+	}
+	rec.Data = make([]byte, n)
+	if _, err := io.ReadFull(mr.r, rec.Data); err != nil {
+		return MRTRecord{}, io.ErrUnexpectedEOF
+	}
+	if rec.Type == MRTBGP4MPET {
+		if n < 4 {
+			return MRTRecord{}, errors.New("MRT record is too short for its microseconds")
+		}
+		rec.Time = rec.Time.Add(time.Duration(binary.BigEndian.Uint32(rec.Data)) * time.Microsecond)
+		rec.Data = rec.Data[4:]
+	}
+	return rec, nil
+}
+
+// NextRIB returns the next prefix of a table dump, io.EOF at the end.  Peer index tables are kept to
+// look up the peers of the RIB records that follow, and records that aren't part of a table dump are
+// skipped
+func (mr *MRTReader) NextRIB() (*RIB, error) {
+	for {
+		rec, err := mr.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case rec.Type == MRTTableDumpV2 && rec.Subtype == MRTPeerIndexTable:
+			if mr.peers, err = parsePeerIndex(rec.Data); err != nil {
+				return nil, err
+			}
+		case rec.Type == MRTTableDumpV2 && rec.Subtype >= MRTRIBIPv4Unicast && rec.Subtype <= MRTRIBGeneric:
+			return mr.parseRIB(rec)
+		case rec.Type == MRTTableDump:
+			return parseTableDump(rec)
+		}
+	}
+}
+
+// parsePeerIndex decodes a peer index table.  Each peer's type says whether its address is IPv6 and
+// whether its AS number takes 4 bytes
+func parsePeerIndex(b []byte) ([]MRTPeer, error) {
+	errShort := errors.New("peer index table is truncated")
+	if len(b) < 6 || len(b) < 8+int(binary.BigEndian.Uint16(b[4:])) {
+		return nil, errShort
+	}
+	b = b[6+binary.BigEndian.Uint16(b[4:]):]
+	peers := make([]MRTPeer, binary.BigEndian.Uint16(b))
+	b = b[2:]
+	for i := range peers {
+		if len(b) < 5 {
+			return nil, errShort
+		}
+		typ, addrLen, asLen := b[0], 4, 2
+		if typ&mrtPeerIPv6 != 0 {
+			addrLen = 16
+		}
+		if typ&mrtPeerAS4 != 0 {
+			asLen = 4
+		}
+		if len(b) < 5+addrLen+asLen {
+			return nil, errShort
+		}
+		p := &peers[i]
+		p.BGPID = binary.BigEndian.Uint32(b[1:])
+		p.Addr, _ = netip.AddrFromSlice(b[5 : 5+addrLen])
+		if asLen == 4 {
+			p.AS = binary.BigEndian.Uint32(b[5+addrLen:])
+		} else {
+			p.AS = uint32(binary.BigEndian.Uint16(b[5+addrLen:]))
+		}
+		b = b[5+addrLen+asLen:]
+	}
+	return peers, nil
+}
+
+// parseRIB decodes a TABLE_DUMP_V2 RIB record, the prefix and then an entry for each peer that has a
+// route for it.  A generic RIB record gives its family, the others are named after theirs
+func (mr *MRTReader) parseRIB(rec MRTRecord) (*RIB, error) {
+	b := rec.Data
+	if len(b) < 4 {
+		return nil, errors.New("RIB record is truncated")
+	}
+	r := &RIB{Sequence: binary.BigEndian.Uint32(b), AS4: true}
+	b = b[4:]
+	ipv6 := rec.Subtype == MRTRIBIPv6Unicast || rec.Subtype == MRTRIBIPv6Multicast
+	if rec.Subtype == MRTRIBGeneric {
+		if len(b) < 3 {
+			return nil, errors.New("RIB record is truncated")
+		}
+		var err error
+		if ipv6, err = familyIPv6(binary.BigEndian.Uint16(b), b[2]); err != nil {
+			return nil, err
+		}
+		b = b[3:]
+	}
+	p, n, err := DecodeNLRI(b, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	r.Prefix, b = p, b[n:]
+	if len(b) < 2 {
+		return nil, errors.New("RIB record is truncated")
+	}
+	r.Entries = make([]RIBEntry, binary.BigEndian.Uint16(b))
+	b = b[2:]
+	for i := range r.Entries {
+		if len(b) < 8 || len(b) < 8+int(binary.BigEndian.Uint16(b[6:])) {
+			return nil, fmt.Errorf("RIB entry %d of %s is truncated", i, r.Prefix)
+		}
+		peer := int(binary.BigEndian.Uint16(b))
+		if peer >= len(mr.peers) {
+			return nil, fmt.Errorf("RIB entry for %s refers to peer %d of %d", r.Prefix, peer, len(mr.peers))
+		}
+		e := &r.Entries[i]
+		e.Peer = mr.peers[peer]
+		e.Originated = time.Unix(int64(binary.BigEndian.Uint32(b[2:])), 0)
+		n := int(binary.BigEndian.Uint16(b[6:]))
+		if e.Attributes, err = parseAttributes(b[8 : 8+n]); err != nil {
+			return nil, fmt.Errorf("RIB entry for %s: %v", r.Prefix, err)
+		}
+		b = b[8+n:]
+	}
+	return r, nil
+}
+
+// parseTableDump decodes a record of the older TABLE_DUMP format, which has a single route with its
+// peer inline, and 2 byte AS numbers
+func parseTableDump(rec MRTRecord) (*RIB, error) {
+	addrLen := 4
+	if rec.Subtype == AFIIPv6 {
+		addrLen = 16
+	} else if rec.Subtype != AFIIPv4 {
+		return nil, fmt.Errorf("TABLE_DUMP subtype %d isn't supported", rec.Subtype)
+	}
+	// Besides the prefix and peer addresses the entry has 14 bytes of fixed fields before its attributes
+	b := rec.Data
+	if len(b) < mrtTableDumpEntryLen+2*addrLen {
+		return nil, errors.New("TABLE_DUMP record is truncated")
+	}
+	addr, _ := netip.AddrFromSlice(b[4 : 4+addrLen])
+	r := &RIB{Sequence: uint32(binary.BigEndian.Uint16(b[2:]))}
+	r.Prefix = Prefix{Addr: AddrToUint128(addr), Bits: int(b[4+addrLen]), IPv6: addrLen == 16}
+	if r.Prefix.Bits > r.Prefix.Width() {
+		return nil, fmt.Errorf("TABLE_DUMP prefix length %d is too long", r.Prefix.Bits)
+	}
+	b = b[6+addrLen:]
+	e := RIBEntry{Originated: time.Unix(int64(binary.BigEndian.Uint32(b)), 0)}
+	e.Peer.Addr, _ = netip.AddrFromSlice(b[4 : 4+addrLen])
+	e.Peer.AS = uint32(binary.BigEndian.Uint16(b[4+addrLen:]))
+	n := int(binary.BigEndian.Uint16(b[6+addrLen:]))
+	if len(b) < 8+addrLen+n {
+		return nil, errors.New("TABLE_DUMP attributes are truncated")
+	}
+	var err error
+	if e.Attributes, err = parseAttributes(b[8+addrLen : 8+addrLen+n]); err != nil {
+		return nil, fmt.Errorf("TABLE_DUMP entry for %s: %v", r.Prefix, err)
+	}
+	r.Entries = []RIBEntry{e}
+	return r, nil
+}
+
+// NextHop returns the route's next hop, from NEXT_HOP or MP_REACH_NLRI.  Table dumps shorten
+// MP_REACH_NLRI to only the next hop and its length, since the prefix is in the RIB record, but some
+// writers keep the whole attribute so we take either
+func (e RIBEntry) NextHop() (netip.Addr, bool) {
+	if nh, ok := e.Attributes.NextHop(); ok {
+		return nh, true
+	}
+	a, ok := e.Attributes.find(AttrMPReach)
+	if !ok || len(a.Value) == 0 {
+		return netip.Addr{}, false
+	}
+	if int(a.Value[0]) == len(a.Value)-1 {
+		if nhs, err := decodeNextHops(a.Value[1:]); err == nil {
+			return nhs[0], true
+		}
+	}
+	if mp, _, err := e.Attributes.MPReach(); err == nil && len(mp.NextHops) > 0 {
+		return mp.NextHops[0], true
+	}
+	return netip.Addr{}, false
+}
+
+// BGPDumpLines returns a line for each route of a RIB in the format bgpdump -m writes, fields separated
+// by bars: type, time, B, peer address, peer AS, prefix, AS path, origin, next hop, local preference,
+// MED, communities, whether the route is an atomic aggregate and the aggregator
+func (r *RIB) BGPDumpLines() []string {
+	kind := "TABLE_DUMP2"
+	if !r.AS4 {
+		kind = "TABLE_DUMP"
+	}
+	var lines []string
+	for _, e := range r.Entries {
+		attrs := e.Attributes
+		path, _ := attrs.MergedASPath(r.AS4)
+		origin := ""
+		if o, ok := attrs.Origin(); ok && int(o) < len(originNames) {
+			origin = originNames[o]
+		}
+		nextHop := ""
+		if nh, ok := e.NextHop(); ok {
+			nextHop = nh.String()
+		}
+		pref, _ := attrs.LocalPref()
+		med, _ := attrs.MED()
+		cs, _ := attrs.Communities()
+		lcs, _ := attrs.LargeCommunities()
+		communities := strings.TrimSpace(joinStrings(cs) + " " + joinStrings(lcs))
+		atomic := "NAG"
+		if _, ok := attrs.find(AttrAtomicAggregate); ok {
+			atomic = "AG"
+		}
+		aggregator := ""
+		if as, id, ok := attrs.MergedAggregator(r.AS4); ok {
+			aggregator = fmt.Sprintf("%d %s", as, id)
+		}
+		lines = append(lines, strings.Join([]string{kind, strconv.FormatInt(e.Originated.Unix(), 10), "B",
+			e.Peer.Addr.String(), strconv.FormatUint(uint64(e.Peer.AS), 10), r.Prefix.String(), path.String(),
+			origin, nextHop, strconv.FormatUint(uint64(pref), 10), strconv.FormatUint(uint64(med), 10),
+			communities, atomic, aggregator, ""}, "|"))
+	}
+	return lines
+}
+
+// RIBStats summarises a table dump, counting prefixes by family, the routes each AS originates and how
+// long the AS paths are
+type RIBStats struct {
+	IPv4, IPv6  int
+	Routes      int
+	origins     map[uint32]int
+	pathLengths map[int]int
+	prefixLens  map[int]int
+}
+
+// NewRIBStats returns empty statistics
+func NewRIBStats() *RIBStats {
+	return &RIBStats{origins: map[uint32]int{}, pathLengths: map[int]int{}, prefixLens: map[int]int{}}
+}
+
+// Add counts a prefix and its routes
+func (rs *RIBStats) Add(r *RIB) {
+	if r.Prefix.IPv6 {
+		rs.IPv6++
+	} else {
+		rs.IPv4++
+	}
+	rs.prefixLens[r.Prefix.Bits]++
+	for _, e := range r.Entries {
+		rs.Routes++
+		path, err := e.Attributes.MergedASPath(r.AS4)
+		if err != nil {
+			continue
+		}
+		rs.pathLengths[path.Length()]++
+		if as, ok := path.OriginAS(); ok {
+			rs.origins[as]++
+		}
+	}
+}
+
+// sortedKeys returns the keys of a count map, smallest first
+func sortedKeys(counts map[int]int) []int {
+	keys := make([]int, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Explain describes the table, listing the top ASes by the number of routes they originate
+func (rs *RIBStats) Explain(top int) []Explanation {
+	type originCount struct {
+		as     uint32
+		routes int
+	}
+	var origins []originCount
+	for as, n := range rs.origins {
+		origins = append(origins, originCount{as, n})
+	}
+	sort.Slice(origins, func(i, j int) bool {
+		if origins[i].routes != origins[j].routes {
+			return origins[i].routes > origins[j].routes
+		}
+		return origins[i].as < origins[j].as
+	})
+	var originRows, pathRows, lenRows []ExplainRow
+	for _, o := range origins[:max(0, min(top, len(origins)))] {
+		originRows = append(originRows, ExplainRow{Label: fmt.Sprintf("AS%d", o.as),
+			Value: fmt.Sprintf("%d routes %.1f%%", o.routes, percent(o.routes, rs.Routes))})
+	}
+	for _, l := range sortedKeys(rs.pathLengths) {
+		n := rs.pathLengths[l]
+		pathRows = append(pathRows, ExplainRow{Label: strconv.Itoa(l), Value: fmt.Sprintf("%d routes %.1f%%", n, percent(n, rs.Routes))})
+	}
+	for _, l := range sortedKeys(rs.prefixLens) {
+		n := rs.prefixLens[l]
+		lenRows = append(lenRows, ExplainRow{Label: "/" + strconv.Itoa(l), Value: fmt.Sprintf("%d prefixes %.1f%%", n, percent(n, rs.IPv4+rs.IPv6))})
+	}
+	return []Explanation{
+		{Title: fmt.Sprintf("%d IPv4 prefixes, %d IPv6 prefixes, %d routes", rs.IPv4, rs.IPv6, rs.Routes)},
+		{Title: fmt.Sprintf("Top %d origin ASes by routes", len(originRows)), Rows: padLabels(originRows)},
+		{Title: "AS path lengths", Rows: padLabels(pathRows)},
+		{Title: "Prefix lengths", Rows: padLabels(lenRows)},
+	}
+}
+
+// runMRT is the mrt sub command, it prints the routes of MRT table dumps as bgpdump -m does, or with
+// -summary describes the tables
+func runMRT(args []string) error {
+	fs := flag.NewFlagSet("mrt", flag.ContinueOnError)
+	summary := fs.Bool("summary", false, "Summarise the tables rather than list their routes")
+	top := fs.Int("top", 10, "Number of top origin ASes to list in the summary")
+	format := fs.String("format", "text", "Output format of the summary: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *top < 0 {
+		return fmt.Errorf("-top must not be negative")
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: mrt [-summary] [-top n] [-format text|markdown|html] <file> [<file> ...]")
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, name := range fs.Args() {
+		if err := dumpMRT(name, w, ex, *summary, *top); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpMRT reads one MRT file for runMRT
+func dumpMRT(name string, w io.Writer, ex Explainer, summary bool, top int) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	mr, err := NewMRTReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	rs := NewRIBStats()
+	for {
+		r, err := mr.NextRIB()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if summary {
+			rs.Add(r)
+			continue
+		}
+		for _, line := range r.BGPDumpLines() {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	if !summary {
+		return nil
+	}
+	if err := ex.Note(name); err != nil {
+		return err
+	}
+	for _, e := range rs.Explain(top) {
+		if err := ex.Explain(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// MRT table dump test routines
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// mrtRecord builds an MRT record, stamped 2024-01-01
+func mrtRecord(typ, subtype uint16, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1704067200)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, subtype)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// ribEntry builds a TABLE_DUMP_V2 RIB entry
+func ribEntry(peer uint16, attrs PathAttributes) []byte {
+	enc, _ := appendAttributes(nil, attrs)
+	b := binary.BigEndian.AppendUint16(nil, peer)
+	b = binary.BigEndian.AppendUint32(b, 1700000000)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(enc)))
+	return append(b, enc...)
+}
+
+// sampleTableDump builds a table dump with two peers, an IPv4 prefix both have routes for, an IPv6 prefix,
+// an update record that isn't part of the table and a route in the older TABLE_DUMP format
+func sampleTableDump(t *testing.T) []byte {
+	t.Helper()
+	// A collector with a view called rv01.  Peer 0 is IPv4 with a 2 byte AS, peer 1 IPv6 with a 4 byte AS
+	peers := append(mustHex(t, "c0000201"+"0004"), []byte("rv01")...)
+	peers = append(peers, 0, 2)
+	peers = append(peers, mustHex(t, "00"+"c0000202"+"c0000202"+"fbf0")...)
+	peers = append(peers, mustHex(t, "03"+"c0000203"+"20010db8000000000000000000000003"+"fa56ea00")...)
+
+	nh, _ := NewNextHopAttribute(netip.MustParseAddr("192.0.2.2"))
+	path := func(asns ...uint32) PathAttribute {
+		a, _ := NewASPathAttribute(ASPath{{Type: ASSequence, ASNs: asns}}, true)
+		return a
+	}
+	v4 := append(binary.BigEndian.AppendUint32(nil, 0), mustHex(t, "18c63364")...)
+	v4 = append(v4, 0, 2)
+	v4 = append(v4, ribEntry(0, PathAttributes{NewOriginAttribute(OriginIGP), path(64496, 64500), nh,
+		NewCommunitiesAttribute(64496<<16 | 100), {Flags: AttrFlagTransitive, Type: AttrAtomicAggregate}})...)
+	v4 = append(v4, ribEntry(1, PathAttributes{NewOriginAttribute(OriginEGP), path(4200000000, 64511, 64500), nh,
+		NewMEDAttribute(5), NewLocalPrefAttribute(100), NewLargeCommunitiesAttribute(LargeCommunity{4200000000, 1, 2})})...)
+
+	// Table dumps keep only the next hop of MP_REACH_NLRI
+	mp := PathAttribute{Flags: AttrFlagOptional, Type: AttrMPReach, Value: mustHex(t, "10"+"20010db8000000000000000000000003")}
+	v6 := append(binary.BigEndian.AppendUint32(nil, 1), mustHex(t, "2020010db8")...)
+	v6 = append(v6, 0, 1)
+	v6 = append(v6, ribEntry(1, PathAttributes{NewOriginAttribute(OriginIncomplete), path(4200000000, 64501), mp})...)
+
+	// The older format only has 2 byte AS numbers, so a 4 byte origin that aggregated the route is AS_TRANS
+	// in AS_PATH and AGGREGATOR, and real in AS4_PATH and AS4_AGGREGATOR
+	path2, _ := NewASPathAttribute(ASPath{{Type: ASSequence, ASNs: []uint32{64496, ASTrans}}}, false)
+	path4 := PathAttribute{Flags: AttrFlagOptional | AttrFlagTransitive, Type: AttrAS4Path, Value: mustHex(t, "0201"+"fa56ea00")}
+	agg := PathAttribute{Flags: AttrFlagOptional | AttrFlagTransitive, Type: AttrAggregator, Value: mustHex(t, "5ba0"+"c0000209")}
+	agg4 := PathAttribute{Flags: AttrFlagOptional | AttrFlagTransitive, Type: AttrAS4Aggregator, Value: mustHex(t, "fa56ea00"+"c0000209")}
+	attrs, _ := appendAttributes(nil, PathAttributes{NewOriginAttribute(OriginIGP), path2, nh, agg, path4, agg4})
+	old := mustHex(t, "0000"+"0007"+"cb007100"+"19"+"01"+"6553f100"+"c0000202"+"fbf0")
+	old = append(binary.BigEndian.AppendUint16(old, uint16(len(attrs))), attrs...)
+
+	var b []byte
+	b = append(b, mrtRecord(MRTTableDumpV2, MRTPeerIndexTable, peers)...)
+	b = append(b, mrtRecord(MRTTableDumpV2, MRTRIBIPv4Unicast, v4)...)
+	b = append(b, mrtRecord(MRTBGP4MPET, 4, mustHex(t, "000003e8"+keepalive))...)
+	b = append(b, mrtRecord(MRTTableDumpV2, MRTRIBIPv6Unicast, v6)...)
+	return append(b, mrtRecord(MRTTableDump, AFIIPv4, old)...)
+}
+
+// readRIBs reads every prefix of a dump
+func readRIBs(t *testing.T, data []byte) []*RIB {
+	t.Helper()
+	mr, err := NewMRTReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ribs []*RIB
+	for {
+		r, err := mr.NextRIB()
+		if err == io.EOF {
+			return ribs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ribs = append(ribs, r)
+	}
+}
+
+func TestMRTTableDump(t *testing.T) {
+	data := sampleTableDump(t)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+
+	want := `TABLE_DUMP2|1700000000|B|192.0.2.2|64496|198.51.100.0/24|64496 64500|IGP|192.0.2.2|0|0|64496:100|AG||
+TABLE_DUMP2|1700000000|B|2001:db8::3|4200000000|198.51.100.0/24|4200000000 64511 64500|EGP|192.0.2.2|100|5|4200000000:1:2|NAG||
+TABLE_DUMP2|1700000000|B|2001:db8::3|4200000000|2001:db8::/32|4200000000 64501|INCOMPLETE|2001:db8::3|0|0||NAG||
+TABLE_DUMP|1700000000|B|192.0.2.2|64496|203.0.113.0/25|64496 4200000000|IGP|192.0.2.2|0|0||NAG|4200000000 192.0.2.9|
+`
+	for _, in := range [][]byte{data, gz.Bytes()} {
+		ribs := readRIBs(t, in)
+		var sb strings.Builder
+		for _, r := range ribs {
+			for _, line := range r.BGPDumpLines() {
+				sb.WriteString(line + "\n")
+			}
+		}
+		if sb.String() != want {
+			t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+		}
+	}
+
+	rs := NewRIBStats()
+	for _, r := range readRIBs(t, data) {
+		rs.Add(r)
+	}
+	var sb strings.Builder
+	te := &TextExplainer{W: &sb}
+	for _, e := range rs.Explain(1) {
+		_ = te.Explain(e)
+	}
+	wantStats := `2 IPv4 prefixes, 1 IPv6 prefixes, 4 routes
+Top 1 origin ASes by routes
+AS64500 [2 routes 50.0%]
+AS path lengths
+2 [3 routes 75.0%]
+3 [1 routes 25.0%]
+Prefix lengths
+/24 [1 prefixes 33.3%]
+/25 [1 prefixes 33.3%]
+/32 [1 prefixes 33.3%]
+`
+	if sb.String() != wantStats {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), wantStats)
+	}
+
+	// A negative count lists no origins rather than panicking, and the command rejects it
+	if e := rs.Explain(-1); len(e[1].Rows) != 0 {
+		t.Errorf("a negative count listed %+v", e[1].Rows)
+	}
+	if err := runMRT([]string{"-summary", "-top", "-1", "rib.mrt"}); err == nil {
+		t.Errorf("a negative -top should be rejected")
+	}
+}
+
+func TestMRTErrors(t *testing.T) {
+	data := sampleTableDump(t)
+	mr, _ := NewMRTReader(bytes.NewReader(data[:len(data)-1]))
+	var err error
+	for err == nil {
+		_, err = mr.NextRIB()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("a truncated dump gave %v", err)
+	}
+
+	// A RIB record before any peer index table has no peers to refer to
+	i := bytes.Index(data, mrtRecord(MRTTableDumpV2, MRTRIBIPv4Unicast, nil)[:8])
+	mr, _ = NewMRTReader(bytes.NewReader(data[i:]))
+	if _, err := mr.NextRIB(); err == nil {
+		t.Errorf("a RIB entry for an unknown peer should be rejected")
+	}
+	if _, err := parsePeerIndex(mustHex(t, "c000020100000001")); err == nil {
+		t.Errorf("a truncated peer index table should be rejected")
+	}
+
+	// A TABLE_DUMP entry whose only attribute is ORIGIN is shorter than 22 bytes plus the addresses,
+	// but complete
+	old := mustHex(t, "0000"+"0007"+"cb007100"+"19"+"01"+"6553f100"+"c0000202"+"fbf0"+"0004"+"40010100")
+	r, err := parseTableDump(MRTRecord{Type: MRTTableDump, Subtype: AFIIPv4, Data: old})
+	if err != nil || r.Prefix.String() != "203.0.113.0/25" || len(r.Entries[0].Attributes) != 1 {
+		t.Errorf("a TABLE_DUMP entry with only ORIGIN read as %+v, %v", r, err)
+	}
+	if _, err := parseTableDump(MRTRecord{Type: MRTTableDump, Subtype: AFIIPv4, Data: old[:21]}); err == nil {
+		t.Errorf("a truncated TABLE_DUMP entry should be rejected")
+	}
+}
@@ -0,0 +1,764 @@
+// Reading and writing packet captures, in the classic libpcap format and the newer pcapng format, along
+// with the pcapstat and pcapfilter sub commands built on them
+// Andrew Alston
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"time"
+)
+
+// Link types say what the first header of each captured packet is
+const (
+	LinkTypeNull     = 0
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+)
+
+// Magic numbers.  The classic format's magic is written in the byte order of the machine that wrote the
+// file, and also says whether the timestamps are in micro or nanoseconds.  A pcapng file starts with a
+// section header block, whose type reads the same in either byte order, followed by its own magic
+const (
+	pcapMagicMicro   = 0xA1B2C3D4
+	pcapMagicNano    = 0xA1B23C4D
+	pcapngBlockMagic = 0x1A2B3C4D
+)
+
+// pcapng block types and interface options we understand, every other block and option is skipped
+const (
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterface      = 1
+	pcapngSimplePacket   = 3
+	pcapngEnhancedPacket = 6
+	pcapngOptionEnd      = 0
+	pcapngOptionTSResol  = 9
+)
+
+// Sizes of the fixed parts of the headers and blocks.  pcapMaxBlock stops a corrupt length from making
+// us allocate gigabytes, and pcapSnapLen is the longest packet we write, the same limit tcpdump uses
+const (
+	pcapClassicHeaderLen  = 24
+	pcapClassicRecordLen  = 16
+	pcapngBlockOverhead   = 12
+	pcapngSectionFixedLen = 16
+	pcapngInterfaceFixed  = 8
+	pcapngEnhancedFixed   = 20
+	pcapSnapLen           = 262144
+	pcapMaxBlock          = 16 << 20
+)
+
+// ErrNotCapture is returned when a file is neither a pcap nor a pcapng file
+var ErrNotCapture = errors.New("not a pcap or pcapng file")
+
+// CapturedPacket is a packet read from or written to a capture.  Length is the length the packet had on
+// the wire, which is more than len(Data) when the capture only kept the start of each packet
+type CapturedPacket struct {
+	Time     time.Time
+	Length   int
+	LinkType uint32
+	Data     []byte
+}
+
+// pcapInterface is a pcapng interface description.  Every packet refers to one, which gives its link
+// type and how many timestamp units make a second
+type pcapInterface struct {
+	linkType uint32
+	snapLen  uint32
+	perSec   uint64
+}
+
+// timestamp turns a count of units into a time
+func (pi pcapInterface) timestamp(ts uint64) time.Time {
+	sec, frac := ts/pi.perSec, ts%pi.perSec
+	hi, lo := bits.Mul64(frac, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, pi.perSec)
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// PcapReader reads packets one at a time from a pcap or pcapng file, working out which it has from the
+// first few bytes
+type PcapReader struct {
+	r          *bufio.Reader
+	order      binary.ByteOrder
+	ng         bool
+	interfaces []pcapInterface
+}
+
+// isPcap reports whether data starts like a pcap or pcapng file
+func isPcap(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case pcapMagicMicro, pcapMagicNano, bits.ReverseBytes32(pcapMagicMicro), bits.ReverseBytes32(pcapMagicNano), pcapngSectionHeader:
+		return true
+	}
+	return false
+}
+
+// NewPcapReader reads the file header from r, returning ErrNotCapture if it isn't a capture at all
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	pr := &PcapReader{r: bufio.NewReader(r)}
+	magic, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, ErrNotCapture
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapngSectionHeader {
+		pr.ng = true
+		if err := pr.sectionHeader(); err != nil {
+			return nil, err
+		}
+		return pr, nil
+	}
+
+	hdr := make([]byte, pcapClassicHeaderLen)
+	if _, err := io.ReadFull(pr.r, hdr); err != nil {
+		return nil, fmt.Errorf("pcap file header: %w", err)
+	}
+	perSec := uint64(time.Second / time.Microsecond)
+	switch binary.LittleEndian.Uint32(hdr) {
+	case pcapMagicMicro:
+		pr.order = binary.LittleEndian
+	case pcapMagicNano:
+		pr.order, perSec = binary.LittleEndian, uint64(time.Second)
+	case bits.ReverseBytes32(pcapMagicMicro):
+		pr.order = binary.BigEndian
+	case bits.ReverseBytes32(pcapMagicNano):
+		pr.order, perSec = binary.BigEndian, uint64(time.Second)
+	default:
+		return nil, ErrNotCapture
+	}
+	pr.interfaces = []pcapInterface{{linkType: pr.order.Uint32(hdr[20:]), snapLen: pr.order.Uint32(hdr[16:]), perSec: perSec}}
+	return pr, nil
+}
+
+// LinkType returns the link type of a classic pcap file, or of the first interface of a pcapng file.  A
+// pcapng file may hold packets from several interfaces with different link types, so use the LinkType
+// of each packet to be sure
+func (pr *PcapReader) LinkType() uint32 {
+	if len(pr.interfaces) == 0 {
+		return 0
+	}
+	return pr.interfaces[0].linkType
+}
+
+// Next returns the next packet, or io.EOF when there are no more.  A file cut off part way through a
+// packet gives io.ErrUnexpectedEOF
+func (pr *PcapReader) Next() (CapturedPacket, error) {
+	if pr.ng {
+		return pr.nextBlock()
+	}
+	hdr := make([]byte, pcapClassicRecordLen)
+	if _, err := io.ReadFull(pr.r, hdr); err != nil {
+		return CapturedPacket{}, err
+	}
+	n, length := pr.order.Uint32(hdr[8:]), pr.order.Uint32(hdr[12:])
+	if n > pcapMaxBlock {
+		return CapturedPacket{}, fmt.Errorf("pcap record of %d bytes is too long", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		return CapturedPacket{}, io.ErrUnexpectedEOF
+	}
+	pi := pr.interfaces[0]
+	ts := uint64(pr.order.Uint32(hdr))*pi.perSec + uint64(pr.order.Uint32(hdr[4:]))
+	return CapturedPacket{Time: pi.timestamp(ts), Length: int(max(length, n)), LinkType: pi.linkType, Data: data}, nil
+}
+
+// block reads a whole pcapng block, returning its type and body.  The length is repeated at the end of
+// every block so a reader can walk backwards, we just check the two agree
+func (pr *PcapReader) block() (uint32, []byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(pr.r, hdr); err != nil {
+		return 0, nil, err
+	}
+	kind, length := pr.order.Uint32(hdr), pr.order.Uint32(hdr[4:])
+	if length < pcapngBlockOverhead || length%4 != 0 || length > pcapMaxBlock {
+		return 0, nil, fmt.Errorf("pcapng block length %d is invalid", length)
+	}
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(pr.r, body); err != nil {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if pr.order.Uint32(body[len(body)-4:]) != length {
+		return 0, nil, errors.New("pcapng block lengths don't match")
+	}
+	return kind, body[:len(body)-4], nil
+}
+
+// sectionHeader reads a section header block.  Its magic gives the byte order of the section, and each
+// section numbers its interfaces from zero again
+func (pr *PcapReader) sectionHeader() error {
+	hdr, err := pr.r.Peek(pcapngSectionFixedLen)
+	if err != nil {
+		return fmt.Errorf("pcapng section header: %w", io.ErrUnexpectedEOF)
+	}
+	switch binary.LittleEndian.Uint32(hdr[8:]) {
+	case pcapngBlockMagic:
+		pr.order = binary.LittleEndian
+	case bits.ReverseBytes32(pcapngBlockMagic):
+		pr.order = binary.BigEndian
+	default:
+		return ErrNotCapture
+	}
+	_, body, err := pr.block()
+	if err != nil {
+		return err
+	}
+	if len(body) < pcapngSectionFixedLen-8 {
+		return errors.New("pcapng section header is truncated")
+	}
+	if major := pr.order.Uint16(body[4:]); major != 1 {
+		return fmt.Errorf("pcapng version %d isn't supported", major)
+	}
+	pr.interfaces = nil
+
+	// Read the interface descriptions that follow straight away, so LinkType is known before the first
+	// packet is read
+	for {
+		kind, err := pr.r.Peek(4)
+		if err != nil || pr.order.Uint32(kind) != pcapngInterface {
+			return nil
+		}
+		_, body, err := pr.block()
+		if err != nil {
+			return err
+		}
+		if err := pr.interfaceDescription(body); err != nil {
+			return err
+		}
+	}
+}
+
+// interfaceDescription reads an interface description block, whose only option we care about is the
+// timestamp resolution.  With the top bit clear it is a negative power of ten, with it set a negative
+// power of two, and without it timestamps are in microseconds
+func (pr *PcapReader) interfaceDescription(body []byte) error {
+	if len(body) < pcapngInterfaceFixed {
+		return errors.New("pcapng interface description is truncated")
+	}
+	pi := pcapInterface{linkType: uint32(pr.order.Uint16(body)), snapLen: pr.order.Uint32(body[4:]), perSec: 1e6}
+	for opts := body[pcapngInterfaceFixed:]; len(opts) >= 4; {
+		code, n := pr.order.Uint16(opts), int(pr.order.Uint16(opts[2:]))
+		if code == pcapngOptionEnd {
+			break
+		}
+		if 4+n > len(opts) {
+			return errors.New("pcapng interface option is truncated")
+		}
+		if code == pcapngOptionTSResol && n == 1 {
+			resol := opts[4]
+			switch {
+			case resol&0x80 != 0 && resol&0x7F < 64:
+				pi.perSec = 1 << (resol & 0x7F)
+			case resol&0x80 == 0 && resol <= 19:
+				pi.perSec = uint64(math.Pow10(int(resol)))
+			default:
+				return fmt.Errorf("pcapng timestamp resolution %#x is invalid", resol)
+			}
+		}
+		opts = opts[4+(n+3)&^3:]
+	}
+	pr.interfaces = append(pr.interfaces, pi)
+	return nil
+}
+
+// nextBlock reads blocks until it finds a packet
+func (pr *PcapReader) nextBlock() (CapturedPacket, error) {
+	for {
+		if magic, err := pr.r.Peek(4); err == nil && binary.LittleEndian.Uint32(magic) == pcapngSectionHeader {
+			if err := pr.sectionHeader(); err != nil {
+				return CapturedPacket{}, err
+			}
+			continue
+		}
+		kind, body, err := pr.block()
+		if err != nil {
+			return CapturedPacket{}, err
+		}
+		switch kind {
+		case pcapngInterface:
+			if err := pr.interfaceDescription(body); err != nil {
+				return CapturedPacket{}, err
+			}
+		case pcapngEnhancedPacket:
+			if len(body) < pcapngEnhancedFixed {
+				return CapturedPacket{}, errors.New("pcapng enhanced packet is truncated")
+			}
+			id := pr.order.Uint32(body)
+			if id >= uint32(len(pr.interfaces)) {
+				return CapturedPacket{}, fmt.Errorf("pcapng packet refers to unknown interface %d", id)
+			}
+			pi := pr.interfaces[id]
+			ts := uint64(pr.order.Uint32(body[4:]))<<32 | uint64(pr.order.Uint32(body[8:]))
+			n, length := pr.order.Uint32(body[12:]), pr.order.Uint32(body[16:])
+			if uint64(n) > uint64(len(body)-pcapngEnhancedFixed) {
+				return CapturedPacket{}, errors.New("pcapng packet is longer than its block")
+			}
+			data := body[pcapngEnhancedFixed : pcapngEnhancedFixed+n]
+			return CapturedPacket{Time: pi.timestamp(ts), Length: int(max(length, n)), LinkType: pi.linkType, Data: data}, nil
+		case pcapngSimplePacket:
+			// Simple packets have no timestamp and always come from the first interface, they only give
+			// the original length, so the captured length is that cut down to the snap length
+			if len(body) < 4 || len(pr.interfaces) == 0 {
+				return CapturedPacket{}, errors.New("pcapng simple packet is invalid")
+			}
+			pi := pr.interfaces[0]
+			length := pr.order.Uint32(body)
+			n := uint64(length)
+			if pi.snapLen != 0 {
+				n = min(n, uint64(pi.snapLen))
+			}
+			if n > uint64(len(body)-4) {
+				return CapturedPacket{}, errors.New("pcapng packet is longer than its block")
+			}
+			return CapturedPacket{Length: int(length), LinkType: pi.linkType, Data: body[4 : 4+n]}, nil
+		}
+	}
+}
+
+// PcapWriter writes packets to a classic pcap file, with microsecond timestamps, or to a pcapng file,
+// with nanosecond timestamps.  Both are written little endian
+type PcapWriter struct {
+	w          io.Writer
+	ng         bool
+	linkType   uint32
+	interfaces map[uint32]uint32
+}
+
+// NewPcapWriter writes the header of a classic pcap file holding packets of the given link type
+func NewPcapWriter(w io.Writer, linkType uint32) (*PcapWriter, error) {
+	hdr := binary.LittleEndian.AppendUint32(nil, pcapMagicMicro)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 2)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 4)
+	hdr = append(hdr, make([]byte, 8)...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, pcapSnapLen)
+	hdr = binary.LittleEndian.AppendUint32(hdr, linkType)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w, linkType: linkType}, nil
+}
+
+// NewPcapNGWriter writes the section header of a pcapng file.  An interface is described the first time
+// a packet of each link type is written, so one file can hold packets of several link types
+func NewPcapNGWriter(w io.Writer) (*PcapWriter, error) {
+	body := binary.LittleEndian.AppendUint32(nil, pcapngBlockMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	// The section length isn't known as we write it as we go
+	body = binary.LittleEndian.AppendUint64(body, math.MaxUint64)
+	pw := &PcapWriter{w: w, ng: true, interfaces: map[uint32]uint32{}}
+	if err := pw.writeBlock(pcapngSectionHeader, body); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// writeBlock writes a pcapng block, padding the body to a multiple of 4 bytes
+func (pw *PcapWriter) writeBlock(kind uint32, body []byte) error {
+	pad := -len(body) & 3
+	length := uint32(pcapngBlockOverhead + len(body) + pad)
+	b := binary.LittleEndian.AppendUint32(nil, kind)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = append(b, body...)
+	b = append(b, make([]byte, pad)...)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	_, err := pw.w.Write(b)
+	return err
+}
+
+// WritePacket writes a packet.  A classic pcap file only holds packets of the link type it was created
+// with, and neither format can hold a packet from before 1970
+func (pw *PcapWriter) WritePacket(p CapturedPacket) error {
+	if p.Time.Before(time.Unix(0, 0)) {
+		return fmt.Errorf("packet time %v is before 1970", p.Time)
+	}
+	if len(p.Data) > pcapSnapLen {
+		return fmt.Errorf("packet of %d bytes is longer than the snap length", len(p.Data))
+	}
+	length := uint32(max(p.Length, len(p.Data)))
+	if !pw.ng {
+		if p.LinkType != pw.linkType {
+			return fmt.Errorf("packet link type %d doesn't match the file's %d", p.LinkType, pw.linkType)
+		}
+		if p.Time.Unix() > math.MaxUint32 {
+			return fmt.Errorf("packet time %v is too late for a pcap file", p.Time)
+		}
+		b := binary.LittleEndian.AppendUint32(nil, uint32(p.Time.Unix()))
+		b = binary.LittleEndian.AppendUint32(b, uint32(p.Time.Nanosecond()/int(time.Microsecond)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(p.Data)))
+		b = binary.LittleEndian.AppendUint32(b, length)
+		if _, err := pw.w.Write(append(b, p.Data...)); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	id, ok := pw.interfaces[p.LinkType]
+	if !ok {
+		id = uint32(len(pw.interfaces))
+		body := binary.LittleEndian.AppendUint16(nil, uint16(p.LinkType))
+		body = binary.LittleEndian.AppendUint16(body, 0)
+		body = binary.LittleEndian.AppendUint32(body, pcapSnapLen)
+		// Nanosecond timestamps, then the end of the options
+		body = append(body, pcapngOptionTSResol, 0, 1, 0, 9, 0, 0, 0)
+		body = append(body, pcapngOptionEnd, 0, 0, 0)
+		if err := pw.writeBlock(pcapngInterface, body); err != nil {
+			return err
+		}
+		pw.interfaces[p.LinkType] = id
+	}
+	ts := uint64(p.Time.UnixNano())
+	body := binary.LittleEndian.AppendUint32(nil, id)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(p.Data)))
+	body = binary.LittleEndian.AppendUint32(body, length)
+	return pw.writeBlock(pcapngEnhancedPacket, append(body, p.Data...))
+}
+
+// FilterCapture copies the packets keep returns true for from pr to pw, returning how many it read and
+// how many it wrote
+func FilterCapture(pr *PcapReader, pw *PcapWriter, keep func(CapturedPacket) bool) (int, int, error) {
+	read, written := 0, 0
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			return read, written, nil
+		}
+		if err != nil {
+			return read, written, err
+		}
+		read++
+		if !keep(p) {
+			continue
+		}
+		if err := pw.WritePacket(p); err != nil {
+			return read, written, err
+		}
+		written++
+	}
+}
+
+// packetFiveTuple returns the five tuple of an IPv4 packet for matching against ACL rules.  Fragments
+// after the first have no ports, so they match as if both ports were zero
+func packetFiveTuple(p CapturedPacket) (FiveTuple, bool) {
+	var pkt Packet
+	if pkt.Decode(p.Data, p.LinkType) != nil || pkt.NetworkType != EtherTypeIPv4 {
+		return FiveTuple{}, false
+	}
+	data := pkt.Payload
+	var ip IPv4Header
+	if ip.UnmarshalBinary(data) != nil {
+		return FiveTuple{}, false
+	}
+	f := FiveTuple{Proto: ip.Protocol, Src: ip.Src, Dst: ip.Dst}
+	if ports := data[ip.Len():]; hasPorts(int(ip.Protocol)) && ip.FragOffset == 0 && len(ports) >= 4 {
+		f.SrcPort, f.DstPort = binary.BigEndian.Uint16(ports), binary.BigEndian.Uint16(ports[2:])
+	}
+	return f, true
+}
+
+// captureCount is a count of packets and bytes, by protocol, size or address
+type captureCount struct {
+	name    string
+	packets int
+	bytes   int
+}
+
+// sizeBuckets are the upper bounds of the packet size ranges pcapstat counts, the same ranges as the
+// Ethernet interface counters most routers keep
+var sizeBuckets = []int{64, 127, 255, 511, 1023, 1518, math.MaxInt}
+
+// CaptureStats summarises a capture.  Bytes counts the bytes on the wire, which can be more than was
+// captured
+type CaptureStats struct {
+	Packets     int
+	Bytes       int
+	Captured    int
+	First, Last time.Time
+	protocols   map[string]*captureCount
+	talkers     map[string]*captureCount
+	sizes       []captureCount
+}
+
+// NewCaptureStats returns empty statistics
+func NewCaptureStats() *CaptureStats {
+	cs := &CaptureStats{protocols: map[string]*captureCount{}, talkers: map[string]*captureCount{}}
+	lo := 0
+	for _, hi := range sizeBuckets {
+		name := fmt.Sprintf("%d-%d", lo, hi)
+		if hi == math.MaxInt {
+			name = fmt.Sprintf("%d+", lo)
+		}
+		cs.sizes = append(cs.sizes, captureCount{name: name})
+		lo = hi + 1
+	}
+	return cs
+}
+
+// count adds a packet to the count called name, creating it if needed
+func count(counts map[string]*captureCount, name string, length int) {
+	c, ok := counts[name]
+	if !ok {
+		c = &captureCount{name: name}
+		counts[name] = c
+	}
+	c.packets++
+	c.bytes += length
+}
+
+// Add counts a packet by its size, its network and transport protocols and its source address
+func (cs *CaptureStats) Add(p CapturedPacket) {
+	// Simple pcapng packets have no timestamp, so they don't count towards the time covered
+	if !p.Time.IsZero() && (cs.First.IsZero() || p.Time.Before(cs.First)) {
+		cs.First = p.Time
+	}
+	if p.Time.After(cs.Last) {
+		cs.Last = p.Time
+	}
+	cs.Packets++
+	cs.Bytes += p.Length
+	cs.Captured += len(p.Data)
+	for i, hi := range sizeBuckets {
+		if p.Length <= hi {
+			cs.sizes[i].packets++
+			cs.sizes[i].bytes += p.Length
+			break
+		}
+	}
+
+	var pkt Packet
+	if err := pkt.Decode(p.Data, p.LinkType); err != nil {
+		count(cs.protocols, "undecodable", p.Length)
+		return
+	}
+	data := pkt.Payload
+	switch pkt.NetworkType {
+	case EtherTypeIPv4:
+		var h IPv4Header
+		if h.UnmarshalBinary(data) != nil {
+			count(cs.protocols, "ipv4 truncated", p.Length)
+			return
+		}
+		count(cs.protocols, "ipv4 "+protocolName(h.Protocol), p.Length)
+		count(cs.talkers, ipv4String(h.Src), p.Length)
+	case EtherTypeIPv6:
+		var h IPv6Header
+		if h.UnmarshalBinary(data) != nil {
+			count(cs.protocols, "ipv6 truncated", p.Length)
+			return
+		}
+		count(cs.protocols, "ipv6 "+protocolName(h.NextHeader), p.Length)
+		count(cs.talkers, Uint128ToAddr(h.Src, true).String(), p.Length)
+	default:
+		// Name what we can't see inside by its last layer, ARP, an 802.3 frame or an EtherType
+		switch {
+		case pkt.Has(LayerARP):
+			count(cs.protocols, "arp", p.Length)
+		case pkt.Has(LayerLLC):
+			count(cs.protocols, "llc", p.Length)
+		case pkt.Has(LayerMPLS):
+			count(cs.protocols, "mpls", p.Length)
+		case pkt.NetworkType != 0:
+			count(cs.protocols, "ethertype "+etherTypeName(pkt.NetworkType), p.Length)
+		default:
+			count(cs.protocols, "unknown", p.Length)
+		}
+	}
+}
+
+// countRows turns counts into explanation rows, with the share of the packets and bytes of each
+func (cs *CaptureStats) countRows(counts []captureCount) []ExplainRow {
+	width := 0
+	for _, c := range counts {
+		width = max(width, len(c.name))
+	}
+	var rows []ExplainRow
+	for _, c := range counts {
+		rows = append(rows, ExplainRow{
+			Label: fmt.Sprintf("%-*s", width, c.name),
+			Value: fmt.Sprintf("%d packets %.1f%%, %d bytes %.1f%%", c.packets, percent(c.packets, cs.Packets),
+				c.bytes, percent(c.bytes, cs.Bytes)),
+		})
+	}
+	return rows
+}
+
+// percent returns n as a percentage of total
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// busiest returns the counts with the most bytes first, ties broken by name, cut to at most n
+func busiest(counts map[string]*captureCount, n int) []captureCount {
+	var list []captureCount
+	for _, c := range counts {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].bytes != list[j].bytes {
+			return list[i].bytes > list[j].bytes
+		}
+		return list[i].name < list[j].name
+	})
+	return list[:max(0, min(n, len(list)))]
+}
+
+// Explain summarises the capture, with top the number of talkers to list
+func (cs *CaptureStats) Explain(top int) []Explanation {
+	var sizes []captureCount
+	for _, c := range cs.sizes {
+		if c.packets > 0 {
+			sizes = append(sizes, c)
+		}
+	}
+	summary := fmt.Sprintf("%d packets, %d bytes on the wire, %d captured", cs.Packets, cs.Bytes, cs.Captured)
+	if !cs.First.IsZero() {
+		summary += fmt.Sprintf(", over %v from %s", cs.Last.Sub(cs.First), cs.First.UTC().Format(time.RFC3339Nano))
+	}
+	return []Explanation{
+		{Title: summary},
+		{Title: "Protocols", Rows: cs.countRows(busiest(cs.protocols, len(cs.protocols)))},
+		{Title: "Packet sizes", Rows: cs.countRows(sizes)},
+		{Title: fmt.Sprintf("Top %d talkers by bytes sent", top), Rows: cs.countRows(busiest(cs.talkers, top))},
+	}
+}
+
+// openCapture opens a capture file for reading
+func openCapture(name string) (*os.File, *PcapReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr, err := NewPcapReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, pr, nil
+}
+
+// runPcapStat is the pcapstat sub command, it summarises the protocols, packet sizes and top talkers
+// of each capture file given
+func runPcapStat(args []string) error {
+	fs := flag.NewFlagSet("pcapstat", flag.ContinueOnError)
+	top := fs.Int("top", 10, "Number of top talkers to list")
+	format := fs.String("format", "text", "Output format: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *top < 0 {
+		return fmt.Errorf("-top must not be negative")
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: pcapstat [-top n] [-format text|markdown|html] <file> [<file> ...]")
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, name := range fs.Args() {
+		f, pr, err := openCapture(name)
+		if err != nil {
+			return err
+		}
+		cs := NewCaptureStats()
+		for {
+			p, err := pr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("%s: packet %d: %v", name, cs.Packets+1, err)
+			}
+			cs.Add(p)
+		}
+		f.Close()
+		if err := ex.Note(name); err != nil {
+			return err
+		}
+		for _, e := range cs.Explain(*top) {
+			if err := ex.Explain(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runPcapFilter is the pcapfilter sub command, it copies the packets of a capture that match an ACL
+// rule to a new capture.  Only IPv4 packets can match, since ACL rules only describe IPv4
+func runPcapFilter(args []string) error {
+	fs := flag.NewFlagSet("pcapfilter", flag.ContinueOnError)
+	match := fs.String("match", "ip any any", `ACL rule without the action, such as "tcp any any eq 443"`)
+	limit := fs.Int("count", 0, "Stop after writing this many packets, 0 for no limit")
+	ng := fs.Bool("ng", false, "Write pcapng instead of pcap")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: pcapfilter [-match rule] [-count n] [-ng] <in> <out>")
+	}
+	rule, err := ParseACLRule("permit " + *match)
+	if err != nil {
+		return err
+	}
+	in, pr, err := openCapture(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	var pw *PcapWriter
+	if *ng {
+		pw, err = NewPcapNGWriter(bw)
+	} else {
+		pw, err = NewPcapWriter(bw, pr.LinkType())
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	kept := 0
+	read, written, err := FilterCapture(pr, pw, func(p CapturedPacket) bool {
+		f, ok := packetFiveTuple(p)
+		if !ok || !rule.Matches(f) || (*limit > 0 && kept >= *limit) {
+			return false
+		}
+		kept++
+		return true
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d of %d packets matching %q to %s\n", written, read, *match, fs.Arg(1))
+	return nil
+}
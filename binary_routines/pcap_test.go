@@ -0,0 +1,263 @@
+// Packet capture test routines
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pcapFile builds a classic pcap file holding frames in the given byte order, one second apart with
+// timestamps in the units the magic gives
+func pcapFile(order binary.AppendByteOrder, magic, linkType uint32, frames ...[]byte) []byte {
+	b := order.AppendUint32(nil, magic)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = order.AppendUint32(b, 65535)
+	b = order.AppendUint32(b, linkType)
+	for i, f := range frames {
+		b = order.AppendUint32(b, uint32(i))
+		b = order.AppendUint32(b, 500)
+		b = order.AppendUint32(b, uint32(len(f)))
+		b = order.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+// ngBlock builds a pcapng block, padding the body
+func ngBlock(order binary.AppendByteOrder, kind uint32, body []byte) []byte {
+	body = append(body, make([]byte, -len(body)&3)...)
+	b := order.AppendUint32(nil, kind)
+	b = order.AppendUint32(b, uint32(12+len(body)))
+	b = append(b, body...)
+	return order.AppendUint32(b, uint32(12+len(body)))
+}
+
+// readAll reads every packet of a capture
+func readAll(t *testing.T, data []byte) []CapturedPacket {
+	t.Helper()
+	pr, err := NewPcapReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []CapturedPacket
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, p)
+	}
+}
+
+// samplePackets are an Ethernet SYN, the same packet as raw IP cut short, and an IPv6 UDP packet
+func samplePackets(t *testing.T) []CapturedPacket {
+	syn := mustHex(t, synPacket)
+	ether := append(mustHex(t, "00005e00530100005e0053020800"), syn...)
+	ip := &IPv6Header{NextHeader: ProtoUDP, HopLimit: 1, PayloadLength: 8}
+	udp, _ := (&UDPHeader{SrcPort: 1, DstPort: 2, Length: 8}).MarshalBinary()
+	ip6, _ := ip.MarshalBinary()
+	start := time.Date(2024, 2, 29, 12, 0, 0, 123456789, time.UTC)
+	return []CapturedPacket{
+		{Time: start, Length: len(ether), LinkType: LinkTypeEthernet, Data: ether},
+		{Time: start.Add(time.Millisecond), Length: 1500, LinkType: LinkTypeRaw, Data: syn[:20]},
+		{Time: start.Add(time.Second), Length: 48, LinkType: LinkTypeRaw, Data: append(ip6, udp...)},
+	}
+}
+
+func TestPcapRoundTrip(t *testing.T) {
+	packets := samplePackets(t)
+
+	// pcapng holds every link type, with nanosecond timestamps
+	var buf bytes.Buffer
+	pw, err := NewPcapNGWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		if err := pw.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !isPcap(buf.Bytes()) {
+		t.Errorf("pcapng not recognised")
+	}
+	if pr, err := NewPcapReader(bytes.NewReader(buf.Bytes())); err != nil || pr.LinkType() != LinkTypeEthernet {
+		t.Errorf("pcapng link type should be known before the first packet, %v", err)
+	}
+	got := readAll(t, buf.Bytes())
+	if len(got) != len(packets) {
+		t.Fatalf("read %d packets instead of %d", len(got), len(packets))
+	}
+	for i, p := range got {
+		want := packets[i]
+		if !p.Time.Equal(want.Time) || p.Length != want.Length || p.LinkType != want.LinkType || !bytes.Equal(p.Data, want.Data) {
+			t.Errorf("pcapng packet %d read as %+v instead of %+v", i, p, want)
+		}
+	}
+
+	// A classic file holds a single link type, with microsecond timestamps
+	buf.Reset()
+	if pw, err = NewPcapWriter(&buf, LinkTypeRaw); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.WritePacket(packets[0]); err == nil {
+		t.Errorf("an Ethernet packet shouldn't fit in a raw IP file")
+	}
+	for _, p := range packets[1:] {
+		if err := pw.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got = readAll(t, buf.Bytes())
+	for i, p := range got {
+		want := packets[i+1]
+		if !p.Time.Equal(want.Time.Truncate(time.Microsecond)) || p.Length != want.Length || !bytes.Equal(p.Data, want.Data) {
+			t.Errorf("pcap packet %d read as %+v instead of %+v", i, p, want)
+		}
+	}
+	if err := pw.WritePacket(CapturedPacket{Time: time.Unix(-1, 0), LinkType: LinkTypeRaw}); err == nil {
+		t.Errorf("a packet from before 1970 should be rejected")
+	}
+}
+
+func TestPcapByteOrders(t *testing.T) {
+	frame := mustHex(t, synPacket)
+	tests := []struct {
+		order binary.AppendByteOrder
+		magic uint32
+		nsec  int
+	}{
+		{binary.LittleEndian, pcapMagicMicro, 500000},
+		{binary.BigEndian, pcapMagicMicro, 500000},
+		{binary.BigEndian, pcapMagicNano, 500},
+	}
+	for _, tt := range tests {
+		got := readAll(t, pcapFile(tt.order, tt.magic, LinkTypeRaw, frame, frame))
+		if len(got) != 2 || got[1].Time != time.Unix(1, int64(tt.nsec)) || !bytes.Equal(got[1].Data, frame) {
+			t.Errorf("%v %x: read %+v", tt.order, tt.magic, got)
+		}
+	}
+
+	// A big endian pcapng file with timestamps in 64ths of a second, and a simple packet cut to the
+	// snap length
+	order := binary.BigEndian
+	shb := order.AppendUint32(nil, pcapngBlockMagic)
+	shb = append(shb, 0, 1, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	idb := append(order.AppendUint16(nil, LinkTypeRaw), 0, 0, 0, 0, 0, 24, 0, pcapngOptionTSResol, 0, 1, 0x86, 0, 0, 0, 0, 0, 0, 0)
+	epb := order.AppendUint32(nil, 0)
+	epb = order.AppendUint32(epb, 0)
+	epb = order.AppendUint32(epb, 64*10+32)
+	epb = order.AppendUint32(epb, 20)
+	epb = order.AppendUint32(epb, 60)
+	epb = append(epb, frame[:20]...)
+	spb := append(order.AppendUint32(nil, 60), frame[:24]...)
+	var data []byte
+	data = append(data, ngBlock(order, pcapngSectionHeader, shb)...)
+	data = append(data, ngBlock(order, pcapngInterface, idb)...)
+	data = append(data, ngBlock(order, 0x0BAD, []byte("a block we skip"))...)
+	data = append(data, ngBlock(order, pcapngEnhancedPacket, epb)...)
+	data = append(data, ngBlock(order, pcapngSimplePacket, spb)...)
+	got := readAll(t, data)
+	if len(got) != 2 || got[0].Time != time.Unix(10, int64(time.Second/2)) || got[0].Length != 60 ||
+		got[1].Length != 60 || !bytes.Equal(got[1].Data, frame[:24]) {
+		t.Errorf("big endian pcapng read as %+v", got)
+	}
+}
+
+func TestPcapErrors(t *testing.T) {
+	if _, err := NewPcapReader(strings.NewReader(synPacket)); !errors.Is(err, ErrNotCapture) {
+		t.Errorf("hex gave %v", err)
+	}
+	if isPcap([]byte(synPacket)) {
+		t.Errorf("hex shouldn't be recognised as pcap")
+	}
+
+	data := pcapFile(binary.LittleEndian, pcapMagicMicro, LinkTypeRaw, mustHex(t, synPacket))
+	pr, err := NewPcapReader(bytes.NewReader(data[:len(data)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pr.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("a truncated packet gave %v", err)
+	}
+
+	var buf bytes.Buffer
+	pw, _ := NewPcapNGWriter(&buf)
+	_ = pw.WritePacket(samplePackets(t)[1])
+	data = buf.Bytes()
+	data[len(data)-1] ^= 1
+	pr, err = NewPcapReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pr.Next(); err == nil {
+		t.Errorf("mismatched block lengths should be rejected")
+	}
+}
+
+func TestFilterAndStats(t *testing.T) {
+	var buf bytes.Buffer
+	pw, _ := NewPcapNGWriter(&buf)
+	for _, p := range samplePackets(t) {
+		_ = pw.WritePacket(p)
+	}
+	pr, err := NewPcapReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := ParseACLRule("permit tcp any any eq 443")
+	var out bytes.Buffer
+	ow, _ := NewPcapWriter(&out, LinkTypeEthernet)
+	read, written, err := FilterCapture(pr, ow, func(p CapturedPacket) bool {
+		f, ok := packetFiveTuple(p)
+		return ok && rule.Matches(f)
+	})
+	// The cut short copy of the SYN has no ports, so only the Ethernet one matches
+	if err != nil || read != 3 || written != 1 {
+		t.Errorf("filter read %d and wrote %d, %v", read, written, err)
+	}
+
+	cs := NewCaptureStats()
+	for _, p := range append(samplePackets(t), CapturedPacket{Length: 60, LinkType: LinkTypeEthernet,
+		Data: mustHex(t, arpRequest)}) {
+		cs.Add(p)
+	}
+	var sb strings.Builder
+	te := &TextExplainer{W: &sb}
+	for _, e := range cs.Explain(1) {
+		_ = te.Explain(e)
+	}
+	want := `4 packets, 1682 bytes on the wire, 184 captured, over 1s from 2024-02-29T12:00:00.123456789Z
+Protocols
+ipv4 tcp [2 packets 50.0%, 1574 bytes 93.6%]
+arp      [1 packets 25.0%, 60 bytes 3.6%]
+ipv6 udp [1 packets 25.0%, 48 bytes 2.9%]
+Packet sizes
+0-64      [2 packets 50.0%, 108 bytes 6.4%]
+65-127    [1 packets 25.0%, 74 bytes 4.4%]
+1024-1518 [1 packets 25.0%, 1500 bytes 89.2%]
+Top 1 talkers by bytes sent
+192.0.2.1 [2 packets 50.0%, 1574 bytes 93.6%]
+`
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+
+	// A negative count lists no talkers rather than panicking, and the command rejects it
+	if e := cs.Explain(-1); len(e[len(e)-1].Rows) != 0 {
+		t.Errorf("a negative count listed %+v", e[len(e)-1].Rows)
+	}
+	if err := runPcapStat([]string{"-top", "-1", "capture.pcap"}); err == nil {
+		t.Errorf("a negative -top should be rejected")
+	}
+}
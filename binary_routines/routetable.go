@@ -0,0 +1,333 @@
+// Longest prefix match routing table built as a binary radix (Patricia) trie
+// Andrew Alston
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+)
+
+// routeNode is a node in the trie.  Each node holds a prefix, and its children hold longer prefixes
+// within it, with the bit straight after the node's prefix choosing which child.  Runs of nodes with
+// only one child are squashed into the one node, which is what makes it a Patricia trie rather than a
+// plain binary trie, so a lookup visits at most one node per distinct prefix length on its path rather
+// than one node per bit.  Nodes without a value are glue, holding together two branches that split at
+// that point
+type routeNode[V any] struct {
+	prefix   Prefix
+	value    V
+	hasValue bool
+	child    [2]*routeNode[V]
+}
+
+// routeTrie holds the read only operations shared by RouteTable and RouteSnapshot.  IPv4 and IPv6
+// prefixes live in separate tries, since 10.0.0.0/8 and ::a00:0/8 have the same bits
+type routeTrie[V any] struct {
+	roots [2]*routeNode[V]
+	size  int
+}
+
+// RouteTable maps IPv4 and IPv6 prefixes to values of type V, and finds the longest prefix containing
+// an address the way a router picks a route.  A RouteTable is not safe for concurrent use, take a
+// Snapshot to share it between goroutines
+type RouteTable[V any] struct {
+	routeTrie[V]
+}
+
+// RouteSnapshot is a read only copy of a RouteTable.  Since nothing can change it, any number of
+// goroutines can look up routes in it at the same time without locking
+type RouteSnapshot[V any] struct {
+	routeTrie[V]
+}
+
+// family returns the index of the trie holding prefixes of the given family
+func family(ipv6 bool) int {
+	if ipv6 {
+		return 1
+	}
+	return 0
+}
+
+// addrBit returns bit i of an address of the given width, counting from the left the way prefix lengths
+// count.  The bits of a Uint128 are held in two halves, so we find the half holding the bit and use
+// TestBit to check it
+func addrBit(addr Uint128, i, width int) int {
+	pos, half := width-1-i, addr.Lo
+	if pos >= 64 {
+		pos, half = pos-64, addr.Hi
+	}
+	if set, _ := TestBit(half, uint8(pos), LSB0); set {
+		return 1
+	}
+	return 0
+}
+
+// commonBits returns the length of the longest prefix shared by a and b, which is the number of leading
+// zeros in the XOR of their addresses, limited to the shorter of the two prefix lengths.  IPv4 addresses
+// sit at the bottom of the Uint128, so the 96 zeros above them don't count
+func commonBits(a, b Prefix) int {
+	lz := a.Addr.Xor(b.Addr).LeadingZeros() - (128 - a.Width())
+	return min(lz, a.Bits, b.Bits)
+}
+
+// Len returns the number of prefixes in the table
+func (t *routeTrie[V]) Len() int {
+	return t.size
+}
+
+// Lookup returns the value stored for exactly the prefix p
+func (t *routeTrie[V]) Lookup(p Prefix) (V, bool) {
+	p.Addr = p.Network()
+	n := t.roots[family(p.IPv6)]
+	for n != nil && commonBits(n.prefix, p) == n.prefix.Bits {
+		if n.prefix.Bits == p.Bits {
+			return n.value, n.hasValue
+		}
+		n = n.child[addrBit(p.Addr, n.prefix.Bits, p.Width())]
+	}
+	var zero V
+	return zero, false
+}
+
+// LookupLPM returns the longest prefix in the table containing addr, along with its value.  We walk down
+// the trie while each node's prefix contains the address, remembering the last node that held a value
+func (t *routeTrie[V]) LookupLPM(addr Uint128, ipv6 bool) (Prefix, V, bool) {
+	host := Prefix{Addr: addr, IPv6: ipv6}
+	host.Bits = host.Width()
+	var best *routeNode[V]
+	n := t.roots[family(ipv6)]
+	for n != nil && commonBits(n.prefix, host) == n.prefix.Bits {
+		if n.hasValue {
+			best = n
+		}
+		if n.prefix.Bits == host.Bits {
+			break
+		}
+		n = n.child[addrBit(addr, n.prefix.Bits, host.Bits)]
+	}
+	if best == nil {
+		var zero V
+		return Prefix{}, zero, false
+	}
+	return best.prefix, best.value, true
+}
+
+// walkNode visits n and then its children, returning false if fn asked to stop
+func walkNode[V any](n *routeNode[V], fn func(Prefix, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.hasValue && !fn(n.prefix, n.value) {
+		return false
+	}
+	return walkNode(n.child[0], fn) && walkNode(n.child[1], fn)
+}
+
+// Walk calls fn for each prefix in the table, IPv4 first, in address order with shorter prefixes before
+// the longer prefixes they contain.  The walk stops early if fn returns false
+func (t *routeTrie[V]) Walk(fn func(Prefix, V) bool) {
+	_ = walkNode(t.roots[0], fn) && walkNode(t.roots[1], fn)
+}
+
+// Insert adds p to the table with the given value, replacing the value if p is already there.  The host
+// bits of p are ignored.  It returns true if p was already in the table
+func (t *RouteTable[V]) Insert(p Prefix, value V) bool {
+	p.Addr = p.Network()
+	width := p.Width()
+	link := &t.roots[family(p.IPv6)]
+	for {
+		n := *link
+		if n == nil {
+			*link = &routeNode[V]{prefix: p, value: value, hasValue: true}
+			t.size++
+			return false
+		}
+		common := commonBits(n.prefix, p)
+		switch {
+		case common == n.prefix.Bits && common == p.Bits:
+			// The prefix is already a node, perhaps glue, so we just set its value
+			replaced := n.hasValue
+			if !replaced {
+				t.size++
+			}
+			n.value, n.hasValue = value, true
+			return replaced
+		case common == n.prefix.Bits:
+			// The node contains p, so p belongs further down
+			link = &n.child[addrBit(p.Addr, common, width)]
+			continue
+		}
+		leaf := &routeNode[V]{prefix: p, value: value, hasValue: true}
+		if common == p.Bits {
+			// p contains the node, so p goes in its place with the node below it
+			leaf.child[addrBit(n.prefix.Addr, common, width)] = n
+			*link = leaf
+		} else {
+			// p and the node part ways after common bits, so they hang off a new glue node there
+			glue := &routeNode[V]{prefix: Prefix{Addr: p.Addr.And(PrefixMask(common, width)), Bits: common, IPv6: p.IPv6}}
+			glue.child[addrBit(n.prefix.Addr, common, width)] = n
+			glue.child[addrBit(p.Addr, common, width)] = leaf
+			*link = glue
+		}
+		t.size++
+		return false
+	}
+}
+
+// Delete removes p from the table, returning true if it was there.  A node left without a value is
+// only kept if it still joins two branches, otherwise it is replaced by its one child or removed, and
+// if removing it leaves its parent as glue with one child the parent goes too
+func (t *RouteTable[V]) Delete(p Prefix) bool {
+	p.Addr = p.Network()
+	width := p.Width()
+	link := &t.roots[family(p.IPv6)]
+	var parentLink **routeNode[V]
+	for n := *link; n != nil && commonBits(n.prefix, p) == n.prefix.Bits; n = *link {
+		if n.prefix.Bits < p.Bits {
+			parentLink, link = link, &n.child[addrBit(p.Addr, n.prefix.Bits, width)]
+			continue
+		}
+		if !n.hasValue {
+			return false
+		}
+		var zero V
+		n.value, n.hasValue = zero, false
+		t.size--
+		switch {
+		case n.child[0] != nil && n.child[1] != nil:
+			return true
+		case n.child[0] != nil:
+			*link = n.child[0]
+		case n.child[1] != nil:
+			*link = n.child[1]
+		default:
+			*link = nil
+			if parentLink != nil {
+				if parent := *parentLink; !parent.hasValue {
+					*parentLink = parent.child[0]
+					if parent.child[0] == nil {
+						*parentLink = parent.child[1]
+					}
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// copyNode returns a deep copy of n and everything below it
+func copyNode[V any](n *routeNode[V]) *routeNode[V] {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	c.child[0], c.child[1] = copyNode(n.child[0]), copyNode(n.child[1])
+	return &c
+}
+
+// Snapshot returns a read only copy of the table which is safe to share between goroutines, and isn't
+// affected by later changes to the table.  The copy takes time and memory in proportion to the size of
+// the table, so take a snapshot once after loading a table rather than for each lookup
+func (t *RouteTable[V]) Snapshot() *RouteSnapshot[V] {
+	return &RouteSnapshot[V]{routeTrie[V]{
+		roots: [2]*routeNode[V]{copyNode(t.roots[0]), copyNode(t.roots[1])},
+		size:  t.size,
+	}}
+}
+
+// GenerateRouteTable returns n random IPv4 prefixes with lengths spread roughly the way they are in the
+// global routing table, where about 60% of prefixes are /24s and most of the rest are /16 to /23.  The
+// same seed always gives the same table, so benchmarks are repeatable
+func GenerateRouteTable(n int, seed uint64) []Prefix {
+	lengths := []struct {
+		bits    int
+		percent int
+	}{{24, 60}, {23, 10}, {22, 11}, {21, 5}, {20, 4}, {19, 3}, {18, 2}, {17, 1}, {16, 3}, {12, 1}}
+	r := rand.New(rand.NewPCG(seed, seed))
+	res := make([]Prefix, 0, n)
+	for len(res) < n {
+		pick, bits := r.IntN(100), 8
+		for _, l := range lengths {
+			if pick < l.percent {
+				bits = l.bits
+				break
+			}
+			pick -= l.percent
+		}
+		// Keep to 1.0.0.0 - 223.255.255.255, the unicast space routed on the internet
+		addr := Uint128{Lo: uint64(1<<24 + r.IntN(222<<24))}
+		res = append(res, Prefix{Addr: addr.And(PrefixMask(bits, 32)), Bits: bits})
+	}
+	return res
+}
+
+// ReadRouteTable reads a routing table with one route per line, a prefix followed optionally by a value
+// such as a next hop.  Blank lines and anything after a # are ignored
+func ReadRouteTable(r io.Reader) (*RouteTable[string], error) {
+	t := &RouteTable[string]{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		p, err := ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		t.Insert(p, strings.Join(fields[1:], " "))
+	}
+	return t, scanner.Err()
+}
+
+// runLPM is the lpm sub command, it loads a routing table from a file, or generates a random one, and
+// prints the longest matching route for each address given
+func runLPM(args []string) error {
+	fs := flag.NewFlagSet("lpm", flag.ContinueOnError)
+	file := fs.String("table", "", "File holding the routing table, one prefix and optional value per line")
+	generate := fs.Int("generate", 0, "Generate a random table of this many IPv4 prefixes instead of reading one")
+	seed := fs.Uint64("seed", 1, "Seed for the generated table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || (*file == "") == (*generate == 0) {
+		return fmt.Errorf("usage: lpm -table <file> | -generate <count> <address> [<address> ...]")
+	}
+	var table *RouteTable[string]
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if table, err = ReadRouteTable(f); err != nil {
+			return err
+		}
+	} else {
+		table = &RouteTable[string]{}
+		for _, p := range GenerateRouteTable(*generate, *seed) {
+			table.Insert(p, "")
+		}
+	}
+	fmt.Printf("%d routes loaded\n", table.Len())
+	for _, arg := range fs.Args() {
+		addr, err := ParsePrefix(arg)
+		if err != nil {
+			return err
+		}
+		if p, value, ok := table.LookupLPM(addr.Addr, addr.IPv6); ok {
+			fmt.Println(strings.TrimSpace(fmt.Sprintf("%s -> %s %s", addr.FormatAddr(addr.Addr), p, value)))
+		} else {
+			fmt.Printf("%s -> no route\n", addr.FormatAddr(addr.Addr))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+// Routing table test routines
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// linearLPM finds the longest prefix containing addr by checking every prefix, which is slow but
+// obviously right, so we use it to check the trie and as the baseline in the benchmarks
+func linearLPM(prefixes []Prefix, addr Uint128, ipv6 bool) (Prefix, bool) {
+	var best Prefix
+	found := false
+	for _, p := range prefixes {
+		if p.IPv6 == ipv6 && p.Contains(addr) && (!found || p.Bits > best.Bits) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// randomPrefixes returns n random prefixes of the given family, with addresses close enough together
+// that plenty of them nest inside each other
+func randomPrefixes(r *rand.Rand, n int, ipv6 bool) []Prefix {
+	var res []Prefix
+	for i := 0; i < n; i++ {
+		p := Prefix{IPv6: ipv6}
+		if ipv6 {
+			p.Addr = Uint128{Hi: 0x20010db800000000 | r.Uint64()&0xFF000000, Lo: r.Uint64() & 0xFF}
+			p.Bits = 24 + r.IntN(105)
+		} else {
+			p.Addr = Uint128{Lo: 0x0A000000 | r.Uint64()&0xFFFF}
+			p.Bits = 8 + r.IntN(25)
+		}
+		p.Addr = p.Network()
+		res = append(res, p)
+	}
+	return res
+}
+
+// randomAddr returns an address of the given family in the same space as randomPrefixes
+func randomAddr(r *rand.Rand, ipv6 bool) Uint128 {
+	if ipv6 {
+		return Uint128{Hi: 0x20010db800000000 | r.Uint64()&0xFF000000, Lo: r.Uint64() & 0x1FF}
+	}
+	return Uint128{Lo: 0x0A000000 | r.Uint64()&0x1FFFF}
+}
+
+// checkTable compares every lookup in the table against a linear scan of the prefixes
+func checkTable(t *testing.T, r *rand.Rand, table *RouteTable[int], prefixes []Prefix) {
+	t.Helper()
+	if table.Len() != len(prefixes) {
+		t.Fatalf("table holds %d prefixes instead of %d", table.Len(), len(prefixes))
+	}
+	for _, p := range prefixes {
+		if _, ok := table.Lookup(p); !ok {
+			t.Fatalf("%s is missing", p)
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		ipv6 := i%2 == 1
+		addr := randomAddr(r, ipv6)
+		want, wantOK := linearLPM(prefixes, addr, ipv6)
+		got, value, ok := table.LookupLPM(addr, ipv6)
+		if ok != wantOK || got != want {
+			t.Fatalf("lookup of %v found %s %v instead of %s %v", addr, got, ok, want, wantOK)
+		}
+		if ok && value != want.Bits {
+			t.Fatalf("lookup of %v found %s with the wrong value %d", addr, got, value)
+		}
+	}
+}
+
+func TestRouteTableRandom(t *testing.T) {
+	r := rand.New(rand.NewPCG(39, 1))
+	table := &RouteTable[int]{}
+	var prefixes []Prefix
+	for _, p := range append(randomPrefixes(r, 500, false), randomPrefixes(r, 500, true)...) {
+		if !table.Insert(p, p.Bits) {
+			prefixes = append(prefixes, p)
+		}
+	}
+	checkTable(t, r, table, prefixes)
+
+	// Delete prefixes in a random order, checking the table as we go, until it is empty
+	r.Shuffle(len(prefixes), func(i, j int) { prefixes[i], prefixes[j] = prefixes[j], prefixes[i] })
+	for len(prefixes) > 0 {
+		n := min(len(prefixes), 100)
+		for _, p := range prefixes[:n] {
+			if !table.Delete(p) {
+				t.Fatalf("deleting %s failed", p)
+			}
+			if table.Delete(p) {
+				t.Fatalf("deleting %s twice succeeded", p)
+			}
+		}
+		prefixes = prefixes[n:]
+		checkTable(t, r, table, prefixes)
+	}
+	if table.roots[0] != nil || table.roots[1] != nil {
+		t.Errorf("empty table still has nodes")
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	table := &RouteTable[string]{}
+	for _, route := range []string{"0.0.0.0/0 default", "10.0.0.0/8 a", "10.1.0.0/16 b", "10.1.2.0/24 c",
+		"10.1.3.0/24 d", "::/0 v6default", "2001:db8::/32 e", "::a00:0/104 f"} {
+		fields := strings.Fields(route)
+		table.Insert(mustPrefixes(t, fields[0])[0], fields[1])
+	}
+	if table.Insert(mustPrefixes(t, "10.1.2.99/24")[0], "c2") != true {
+		t.Errorf("replacing 10.1.2.0/24 should report it was already there")
+	}
+	tests := []struct {
+		addr  string
+		route string
+	}{
+		{"10.1.2.3", "10.1.2.0/24 c2"},
+		{"10.1.3.3", "10.1.3.0/24 d"},
+		{"10.1.4.3", "10.1.0.0/16 b"},
+		{"10.2.0.0", "10.0.0.0/8 a"},
+		{"11.0.0.0", "0.0.0.0/0 default"},
+		{"2001:db8::1", "2001:db8::/32 e"},
+		{"::10.1.2.3", "::a00:0/104 f"},
+		{"2001:db9::1", "::/0 v6default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			addr := mustPrefixes(t, tt.addr)[0]
+			p, value, ok := table.LookupLPM(addr.Addr, addr.IPv6)
+			if got := p.String() + " " + value; !ok || got != tt.route {
+				t.Errorf("got %s instead of %s", got, tt.route)
+			}
+		})
+	}
+
+	// Removing the default routes leaves addresses outside the other prefixes without a route
+	table.Delete(mustPrefixes(t, "0.0.0.0/0")[0])
+	table.Delete(mustPrefixes(t, "::/0")[0])
+	if _, _, ok := table.LookupLPM(Uint128{Lo: 0x0B000000}, false); ok {
+		t.Errorf("11.0.0.0 still has a route")
+	}
+	if table.Delete(mustPrefixes(t, "10.1.0.0/23")[0]) {
+		t.Errorf("deleted a prefix that was never added")
+	}
+}
+
+func TestRouteTableWalk(t *testing.T) {
+	table := &RouteTable[int]{}
+	in := mustPrefixes(t, "2001:db8::/32", "10.1.0.0/16", "10.0.0.0/8", "192.168.0.0/16", "10.1.0.0/24", "::/0", "10.0.0.0/9")
+	for i, p := range in {
+		table.Insert(p, i)
+	}
+	var got []string
+	table.Walk(func(p Prefix, _ int) bool {
+		got = append(got, p.String())
+		return true
+	})
+	want := []string{"10.0.0.0/8", "10.0.0.0/9", "10.1.0.0/16", "10.1.0.0/24", "192.168.0.0/16", "::/0", "2001:db8::/32"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v instead of %v", got, want)
+	}
+	count := 0
+	table.Walk(func(Prefix, int) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Errorf("walk visited %d prefixes after being told to stop at 3", count)
+	}
+}
+
+func TestRouteSnapshot(t *testing.T) {
+	r := rand.New(rand.NewPCG(39, 2))
+	table := &RouteTable[int]{}
+	prefixes := randomPrefixes(r, 2000, false)
+	for _, p := range prefixes {
+		table.Insert(p, p.Bits)
+	}
+	snap := table.Snapshot()
+	type result struct {
+		p  Prefix
+		ok bool
+	}
+	addrs := make([]Uint128, 1000)
+	want := make([]result, len(addrs))
+	for i := range addrs {
+		addrs[i] = randomAddr(r, false)
+		want[i].p, want[i].ok = linearLPM(prefixes, addrs[i], false)
+	}
+
+	// Change the table while several goroutines read the snapshot, none of them should see the changes
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, addr := range addrs {
+				if p, _, ok := snap.LookupLPM(addr, false); p != want[i].p || ok != want[i].ok {
+					errs <- p.String()
+					return
+				}
+			}
+		}()
+	}
+	for _, p := range prefixes {
+		table.Delete(p)
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Errorf("snapshot lookup returned %s", e)
+	}
+	if snap.Len() == 0 || table.Len() != 0 {
+		t.Errorf("snapshot holds %d and table holds %d", snap.Len(), table.Len())
+	}
+}
+
+func TestGenerateRouteTable(t *testing.T) {
+	a, b := GenerateRouteTable(1000, 7), GenerateRouteTable(1000, 7)
+	if !slices.Equal(a, b) {
+		t.Errorf("the same seed gave different tables")
+	}
+	slash24 := 0
+	for _, p := range a {
+		if p.Addr != p.Network() || p.IPv6 {
+			t.Fatalf("generated an invalid prefix %s", p)
+		}
+		if p.Bits == 24 {
+			slash24++
+		}
+	}
+	if slash24 < 500 || slash24 > 700 {
+		t.Errorf("expected around 600 /24s, got %d", slash24)
+	}
+}
+
+func TestReadRouteTable(t *testing.T) {
+	table, err := ReadRouteTable(strings.NewReader("10.0.0.0/8 via 192.0.2.1 # core\n\n2001:db8::/32\n"))
+	if err != nil || table.Len() != 2 {
+		t.Fatalf("got %d routes, %v", table.Len(), err)
+	}
+	if v, _ := table.Lookup(mustPrefixes(t, "10.0.0.0/8")[0]); v != "via 192.0.2.1" {
+		t.Errorf("got value %q", v)
+	}
+	if _, err := ReadRouteTable(strings.NewReader("bogus\n")); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// benchTable is the 900k prefix table shared by the benchmarks, built the first time one needs it
+var benchTable = sync.OnceValues(func() ([]Prefix, *RouteTable[int]) {
+	prefixes := GenerateRouteTable(900000, 1)
+	table := &RouteTable[int]{}
+	for i, p := range prefixes {
+		table.Insert(p, i)
+	}
+	return prefixes, table
+})
+
+// benchAddrs returns random addresses to look up
+func benchAddrs() []Uint128 {
+	r := rand.New(rand.NewPCG(5, 6))
+	addrs := make([]Uint128, 1024)
+	for i := range addrs {
+		addrs[i] = Uint128{Lo: uint64(r.Uint32())}
+	}
+	return addrs
+}
+
+func BenchmarkLookupLPM(b *testing.B) {
+	_, table := benchTable()
+	addrs := benchAddrs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.LookupLPM(addrs[i%len(addrs)], false)
+	}
+}
+
+func BenchmarkLookupLPMSnapshotParallel(b *testing.B) {
+	_, table := benchTable()
+	snap := table.Snapshot()
+	addrs := benchAddrs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			snap.LookupLPM(addrs[i%len(addrs)], false)
+		}
+	})
+}
+
+func BenchmarkLinearScan(b *testing.B) {
+	prefixes, _ := benchTable()
+	addrs := benchAddrs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearLPM(prefixes, addrs[i%len(addrs)], false)
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	prefixes, _ := benchTable()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table := &RouteTable[int]{}
+		for j, p := range prefixes {
+			table.Insert(p, j)
+		}
+	}
+}
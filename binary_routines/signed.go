@@ -0,0 +1,372 @@
+// Signed integers, two's complement, sign extension and the flags a CPU's ALU sets as it does arithmetic
+// Andrew Alston
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+)
+
+// Signed is a type constraint that matches the signed integer types
+type Signed interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+// IsSigned returns true if T is a signed type.  Inverting zero gives all ones, which is -1 when the top
+// bit is a sign bit and the largest value of the type when it isn't
+func IsSigned[T Integer]() bool {
+	var zero T
+	return ^zero < 0
+}
+
+// MaxValue returns the largest value of T.  For a signed type that is every bit but the sign bit set,
+// and for an unsigned type every bit set
+func MaxValue[T Integer]() T {
+	v := ^T(0)
+	if IsSigned[T]() {
+		return T(unsignedBits(v) >> 1)
+	}
+	return v
+}
+
+// MinValue returns the smallest value of T.  For a signed type that is only the sign bit set, which has
+// no positive counterpart, and for an unsigned type zero
+func MinValue[T Integer]() T {
+	return ^MaxValue[T]()
+}
+
+// signExtend returns the bottom width bits of v as a signed number.  Shifting left puts bit width-1 at
+// the top of an int64, and the arithmetic shift back down copies it in to every bit above
+func signExtend(v uint64, width int) int64 {
+	shift := 64 - width
+	return int64(v<<shift) >> shift
+}
+
+// Negate returns -v the way the hardware works it out in two's complement, by inverting every bit and
+// adding one.  Negating the smallest value overflows back to itself, since its positive counterpart
+// needs one more bit than the type has
+func Negate[T Integer](v T) T {
+	return ^v + 1
+}
+
+// ExplainTwosComplement describes how v is represented in two's complement.  The sign bit has a weight
+// of minus the value it would have if unsigned, so the value is the weight of the sign bit, if it is
+// set, plus the value of the bits below it
+func ExplainTwosComplement[T Signed](v T) Explanation {
+	sign, rest := v&MinValue[T](), v&MaxValue[T]()
+	return Explanation{
+		Title: fmt.Sprintf("%d in %d bit two's complement is %d + %d", v, BitWidth[T](), sign, rest),
+		Width: len(fmt.Sprint(MinValue[T]())),
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(v), Bits: Binary(v)},
+			{Label: "Sign  ", Value: fmt.Sprint(sign), Bits: Binary(sign)},
+			{Label: "Rest  ", Value: fmt.Sprint(rest), Bits: Binary(rest)},
+		},
+	}
+}
+
+// ExplainNegate describes negating v by inverting its bits and adding one
+func ExplainNegate[T Integer](v T) Explanation {
+	return Explanation{
+		Title: fmt.Sprintf("Negating %d in two's complement", v),
+		Width: len(fmt.Sprint(MinValue[T]())),
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(v), Bits: Binary(v)},
+			{Label: "Invert", Value: fmt.Sprint(^v), Bits: Binary(^v)},
+			{Label: "Plus 1", Value: fmt.Sprint(Negate(v)), Bits: Binary(Negate(v))},
+		},
+	}
+}
+
+// ExplainExtend describes converting v from type F to a wider type T.  Go picks the extension from the
+// type being converted from, a signed value is sign extended, copying its sign bit in to the new bits
+// so the value is kept, and an unsigned value is zero extended.  We show both, right aligned so the
+// original bits line up
+func ExplainExtend[F, T Integer](v F) Explanation {
+	width := BitWidth[T]()
+	kind := "zero"
+	if IsSigned[F]() {
+		kind = "sign"
+	}
+	zero := T(unsignedBits(v))
+	sign := T(signExtend(unsignedBits(v), BitWidth[F]()))
+	return Explanation{
+		Title: fmt.Sprintf("Converting %T %d to %T %d %s extends it", v, v, T(v), T(v), kind),
+		Width: max(len(fmt.Sprint(zero)), len(fmt.Sprint(sign))),
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(v), Bits: fmt.Sprintf("%*s", width, Binary(v))},
+			{Label: "Zero  ", Value: fmt.Sprint(zero), Bits: Binary(zero)},
+			{Label: "Sign  ", Value: fmt.Sprint(sign), Bits: Binary(sign)},
+		},
+	}
+}
+
+// LogicalShiftRight shifts v right pulling in zeros whatever its type, where Go's >> pulls in copies
+// of the sign bit for signed types
+func LogicalShiftRight[T Integer](v T, n uint) T {
+	return T(unsignedBits(v) >> n)
+}
+
+// ArithmeticShiftRight shifts v right pulling in copies of its sign bit whatever its type, which divides
+// it by 2 to the n rounding towards minus infinity
+func ArithmeticShiftRight[T Integer](v T, n uint) T {
+	return T(signExtend(unsignedBits(v), BitWidth[T]()) >> n)
+}
+
+// ExplainShiftRight describes shifting v right both ways
+func ExplainShiftRight[T Integer](v T, n uint) Explanation {
+	arith, logic := ArithmeticShiftRight(v, n), LogicalShiftRight(v, n)
+	return Explanation{
+		Title: fmt.Sprintf("Right shifting %d by %d bits, arithmetic and logical", v, n),
+		Width: max(len(fmt.Sprint(v)), len(fmt.Sprint(arith)), len(fmt.Sprint(logic))),
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(v), Bits: Binary(v)},
+			{Label: "Arith ", Value: fmt.Sprint(arith), Bits: Binary(arith)},
+			{Label: "Logic ", Value: fmt.Sprint(logic), Bits: Binary(logic)},
+		},
+	}
+}
+
+// ALUFlags are the condition flags a CPU sets after arithmetic.  Negative is a copy of the top bit of
+// the result and Zero is set when every bit is clear.  Carry is set when the result is wrong read as
+// unsigned, a carry out of the top bit on an add, a borrow in to it on a subtract, as x86 does it (ARM
+// sets it when there is no borrow instead), and a product too big for the width on a multiply.
+// Overflow is set when the result is wrong read as signed
+type ALUFlags struct {
+	Negative bool
+	Zero     bool
+	Carry    bool
+	Overflow bool
+}
+
+// String returns the flags in the order ARM lists them, N Z C V, each 1 or 0
+func (f ALUFlags) String() string {
+	b := func(set bool) int {
+		if set {
+			return 1
+		}
+		return 0
+	}
+	return fmt.Sprintf("N=%d Z=%d C=%d V=%d", b(f.Negative), b(f.Zero), b(f.Carry), b(f.Overflow))
+}
+
+// aluOp works out the result and flags of an operation on the raw bits of a and b at the width of T.
+// The carry is found from the unsigned bits and the overflow from the sign bits, the same way the
+// hardware does it, rather than by working in a wider type
+func aluOp[T Integer](op string, a, b T) (T, ALUFlags, error) {
+	width := BitWidth[T]()
+	ua, ub := unsignedBits(a), unsignedBits(b)
+	top := uint64(1) << (width - 1)
+	var res uint64
+	var f ALUFlags
+	switch op {
+	case "+":
+		var carry uint64
+		res, carry = bits.Add64(ua, ub, 0)
+		f.Carry = carry == 1 || (width < 64 && res>>width != 0)
+		// Adding two numbers with the same sign can't give a result with the other sign
+		f.Overflow = (ua^ub)&top == 0 && (res^ua)&top != 0
+	case "-":
+		res = ua - ub
+		f.Carry = ua < ub
+		// Subtracting a number with the other sign is adding one with the same sign
+		f.Overflow = (ua^ub)&top != 0 && (res^ua)&top != 0
+	case "*":
+		var hi uint64
+		hi, res = bits.Mul64(ua, ub)
+		f.Carry = hi != 0 || (width < 64 && res>>width != 0)
+		sa, sb := signExtend(ua, width), signExtend(ub, width)
+		p := sa * sb
+		if width <= 32 {
+			// Two 32 bit numbers multiply to at most 64 bits, so the product is exact
+			f.Overflow = p != signExtend(uint64(p), width)
+		} else {
+			// Dividing back only fails to give b when the product wrapped, apart from -1 times the
+			// smallest value, where the division wraps too
+			f.Overflow = sa != 0 && (p/sa != sb || (sa == -1 && sb == signExtend(top, width)))
+		}
+	default:
+		return 0, ALUFlags{}, fmt.Errorf("unknown ALU operation %q", op)
+	}
+	r := T(res)
+	f.Zero = unsignedBits(r) == 0
+	f.Negative = unsignedBits(r)&top != 0
+	return r, f, nil
+}
+
+// AddFlags returns a + b, wrapping around at the width of T, with the flags an ALU would set
+func AddFlags[T Integer](a, b T) (T, ALUFlags) {
+	r, f, _ := aluOp("+", a, b)
+	return r, f
+}
+
+// SubFlags returns a - b, wrapping around at the width of T, with the flags an ALU would set
+func SubFlags[T Integer](a, b T) (T, ALUFlags) {
+	r, f, _ := aluOp("-", a, b)
+	return r, f
+}
+
+// MulFlags returns the bottom half of a * b, the part that fits in T, with the flags an ALU would set
+func MulFlags[T Integer](a, b T) (T, ALUFlags) {
+	r, f, _ := aluOp("*", a, b)
+	return r, f
+}
+
+// saturate returns the result of an operation clamped to the range of T.  When a signed result
+// overflows the direction it went tells us which end to clamp to: an add overflows upwards when a is
+// positive, a subtract when a is positive, and a multiply when the signs are the same.  An unsigned
+// result that carries clamps to the top, except a subtract which can only go below zero
+func saturate[T Integer](op string, a, b T) (T, error) {
+	r, f, err := aluOp(op, a, b)
+	if err != nil {
+		return 0, err
+	}
+	if IsSigned[T]() {
+		if !f.Overflow {
+			return r, nil
+		}
+		up := a >= 0
+		if op == "*" {
+			up = (a < 0) == (b < 0)
+		}
+		if up {
+			return MaxValue[T](), nil
+		}
+		return MinValue[T](), nil
+	}
+	if !f.Carry {
+		return r, nil
+	}
+	if op == "-" {
+		return 0, nil
+	}
+	return MaxValue[T](), nil
+}
+
+// SaturatingAdd returns a + b, clamped to the range of T rather than wrapping around, the way DSP and
+// SIMD instructions handle audio and pixel values
+func SaturatingAdd[T Integer](a, b T) T {
+	r, _ := saturate("+", a, b)
+	return r
+}
+
+// SaturatingSub returns a - b, clamped to the range of T
+func SaturatingSub[T Integer](a, b T) T {
+	r, _ := saturate("-", a, b)
+	return r
+}
+
+// SaturatingMul returns a * b, clamped to the range of T
+func SaturatingMul[T Integer](a, b T) T {
+	r, _ := saturate("*", a, b)
+	return r
+}
+
+// aluTitles are the titles of the ALU explanations, in the same words the calculator uses
+var aluTitles = map[string]string{"+": "Adding %s and %s", "-": "Subtracting %[2]s from %[1]s", "*": "Multiplying %s by %s"}
+
+// bothWays returns v read as unsigned and as signed, separated by a slash
+func bothWays[T Integer](v T) string {
+	return fmt.Sprintf("%d/%d", unsignedBits(v), signExtend(unsignedBits(v), BitWidth[T]()))
+}
+
+// ExplainALU describes an add, subtract or multiply the way the hardware sees it.  The bits don't say
+// whether they are signed, so each value is shown read both ways, unsigned then signed, along with the
+// flags and the saturated result
+func ExplainALU[T Integer](op string, a, b T) (Explanation, error) {
+	r, f, err := aluOp(op, a, b)
+	if err != nil {
+		return Explanation{}, err
+	}
+	sat, _ := saturate(op, a, b)
+	rows := []ExplainRow{
+		{Label: "Binary", Value: bothWays(a), Bits: Binary(a)},
+		{Label: "Binary", Value: bothWays(b), Bits: Binary(b)},
+		{Label: "Result", Value: bothWays(r), Bits: Binary(r)},
+		{Label: "Clamp ", Value: fmt.Sprint(sat), Bits: Binary(sat)},
+		{Label: "Flags ", Value: f.String()},
+	}
+	width := 0
+	for _, row := range rows[:4] {
+		width = max(width, len(row.Value))
+	}
+	return Explanation{
+		Title: fmt.Sprintf(aluTitles[op], fmt.Sprint(a), fmt.Sprint(b)) + fmt.Sprintf(" in %d bits", BitWidth[T]()),
+		Width: width,
+		Rows:  rows,
+	}, nil
+}
+
+// parseALUValue parses a value for the alu sub command, accepting anything that fits the width read as
+// either signed or unsigned, and returns its bits
+func parseALUValue(s string, width int) (uint64, error) {
+	if v, err := strconv.ParseInt(s, 0, width); err == nil {
+		return uint64(v), nil
+	}
+	v, err := strconv.ParseUint(s, 0, width)
+	if err != nil {
+		return 0, fmt.Errorf("%q doesn't fit in %d bits", s, width)
+	}
+	return v, nil
+}
+
+// explainALUWidth runs ExplainALU at the width of T, with the operands given as raw bits
+func explainALUWidth[T Integer](op string, a, b uint64) (Explanation, error) {
+	return ExplainALU(op, T(a), T(b))
+}
+
+// runALU is the alu sub command, it explains an add, subtract or multiply at a given width.  The
+// operands are signed when -signed is given, which changes the clamped result and how they are printed
+func runALU(args []string) error {
+	fs := flag.NewFlagSet("alu", flag.ContinueOnError)
+	width := fs.Int("width", 8, "Width in bits: 8, 16, 32 or 64")
+	signed := fs.Bool("signed", false, "Treat the operands as signed")
+	format := fs.String("format", "text", "Output format: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return fmt.Errorf("usage: alu [-width 8|16|32|64] [-signed] [-format text|markdown|html] <a> <+|-|*> <b>")
+	}
+	a, err := parseALUValue(fs.Arg(0), *width)
+	if err != nil {
+		return err
+	}
+	b, err := parseALUValue(fs.Arg(2), *width)
+	if err != nil {
+		return err
+	}
+	var e Explanation
+	switch op := fs.Arg(1); {
+	case *width == 8 && *signed:
+		e, err = explainALUWidth[int8](op, a, b)
+	case *width == 8:
+		e, err = explainALUWidth[uint8](op, a, b)
+	case *width == 16 && *signed:
+		e, err = explainALUWidth[int16](op, a, b)
+	case *width == 16:
+		e, err = explainALUWidth[uint16](op, a, b)
+	case *width == 32 && *signed:
+		e, err = explainALUWidth[int32](op, a, b)
+	case *width == 32:
+		e, err = explainALUWidth[uint32](op, a, b)
+	case *width == 64 && *signed:
+		e, err = explainALUWidth[int64](op, a, b)
+	case *width == 64:
+		e, err = explainALUWidth[uint64](op, a, b)
+	default:
+		return fmt.Errorf("width %d must be 8, 16, 32 or 64", *width)
+	}
+	if err != nil {
+		return err
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	return ex.Explain(e)
+}
@@ -0,0 +1,257 @@
+// Signed arithmetic test routines
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	if MaxValue[int8]() != math.MaxInt8 || MinValue[int8]() != math.MinInt8 {
+		t.Errorf("int8 limits %d %d", MinValue[int8](), MaxValue[int8]())
+	}
+	if MaxValue[int64]() != math.MaxInt64 || MinValue[int64]() != math.MinInt64 {
+		t.Errorf("int64 limits %d %d", MinValue[int64](), MaxValue[int64]())
+	}
+	if MaxValue[uint16]() != math.MaxUint16 || MinValue[uint16]() != 0 {
+		t.Errorf("uint16 limits %d %d", MinValue[uint16](), MaxValue[uint16]())
+	}
+	if !IsSigned[int]() || IsSigned[uint]() || IsSigned[uintptr]() || !IsSigned[int32]() {
+		t.Errorf("IsSigned got a type wrong")
+	}
+}
+
+// reference works out the result, flags and saturated result of an operation with big integers, reading
+// the operands as unsigned for the carry and as signed for the overflow
+func reference[T Integer](op string, a, b T) (T, ALUFlags, T) {
+	width := BitWidth[T]()
+	calc := func(x, y *big.Int) *big.Int {
+		switch op {
+		case "+":
+			return new(big.Int).Add(x, y)
+		case "-":
+			return new(big.Int).Sub(x, y)
+		}
+		return new(big.Int).Mul(x, y)
+	}
+	ua, ub := unsignedBits(a), unsignedBits(b)
+	sa, sb := signExtend(ua, width), signExtend(ub, width)
+	u := calc(new(big.Int).SetUint64(ua), new(big.Int).SetUint64(ub))
+	s := calc(big.NewInt(sa), big.NewInt(sb))
+
+	umax := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(width)), big.NewInt(1))
+	smax := new(big.Int).Rsh(umax, 1)
+	smin := new(big.Int).Neg(new(big.Int).Add(smax, big.NewInt(1)))
+	var f ALUFlags
+	f.Carry = u.Sign() < 0 || u.Cmp(umax) > 0
+	f.Overflow = s.Cmp(smin) < 0 || s.Cmp(smax) > 0
+
+	res := T(new(big.Int).And(u, umax).Uint64())
+	f.Zero = unsignedBits(res) == 0
+	f.Negative = unsignedBits(res)>>(width-1) == 1
+
+	// Clamp the exact result to the range of the type
+	exact, lo, hi := u, big.NewInt(0), umax
+	if IsSigned[T]() {
+		exact, lo, hi = s, smin, smax
+	}
+	sat := res
+	if exact.Cmp(lo) < 0 {
+		sat = MinValue[T]()
+	} else if exact.Cmp(hi) > 0 {
+		sat = MaxValue[T]()
+	}
+	return res, f, sat
+}
+
+// checkALU compares every operation on a and b against the big integer reference
+func checkALU[T Integer](t *testing.T, a, b T) {
+	t.Helper()
+	ops := map[string]struct {
+		flags func(T, T) (T, ALUFlags)
+		sat   func(T, T) T
+	}{
+		"+": {AddFlags[T], SaturatingAdd[T]},
+		"-": {SubFlags[T], SaturatingSub[T]},
+		"*": {MulFlags[T], SaturatingMul[T]},
+	}
+	for op, fns := range ops {
+		wantRes, wantFlags, wantSat := reference(op, a, b)
+		res, flags := fns.flags(a, b)
+		if res != wantRes || flags != wantFlags {
+			t.Fatalf("%T %d %s %d gave %d %s instead of %d %s", a, a, op, b, res, flags, wantRes, wantFlags)
+		}
+		if sat := fns.sat(a, b); sat != wantSat {
+			t.Fatalf("%T saturating %d %s %d gave %d instead of %d", a, a, op, b, sat, wantSat)
+		}
+		// Go's own wrapping arithmetic must agree with the result
+		var goRes T
+		switch op {
+		case "+":
+			goRes = a + b
+		case "-":
+			goRes = a - b
+		case "*":
+			goRes = a * b
+		}
+		if res != goRes {
+			t.Fatalf("%T %d %s %d gave %d but Go gives %d", a, a, op, b, res, goRes)
+		}
+	}
+}
+
+// interesting are values either side of the points where the flags change at every width, which the
+// random tests might never hit
+func interesting[T Integer]() []T {
+	return []T{0, 1, 2, MaxValue[T](), MaxValue[T]() - 1, MinValue[T](), MinValue[T]() + 1, ^T(0),
+		T(1) << (BitWidth[T]() - 1), T(1)<<(BitWidth[T]()-1) - 1, T(1) << (BitWidth[T]() / 2)}
+}
+
+// checkWidth tests the ALU at the width of T, with every pair of interesting values and random pairs
+func checkWidth[T Integer](t *testing.T) {
+	var zero T
+	t.Run(fmt.Sprintf("%T", zero), func(t *testing.T) {
+		vals := interesting[T]()
+		for _, a := range vals {
+			for _, b := range vals {
+				checkALU(t, a, b)
+			}
+		}
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 20000; i++ {
+			checkALU(t, T(r.Uint64()), T(r.Uint64()))
+		}
+	})
+}
+
+func TestALUExhaustive8(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			checkALU(t, uint8(a), uint8(b))
+			checkALU(t, int8(a), int8(b))
+		}
+	}
+}
+
+func TestALUEveryWidth(t *testing.T) {
+	checkWidth[int8](t)
+	checkWidth[uint8](t)
+	checkWidth[int16](t)
+	checkWidth[uint16](t)
+	checkWidth[int32](t)
+	checkWidth[uint32](t)
+	checkWidth[int64](t)
+	checkWidth[uint64](t)
+	checkWidth[int](t)
+	checkWidth[uint](t)
+}
+
+// checkShifts compares the shifts against Go's, which is arithmetic for signed types and logical for
+// unsigned ones, at every shift count
+func checkShifts[T Integer, S Signed, U Integer](t *testing.T, vals []T) {
+	t.Helper()
+	for _, v := range vals {
+		for n := uint(0); n <= uint(BitWidth[T]()); n++ {
+			if got, want := ArithmeticShiftRight(v, n), T(S(v)>>n); got != want {
+				t.Errorf("%T %d arithmetic >> %d gave %d instead of %d", v, v, n, got, want)
+			}
+			if got, want := LogicalShiftRight(v, n), T(U(v)>>n); got != want {
+				t.Errorf("%T %d logical >> %d gave %d instead of %d", v, v, n, got, want)
+			}
+		}
+	}
+}
+
+func TestShiftRight(t *testing.T) {
+	checkShifts[int8, int8, uint8](t, interesting[int8]())
+	checkShifts[uint8, int8, uint8](t, interesting[uint8]())
+	checkShifts[int16, int16, uint16](t, interesting[int16]())
+	checkShifts[int32, int32, uint32](t, interesting[int32]())
+	checkShifts[uint32, int32, uint32](t, interesting[uint32]())
+	checkShifts[int64, int64, uint64](t, interesting[int64]())
+	checkShifts[uint64, int64, uint64](t, interesting[uint64]())
+}
+
+func TestNegate(t *testing.T) {
+	for v := -128; v < 128; v++ {
+		if got := Negate(int8(v)); got != -int8(v) {
+			t.Errorf("Negate(%d) = %d", v, got)
+		}
+	}
+	if Negate(int64(math.MinInt64)) != math.MinInt64 {
+		t.Errorf("negating the smallest int64 should overflow to itself")
+	}
+	if Negate(uint32(1)) != math.MaxUint32 {
+		t.Errorf("negating unsigned 1 should give all ones")
+	}
+}
+
+func TestSignedExplanations(t *testing.T) {
+	var sb strings.Builder
+	te := &TextExplainer{W: &sb}
+	_ = te.Explain(ExplainTwosComplement(int8(-50)))
+	_ = te.Explain(ExplainNegate(int8(50)))
+	_ = te.Explain(ExplainExtend[int8, int16](-50))
+	_ = te.Explain(ExplainExtend[uint8, int16](206))
+	_ = te.Explain(ExplainShiftRight(int8(-50), 2))
+	want := `-50 in 8 bit two's complement is -128 + 78
+Binary [ -50]: 11001110
+Sign   [-128]: 10000000
+Rest   [  78]: 01001110
+Negating 50 in two's complement
+Binary [  50]: 00110010
+Invert [ -51]: 11001101
+Plus 1 [ -50]: 11001110
+Converting int8 -50 to int16 -50 sign extends it
+Binary [-50]:         11001110
+Zero   [206]: 0000000011001110
+Sign   [-50]: 1111111111001110
+Converting uint8 206 to int16 206 zero extends it
+Binary [206]:         11001110
+Zero   [206]: 0000000011001110
+Sign   [-50]: 1111111111001110
+Right shifting -50 by 2 bits, arithmetic and logical
+Binary [-50]: 11001110
+Arith  [-13]: 11110011
+Logic  [ 51]: 00110011
+`
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+
+	e, err := ExplainALU[uint8]("+", 200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Rows[2].Value != "44/44" || e.Rows[3].Value != "255" || e.Rows[4].Value != "N=0 Z=0 C=1 V=0" {
+		t.Errorf("unexpected rows %+v", e.Rows)
+	}
+	if _, err := ExplainALU[int8]("/", 1, 2); err == nil {
+		t.Errorf("an unknown operation should be rejected")
+	}
+}
+
+func TestParseALUValue(t *testing.T) {
+	tests := []struct {
+		in    string
+		width int
+		want  uint64
+		ok    bool
+	}{
+		{"-1", 8, 0xFFFFFFFFFFFFFFFF, true},
+		{"255", 8, 255, true},
+		{"256", 8, 0, false},
+		{"-129", 8, 0, false},
+		{"0x8000", 16, 0x8000, true},
+	}
+	for _, tt := range tests {
+		got, err := parseALUValue(tt.in, tt.width)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseALUValue(%q, %d) = %x, %v", tt.in, tt.width, got, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// IPv4 and IPv6 subnet calculator built on the bitwise routines
+// Andrew Alston
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Prefix is an IPv4 or IPv6 prefix.  The address is held as a 128 bit number, with IPv4 addresses
+// using the bottom 32 bits, so the same bitwise operations work for both.  Addr is the address as it
+// was given and may have host bits set, for example 192.168.1.10/24
+type Prefix struct {
+	Addr Uint128
+	Bits int
+	IPv6 bool
+}
+
+// AddrToUint128 returns the bits of a netip address as a Uint128
+func AddrToUint128(a netip.Addr) Uint128 {
+	b := a.As16()
+	var u Uint128
+	for i := 0; i < 8; i++ {
+		u.Hi = u.Hi<<8 | uint64(b[i])
+		u.Lo = u.Lo<<8 | uint64(b[i+8])
+	}
+	if a.Is4() {
+		// As16 returns IPv4 addresses in their ::ffff:a.b.c.d form, we only want the bottom 32 bits
+		return Uint128{Lo: u.Lo & 0xFFFFFFFF}
+	}
+	return u
+}
+
+// Uint128ToAddr turns a Uint128 back into a netip address
+func Uint128ToAddr(u Uint128, ipv6 bool) netip.Addr {
+	if !ipv6 {
+		return netip.AddrFrom4([4]byte{byte(u.Lo >> 24), byte(u.Lo >> 16), byte(u.Lo >> 8), byte(u.Lo)})
+	}
+	var b [16]byte
+	for i := 0; i < 8; i++ {
+		b[i] = byte(u.Hi >> (56 - 8*i))
+		b[i+8] = byte(u.Lo >> (56 - 8*i))
+	}
+	return netip.AddrFrom16(b)
+}
+
+// ParsePrefix parses a prefix in CIDR form such as 10.1.2.3/8 or 2001:db8::1/32.  A plain address
+// without a length is treated as a single host, /32 for IPv4 and /128 for IPv6
+func ParsePrefix(s string) (Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return Prefix{}, fmt.Errorf("invalid address %q", s)
+		}
+		s = fmt.Sprintf("%s/%d", s, a.BitLen())
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return Prefix{}, fmt.Errorf("invalid prefix %q", s)
+	}
+	// Treat IPv4 mapped IPv6 addresses as the IPv4 address they contain
+	addr, bits := p.Addr(), p.Bits()
+	if addr.Is4In6() {
+		if bits < 96 {
+			return Prefix{}, fmt.Errorf("invalid prefix %q, an IPv4 mapped prefix needs a length of at least 96", s)
+		}
+		addr, bits = addr.Unmap(), bits-96
+	}
+	return Prefix{Addr: AddrToUint128(addr), Bits: bits, IPv6: addr.Is6()}, nil
+}
+
+// Width returns the number of bits in an address of this family, 32 for IPv4 and 128 for IPv6
+func (p Prefix) Width() int {
+	if p.IPv6 {
+		return 128
+	}
+	return 32
+}
+
+// Netmask returns the mask with the prefix bits set, for example 255.255.255.0 for a /24
+func (p Prefix) Netmask() Uint128 {
+	return PrefixMask(p.Bits, p.Width())
+}
+
+// HostMask returns the inverse of the netmask, the wildcard mask used in ACLs, for example 0.0.0.255
+// for a /24.  We get this by XORing the netmask with an address that has every bit set
+func (p Prefix) HostMask() Uint128 {
+	return p.Netmask().Xor(PrefixMask(p.Width(), p.Width()))
+}
+
+// Network returns the network address, which is the address ANDed with the netmask to clear the host bits
+func (p Prefix) Network() Uint128 {
+	return p.Addr.And(p.Netmask())
+}
+
+// Broadcast returns the last address in the prefix, which is the network address ORed with the host
+// mask to set every host bit.  IPv6 doesn't have broadcast, but this is still the last address
+func (p Prefix) Broadcast() Uint128 {
+	return p.Network().Or(p.HostMask())
+}
+
+// FirstHost returns the first usable host address.  In IPv4 the network address itself isn't usable,
+// except in a /31 point to point link (RFC 3021) or a /32 host route.  IPv6 has no such restriction
+func (p Prefix) FirstHost() Uint128 {
+	if !p.IPv6 && p.Bits <= 30 {
+		return p.Network().Add(Uint128{Lo: 1})
+	}
+	return p.Network()
+}
+
+// LastHost returns the last usable host address, which in IPv4 is the one before the broadcast address
+func (p Prefix) LastHost() Uint128 {
+	if !p.IPv6 && p.Bits <= 30 {
+		return p.Broadcast().Sub(Uint128{Lo: 1})
+	}
+	return p.Broadcast()
+}
+
+// HostCount returns the number of usable host addresses.  A /64 IPv6 prefix alone holds 2^64 addresses
+// so we need a big.Int here
+func (p Prefix) HostCount() *big.Int {
+	n := new(big.Int).Lsh(big.NewInt(1), uint(p.Width()-p.Bits))
+	if !p.IPv6 && p.Bits <= 30 {
+		n.Sub(n, big.NewInt(2))
+	}
+	return n
+}
+
+// Contains returns true if addr is within the prefix, which is when clearing its host bits leaves the
+// network address
+func (p Prefix) Contains(addr Uint128) bool {
+	return addr.And(p.Netmask()) == p.Network()
+}
+
+// FormatAddr returns u in dotted form for IPv4 or colon form for IPv6
+func (p Prefix) FormatAddr(u Uint128) string {
+	return Uint128ToAddr(u, p.IPv6).String()
+}
+
+// FormatBinary returns u in binary at the width of the address, split in to octets for IPv4 and 16 bit
+// groups for IPv6 the same way the address is written, with a | marking the prefix boundary
+func (p Prefix) FormatBinary(u Uint128) string {
+	group, sep := 8, "."
+	if p.IPv6 {
+		group, sep = 16, ":"
+	}
+	width := p.Width()
+	digits := u.Binary()[128-width:]
+	var sb strings.Builder
+	for i := 0; i < width; i++ {
+		switch {
+		case i > 0 && i == p.Bits:
+			sb.WriteString("|")
+		case i > 0 && i%group == 0:
+			sb.WriteString(sep)
+		}
+		sb.WriteByte(digits[i])
+	}
+	return sb.String()
+}
+
+// String returns the prefix in CIDR form with the host bits cleared
+func (p Prefix) String() string {
+	return fmt.Sprintf("%s/%d", p.FormatAddr(p.Network()), p.Bits)
+}
+
+// ExplainSubnet describes a prefix, showing each address the calculator works out in both its usual
+// form and in binary
+func ExplainSubnet(p Prefix) Explanation {
+	last := "Broadcast"
+	if p.IPv6 {
+		last = "Last addr"
+	}
+	rows := []ExplainRow{
+		{Label: "Address", Value: p.FormatAddr(p.Addr), Bits: p.FormatBinary(p.Addr)},
+		{Label: "Netmask", Value: p.FormatAddr(p.Netmask()), Bits: p.FormatBinary(p.Netmask())},
+		{Label: "Wildcard", Value: p.FormatAddr(p.HostMask()), Bits: p.FormatBinary(p.HostMask())},
+		{Label: "Network", Value: p.FormatAddr(p.Network()), Bits: p.FormatBinary(p.Network())},
+		{Label: last, Value: p.FormatAddr(p.Broadcast()), Bits: p.FormatBinary(p.Broadcast())},
+		{Label: "HostMin", Value: p.FormatAddr(p.FirstHost()), Bits: p.FormatBinary(p.FirstHost())},
+		{Label: "HostMax", Value: p.FormatAddr(p.LastHost()), Bits: p.FormatBinary(p.LastHost())},
+		{Label: "Hosts", Value: p.HostCount().String()},
+	}
+	// Pad the labels and values so the binary columns line up
+	width := 0
+	for _, r := range rows {
+		width = max(width, len(r.Value))
+	}
+	for i := range rows {
+		rows[i].Label = fmt.Sprintf("%-9s", rows[i].Label)
+	}
+	return Explanation{Title: fmt.Sprintf("Subnet %s", p), Width: width, Rows: rows}
+}
+
+// runSubnet is the subnet sub command, it describes each prefix given on the command line
+func runSubnet(args []string) error {
+	fs := flag.NewFlagSet("subnet", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: subnet [-format text|markdown|html] <prefix> [<prefix> ...]")
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, arg := range fs.Args() {
+		p, err := ParsePrefix(arg)
+		if err != nil {
+			return err
+		}
+		if err := ex.Explain(ExplainSubnet(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
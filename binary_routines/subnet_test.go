@@ -0,0 +1,123 @@
+// Subnet calculator test routines
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ipv6 bool
+		err  bool
+	}{
+		{"192.168.1.10/24", "192.168.1.0/24", false, false},
+		{"10.0.0.1", "10.0.0.1/32", false, false},
+		{"2001:db8::1/32", "2001:db8::/32", true, false},
+		{"::ffff:10.1.2.3/120", "10.1.2.0/24", false, false},
+		{"::1", "::1/128", true, false},
+		{"10.0.0.0/33", "", false, true},
+		{"bogus", "", false, true},
+		{"::ffff:10.1.2.3/64", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			p, err := ParsePrefix(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error state %v", err)
+			}
+			if err == nil && (p.String() != tt.want || p.IPv6 != tt.ipv6) {
+				t.Errorf("got %v [ipv6 %v] instead of %s", p, p.IPv6, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubnetCalculations(t *testing.T) {
+	tests := []struct {
+		in                                                        string
+		netmask, wildcard, network, broadcast, first, last, hosts string
+	}{
+		{"192.168.1.10/24", "255.255.255.0", "0.0.0.255", "192.168.1.0", "192.168.1.255", "192.168.1.1", "192.168.1.254", "254"},
+		{"172.16.5.4/12", "255.240.0.0", "0.15.255.255", "172.16.0.0", "172.31.255.255", "172.16.0.1", "172.31.255.254", "1048574"},
+		{"10.0.0.1/31", "255.255.255.254", "0.0.0.1", "10.0.0.0", "10.0.0.1", "10.0.0.0", "10.0.0.1", "2"},
+		{"10.0.0.1/32", "255.255.255.255", "0.0.0.0", "10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.1", "1"},
+		{"1.2.3.4/0", "0.0.0.0", "255.255.255.255", "0.0.0.0", "255.255.255.255", "0.0.0.1", "255.255.255.254", "4294967294"},
+		{"2001:db8:abcd::1/52", "ffff:ffff:ffff:f000::", "::fff:ffff:ffff:ffff:ffff", "2001:db8:abcd::",
+			"2001:db8:abcd:fff:ffff:ffff:ffff:ffff", "2001:db8:abcd::", "2001:db8:abcd:fff:ffff:ffff:ffff:ffff", "75557863725914323419136"},
+		{"2001:db8::1/127", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "::1", "2001:db8::", "2001:db8::1", "2001:db8::", "2001:db8::1", "2"},
+		{"::/0", "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::",
+			"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "340282366920938463463374607431768211456"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			p, err := ParsePrefix(tt.in)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			got := []string{p.FormatAddr(p.Netmask()), p.FormatAddr(p.HostMask()), p.FormatAddr(p.Network()),
+				p.FormatAddr(p.Broadcast()), p.FormatAddr(p.FirstHost()), p.FormatAddr(p.LastHost()), p.HostCount().String()}
+			want := []string{tt.netmask, tt.wildcard, tt.network, tt.broadcast, tt.first, tt.last, tt.hosts}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("got %v instead of %v", got, want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestPrefixContains(t *testing.T) {
+	p, _ := ParsePrefix("10.1.0.0/16")
+	in, _ := ParsePrefix("10.1.255.3")
+	out, _ := ParsePrefix("10.2.0.0")
+	if !p.Contains(in.Addr) || p.Contains(out.Addr) {
+		t.Errorf("unexpected containment results")
+	}
+}
+
+func TestFormatBinary(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"192.168.1.10/24", "11000000.10101000.00000001|00001010"},
+		{"192.168.1.10/20", "11000000.10101000.0000|0001.00001010"},
+		{"192.168.1.10/32", "11000000.10101000.00000001.00001010"},
+		{"192.168.1.10/0", "11000000.10101000.00000001.00001010"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			p, _ := ParsePrefix(tt.in)
+			if got := p.FormatBinary(p.Addr); got != tt.want {
+				t.Errorf("got %s instead of %s", got, tt.want)
+			}
+		})
+	}
+	p, _ := ParsePrefix("2001:db8::/20")
+	if got := p.FormatBinary(p.Addr); !strings.HasPrefix(got, "0010000000000001:0000|110110111000:") {
+		t.Errorf("unexpected IPv6 binary %s", got)
+	}
+}
+
+func TestExplainSubnet(t *testing.T) {
+	p, _ := ParsePrefix("10.0.0.5/30")
+	var buf bytes.Buffer
+	_ = (&TextExplainer{W: &buf}).Explain(ExplainSubnet(p))
+	want := "Subnet 10.0.0.4/30\n" +
+		"Address   [       10.0.0.5]: 00001010.00000000.00000000.000001|01\n" +
+		"Netmask   [255.255.255.252]: 11111111.11111111.11111111.111111|00\n" +
+		"Wildcard  [        0.0.0.3]: 00000000.00000000.00000000.000000|11\n" +
+		"Network   [       10.0.0.4]: 00001010.00000000.00000000.000001|00\n" +
+		"Broadcast [       10.0.0.7]: 00001010.00000000.00000000.000001|11\n" +
+		"HostMin   [       10.0.0.5]: 00001010.00000000.00000000.000001|01\n" +
+		"HostMax   [       10.0.0.6]: 00001010.00000000.00000000.000001|10\n" +
+		"Hosts     [              2]\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\ninstead of\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,148 @@
+// 128 bit unsigned integer built from two uint64 halves, big enough to hold an IPv6 address
+// Andrew Alston
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// Uint128 is an unsigned 128 bit integer.  Go has no native 128 bit type, so we store the top 64 bits
+// in Hi and the bottom 64 bits in Lo, and build every operation out of operations on the two halves
+type Uint128 struct {
+	Hi, Lo uint64
+}
+
+// Uint128Max has every bit set
+var Uint128Max = Uint128{Hi: ^uint64(0), Lo: ^uint64(0)}
+
+// And returns the bitwise AND of u and v, which is just the AND of each half
+func (u Uint128) And(v Uint128) Uint128 {
+	return Uint128{Hi: BitwiseAnd(u.Hi, v.Hi), Lo: BitwiseAnd(u.Lo, v.Lo)}
+}
+
+// Or returns the bitwise OR of u and v
+func (u Uint128) Or(v Uint128) Uint128 {
+	return Uint128{Hi: BitwiseOr(u.Hi, v.Hi), Lo: BitwiseOr(u.Lo, v.Lo)}
+}
+
+// Xor returns the exclusive OR of u and v
+func (u Uint128) Xor(v Uint128) Uint128 {
+	return Uint128{Hi: ExclusiveOr(u.Hi, v.Hi), Lo: ExclusiveOr(u.Lo, v.Lo)}
+}
+
+// Not returns u with every bit flipped
+func (u Uint128) Not() Uint128 {
+	return Uint128{Hi: ^u.Hi, Lo: ^u.Lo}
+}
+
+// Lsh returns u shifted left by n bits.  Bits pushed out of the top of Lo carry into the bottom of Hi,
+// and shifting by 64 or more moves Lo straight into Hi
+func (u Uint128) Lsh(n uint) Uint128 {
+	switch {
+	case n >= 128:
+		return Uint128{}
+	case n >= 64:
+		return Uint128{Hi: u.Lo << (n - 64)}
+	case n == 0:
+		return u
+	}
+	return Uint128{Hi: u.Hi<<n | u.Lo>>(64-n), Lo: u.Lo << n}
+}
+
+// Rsh returns u shifted right by n bits, carrying the bits pushed out of the bottom of Hi into Lo
+func (u Uint128) Rsh(n uint) Uint128 {
+	switch {
+	case n >= 128:
+		return Uint128{}
+	case n >= 64:
+		return Uint128{Lo: u.Hi >> (n - 64)}
+	case n == 0:
+		return u
+	}
+	return Uint128{Hi: u.Hi >> n, Lo: u.Lo>>n | u.Hi<<(64-n)}
+}
+
+// Add returns u + v, wrapping around on overflow.  bits.Add64 gives us the carry out of the low half
+// so we can add it into the high half
+func (u Uint128) Add(v Uint128) Uint128 {
+	lo, carry := bits.Add64(u.Lo, v.Lo, 0)
+	hi, _ := bits.Add64(u.Hi, v.Hi, carry)
+	return Uint128{Hi: hi, Lo: lo}
+}
+
+// Sub returns u - v, wrapping around on underflow, borrowing from the high half when needed
+func (u Uint128) Sub(v Uint128) Uint128 {
+	lo, borrow := bits.Sub64(u.Lo, v.Lo, 0)
+	hi, _ := bits.Sub64(u.Hi, v.Hi, borrow)
+	return Uint128{Hi: hi, Lo: lo}
+}
+
+// Cmp compares u and v, returning -1 if u < v, 0 if they are equal and 1 if u > v
+func (u Uint128) Cmp(v Uint128) int {
+	switch {
+	case u.Hi < v.Hi || (u.Hi == v.Hi && u.Lo < v.Lo):
+		return -1
+	case u == v:
+		return 0
+	}
+	return 1
+}
+
+// IsZero returns true if no bits are set
+func (u Uint128) IsZero() bool {
+	return u.Hi == 0 && u.Lo == 0
+}
+
+// Bit returns bit i of u, counting from the right starting at 0
+func (u Uint128) Bit(i int) uint {
+	if i >= 64 {
+		return uint(u.Hi>>(i-64)) & 1
+	}
+	return uint(u.Lo>>i) & 1
+}
+
+// LeadingZeros returns the number of zero bits above the highest set bit
+func (u Uint128) LeadingZeros() int {
+	if u.Hi != 0 {
+		return LeadingZeros(u.Hi)
+	}
+	return 64 + LeadingZeros(u.Lo)
+}
+
+// TrailingZeros returns the number of zero bits below the lowest set bit
+func (u Uint128) TrailingZeros() int {
+	if u.Lo != 0 {
+		return TrailingZeros(u.Lo)
+	}
+	return 64 + TrailingZeros(u.Hi)
+}
+
+// Big returns u as a big.Int, which is the easiest way to print it in decimal
+func (u Uint128) Big() *big.Int {
+	b := new(big.Int).SetUint64(u.Hi)
+	b.Lsh(b, 64)
+	return b.Or(b, new(big.Int).SetUint64(u.Lo))
+}
+
+// String returns u in decimal
+func (u Uint128) String() string {
+	return u.Big().String()
+}
+
+// Binary returns u as 128 binary digits
+func (u Uint128) Binary() string {
+	return fmt.Sprintf("%064b%064b", u.Hi, u.Lo)
+}
+
+// PrefixMask returns a mask with the top bits bits set out of an address of width bits, lined up with
+// the bottom of the Uint128.  For IPv4 the width is 32, so PrefixMask(24, 32) is 255.255.255.0
+func PrefixMask(bits, width int) Uint128 {
+	if bits <= 0 {
+		return Uint128{}
+	}
+	all := Uint128Max.Rsh(uint(128 - width))
+	return all.Rsh(uint(width - bits)).Lsh(uint(width - bits))
+}
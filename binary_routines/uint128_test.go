@@ -0,0 +1,91 @@
+// 128 bit integer test routines
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand/v2"
+	"testing"
+)
+
+// mod128 is 2^128, used to wrap the big.Int reference results
+var mod128 = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// wrap reduces a big.Int modulo 2^128, the same as Uint128 arithmetic wraps
+func wrap(b *big.Int) *big.Int {
+	return b.Mod(b, mod128)
+}
+
+func TestUint128(t *testing.T) {
+	r := rand.New(rand.NewPCG(128, 1))
+	values := []Uint128{{}, {Lo: 1}, {Hi: 1}, Uint128Max, {Lo: ^uint64(0)}, {Hi: 1 << 63}}
+	for i := 0; i < 200; i++ {
+		values = append(values, Uint128{Hi: r.Uint64(), Lo: r.Uint64()})
+	}
+	for i, u := range values {
+		v := values[(i+1)%len(values)]
+		bu, bv := u.Big(), v.Big()
+		checks := []struct {
+			name string
+			got  Uint128
+			want *big.Int
+		}{
+			{"and", u.And(v), new(big.Int).And(bu, bv)},
+			{"or", u.Or(v), new(big.Int).Or(bu, bv)},
+			{"xor", u.Xor(v), new(big.Int).Xor(bu, bv)},
+			{"not", u.Not(), new(big.Int).Xor(bu, wrap(big.NewInt(-1)))},
+			{"add", u.Add(v), wrap(new(big.Int).Add(bu, bv))},
+			{"sub", u.Sub(v), wrap(new(big.Int).Sub(bu, bv))},
+		}
+		for _, n := range []uint{0, 1, 13, 63, 64, 65, 100, 127, 128} {
+			checks = append(checks,
+				struct {
+					name string
+					got  Uint128
+					want *big.Int
+				}{fmt.Sprintf("lsh %d", n), u.Lsh(n), wrap(new(big.Int).Lsh(bu, n))},
+				struct {
+					name string
+					got  Uint128
+					want *big.Int
+				}{fmt.Sprintf("rsh %d", n), u.Rsh(n), new(big.Int).Rsh(bu, n)})
+		}
+		for _, c := range checks {
+			if c.got.Big().Cmp(c.want) != 0 {
+				t.Errorf("%s of %v and %v gave %v instead of %v", c.name, u, v, c.got, c.want)
+			}
+		}
+		if u.Cmp(v) != bu.Cmp(bv) {
+			t.Errorf("Cmp(%v, %v) gave %d", u, v, u.Cmp(v))
+		}
+		if !u.IsZero() && (u.LeadingZeros() != 128-bu.BitLen() || u.Bit(u.TrailingZeros()) != 1) {
+			t.Errorf("zero counts of %v were wrong", u)
+		}
+		if u.String() != bu.String() || len(u.Binary()) != 128 {
+			t.Errorf("formatting of %v was wrong", u)
+		}
+	}
+}
+
+func TestPrefixMask(t *testing.T) {
+	tests := []struct {
+		bits, width int
+		want        Uint128
+	}{
+		{0, 32, Uint128{}},
+		{24, 32, Uint128{Lo: 0xFFFFFF00}},
+		{32, 32, Uint128{Lo: 0xFFFFFFFF}},
+		{1, 128, Uint128{Hi: 1 << 63}},
+		{64, 128, Uint128{Hi: ^uint64(0)}},
+		{65, 128, Uint128{Hi: ^uint64(0), Lo: 1 << 63}},
+		{128, 128, Uint128Max},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d/%d", tt.bits, tt.width)
+		t.Run(testName, func(t *testing.T) {
+			if got := PrefixMask(tt.bits, tt.width); got != tt.want {
+				t.Errorf("got %s instead of %s", got.Binary(), tt.want.Binary())
+			}
+		})
+	}
+}
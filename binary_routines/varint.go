@@ -0,0 +1,694 @@
+// Variable length integer encodings, LEB128, zigzag, protobuf, QUIC and ASN.1 BER lengths and OIDs
+// Andrew Alston
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the decoders.  Every decoder is strict, it rejects an encoding that uses more bytes
+// than it needs, since accepting them lets the same value be written in more than one way, which is the
+// root of many parser confusion bugs
+var (
+	ErrVarintTruncated  = errors.New("truncated encoding")
+	ErrVarintNonMinimal = errors.New("non-minimal encoding")
+	ErrVarintOverflow   = errors.New("encoding overflows 64 bits")
+	ErrQUICRange        = errors.New("value too large for a QUIC variable length integer")
+	ErrBERIndefinite    = errors.New("indefinite length form not supported")
+)
+
+// AppendUvarint appends v as unsigned LEB128.  The value is split in to 7 bit groups, least significant
+// group first, and each group goes in a byte whose top bit is set when more bytes follow.  300 is
+// 10 0101100 in binary, so it encodes as 1 0101100 followed by 0 0000010, or ac 02
+func AppendUvarint(dst []byte, v uint64) []byte {
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+// DecodeUvarint decodes an unsigned LEB128 value from the start of b, returning it and the number of
+// bytes used.  A last byte of zero after the first only adds leading zeros, so it is non-minimal
+func DecodeUvarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b); i++ {
+		if i == 9 && b[i] > 1 {
+			// The tenth byte only has room for bit 63
+			return 0, 0, ErrVarintOverflow
+		}
+		v |= uint64(b[i]&0x7F) << (7 * i)
+		if b[i]&0x80 == 0 {
+			if i > 0 && b[i] == 0 {
+				return 0, 0, ErrVarintNonMinimal
+			}
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, ErrVarintTruncated
+}
+
+// AppendVarint appends v as signed LEB128, as used by DWARF and WebAssembly.  The groups are the same as
+// for unsigned LEB128, but we stop once the rest of the value is only copies of the sign bit, which is
+// bit 6 of the last byte.  -2 is 1111110 in its bottom 7 bits with nothing but ones above, so it encodes
+// as the single byte 7e
+func AppendVarint(dst []byte, v int64) []byte {
+	for {
+		b := byte(v & 0x7F)
+		// Go shifts signed values arithmetically, pulling in copies of the sign bit
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(dst, b)
+		}
+		dst = append(dst, b|0x80)
+	}
+}
+
+// DecodeVarint decodes a signed LEB128 value from the start of b, returning it and the number of bytes
+// used.  The last byte is non-minimal when it only repeats the sign bit of the byte before
+func DecodeVarint(b []byte) (int64, int, error) {
+	var v int64
+	for i := 0; i < len(b); i++ {
+		if i == 9 && b[i] != 0 && b[i] != 0x7F {
+			// Bit 0 of the tenth byte is bit 63, the rest must all be copies of it
+			return 0, 0, ErrVarintOverflow
+		}
+		v |= int64(b[i]&0x7F) << (7 * i)
+		if b[i]&0x80 != 0 {
+			continue
+		}
+		if i > 0 && ((b[i] == 0 && b[i-1]&0x40 == 0) || (b[i] == 0x7F && b[i-1]&0x40 != 0)) {
+			return 0, 0, ErrVarintNonMinimal
+		}
+		if shift := 7 * (i + 1); shift < 64 && b[i]&0x40 != 0 {
+			// Sign extend from the top bit of the last group
+			v |= -1 << shift
+		}
+		return v, i + 1, nil
+	}
+	return 0, 0, ErrVarintTruncated
+}
+
+// ZigZagEncode maps signed values to unsigned ones so small negative numbers stay small: 0, -1, 1, -2,
+// 2 become 0, 1, 2, 3, 4.  Shifting left moves the sign out of the top bit, and XORing with the sign
+// bit copied across the whole word by an arithmetic right shift flips the rest for negative numbers
+func ZigZagEncode(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// ZigZagDecode reverses ZigZagEncode.  The bottom bit holds the sign, and negating it gives either all
+// zeros or all ones to XOR with the rest
+func ZigZagDecode(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// Protobuf wire types, the bottom 3 bits of a field tag
+const (
+	ProtoVarint = 0
+	ProtoI64    = 1
+	ProtoLen    = 2
+	ProtoI32    = 5
+)
+
+// maxProtoField is the largest protobuf field number
+const maxProtoField = 1<<29 - 1
+
+// AppendProtoInt appends v the way protobuf encodes int32 and int64 fields, as unsigned LEB128 of its
+// two's complement bits, which makes every negative number take ten bytes
+func AppendProtoInt(dst []byte, v int64) []byte {
+	return AppendUvarint(dst, uint64(v))
+}
+
+// DecodeProtoInt decodes an int32 or int64 protobuf field
+func DecodeProtoInt(b []byte) (int64, int, error) {
+	v, n, err := DecodeUvarint(b)
+	return int64(v), n, err
+}
+
+// AppendProtoSint appends v the way protobuf encodes sint32 and sint64 fields, zigzag encoded first so
+// small negative numbers take a single byte
+func AppendProtoSint(dst []byte, v int64) []byte {
+	return AppendUvarint(dst, ZigZagEncode(v))
+}
+
+// DecodeProtoSint decodes a sint32 or sint64 protobuf field
+func DecodeProtoSint(b []byte) (int64, int, error) {
+	v, n, err := DecodeUvarint(b)
+	return ZigZagDecode(v), n, err
+}
+
+// AppendProtoTag appends the tag that starts every protobuf field, the field number shifted left 3 bits
+// with the wire type in the bottom 3, as a varint
+func AppendProtoTag(dst []byte, field uint32, wireType uint8) ([]byte, error) {
+	if field == 0 || field > maxProtoField {
+		return dst, fmt.Errorf("protobuf field number %d out of range", field)
+	}
+	if wireType > 7 {
+		return dst, fmt.Errorf("protobuf wire type %d out of range", wireType)
+	}
+	return AppendUvarint(dst, uint64(field)<<3|uint64(wireType)), nil
+}
+
+// DecodeProtoTag decodes a protobuf field tag, returning the field number, wire type and bytes used
+func DecodeProtoTag(b []byte) (uint32, uint8, int, error) {
+	v, n, err := DecodeUvarint(b)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	field := v >> 3
+	if field == 0 || field > maxProtoField {
+		return 0, 0, 0, fmt.Errorf("protobuf field number %d out of range", field)
+	}
+	return uint32(field), uint8(v & 7), n, nil
+}
+
+// MaxQUICVarint is the largest value a QUIC variable length integer holds, 62 bits
+const MaxQUICVarint = 1<<62 - 1
+
+// quicLen returns the number of bytes QUIC needs for v, or 0 if it is too large
+func quicLen(v uint64) int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	case v <= MaxQUICVarint:
+		return 8
+	}
+	return 0
+}
+
+// AppendQUICVarint appends v as a QUIC variable length integer from RFC 9000 section 16.  The top 2 bits
+// of the first byte give the length, 00 for 1 byte up to 11 for 8 bytes, and the value fills the rest
+// of the bytes big endian, so it has 6, 14, 30 or 62 bits
+func AppendQUICVarint(dst []byte, v uint64) ([]byte, error) {
+	n := quicLen(v)
+	if n == 0 {
+		return dst, ErrQUICRange
+	}
+	// The length prefix is log2 of the byte count
+	v |= uint64(bits.TrailingZeros(uint(n))) << (8*n - 2)
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(v>>(8*i)))
+	}
+	return dst, nil
+}
+
+// DecodeQUICVarint decodes a QUIC variable length integer from the start of b, returning it and the
+// number of bytes used.  RFC 9000 lets senders use a longer encoding than needed, but we reject them
+func DecodeQUICVarint(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrVarintTruncated
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0, ErrVarintTruncated
+	}
+	v := uint64(b[0] & 0x3F)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	if quicLen(v) != n {
+		return 0, 0, ErrVarintNonMinimal
+	}
+	return v, n, nil
+}
+
+// AppendBERLength appends an ASN.1 BER length in the minimal form DER requires.  Lengths below 128 take
+// one byte, the short form.  Longer ones use the long form, a first byte of 0x80 plus the number of
+// bytes that follow, then the length itself big endian
+func AppendBERLength(dst []byte, length int) ([]byte, error) {
+	if length < 0 {
+		return dst, fmt.Errorf("negative length %d", length)
+	}
+	if length < 0x80 {
+		return append(dst, byte(length)), nil
+	}
+	n := (bits.Len64(uint64(length)) + 7) / 8
+	dst = append(dst, 0x80|byte(n))
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(length>>(8*i)))
+	}
+	return dst, nil
+}
+
+// DecodeBERLength decodes an ASN.1 BER length from the start of b, returning it and the number of bytes
+// used.  It rejects the indefinite form, a first byte of 0x80 meaning the contents end with a marker,
+// and the reserved 0xFF, along with long forms that have leading zeros or could have been short
+func DecodeBERLength(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrVarintTruncated
+	}
+	if b[0] < 0x80 {
+		return int(b[0]), 1, nil
+	}
+	n := int(b[0] & 0x7F)
+	switch {
+	case n == 0:
+		return 0, 0, ErrBERIndefinite
+	case n == 0x7F:
+		return 0, 0, errors.New("reserved length form 0xff")
+	case n > 8:
+		return 0, 0, ErrVarintOverflow
+	case len(b) < n+1:
+		return 0, 0, ErrVarintTruncated
+	case b[1] == 0:
+		return 0, 0, ErrVarintNonMinimal
+	}
+	var length uint64
+	for _, c := range b[1 : n+1] {
+		length = length<<8 | uint64(c)
+	}
+	if length > math.MaxInt {
+		return 0, 0, ErrVarintOverflow
+	}
+	if length < 0x80 {
+		return 0, 0, ErrVarintNonMinimal
+	}
+	return int(length), n + 1, nil
+}
+
+// appendBase128 appends v as an ASN.1 subidentifier.  It uses the same 7 bit groups and continuation bit
+// as LEB128, but with the most significant group first
+func appendBase128(dst []byte, v uint64) []byte {
+	n := max(1, (bits.Len64(v)+6)/7)
+	for i := n - 1; i > 0; i-- {
+		dst = append(dst, byte(v>>(7*i))|0x80)
+	}
+	return append(dst, byte(v&0x7F))
+}
+
+// decodeBase128 decodes a subidentifier from the start of b, returning it and the number of bytes used.
+// A leading group of zero, a first byte of 0x80, is non-minimal
+func decodeBase128(b []byte) (uint64, int, error) {
+	if len(b) > 0 && b[0] == 0x80 {
+		return 0, 0, ErrVarintNonMinimal
+	}
+	var v uint64
+	for i, c := range b {
+		if v>>57 != 0 {
+			return 0, 0, ErrVarintOverflow
+		}
+		v = v<<7 | uint64(c&0x7F)
+		if c&0x80 == 0 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, ErrVarintTruncated
+}
+
+// oidFirst combines the first two arcs of an OID in to the first subidentifier, 40 times the first arc
+// plus the second.  The first arc is 0, 1 or 2, and under 0 and 1 the second is below 40, which is what
+// lets a decoder split them again
+func oidFirst(arcs []uint64) (uint64, error) {
+	if len(arcs) < 2 {
+		return 0, errors.New("an OID needs at least two arcs")
+	}
+	switch {
+	case arcs[0] > 2:
+		return 0, fmt.Errorf("first OID arc %d must be 0, 1 or 2", arcs[0])
+	case arcs[0] < 2 && arcs[1] >= 40:
+		return 0, fmt.Errorf("second OID arc %d must be below 40 under arc %d", arcs[1], arcs[0])
+	case arcs[1] > math.MaxUint64-80:
+		return 0, ErrVarintOverflow
+	}
+	return arcs[0]*40 + arcs[1], nil
+}
+
+// AppendOID appends the contents of an ASN.1 OBJECT IDENTIFIER, without the tag and length
+func AppendOID(dst []byte, arcs []uint64) ([]byte, error) {
+	first, err := oidFirst(arcs)
+	if err != nil {
+		return dst, err
+	}
+	dst = appendBase128(dst, first)
+	for _, arc := range arcs[2:] {
+		dst = appendBase128(dst, arc)
+	}
+	return dst, nil
+}
+
+// DecodeOID decodes the contents of an ASN.1 OBJECT IDENTIFIER in to its arcs
+func DecodeOID(b []byte) ([]uint64, error) {
+	if len(b) == 0 {
+		return nil, ErrVarintTruncated
+	}
+	var arcs []uint64
+	for len(b) > 0 {
+		v, n, err := decodeBase128(b)
+		if err != nil {
+			return nil, err
+		}
+		if arcs == nil {
+			first := min(v/40, 2)
+			arcs = append(arcs, first, v-40*first)
+		} else {
+			arcs = append(arcs, v)
+		}
+		b = b[n:]
+	}
+	return arcs, nil
+}
+
+// ParseOID parses an OID in dotted form such as 1.3.6.1.2.1
+func ParseOID(s string) ([]uint64, error) {
+	var arcs []uint64
+	for _, part := range strings.Split(s, ".") {
+		arc, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q: %v", s, err)
+		}
+		arcs = append(arcs, arc)
+	}
+	if _, err := oidFirst(arcs); err != nil {
+		return nil, err
+	}
+	return arcs, nil
+}
+
+// FormatOID returns an OID in dotted form
+func FormatOID(arcs []uint64) string {
+	parts := make([]string, len(arcs))
+	for i, arc := range arcs {
+		parts[i] = strconv.FormatUint(arc, 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// groups7 returns the bottom n 7 bit groups of v in binary, most significant first, separated by spaces
+func groups7(v uint64, n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%07b", v>>(7*(n-1-i))&0x7F)
+	}
+	return strings.Join(parts, " ")
+}
+
+// byteRows returns a row for each encoded byte, splitting the bits of each with split so the
+// continuation or length bits stand apart from the value bits.  The bytes are numbered from start and
+// the labels padded to width
+func byteRows(enc []byte, start, width int, split func(i int, b byte) string) []ExplainRow {
+	rows := make([]ExplainRow, len(enc))
+	for i, b := range enc {
+		label := fmt.Sprintf("%-*s", width, fmt.Sprintf("Byte %d", start+i))
+		rows[i] = ExplainRow{Label: label, Value: fmt.Sprintf("%02x", b), Bits: split(start+i, b)}
+	}
+	return rows
+}
+
+// byteLabelWidth returns the width of the longest byte label for n bytes, never narrower than "Value "
+func byteLabelWidth(n int) int {
+	return max(6, len(fmt.Sprintf("Byte %d", n-1)))
+}
+
+// byteCount returns n followed by byte or bytes
+func byteCount(n int) string {
+	if n == 1 {
+		return "1 byte"
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// continuationBits splits a byte in to its continuation bit and 7 value bits
+func continuationBits(_ int, b byte) string {
+	return fmt.Sprintf("%d %07b", b>>7, b&0x7F)
+}
+
+// leb128Explanation describes a LEB128 encoding, the value split in to 7 bit groups above the bytes
+// they went in to.  The groups are read right to left and the bytes top to bottom, so the right most
+// group is byte 0
+func leb128Explanation(title, value string, bitsValue uint64, enc []byte) Explanation {
+	w := byteLabelWidth(len(enc))
+	rows := []ExplainRow{{Label: fmt.Sprintf("%-*s", w, "Value"), Value: value, Bits: "  " + groups7(bitsValue, len(enc))}}
+	rows = append(rows, byteRows(enc, 0, w, continuationBits)...)
+	return Explanation{
+		Title: fmt.Sprintf("%s in %s [% x]", title, byteCount(len(enc)), enc),
+		Width: max(2, len(value)),
+		Rows:  rows,
+	}
+}
+
+// ExplainUvarint describes the unsigned LEB128 encoding of v
+func ExplainUvarint(v uint64) Explanation {
+	return leb128Explanation(fmt.Sprintf("Unsigned LEB128 of %d", v), fmt.Sprint(v), v, AppendUvarint(nil, v))
+}
+
+// ExplainVarint describes the signed LEB128 encoding of v, the groups being its two's complement bits
+func ExplainVarint(v int64) Explanation {
+	return leb128Explanation(fmt.Sprintf("Signed LEB128 of %d", v), fmt.Sprint(v), uint64(v), AppendVarint(nil, v))
+}
+
+// ExplainZigZag describes the zigzag encoding of v, step by step the way the shift explanations are
+func ExplainZigZag(v int64) Explanation {
+	res := ZigZagEncode(v)
+	w := len(fmt.Sprint(v))
+	return Explanation{
+		Title: fmt.Sprintf("ZigZag encoding %d as %d", v, res),
+		Width: max(w, len(fmt.Sprint(res))),
+		Rows: []ExplainRow{
+			{Label: "Binary", Value: fmt.Sprint(v), Bits: Binary(v)},
+			{Label: "v << 1", Value: fmt.Sprint(v << 1), Bits: Binary(v << 1)},
+			{Label: "v >>63", Value: fmt.Sprint(v >> 63), Bits: Binary(v >> 63)},
+			{Label: "XOR   ", Value: fmt.Sprint(res), Bits: Binary(res)},
+		},
+	}
+}
+
+// ExplainQUICVarint describes the QUIC encoding of v, with the length prefix split from the value bits
+func ExplainQUICVarint(v uint64) (Explanation, error) {
+	enc, err := AppendQUICVarint(nil, v)
+	if err != nil {
+		return Explanation{}, err
+	}
+	w := byteLabelWidth(len(enc))
+	valueBits := strings.Repeat(" ", 3) + fmt.Sprintf("%0*b", 8*len(enc)-2, v)
+	rows := []ExplainRow{{Label: fmt.Sprintf("%-*s", w, "Value"), Value: fmt.Sprint(v), Bits: valueBits}}
+	rows = append(rows, byteRows(enc, 0, w, func(i int, b byte) string {
+		if i == 0 {
+			return fmt.Sprintf("%02b %06b", b>>6, b&0x3F)
+		}
+		return fmt.Sprintf("%08b", b)
+	})...)
+	return Explanation{
+		Title: fmt.Sprintf("QUIC variable length integer %d in %s [% x]", v, byteCount(len(enc)), enc),
+		Width: max(2, len(fmt.Sprint(v))),
+		Rows:  rows,
+	}, nil
+}
+
+// ExplainBERLength describes the ASN.1 BER encoding of a length
+func ExplainBERLength(length int) (Explanation, error) {
+	enc, err := AppendBERLength(nil, length)
+	if err != nil {
+		return Explanation{}, err
+	}
+	w := byteLabelWidth(len(enc))
+	form := "short"
+	if len(enc) > 1 {
+		form = "long"
+	}
+	rows := []ExplainRow{{Label: fmt.Sprintf("%-*s", w, "Value"), Value: fmt.Sprint(length)}}
+	rows = append(rows, byteRows(enc, 0, w, func(i int, b byte) string {
+		if i == 0 {
+			// The top bit of the first byte says which form, and the rest is the length or the count
+			return fmt.Sprintf("%d %07b", b>>7, b&0x7F)
+		}
+		return fmt.Sprintf("%08b", b)
+	})...)
+	return Explanation{
+		Title: fmt.Sprintf("BER %s form length %d in %s [% x]", form, length, byteCount(len(enc)), enc),
+		Width: max(2, len(fmt.Sprint(length))),
+		Rows:  rows,
+	}, nil
+}
+
+// ExplainOID describes the encoding of an OID, each subidentifier in 7 bit groups above its bytes
+func ExplainOID(arcs []uint64) (Explanation, error) {
+	enc, err := AppendOID(nil, arcs)
+	if err != nil {
+		return Explanation{}, err
+	}
+	w := byteLabelWidth(len(enc))
+	first, _ := oidFirst(arcs)
+	subids := append([]uint64{first}, arcs[2:]...)
+	var rows []ExplainRow
+	var pos int
+	width := 2
+	for i, sub := range subids {
+		label := fmt.Sprintf("Arc %d", i+1)
+		if i == 0 {
+			label = fmt.Sprintf("%d.%d", arcs[0], arcs[1])
+		}
+		n := len(appendBase128(nil, sub))
+		rows = append(rows, ExplainRow{Label: fmt.Sprintf("%-*s", w, label), Value: fmt.Sprint(sub), Bits: "  " + groups7(sub, n)})
+		rows = append(rows, byteRows(enc[pos:pos+n], pos, w, continuationBits)...)
+		pos += n
+		width = max(width, len(fmt.Sprint(sub)))
+	}
+	return Explanation{
+		Title: fmt.Sprintf("OID %s in %s [% x]", FormatOID(arcs), byteCount(len(enc)), enc),
+		Width: width,
+		Rows:  rows,
+	}, nil
+}
+
+// varintCodec is one encoding the varint sub command knows, explain parses a value and describes its
+// encoding, decode decodes one from the start of its input
+type varintCodec struct {
+	explain func(arg string) (Explanation, error)
+	decode  func(b []byte) (string, int, error)
+}
+
+// varintCodecs are the encodings known to the varint sub command, by name
+var varintCodecs = map[string]varintCodec{
+	"uleb128": {
+		explain: func(arg string) (Explanation, error) {
+			v, err := strconv.ParseUint(arg, 0, 64)
+			return ExplainUvarint(v), err
+		},
+		decode: func(b []byte) (string, int, error) {
+			v, n, err := DecodeUvarint(b)
+			return fmt.Sprint(v), n, err
+		},
+	},
+	"sleb128": {
+		explain: func(arg string) (Explanation, error) {
+			v, err := strconv.ParseInt(arg, 0, 64)
+			return ExplainVarint(v), err
+		},
+		decode: func(b []byte) (string, int, error) {
+			v, n, err := DecodeVarint(b)
+			return fmt.Sprint(v), n, err
+		},
+	},
+	"zigzag": {
+		explain: func(arg string) (Explanation, error) {
+			v, err := strconv.ParseInt(arg, 0, 64)
+			return ExplainZigZag(v), err
+		},
+		decode: func(b []byte) (string, int, error) {
+			v, n, err := DecodeProtoSint(b)
+			return fmt.Sprint(v), n, err
+		},
+	},
+	"protosint": {
+		explain: func(arg string) (Explanation, error) {
+			v, err := strconv.ParseInt(arg, 0, 64)
+			e := ExplainUvarint(ZigZagEncode(v))
+			e.Title = fmt.Sprintf("Protobuf sint %d, zigzag %s", v, strings.TrimPrefix(e.Title, "Unsigned LEB128 of "))
+			return e, err
+		},
+		decode: func(b []byte) (string, int, error) {
+			v, n, err := DecodeProtoSint(b)
+			return fmt.Sprint(v), n, err
+		},
+	},
+	"protoint": {
+		explain: func(arg string) (Explanation, error) {
+			v, err := strconv.ParseInt(arg, 0, 64)
+			e := ExplainUvarint(uint64(v))
+			e.Title = fmt.Sprintf("Protobuf int %d, as unsigned %s", v, strings.TrimPrefix(e.Title, "Unsigned LEB128 of "))
+			return e, err
+		},
+		decode: func(b []byte) (string, int, error) {
+			v, n, err := DecodeProtoInt(b)
+			return fmt.Sprint(v), n, err
+		},
+	},
+	"quic": {
+		explain: func(arg string) (Explanation, error) {
+			v, err := strconv.ParseUint(arg, 0, 64)
+			if err != nil {
+				return Explanation{}, err
+			}
+			return ExplainQUICVarint(v)
+		},
+		decode: func(b []byte) (string, int, error) {
+			v, n, err := DecodeQUICVarint(b)
+			return fmt.Sprint(v), n, err
+		},
+	},
+	"berlen": {
+		explain: func(arg string) (Explanation, error) {
+			v, err := strconv.Atoi(arg)
+			if err != nil {
+				return Explanation{}, err
+			}
+			return ExplainBERLength(v)
+		},
+		decode: func(b []byte) (string, int, error) {
+			v, n, err := DecodeBERLength(b)
+			return fmt.Sprint(v), n, err
+		},
+	},
+	"oid": {
+		explain: func(arg string) (Explanation, error) {
+			arcs, err := ParseOID(arg)
+			if err != nil {
+				return Explanation{}, err
+			}
+			return ExplainOID(arcs)
+		},
+		decode: func(b []byte) (string, int, error) {
+			arcs, err := DecodeOID(b)
+			return FormatOID(arcs), len(b), err
+		},
+	},
+}
+
+// runVarint is the varint sub command, it explains the encoding of each argument, or with -decode
+// decodes each argument as hex
+func runVarint(args []string) error {
+	fs := flag.NewFlagSet("varint", flag.ContinueOnError)
+	kind := fs.String("type", "uleb128", "Encoding: uleb128, sleb128, zigzag, protoint, protosint, quic, berlen or oid")
+	decode := fs.Bool("decode", false, "Decode the arguments as hex instead of encoding them")
+	format := fs.String("format", "text", "Output format: text, markdown or html")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	codec, ok := varintCodecs[*kind]
+	if !ok {
+		return fmt.Errorf("unknown varint type %q", *kind)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: varint [-type name] [-decode] [-format text|markdown|html] <value> [<value> ...]")
+	}
+	ex, err := NewExplainer(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, arg := range fs.Args() {
+		if *decode {
+			b, err := parseHexBytes(arg)
+			if err != nil {
+				return err
+			}
+			v, n, err := codec.decode(b)
+			if err != nil {
+				return fmt.Errorf("%s: %v", arg, err)
+			}
+			if err := ex.Note(fmt.Sprintf("[% x] is %s using %d of %s", b, v, n, byteCount(len(b)))); err != nil {
+				return err
+			}
+			continue
+		}
+		e, err := codec.explain(arg)
+		if err != nil {
+			return err
+		}
+		if err := ex.Explain(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+// Variable length integer test routines
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+// mustHex decodes a hex string for test vectors
+func mustHex(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUvarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 300, 16383, 16384, 1 << 56, math.MaxUint64} {
+		enc := AppendUvarint(nil, v)
+		if want := binary.AppendUvarint(nil, v); !bytes.Equal(enc, want) {
+			t.Errorf("%d encoded as % x instead of % x", v, enc, want)
+		}
+		if got, n, err := DecodeUvarint(enc); err != nil || got != v || n != len(enc) {
+			t.Errorf("% x decoded as %d, %d, %v", enc, got, n, err)
+		}
+	}
+	tests := []struct {
+		in  string
+		err error
+	}{
+		{"", ErrVarintTruncated},
+		{"ac", ErrVarintTruncated},
+		{"8000", ErrVarintNonMinimal},
+		{"ac8200", ErrVarintNonMinimal},
+		{"ffffffffffffffffff02", ErrVarintOverflow},
+		{"ffffffffffffffffff81", ErrVarintOverflow},
+	}
+	for _, tt := range tests {
+		if _, _, err := DecodeUvarint(mustHex(t, tt.in)); err != tt.err {
+			t.Errorf("%s: got %v instead of %v", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestVarint(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want string
+	}{
+		{0, "00"},
+		{-1, "7f"},
+		{63, "3f"},
+		{64, "c000"},
+		{-64, "40"},
+		{-65, "bf7f"},
+		{-129, "ff7e"},
+		{624485, "e58e26"},
+		{-123456, "c0bb78"},
+		{math.MaxInt64, "ffffffffffffffffff00"},
+		{math.MinInt64, "8080808080808080807f"},
+	}
+	for _, tt := range tests {
+		enc := AppendVarint(nil, tt.v)
+		if hex.EncodeToString(enc) != tt.want {
+			t.Errorf("%d encoded as % x instead of %s", tt.v, enc, tt.want)
+		}
+		if got, n, err := DecodeVarint(enc); err != nil || got != tt.v || n != len(enc) {
+			t.Errorf("% x decoded as %d, %d, %v", enc, got, n, err)
+		}
+	}
+	errs := []struct {
+		in  string
+		err error
+	}{
+		{"80", ErrVarintTruncated},
+		{"8000", ErrVarintNonMinimal},
+		{"ff7f", ErrVarintNonMinimal},
+		{"c07f", ErrVarintNonMinimal},
+		{"ffffffffffffffffff01", ErrVarintOverflow},
+	}
+	for _, tt := range errs {
+		if _, _, err := DecodeVarint(mustHex(t, tt.in)); err != tt.err {
+			t.Errorf("%s: got %v instead of %v", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestZigZag(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want uint64
+	}{
+		{0, 0}, {-1, 1}, {1, 2}, {-2, 3}, {2147483647, 4294967294}, {-2147483648, 4294967295},
+		{math.MaxInt64, math.MaxUint64 - 1}, {math.MinInt64, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		if got := ZigZagEncode(tt.v); got != tt.want {
+			t.Errorf("ZigZagEncode(%d) = %d instead of %d", tt.v, got, tt.want)
+		}
+		if got := ZigZagDecode(tt.want); got != tt.v {
+			t.Errorf("ZigZagDecode(%d) = %d instead of %d", tt.want, got, tt.v)
+		}
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	if enc := AppendProtoInt(nil, -1); hex.EncodeToString(enc) != "ffffffffffffffffff01" {
+		t.Errorf("int -1 encoded as % x", enc)
+	}
+	if enc := AppendProtoSint(nil, -1); hex.EncodeToString(enc) != "01" {
+		t.Errorf("sint -1 encoded as % x", enc)
+	}
+	if v, _, err := DecodeProtoSint(mustHex(t, "03")); err != nil || v != -2 {
+		t.Errorf("sint 03 decoded as %d, %v", v, err)
+	}
+
+	// Field 1 as a varint, then field 2 as a length delimited string, from the protobuf encoding guide
+	msg, _ := AppendProtoTag(nil, 1, ProtoVarint)
+	msg = AppendProtoInt(msg, 150)
+	msg, _ = AppendProtoTag(msg, 2, ProtoLen)
+	if hex.EncodeToString(msg) != "089601"+"12" {
+		t.Errorf("message encoded as % x", msg)
+	}
+	field, wire, n, err := DecodeProtoTag(msg[3:])
+	if err != nil || field != 2 || wire != ProtoLen || n != 1 {
+		t.Errorf("tag decoded as %d, %d, %d, %v", field, wire, n, err)
+	}
+	if _, err := AppendProtoTag(nil, 0, ProtoVarint); err == nil {
+		t.Errorf("field 0 should be rejected")
+	}
+	if _, err := AppendProtoTag(nil, maxProtoField+1, ProtoVarint); err == nil {
+		t.Errorf("field %d should be rejected", maxProtoField+1)
+	}
+	if _, _, _, err := DecodeProtoTag(mustHex(t, "07")); err == nil {
+		t.Errorf("a tag for field 0 should be rejected")
+	}
+}
+
+func TestQUICVarint(t *testing.T) {
+	// The examples from RFC 9000 appendix A.1
+	tests := []struct {
+		enc string
+		v   uint64
+	}{
+		{"c2197c5eff14e88c", 151288809941952652},
+		{"9d7f3e7d", 494878333},
+		{"7bbd", 15293},
+		{"25", 37},
+	}
+	for _, tt := range tests {
+		enc, err := AppendQUICVarint(nil, tt.v)
+		if err != nil || hex.EncodeToString(enc) != tt.enc {
+			t.Errorf("%d encoded as % x, %v instead of %s", tt.v, enc, err, tt.enc)
+		}
+		if v, n, err := DecodeQUICVarint(mustHex(t, tt.enc)); err != nil || v != tt.v || n != len(enc) {
+			t.Errorf("%s decoded as %d, %d, %v", tt.enc, v, n, err)
+		}
+	}
+	// The RFC notes 4025 also decodes to 37, which is the non-minimal encoding we reject
+	if _, _, err := DecodeQUICVarint(mustHex(t, "4025")); err != ErrVarintNonMinimal {
+		t.Errorf("4025 gave %v instead of non-minimal", err)
+	}
+	if _, _, err := DecodeQUICVarint(mustHex(t, "9d7f3e")); err != ErrVarintTruncated {
+		t.Errorf("expected truncated, got %v", err)
+	}
+	if _, err := AppendQUICVarint(nil, MaxQUICVarint+1); err != ErrQUICRange {
+		t.Errorf("expected ErrQUICRange, got %v", err)
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	tests := []struct {
+		length int
+		enc    string
+	}{
+		{0, "00"}, {127, "7f"}, {128, "8180"}, {255, "81ff"}, {256, "820100"}, {435, "8201b3"},
+		{math.MaxInt64, "887fffffffffffffff"},
+	}
+	for _, tt := range tests {
+		enc, err := AppendBERLength(nil, tt.length)
+		if err != nil || hex.EncodeToString(enc) != tt.enc {
+			t.Errorf("%d encoded as % x, %v instead of %s", tt.length, enc, err, tt.enc)
+		}
+		if v, n, err := DecodeBERLength(enc); err != nil || v != tt.length || n != len(enc) {
+			t.Errorf("% x decoded as %d, %d, %v", enc, v, n, err)
+		}
+	}
+	errs := []struct {
+		in  string
+		err error
+	}{
+		{"", ErrVarintTruncated},
+		{"80", ErrBERIndefinite},
+		{"82", ErrVarintTruncated},
+		{"8201", ErrVarintTruncated},
+		{"817f", ErrVarintNonMinimal},
+		{"820080", ErrVarintNonMinimal},
+		{"8980000000000000000000", ErrVarintOverflow},
+		{"888000000000000000", ErrVarintOverflow},
+	}
+	for _, tt := range errs {
+		b, _ := hex.DecodeString(tt.in)
+		if _, _, err := DecodeBERLength(b); err != tt.err {
+			t.Errorf("%s: got %v instead of %v", tt.in, err, tt.err)
+		}
+	}
+	if _, _, err := DecodeBERLength([]byte{0xFF}); err == nil {
+		t.Errorf("the reserved ff form should be rejected")
+	}
+	if _, err := AppendBERLength(nil, -1); err == nil {
+		t.Errorf("a negative length should be rejected")
+	}
+}
+
+func TestOID(t *testing.T) {
+	tests := []struct {
+		oid string
+		enc string
+	}{
+		{"1.2.840.113549", "2a864886f70d"},
+		{"1.3.6.1.4.1.311.21.20", "2b0601040182371514"},
+		{"0.0", "00"},
+		{"2.100.3", "813403"},
+		{"2.999", "8837"},
+	}
+	for _, tt := range tests {
+		arcs, err := ParseOID(tt.oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := AppendOID(nil, arcs)
+		if err != nil || hex.EncodeToString(enc) != tt.enc {
+			t.Errorf("%s encoded as % x, %v instead of %s", tt.oid, enc, err, tt.enc)
+		}
+		if got, err := DecodeOID(enc); err != nil || FormatOID(got) != tt.oid {
+			t.Errorf("% x decoded as %v, %v", enc, got, err)
+		}
+	}
+	for _, s := range []string{"1", "3.1", "1.40", "1.a", "", "1..2"} {
+		if _, err := ParseOID(s); err == nil {
+			t.Errorf("%q should not parse", s)
+		}
+	}
+	errs := []struct {
+		in  string
+		err error
+	}{
+		{"", ErrVarintTruncated},
+		{"2a86", ErrVarintTruncated},
+		{"2a8001", ErrVarintNonMinimal},
+		{"2a81808080808080808080807f", ErrVarintOverflow},
+	}
+	for _, tt := range errs {
+		b, _ := hex.DecodeString(tt.in)
+		if _, err := DecodeOID(b); err != tt.err {
+			t.Errorf("%s: got %v instead of %v", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestExplainVarint(t *testing.T) {
+	var sb strings.Builder
+	te := &TextExplainer{W: &sb}
+	_ = te.Explain(ExplainUvarint(300))
+	e, _ := ExplainQUICVarint(15293)
+	_ = te.Explain(e)
+	want := `Unsigned LEB128 of 300 in 2 bytes [ac 02]
+Value  [300]:   0000010 0101100
+Byte 0 [ ac]: 1 0101100
+Byte 1 [ 02]: 0 0000010
+QUIC variable length integer 15293 in 2 bytes [7b bd]
+Value  [15293]:    11101110111101
+Byte 0 [   7b]: 01 111011
+Byte 1 [   bd]: 10111101
+`
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+	e, _ = ExplainOID([]uint64{1, 2, 840})
+	if len(e.Rows) != 5 || e.Rows[2].Label != "Arc 2 " || e.Rows[4].Label != "Byte 2" {
+		t.Errorf("unexpected OID rows %+v", e.Rows)
+	}
+	if _, err := ExplainBERLength(-1); err == nil {
+		t.Errorf("a negative length should be rejected")
+	}
+}
+
+// quicPadded encodes v as a QUIC variable length integer of n bytes, whether or not it needs them
+func quicPadded(v uint64, n int) []byte {
+	var prefix uint64
+	for 1<<prefix < n {
+		prefix++
+	}
+	v |= prefix << (8*n - 2)
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(v >> (8 * (n - 1 - i)))
+	}
+	return b
+}
+
+// checkPrefixes checks that every proper prefix of enc is rejected as truncated
+func checkPrefixes(t *testing.T, name string, enc []byte, decode func([]byte) error) {
+	t.Helper()
+	for i := 0; i < len(enc); i++ {
+		if err := decode(enc[:i]); err != ErrVarintTruncated {
+			t.Fatalf("%s: prefix % x of % x gave %v instead of truncated", name, enc[:i], enc, err)
+		}
+	}
+}
+
+// FuzzVarintRoundTrip encodes random values with every encoding, checking they decode to the same value,
+// that every truncation of them is rejected, and that padding them out to a longer form is rejected
+func FuzzVarintRoundTrip(f *testing.F) {
+	f.Add(uint64(300), int64(-129))
+	f.Add(uint64(0), int64(0))
+	f.Add(uint64(math.MaxUint64), int64(math.MinInt64))
+	f.Add(uint64(MaxQUICVarint), int64(math.MaxInt64))
+	f.Fuzz(func(t *testing.T, u uint64, s int64) {
+		enc := AppendUvarint(nil, u)
+		if v, n, err := DecodeUvarint(enc); err != nil || v != u || n != len(enc) {
+			t.Fatalf("uleb128 %d: % x decoded as %d, %d, %v", u, enc, v, n, err)
+		}
+		checkPrefixes(t, "uleb128", enc, func(b []byte) error { _, _, err := DecodeUvarint(b); return err })
+		if len(enc) < 10 {
+			padded := append(bytes.Clone(enc), 0)
+			padded[len(enc)-1] |= 0x80
+			if _, _, err := DecodeUvarint(padded); err != ErrVarintNonMinimal {
+				t.Fatalf("uleb128 % x gave %v instead of non-minimal", padded, err)
+			}
+		}
+
+		enc = AppendVarint(nil, s)
+		if v, n, err := DecodeVarint(enc); err != nil || v != s || n != len(enc) {
+			t.Fatalf("sleb128 %d: % x decoded as %d, %d, %v", s, enc, v, n, err)
+		}
+		checkPrefixes(t, "sleb128", enc, func(b []byte) error { _, _, err := DecodeVarint(b); return err })
+		if len(enc) < 10 {
+			var sign byte
+			if enc[len(enc)-1]&0x40 != 0 {
+				sign = 0x7F
+			}
+			padded := append(bytes.Clone(enc), sign)
+			padded[len(enc)-1] |= 0x80
+			if _, _, err := DecodeVarint(padded); err != ErrVarintNonMinimal {
+				t.Fatalf("sleb128 % x gave %v instead of non-minimal", padded, err)
+			}
+		}
+
+		enc = AppendProtoSint(nil, s)
+		if v, _, err := DecodeProtoSint(enc); err != nil || v != s {
+			t.Fatalf("sint %d: % x decoded as %d, %v", s, enc, v, err)
+		}
+
+		q := u & MaxQUICVarint
+		enc, err := AppendQUICVarint(nil, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, n, err := DecodeQUICVarint(enc); err != nil || v != q || n != len(enc) {
+			t.Fatalf("quic %d: % x decoded as %d, %d, %v", q, enc, v, n, err)
+		}
+		checkPrefixes(t, "quic", enc, func(b []byte) error { _, _, err := DecodeQUICVarint(b); return err })
+		for n := len(enc) * 2; n <= 8; n *= 2 {
+			if _, _, err := DecodeQUICVarint(quicPadded(q, n)); err != ErrVarintNonMinimal {
+				t.Fatalf("quic %d in %d bytes gave %v instead of non-minimal", q, n, err)
+			}
+		}
+
+		length := int(u & math.MaxInt64)
+		enc, err = AppendBERLength(nil, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, n, err := DecodeBERLength(enc); err != nil || v != length || n != len(enc) {
+			t.Fatalf("ber %d: % x decoded as %d, %d, %v", length, enc, v, n, err)
+		}
+		checkPrefixes(t, "ber", enc, func(b []byte) error { _, _, err := DecodeBERLength(b); return err })
+		// Pad with a leading zero byte, turning the short form in to the long form
+		var padded []byte
+		if len(enc) == 1 {
+			padded = []byte{0x81, enc[0]}
+		} else if len(enc) < 9 {
+			padded = append([]byte{enc[0] + 1, 0}, enc[1:]...)
+		}
+		if _, _, err := DecodeBERLength(padded); padded != nil && err != ErrVarintNonMinimal {
+			t.Fatalf("ber % x gave %v instead of non-minimal", padded, err)
+		}
+
+		arcs := []uint64{2, u >> 1, uint64(s)}
+		enc, err = AppendOID(nil, arcs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := DecodeOID(enc); err != nil || FormatOID(got) != FormatOID(arcs) {
+			t.Fatalf("oid %v: % x decoded as %v, %v", arcs, enc, got, err)
+		}
+		if _, err := DecodeOID(enc[:len(enc)-1]); err != ErrVarintTruncated && err != nil {
+			t.Fatalf("oid % x truncated gave %v", enc, err)
+		}
+		if _, err := DecodeOID(append([]byte{0x80}, enc...)); err != ErrVarintNonMinimal {
+			t.Fatalf("oid with a leading zero group gave %v instead of non-minimal", err)
+		}
+	})
+}
+
+// FuzzVarintDecode decodes arbitrary input with every decoder, checking none of them panic, that
+// anything they accept encodes back to exactly the bytes they read, which is only true when every
+// encoding they accept is minimal, and that the LEB128 decoder agrees with encoding/binary
+func FuzzVarintDecode(f *testing.F) {
+	for _, s := range []string{"ac02", "8000", "ffffffffffffffffff01", "c2197c5eff14e88c", "4025", "8201b3", "2a864886f70d"} {
+		b, _ := hex.DecodeString(s)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if v, n, err := DecodeUvarint(data); err == nil {
+			if enc := AppendUvarint(nil, v); !bytes.Equal(enc, data[:n]) {
+				t.Fatalf("uleb128 % x decoded as %d which encodes as % x", data[:n], v, enc)
+			}
+			if sv, sn := binary.Uvarint(data); sv != v || sn != n {
+				t.Fatalf("uleb128 % x decoded as %d, %d but encoding/binary gave %d, %d", data, v, n, sv, sn)
+			}
+		}
+		if v, n, err := DecodeVarint(data); err == nil {
+			if enc := AppendVarint(nil, v); !bytes.Equal(enc, data[:n]) {
+				t.Fatalf("sleb128 % x decoded as %d which encodes as % x", data[:n], v, enc)
+			}
+		}
+		if v, n, err := DecodeQUICVarint(data); err == nil {
+			if enc, _ := AppendQUICVarint(nil, v); !bytes.Equal(enc, data[:n]) {
+				t.Fatalf("quic % x decoded as %d which encodes as % x", data[:n], v, enc)
+			}
+		}
+		if v, n, err := DecodeBERLength(data); err == nil {
+			if enc, _ := AppendBERLength(nil, v); !bytes.Equal(enc, data[:n]) {
+				t.Fatalf("ber % x decoded as %d which encodes as % x", data[:n], v, enc)
+			}
+		}
+		if arcs, err := DecodeOID(data); err == nil {
+			if enc, err := AppendOID(nil, arcs); err != nil || !bytes.Equal(enc, data) {
+				t.Fatalf("oid % x decoded as %v which encodes as % x, %v", data, arcs, enc, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,138 @@
+// Benchmark harness test routines
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestGeometricSizes(t *testing.T) {
+	tests := []struct {
+		min, max int
+		factor   float64
+		want     []int
+		err      bool
+	}{
+		{10, 80, 2, []int{10, 20, 40, 80}, false},
+		{10, 100, 2, []int{10, 20, 40, 80, 100}, false},
+		{2, 5, 1.2, []int{2, 3, 4, 5}, false},
+		{50, 50, 2, []int{50}, false},
+		{1, 100, 2, nil, true},
+		{10, 100, 1, nil, true},
+		{100, 10, 2, nil, true},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d,%d,%.1f", tt.min, tt.max, tt.factor)
+		t.Run(testName, func(t *testing.T) {
+			ans, err := GeometricSizes(tt.min, tt.max, tt.factor)
+			if (err != nil) != tt.err || fmt.Sprint(ans) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v [%v] instead of %v", ans, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	times := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p := percentile(times, 50); p != 5 {
+		t.Errorf("median was %d instead of 5", p)
+	}
+	if p := percentile(times, 95); p != 10 {
+		t.Errorf("p95 was %d instead of 10", p)
+	}
+	if p := percentile([]int64{7}, 95); p != 7 {
+		t.Errorf("p95 of a single time was %d instead of 7", p)
+	}
+}
+
+func TestFitExponent(t *testing.T) {
+	// Timings that grow exactly as size^2 should fit an exponent of 2
+	var results []BenchResult
+	for _, s := range []int{10, 20, 40, 80} {
+		results = append(results, BenchResult{Size: s, MedianNs: int64(3 * s * s)})
+	}
+	if exp := fitExponent(results); math.Abs(exp-2) > 1e-9 {
+		t.Errorf("got exponent %f instead of 2", exp)
+	}
+	if exp := fitExponent(results[:1]); exp != 0 {
+		t.Errorf("a single point should give 0, got %f", exp)
+	}
+}
+
+func TestRunBenchmarks(t *testing.T) {
+	calls := 0
+	algs := []BenchAlgorithm{{Name: "counter", Run: func(n int) { calls++ }}}
+	report, err := RunBenchmarks(algs, BenchOptions{Min: 10, Max: 40, Factor: 2, Reps: 3, Label: "v1"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls != 9 || len(report.Results) != 3 {
+		t.Errorf("got %d calls and %d results instead of 9 and 3", calls, len(report.Results))
+	}
+	if _, err := RunBenchmarks(algs, BenchOptions{Min: 10, Max: 40, Factor: 2}); err == nil {
+		t.Errorf("expected an error with no repetitions")
+	}
+}
+
+// square is an algorithm whose time grows as the square of its size
+var square = BenchAlgorithm{Name: "square", Run: func(n int) {
+	total := 0
+	for i := range n {
+		for j := range n {
+			total += i ^ j
+		}
+	}
+	_ = total
+}}
+
+func TestWriteBenchReport(t *testing.T) {
+	report, _ := RunBenchmarks([]BenchAlgorithm{square}, BenchOptions{Min: 100, Max: 400, Factor: 2, Reps: 2, Label: "test"})
+
+	var buf bytes.Buffer
+	if err := WriteBenchReport(&buf, report, "csv"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 1+len(report.Results) || rows[1][0] != "test" {
+		t.Errorf("unexpected csv output %v [%v]", rows, err)
+	}
+
+	buf.Reset()
+	if err := WriteBenchReport(&buf, report, "json"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var decoded BenchReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Results) != len(report.Results) {
+		t.Errorf("json did not round trip: %v", err)
+	}
+
+	buf.Reset()
+	if err := WriteBenchReport(&buf, report, "text"); err != nil || !strings.Contains(buf.String(), "square: time grows") {
+		t.Errorf("unexpected text output %q [%v]", buf.String(), err)
+	}
+	if err := WriteBenchReport(&buf, report, "xml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestBenchFlags(t *testing.T) {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	f := RegisterBenchFlags(fs, 10, 1000)
+	if err := fs.Parse([]string{"-bench-max", "20", "-bench-reps", "1", "-bench-format", "csv", "-bench-label", "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f.Run(&buf, []BenchAlgorithm{square}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 3 || rows[1][0] != "v2" || rows[2][2] != "20" {
+		t.Errorf("unexpected csv output %v [%v]", rows, err)
+	}
+}
@@ -48,8 +48,16 @@ func main() {
 	Maximum := flag.Int("max", 4000, "Maximum number in range to search for primes")
 	DumpPrimes := flag.Bool("dump-prime", false, "Dump the list of prime numbers located")
 	TimeExecution := flag.Bool("timing", false, "Dump the execution and prime the results")
+	Bench := flag.Bool("bench", false, "Benchmark the prime algorithm over a range of sizes instead of searching")
+	BenchOpts := RegisterBenchFlags(flag.CommandLine, 1000, 32000)
 	flag.Parse()
 
+	// Benchmarking searches from 2 up to each size in turn, so it ignores the minimum and maximum
+	if *Bench {
+		runBench(BenchOpts)
+		return
+	}
+
 	// Note: flags are always pointers, so we have to de-reference them, hence the asterix
 	if *Minimum > *Maximum || *Minimum == *Maximum {
 		fmt.Printf("Minimum in range must be smaller than range maximum")
@@ -0,0 +1,233 @@
+// Benchmark harness for the prime number algorithms
+// Andrew Alston
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// benchAlgorithms are the algorithms the -bench flag will time, each one is run with the size as its maximum
+var benchAlgorithms = []BenchAlgorithm{
+	{Name: "sieve", Run: func(n int) { Sieve(n) }},
+}
+
+// BenchAlgorithm is a named function to benchmark, Run is called with the size of the problem
+type BenchAlgorithm struct {
+	Name string
+	Run  func(n int)
+}
+
+// BenchOptions describes a benchmark run.  We run every algorithm on sizes from Min to Max, multiplying
+// the size by Factor each time, and repeat each size Reps times
+type BenchOptions struct {
+	Min, Max int
+	Factor   float64
+	Reps     int
+	Label    string
+}
+
+// BenchResult holds the timings and allocations for one algorithm at one size.  Allocations and bytes are
+// averaged per run, and Exponent is the fitted complexity exponent for the algorithm across all sizes
+type BenchResult struct {
+	Algorithm string  `json:"algorithm"`
+	Size      int     `json:"size"`
+	Reps      int     `json:"reps"`
+	MinNs     int64   `json:"min_ns"`
+	MedianNs  int64   `json:"median_ns"`
+	P95Ns     int64   `json:"p95_ns"`
+	Allocs    uint64  `json:"allocs_per_op"`
+	Bytes     uint64  `json:"bytes_per_op"`
+	Exponent  float64 `json:"exponent"`
+}
+
+// BenchReport is the full output of a benchmark run.  Label lets us tag a run with a version or commit so
+// we can compare reports from different builds
+type BenchReport struct {
+	Label     string             `json:"label,omitempty"`
+	GoVersion string             `json:"go_version"`
+	Time      time.Time          `json:"time"`
+	Results   []BenchResult      `json:"results"`
+	Exponents map[string]float64 `json:"exponents"`
+}
+
+// GeometricSizes returns the sizes from min to max, multiplying by factor each time.  max is always
+// included as the final size
+func GeometricSizes(min, max int, factor float64) ([]int, error) {
+	if min < 2 || max < min || factor <= 1 {
+		return nil, fmt.Errorf("need 2 <= min <= max and a factor greater than 1")
+	}
+	var res []int
+	for s := float64(min); int(s) < max; s *= factor {
+		if len(res) == 0 || res[len(res)-1] != int(s) {
+			res = append(res, int(s))
+		}
+	}
+	return append(res, max), nil
+}
+
+// RunBenchmarks times every algorithm against every size in opts
+func RunBenchmarks(algs []BenchAlgorithm, opts BenchOptions) (*BenchReport, error) {
+	if opts.Reps < 1 {
+		return nil, fmt.Errorf("need at least one repetition")
+	}
+	sizes, err := GeometricSizes(opts.Min, opts.Max, opts.Factor)
+	if err != nil {
+		return nil, err
+	}
+	report := &BenchReport{
+		Label:     opts.Label,
+		GoVersion: runtime.Version(),
+		Time:      time.Now().UTC(),
+		Exponents: make(map[string]float64),
+	}
+	for _, alg := range algs {
+		var results []BenchResult
+		for _, size := range sizes {
+			results = append(results, measure(alg, size, opts.Reps))
+		}
+		exp := fitExponent(results)
+		for i := range results {
+			results[i].Exponent = exp
+		}
+		report.Exponents[alg.Name] = exp
+		report.Results = append(report.Results, results...)
+	}
+	return report, nil
+}
+
+// measure runs a single algorithm at a single size reps times.  runtime.MemStats gives us the count of
+// heap allocations and bytes allocated, we read it either side of each run and keep the difference
+func measure(alg BenchAlgorithm, size, reps int) BenchResult {
+	var before, after runtime.MemStats
+	times := make([]int64, reps)
+	var allocs, bytes uint64
+	for i := range reps {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		alg.Run(size)
+		times[i] = int64(time.Since(start))
+		runtime.ReadMemStats(&after)
+		allocs += after.Mallocs - before.Mallocs
+		bytes += after.TotalAlloc - before.TotalAlloc
+	}
+	slices.Sort(times)
+	return BenchResult{
+		Algorithm: alg.Name,
+		Size:      size,
+		Reps:      reps,
+		MinNs:     times[0],
+		MedianNs:  percentile(times, 50),
+		P95Ns:     percentile(times, 95),
+		Allocs:    allocs / uint64(reps),
+		Bytes:     bytes / uint64(reps),
+	}
+}
+
+// percentile returns the p-th percentile of the sorted times using the nearest rank method
+func percentile(sorted []int64, p int) int64 {
+	rank := int(math.Ceil(float64(p)/100*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+// fitExponent fits time = c * size^k to the median timings and returns k.  Taking logs of both sides
+// gives log(time) = log(c) + k*log(size), which is a straight line, so k is the least squares slope
+func fitExponent(results []BenchResult) float64 {
+	var n, sx, sy, sxx, sxy float64
+	for _, r := range results {
+		if r.MedianNs <= 0 {
+			continue
+		}
+		x, y := math.Log(float64(r.Size)), math.Log(float64(r.MedianNs))
+		n++
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	if n < 2 || n*sxx-sx*sx == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / (n*sxx - sx*sx)
+}
+
+// WriteBenchReport writes the report to w as csv, json or a text table
+func WriteBenchReport(w io.Writer, report *BenchReport, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"label", "algorithm", "size", "reps", "min_ns", "median_ns", "p95_ns",
+			"allocs_per_op", "bytes_per_op", "exponent"})
+		for _, r := range report.Results {
+			_ = cw.Write([]string{report.Label, r.Algorithm, strconv.Itoa(r.Size), strconv.Itoa(r.Reps),
+				strconv.FormatInt(r.MinNs, 10), strconv.FormatInt(r.MedianNs, 10), strconv.FormatInt(r.P95Ns, 10),
+				strconv.FormatUint(r.Allocs, 10), strconv.FormatUint(r.Bytes, 10), strconv.FormatFloat(r.Exponent, 'f', 3, 64)})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "text":
+		fmt.Fprintf(w, "%-16s %10s %14s %14s %14s %10s %12s\n", "Algorithm", "Size", "Min", "Median", "P95", "Allocs", "Bytes")
+		for _, r := range report.Results {
+			fmt.Fprintf(w, "%-16s %10d %14s %14s %14s %10d %12d\n", r.Algorithm, r.Size,
+				time.Duration(r.MinNs), time.Duration(r.MedianNs), time.Duration(r.P95Ns), r.Allocs, r.Bytes)
+		}
+		for i, r := range report.Results {
+			if i == 0 || report.Results[i-1].Algorithm != r.Algorithm {
+				fmt.Fprintf(w, "%s: time grows as size^%.2f\n", r.Algorithm, r.Exponent)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected text, csv or json", format)
+	}
+}
+
+// BenchFlags holds the values of the benchmark flags
+type BenchFlags struct {
+	min, max *int
+	factor   *float64
+	reps     *int
+	format   *string
+	label    *string
+}
+
+// RegisterBenchFlags adds the -bench-* flags to fs, with the default range of sizes suiting the algorithms the
+// program has
+func RegisterBenchFlags(fs *flag.FlagSet, min, max int) *BenchFlags {
+	return &BenchFlags{
+		min:    fs.Int("bench-min", min, "Smallest size to benchmark"),
+		max:    fs.Int("bench-max", max, "Largest size to benchmark"),
+		factor: fs.Float64("bench-factor", 2, "Multiply the benchmark size by this much at each step"),
+		reps:   fs.Int("bench-reps", 5, "Number of times to repeat each benchmark size"),
+		format: fs.String("bench-format", "text", "Benchmark output format: text, csv or json"),
+		label:  fs.String("bench-label", "", "Label to tag benchmark results with, such as a version or commit"),
+	}
+}
+
+// Run benchmarks the algorithms with the options given on the command line and writes the report to w
+func (f *BenchFlags) Run(w io.Writer, algs []BenchAlgorithm) error {
+	report, err := RunBenchmarks(algs, BenchOptions{Min: *f.min, Max: *f.max, Factor: *f.factor, Reps: *f.reps, Label: *f.label})
+	if err != nil {
+		return err
+	}
+	return WriteBenchReport(w, report, *f.format)
+}
+
+// runBench benchmarks benchAlgorithms with the options from the -bench-* flags
+func runBench(f *BenchFlags) {
+	if err := f.Run(os.Stdout, benchAlgorithms); err != nil {
+		fmt.Printf("Error running benchmarks: %v\n", err)
+	}
+}
@@ -0,0 +1,624 @@
+// Modular arithmetic routines that work alongside the prime number code
+// Andrew Alston
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// factorBaseLimit is the size of the sieve we use as a factor base.  Trial division by every prime up to
+// this limit is quick, and whatever is left over is either prime or handed to Pollard's rho
+const factorBaseLimit = 1 << 16
+
+// factorBase holds the primes from Sieve used for trial division, it is populated the first time it is used
+var factorBase *PrimeSet
+
+// getFactorBase returns the factor base, running Sieve if we haven't done so yet
+func getFactorBase() *PrimeSet {
+	if factorBase == nil {
+		factorBase = Sieve(factorBaseLimit)
+	}
+	return factorBase
+}
+
+// mulMod returns a*b mod m without overflowing.  bits.Mul64 gives us the full 128 bit product as two
+// 64 bit halves, and bits.Rem64 divides that 128 bit number by m
+func mulMod(a, b, m uint64) uint64 {
+	hi, lo := bits.Mul64(a%m, b%m)
+	return bits.Rem64(hi, lo, m)
+}
+
+// addMod returns a+b mod m without overflowing, a and b must already be smaller than m
+func addMod(a, b, m uint64) uint64 {
+	if a >= m-b {
+		return a - (m - b)
+	}
+	return a + b
+}
+
+// subMod returns a-b mod m, a and b must already be smaller than m
+func subMod(a, b, m uint64) uint64 {
+	if a >= b {
+		return a - b
+	}
+	return m - (b - a)
+}
+
+// gcd returns the greatest common divisor of a and b using Euclid's algorithm
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// ModExp returns base^exp mod m using square and multiply.  We walk the exponent from the right most bit,
+// squaring the base at every step and multiplying it into the result whenever the bit is set
+func ModExp(base, exp, m uint64) uint64 {
+	if m == 1 {
+		return 0
+	}
+	res := uint64(1)
+	base %= m
+	for exp > 0 {
+		if exp&1 == 1 {
+			res = mulMod(res, base, m)
+		}
+		base = mulMod(base, base, m)
+		exp >>= 1
+	}
+	return res
+}
+
+// ModExpBig returns base^exp mod m.  A negative exp raises the inverse of base, so nil is returned if
+// base has no inverse modulo m
+func ModExpBig(base, exp, m *big.Int) *big.Int {
+	return new(big.Int).Exp(base, exp, m)
+}
+
+// ExtendedGCD returns the greatest common divisor g of a and b along with x and y such that a*x + b*y = g
+func ExtendedGCD(a, b int64) (g, x, y int64) {
+	// We keep the remainders in r and the coefficients that produce each remainder in s and t
+	oldR, r := a, b
+	oldS, s := int64(1), int64(0)
+	oldT, t := int64(0), int64(1)
+	for r != 0 {
+		q := oldR / r
+		oldR, r = r, oldR-q*r
+		oldS, s = s, oldS-q*s
+		oldT, t = t, oldT-q*t
+	}
+	if oldR < 0 {
+		return -oldR, -oldS, -oldT
+	}
+	return oldR, oldS, oldT
+}
+
+// ExtendedGCDBig returns the greatest common divisor g of a and b along with x and y such that a*x + b*y = g
+func ExtendedGCDBig(a, b *big.Int) (g, x, y *big.Int) {
+	x, y = new(big.Int), new(big.Int)
+	g = new(big.Int).GCD(x, y, a, b)
+	return g, x, y
+}
+
+// ModInverse returns x such that a*x mod m == 1 using the extended Euclidean algorithm.  Since the
+// coefficients can go negative and won't fit in an int64 for large m, we track them modulo m instead.
+// An error is returned if a and m aren't coprime, since no inverse exists
+func ModInverse(a, m uint64) (uint64, error) {
+	if m < 2 {
+		return 0, fmt.Errorf("modulus must be at least 2")
+	}
+	r0, r1 := m, a%m
+	t0, t1 := uint64(0), uint64(1)
+	for r1 != 0 {
+		q := r0 / r1
+		r0, r1 = r1, r0-q*r1
+		t0, t1 = t1, subMod(t0, mulMod(q, t1, m), m)
+	}
+	if r0 != 1 {
+		return 0, fmt.Errorf("%d has no inverse modulo %d", a, m)
+	}
+	return t0, nil
+}
+
+// ModInverseBig returns x such that a*x mod m == 1, or an error if no inverse exists
+func ModInverseBig(a, m *big.Int) (*big.Int, error) {
+	if m.Cmp(big.NewInt(2)) < 0 {
+		return nil, fmt.Errorf("modulus must be at least 2")
+	}
+	x := new(big.Int).ModInverse(a, m)
+	if x == nil {
+		return nil, fmt.Errorf("%v has no inverse modulo %v", a, m)
+	}
+	return x, nil
+}
+
+// CRT solves the system x = residues[i] mod moduli[i] using the Chinese Remainder Theorem, returning x
+// and the combined modulus.  The moduli don't have to be coprime, but an error is returned if the system
+// has no solution or if the combined modulus doesn't fit in a uint64
+func CRT(residues, moduli []uint64) (uint64, uint64, error) {
+	r := make([]*big.Int, len(residues))
+	m := make([]*big.Int, len(moduli))
+	for i := range residues {
+		r[i] = new(big.Int).SetUint64(residues[i])
+	}
+	for i := range moduli {
+		m[i] = new(big.Int).SetUint64(moduli[i])
+	}
+	x, mod, err := CRTBig(r, m)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !mod.IsUint64() {
+		return 0, 0, fmt.Errorf("combined modulus %v overflows uint64", mod)
+	}
+	return x.Uint64(), mod.Uint64(), nil
+}
+
+// CRTBig solves the system x = residues[i] mod moduli[i], returning x and the combined modulus.  We merge
+// the congruences one at a time.  Given x = a mod m and x = b mod n with g = gcd(m, n), a solution only
+// exists if g divides b-a, in which case x = a + m*k where k = ((b-a)/g) * inverse(m/g) mod n/g
+func CRTBig(residues, moduli []*big.Int) (*big.Int, *big.Int, error) {
+	if len(residues) != len(moduli) || len(moduli) == 0 {
+		return nil, nil, fmt.Errorf("need the same non-zero number of residues and moduli")
+	}
+	x := new(big.Int)
+	m := big.NewInt(1)
+	for i := range moduli {
+		n := moduli[i]
+		if n.Sign() <= 0 {
+			return nil, nil, fmt.Errorf("modulus %v must be positive", n)
+		}
+		g := new(big.Int).GCD(nil, nil, m, n)
+		diff := new(big.Int).Sub(residues[i], x)
+		rem := new(big.Int)
+		diff.QuoRem(diff, g, rem)
+		if rem.Sign() != 0 {
+			return nil, nil, fmt.Errorf("no solution, %v is not congruent to %v modulo %v", x, residues[i], g)
+		}
+		ng := new(big.Int).Quo(n, g)
+		k := big.NewInt(0)
+		if ng.Cmp(big.NewInt(1)) != 0 {
+			inv := new(big.Int).ModInverse(new(big.Int).Quo(m, g), ng)
+			k.Mul(diff, inv)
+			k.Mod(k, ng)
+		}
+		x.Add(x, k.Mul(k, m))
+		m.Mul(m, ng)
+		x.Mod(x, m)
+	}
+	return x, m, nil
+}
+
+// Legendre returns the Legendre symbol (a/p) for an odd prime p using Euler's criterion.  a^((p-1)/2)
+// mod p is 1 if a is a square modulo p, p-1 (which is -1) if it isn't, and 0 if p divides a
+func Legendre(a, p uint64) int {
+	switch ModExp(a, (p-1)/2, p) {
+	case 0:
+		return 0
+	case 1:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// LegendreBig returns the Legendre symbol (a/p) for an odd prime p
+func LegendreBig(a, p *big.Int) int {
+	e := new(big.Int).Rsh(new(big.Int).Sub(p, big.NewInt(1)), 1)
+	r := new(big.Int).Exp(a, e, p)
+	switch {
+	case r.Sign() == 0:
+		return 0
+	case r.Cmp(big.NewInt(1)) == 0:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// Jacobi returns the Jacobi symbol (a/n) for an odd positive n.  Rather than factoring n, we use quadratic
+// reciprocity to flip the symbol around and reduce it, in much the same way as Euclid's algorithm
+func Jacobi(a, n uint64) (int, error) {
+	if n == 0 || n%2 == 0 {
+		return 0, fmt.Errorf("jacobi symbol needs an odd positive n, got %d", n)
+	}
+	a %= n
+	res := 1
+	for a != 0 {
+		// Pull out factors of two, (2/n) is -1 when n is 3 or 5 mod 8
+		for a%2 == 0 {
+			a /= 2
+			if r := n % 8; r == 3 || r == 5 {
+				res = -res
+			}
+		}
+		// Reciprocity: (a/n) = (n/a) unless both are 3 mod 4, in which case the sign flips
+		a, n = n, a
+		if a%4 == 3 && n%4 == 3 {
+			res = -res
+		}
+		a %= n
+	}
+	if n == 1 {
+		return res, nil
+	}
+	return 0, nil
+}
+
+// JacobiBig returns the Jacobi symbol (a/n) for an odd positive n
+func JacobiBig(a, n *big.Int) (int, error) {
+	if n.Sign() <= 0 || n.Bit(0) == 0 {
+		return 0, fmt.Errorf("jacobi symbol needs an odd positive n, got %v", n)
+	}
+	return big.Jacobi(a, n), nil
+}
+
+// SqrtMod returns x such that x*x mod p == a for an odd prime p using the Tonelli-Shanks algorithm.  Of
+// the two roots x and p-x, the smaller is returned.  An error is returned if p isn't prime or a is not a
+// square modulo p
+func SqrtMod(a, p uint64) (uint64, error) {
+	if p < 2 || !isPrime64(p) {
+		return 0, fmt.Errorf("square roots need a prime modulus, got %d", p)
+	}
+	a %= p
+	if p == 2 || a == 0 {
+		return a, nil
+	}
+	if Legendre(a, p) != 1 {
+		return 0, fmt.Errorf("%d is not a square modulo %d", a, p)
+	}
+	// Write p-1 as q * 2^s with q odd
+	q, s := p-1, 0
+	for q%2 == 0 {
+		q /= 2
+		s++
+	}
+	var r uint64
+	if s == 1 {
+		// When p is 3 mod 4 there is a direct formula
+		r = ModExp(a, (p+1)/4, p)
+	} else {
+		// Find any non-square z, its powers give us the corrections we need
+		z := uint64(2)
+		for Legendre(z, p) != -1 {
+			z++
+		}
+		m, c, t := s, ModExp(z, q, p), ModExp(a, q, p)
+		r = ModExp(a, (q+1)/2, p)
+		for t != 1 {
+			// Find the smallest i such that t^(2^i) == 1
+			i, tt := 0, t
+			for tt != 1 {
+				tt = mulMod(tt, tt, p)
+				i++
+			}
+			b := c
+			for j := 0; j < m-i-1; j++ {
+				b = mulMod(b, b, p)
+			}
+			m, c = i, mulMod(b, b, p)
+			t, r = mulMod(t, c, p), mulMod(r, b, p)
+		}
+	}
+	return min(r, p-r), nil
+}
+
+// SqrtModBig returns x such that x*x mod p == a for an odd prime p, or an error if p isn't an odd prime
+// or a is not a square
+func SqrtModBig(a, p *big.Int) (*big.Int, error) {
+	// ModSqrt panics on an even modulus and never finishes on an odd composite one
+	if !isOddPrimeBig(p) {
+		return nil, fmt.Errorf("square roots need an odd prime modulus, got %v", p)
+	}
+	x := new(big.Int).ModSqrt(a, p)
+	if x == nil {
+		return nil, fmt.Errorf("%v is not a square modulo %v", a, p)
+	}
+	if y := new(big.Int).Sub(p, x); y.Cmp(x) < 0 && x.Sign() != 0 {
+		x = y
+	}
+	return x, nil
+}
+
+// isOddPrimeBig returns true if p is an odd prime
+func isOddPrimeBig(p *big.Int) bool {
+	return p.Sign() > 0 && p.Bit(0) == 1 && p.ProbablyPrime(20)
+}
+
+// isPrime64 tests a uint64 for primality, big.Int's test is exact for anything that fits in 64 bits
+func isPrime64(n uint64) bool {
+	return new(big.Int).SetUint64(n).ProbablyPrime(0)
+}
+
+// pollardRho finds a non-trivial factor of the composite n using Pollard's rho algorithm.  We iterate
+// x -> x*x + c mod n at two speeds, and once the two values meet modulo one of n's factors, the gcd
+// of their difference and n reveals that factor
+func pollardRho(n uint64) uint64 {
+	if n%2 == 0 {
+		return 2
+	}
+	for c := uint64(1); ; c++ {
+		f := func(v uint64) uint64 { return addMod(mulMod(v, v, n), c, n) }
+		x, y, d := uint64(2), uint64(2), uint64(1)
+		for d == 1 {
+			x, y = f(x), f(f(y))
+			d = gcd(max(x, y)-min(x, y), n)
+		}
+		if d != n {
+			return d
+		}
+	}
+}
+
+// Factor returns the prime factors of n in ascending order, repeated according to their multiplicity.
+// Small factors are removed by trial division using the primes from Sieve, and anything left that isn't
+// prime is split with Pollard's rho.  0 has no factorisation, so nil is returned for it as it is for 1
+func Factor(n uint64) []uint64 {
+	if n == 0 {
+		return nil
+	}
+	var res []uint64
+	for p := range getFactorBase().All() {
+		pp := uint64(p)
+		if pp*pp > n {
+			break
+		}
+		for n%pp == 0 {
+			res = append(res, pp)
+			n /= pp
+		}
+	}
+	var split func(n uint64)
+	split = func(n uint64) {
+		if n == 1 {
+			return
+		}
+		if isPrime64(n) {
+			res = append(res, n)
+			return
+		}
+		d := pollardRho(n)
+		split(d)
+		split(n / d)
+	}
+	split(n)
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// pollardRhoBig is the big.Int version of pollardRho
+func pollardRhoBig(n *big.Int) *big.Int {
+	if n.Bit(0) == 0 {
+		return big.NewInt(2)
+	}
+	one := big.NewInt(1)
+	for c := int64(1); ; c++ {
+		bc := big.NewInt(c)
+		f := func(v *big.Int) *big.Int {
+			v.Mul(v, v)
+			v.Add(v, bc)
+			return v.Mod(v, n)
+		}
+		x, y, d := big.NewInt(2), big.NewInt(2), big.NewInt(1)
+		diff := new(big.Int)
+		for d.Cmp(one) == 0 {
+			f(x)
+			f(f(y))
+			d.GCD(nil, nil, diff.Abs(diff.Sub(x, y)), n)
+		}
+		if d.Cmp(n) != 0 {
+			return d
+		}
+	}
+}
+
+// FactorBig returns the prime factors of n in ascending order, repeated according to their multiplicity.
+// This works well on numbers made of small factors and at most one large one, such as p-1 for a safe
+// prime, but Pollard's rho will take a very long time on a product of two large primes.  nil is returned
+// for anything less than 2
+func FactorBig(n *big.Int) []*big.Int {
+	if n.Sign() <= 0 {
+		return nil
+	}
+	var res []*big.Int
+	n = new(big.Int).Set(n)
+	rem, bp := new(big.Int), new(big.Int)
+	for p := range getFactorBase().All() {
+		bp.SetInt64(int64(p))
+		if new(big.Int).Mul(bp, bp).Cmp(n) > 0 {
+			break
+		}
+		for {
+			q := new(big.Int)
+			q.QuoRem(n, bp, rem)
+			if rem.Sign() != 0 {
+				break
+			}
+			res = append(res, big.NewInt(int64(p)))
+			n = q
+		}
+	}
+	var split func(n *big.Int)
+	split = func(n *big.Int) {
+		if n.Cmp(big.NewInt(1)) == 0 {
+			return
+		}
+		if n.ProbablyPrime(20) {
+			res = append(res, n)
+			return
+		}
+		d := pollardRhoBig(n)
+		split(d)
+		split(new(big.Int).Quo(n, d))
+	}
+	split(n)
+	sort.Slice(res, func(i, j int) bool { return res[i].Cmp(res[j]) < 0 })
+	return res
+}
+
+// PrimitiveRoot returns the smallest primitive root modulo the prime p.  g is a primitive root if its
+// powers produce every number from 1 to p-1, which is true exactly when g^((p-1)/q) != 1 for every
+// prime q dividing p-1, so this needs the factorisation of p-1
+func PrimitiveRoot(p uint64) (uint64, error) {
+	if !isPrime64(p) {
+		return 0, fmt.Errorf("%d is not prime", p)
+	}
+	if p == 2 {
+		return 1, nil
+	}
+	factors := Factor(p - 1)
+NextCandidate:
+	for g := uint64(2); g < p; g++ {
+		for i, q := range factors {
+			if i > 0 && factors[i-1] == q {
+				continue
+			}
+			if ModExp(g, (p-1)/q, p) == 1 {
+				continue NextCandidate
+			}
+		}
+		return g, nil
+	}
+	return 0, fmt.Errorf("no primitive root found for %d", p)
+}
+
+// PrimitiveRootBig returns the smallest primitive root modulo the prime p
+func PrimitiveRootBig(p *big.Int) (*big.Int, error) {
+	if !p.ProbablyPrime(20) {
+		return nil, fmt.Errorf("%v is not prime", p)
+	}
+	one := big.NewInt(1)
+	pm1 := new(big.Int).Sub(p, one)
+	if pm1.Cmp(one) == 0 {
+		return big.NewInt(1), nil
+	}
+	factors := FactorBig(pm1)
+	e := new(big.Int)
+NextCandidate:
+	for g := big.NewInt(2); g.Cmp(p) < 0; g.Add(g, one) {
+		for i, q := range factors {
+			if i > 0 && factors[i-1].Cmp(q) == 0 {
+				continue
+			}
+			if new(big.Int).Exp(g, e.Quo(pm1, q), p).Cmp(one) == 0 {
+				continue NextCandidate
+			}
+		}
+		return g, nil
+	}
+	return nil, fmt.Errorf("no primitive root found for %v", p)
+}
+
+// modularUsage describes the operations available through the -mod flag
+const modularUsage = `Modular arithmetic operations (numbers may be given in decimal or with a 0x prefix):
+	modexp <base> <exp> <mod>
+	inverse <a> <mod>
+	crt <residue>,<mod> [<residue>,<mod> ...]
+	legendre <a> <p>
+	jacobi <a> <n>
+	sqrt <a> <p>
+	primroot <p>
+	factor <n>
+`
+
+// runModular is the CLI front-end for the modular arithmetic routines, it uses the big.Int variants so
+// that any size number can be given on the command line
+func runModular(op string, args []string) (string, error) {
+	nums := make([]*big.Int, 0, len(args))
+	if op != "crt" {
+		for _, a := range args {
+			n, ok := new(big.Int).SetString(a, 0)
+			if !ok {
+				return "", fmt.Errorf("invalid number %q", a)
+			}
+			nums = append(nums, n)
+		}
+	}
+	want := map[string]int{"modexp": 3, "inverse": 2, "legendre": 2, "jacobi": 2, "sqrt": 2, "primroot": 1, "factor": 1}
+	if n, ok := want[op]; ok && len(nums) != n {
+		return "", fmt.Errorf("%s needs %d arguments", op, n)
+	}
+
+	switch op {
+	case "modexp":
+		if nums[2].Sign() <= 0 {
+			return "", fmt.Errorf("modulus must be positive")
+		}
+		r := ModExpBig(nums[0], nums[1], nums[2])
+		if r == nil {
+			return "", fmt.Errorf("%v has no inverse modulo %v, so can't be raised to a negative power", nums[0], nums[2])
+		}
+		return fmt.Sprintf("%v^%v mod %v = %v", nums[0], nums[1], nums[2], r), nil
+	case "inverse":
+		x, err := ModInverseBig(nums[0], nums[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v^-1 mod %v = %v", nums[0], nums[1], x), nil
+	case "crt":
+		var r, m []*big.Int
+		for _, a := range args {
+			parts := strings.Split(a, ",")
+			if len(parts) != 2 {
+				return "", fmt.Errorf("expected <residue>,<mod> but got %q", a)
+			}
+			rv, ok1 := new(big.Int).SetString(parts[0], 0)
+			mv, ok2 := new(big.Int).SetString(parts[1], 0)
+			if !ok1 || !ok2 {
+				return "", fmt.Errorf("invalid congruence %q", a)
+			}
+			r, m = append(r, rv), append(m, mv)
+		}
+		x, mod, err := CRTBig(r, m)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("x = %v mod %v", x, mod), nil
+	case "legendre":
+		if !isOddPrimeBig(nums[1]) {
+			return "", fmt.Errorf("legendre symbol needs an odd prime p, got %v", nums[1])
+		}
+		return fmt.Sprintf("(%v/%v) = %d", nums[0], nums[1], LegendreBig(nums[0], nums[1])), nil
+	case "jacobi":
+		j, err := JacobiBig(nums[0], nums[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%v/%v) = %d", nums[0], nums[1], j), nil
+	case "sqrt":
+		x, err := SqrtModBig(nums[0], nums[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("sqrt(%v) mod %v = %v, %v", nums[0], nums[1], x, new(big.Int).Sub(nums[1], x)), nil
+	case "primroot":
+		g, err := PrimitiveRootBig(nums[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("smallest primitive root of %v is %v", nums[0], g), nil
+	case "factor":
+		if nums[0].Sign() <= 0 {
+			return "", fmt.Errorf("can only factor positive numbers")
+		}
+		factors := FactorBig(nums[0])
+		strs := []string{"1"}
+		if len(factors) > 0 {
+			strs = make([]string, len(factors))
+		}
+		for i, f := range factors {
+			strs[i] = f.String()
+		}
+		return fmt.Sprintf("%v = %s", nums[0], strings.Join(strs, " * ")), nil
+	case "help":
+		return modularUsage, nil
+	default:
+		return "", fmt.Errorf("unknown operation %q\n%s", op, modularUsage)
+	}
+}
@@ -0,0 +1,297 @@
+// Modular arithmetic test routines
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestModExp(t *testing.T) {
+	tests := []struct {
+		base, exp, mod uint64
+		want           uint64
+	}{
+		{4, 13, 497, 445},
+		{2, 10, 1000, 24},
+		{3, 0, 7, 1},
+		{5, 3, 1, 0},
+		{0xFFFFFFFFFFFFFFFE, 2, 0xFFFFFFFFFFFFFFFF, 1},
+		{2, 0xFFFFFFFFFFFFFFC4, 0xFFFFFFFFFFFFFFC5, 1},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d^%d mod %d", tt.base, tt.exp, tt.mod)
+		t.Run(testName, func(t *testing.T) {
+			ans := ModExp(tt.base, tt.exp, tt.mod)
+			if ans != tt.want {
+				t.Errorf("got %d instead of %d", ans, tt.want)
+			}
+			big := ModExpBig(new(big.Int).SetUint64(tt.base), new(big.Int).SetUint64(tt.exp), new(big.Int).SetUint64(tt.mod))
+			if big.Uint64() != tt.want {
+				t.Errorf("big variant got %v instead of %d", big, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendedGCD(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		want int64
+	}{
+		{240, 46, 2},
+		{46, 240, 2},
+		{17, 5, 1},
+		{-12, 18, 6},
+		{0, 9, 9},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d,%d", tt.a, tt.b)
+		t.Run(testName, func(t *testing.T) {
+			g, x, y := ExtendedGCD(tt.a, tt.b)
+			if g != tt.want || tt.a*x+tt.b*y != g {
+				t.Errorf("got g=%d x=%d y=%d, wanted g=%d", g, x, y, tt.want)
+			}
+		})
+	}
+	g, x, y := ExtendedGCDBig(big.NewInt(240), big.NewInt(46))
+	if g.Int64() != 2 || 240*x.Int64()+46*y.Int64() != 2 {
+		t.Errorf("big variant got g=%v x=%v y=%v", g, x, y)
+	}
+}
+
+func TestModInverse(t *testing.T) {
+	tests := []struct {
+		a, m uint64
+		want uint64
+		err  bool
+	}{
+		{3, 11, 4, false},
+		{10, 17, 12, false},
+		{6, 9, 0, true},
+		{5, 1, 0, true},
+		{2, 0xFFFFFFFFFFFFFFC5, 0x7FFFFFFFFFFFFFE3, false},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d,%d", tt.a, tt.m)
+		t.Run(testName, func(t *testing.T) {
+			ans, err := ModInverse(tt.a, tt.m)
+			if (err != nil) != tt.err || ans != tt.want {
+				t.Errorf("got %d [%v] instead of %d", ans, err, tt.want)
+			}
+			bigAns, err := ModInverseBig(new(big.Int).SetUint64(tt.a), new(big.Int).SetUint64(tt.m))
+			if (err != nil) != tt.err || (err == nil && bigAns.Uint64() != tt.want) {
+				t.Errorf("big variant got %v [%v] instead of %d", bigAns, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCRT(t *testing.T) {
+	tests := []struct {
+		r, m      []uint64
+		want, mod uint64
+		err       bool
+	}{
+		{[]uint64{2, 3, 2}, []uint64{3, 5, 7}, 23, 105, false},
+		{[]uint64{1, 4, 6}, []uint64{3, 5, 7}, 34, 105, false},
+		{[]uint64{3, 5}, []uint64{4, 6}, 11, 12, false},
+		{[]uint64{1, 2}, []uint64{4, 6}, 0, 0, true},
+		{[]uint64{1, 1}, []uint64{1 << 40, (1 << 40) + 1}, 1, 0, true},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%v,%v", tt.r, tt.m)
+		t.Run(testName, func(t *testing.T) {
+			x, m, err := CRT(tt.r, tt.m)
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error state %v", err)
+			}
+			if err == nil && (x != tt.want || m != tt.mod) {
+				t.Errorf("got %d mod %d instead of %d mod %d", x, m, tt.want, tt.mod)
+			}
+		})
+	}
+}
+
+func TestLegendreJacobi(t *testing.T) {
+	// Compare both symbols against a brute force search for squares modulo small primes
+	for _, p := range Sieve(100).Slice()[1:] {
+		squares := make(map[uint64]bool)
+		for x := uint64(1); x < uint64(p); x++ {
+			squares[x*x%uint64(p)] = true
+		}
+		for a := uint64(0); a < uint64(p); a++ {
+			want := -1
+			if a == 0 {
+				want = 0
+			} else if squares[a] {
+				want = 1
+			}
+			j, err := Jacobi(a, uint64(p))
+			if Legendre(a, uint64(p)) != want || j != want || err != nil {
+				t.Errorf("(%d/%d): legendre %d jacobi %d, wanted %d", a, p, Legendre(a, uint64(p)), j, want)
+			}
+			if LegendreBig(new(big.Int).SetUint64(a), big.NewInt(int64(p))) != want {
+				t.Errorf("(%d/%d): big legendre did not give %d", a, p, want)
+			}
+		}
+	}
+	// Jacobi symbols for composite n, (2/15) is 1 even though 2 is not a square modulo 15
+	tests := []struct {
+		a, n uint64
+		want int
+	}{
+		{2, 15, 1},
+		{7, 15, -1},
+		{1001, 9907, -1},
+		{19, 45, 1},
+		{6, 9, 0},
+	}
+	for _, tt := range tests {
+		ans, err := Jacobi(tt.a, tt.n)
+		bigAns, bigErr := JacobiBig(new(big.Int).SetUint64(tt.a), new(big.Int).SetUint64(tt.n))
+		if ans != tt.want || bigAns != tt.want || err != nil || bigErr != nil {
+			t.Errorf("(%d/%d): got %d and %d, wanted %d", tt.a, tt.n, ans, bigAns, tt.want)
+		}
+	}
+	if _, err := Jacobi(3, 10); err == nil {
+		t.Errorf("expected an error for an even n")
+	}
+}
+
+func TestSqrtMod(t *testing.T) {
+	tests := []struct {
+		a, p uint64
+		want uint64
+		err  bool
+	}{
+		{10, 13, 6, false},
+		{5, 41, 13, false},
+		{2, 113, 51, false},
+		{3, 7, 0, true},
+		{0, 17, 0, false},
+		{4, 0xFFFFFFFFFFFFFFC5, 2, false},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d,%d", tt.a, tt.p)
+		t.Run(testName, func(t *testing.T) {
+			ans, err := SqrtMod(tt.a, tt.p)
+			if (err != nil) != tt.err || ans != tt.want {
+				t.Errorf("got %d [%v] instead of %d", ans, err, tt.want)
+			}
+			bigAns, err := SqrtModBig(new(big.Int).SetUint64(tt.a), new(big.Int).SetUint64(tt.p))
+			if (err != nil) != tt.err || (err == nil && bigAns.Uint64() != tt.want) {
+				t.Errorf("big variant got %v [%v] instead of %d", bigAns, err, tt.want)
+			}
+		})
+	}
+	// Every square modulo a prime with a large power of two in p-1 exercises the full Tonelli-Shanks loop
+	p := uint64(65537)
+	for x := uint64(1); x < 2000; x++ {
+		a := x * x % p
+		r, err := SqrtMod(a, p)
+		if err != nil || r*r%p != a {
+			t.Errorf("sqrt(%d) mod %d gave %d [%v]", a, p, r, err)
+		}
+	}
+}
+
+func TestFactor(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want []uint64
+	}{
+		{0, nil},
+		{1, nil},
+		{2, []uint64{2}},
+		{360, []uint64{2, 2, 2, 3, 3, 5}},
+		{4295098369, []uint64{65537, 65537}},
+		{600851475143, []uint64{71, 839, 1471, 6857}},
+		{0xFFFFFFFFFFFFFFFF, []uint64{3, 5, 17, 257, 641, 65537, 6700417}},
+		{1000000016000000063, []uint64{1000000007, 1000000009}},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d", tt.n)
+		t.Run(testName, func(t *testing.T) {
+			ans := Factor(tt.n)
+			bigAns := FactorBig(new(big.Int).SetUint64(tt.n))
+			if len(ans) != len(tt.want) || len(bigAns) != len(tt.want) {
+				t.Fatalf("got %v and %v instead of %v", ans, bigAns, tt.want)
+			}
+			for i := range ans {
+				if ans[i] != tt.want[i] || bigAns[i].Uint64() != tt.want[i] {
+					t.Errorf("got %v and %v instead of %v", ans, bigAns, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrimitiveRoot(t *testing.T) {
+	tests := []struct {
+		p    uint64
+		want uint64
+		err  bool
+	}{
+		{2, 1, false},
+		{7, 3, false},
+		{23, 5, false},
+		{41, 6, false},
+		{65537, 3, false},
+		{1000000007, 5, false},
+		{15, 0, true},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%d", tt.p)
+		t.Run(testName, func(t *testing.T) {
+			ans, err := PrimitiveRoot(tt.p)
+			if (err != nil) != tt.err || ans != tt.want {
+				t.Errorf("got %d [%v] instead of %d", ans, err, tt.want)
+			}
+			bigAns, err := PrimitiveRootBig(new(big.Int).SetUint64(tt.p))
+			if (err != nil) != tt.err || (err == nil && bigAns.Uint64() != tt.want) {
+				t.Errorf("big variant got %v [%v] instead of %d", bigAns, err, tt.want)
+			}
+		})
+	}
+	// A generated safe prime should always have a primitive root we can find and verify
+	p, _ := GenerateSafePrime(128, NewSeededReader(1))
+	if _, err := PrimitiveRootBig(p); err != nil {
+		t.Errorf("no primitive root for safe prime %v: %v", p, err)
+	}
+}
+
+func TestRunModular(t *testing.T) {
+	tests := []struct {
+		op   string
+		args []string
+		want string
+		err  bool
+	}{
+		{"modexp", []string{"4", "13", "497"}, "4^13 mod 497 = 445", false},
+		{"inverse", []string{"0x3", "11"}, "3^-1 mod 11 = 4", false},
+		{"crt", []string{"2,3", "3,5", "2,7"}, "x = 23 mod 105", false},
+		{"sqrt", []string{"10", "13"}, "sqrt(10) mod 13 = 6, 7", false},
+		{"factor", []string{"360"}, "360 = 2 * 2 * 2 * 3 * 3 * 5", false},
+		{"primroot", []string{"23"}, "smallest primitive root of 23 is 5", false},
+		{"modexp", []string{"4", "13"}, "", true},
+		{"inverse", []string{"x", "11"}, "", true},
+		{"modexp", []string{"3", "-1", "11"}, "3^-1 mod 11 = 4", false},
+		{"modexp", []string{"2", "-1", "4"}, "", true},
+		{"legendre", []string{"2", "7"}, "(2/7) = 1", false},
+		{"legendre", []string{"2", "0"}, "", true},
+		{"legendre", []string{"2", "15"}, "", true},
+		{"sqrt", []string{"4", "10"}, "", true},
+		{"sqrt", []string{"1", "9"}, "", true},
+		{"unknown", nil, "", true},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%s %v", tt.op, tt.args)
+		t.Run(testName, func(t *testing.T) {
+			ans, err := runModular(tt.op, tt.args)
+			if (err != nil) != tt.err || ans != tt.want {
+				t.Errorf("got %q [%v] instead of %q", ans, err, tt.want)
+			}
+		})
+	}
+}
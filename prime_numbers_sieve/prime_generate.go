@@ -0,0 +1,187 @@
+// Probable and safe prime generation for cryptographic key sizes
+// Andrew Alston
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand/v2"
+)
+
+// sieveLimit is the largest small prime we use to pre-filter candidates.  Dividing by the first few
+// hundred primes removes roughly 90% of odd candidates before we ever need an expensive primality test
+const sieveLimit = 2000
+
+// maxDelta is the furthest we will walk forward from a random starting point before giving up on it
+// and reading a fresh random number
+const maxDelta = 1 << 20
+
+// Generator holds the configuration for generating large primes.  Rand is the source of randomness,
+// and if Progress is not nil, a '.' is written for every candidate that survives the sieve but fails
+// the primality test, a '+' for every candidate that passes, and a newline once we are done.  This
+// mimics the output of tools such as openssl dhparam so students can watch the search happen
+type Generator struct {
+	Rand     io.Reader
+	Progress io.Writer
+	// smallPrimes is populated from Sieve the first time the generator is used
+	smallPrimes *PrimeSet
+}
+
+// GenerateProbablePrime returns a random prime of exactly bits bits read from rand
+func GenerateProbablePrime(bits int, rand io.Reader) (*big.Int, error) {
+	g := &Generator{Rand: rand}
+	return g.ProbablePrime(bits)
+}
+
+// GenerateSafePrime returns a random safe prime p of exactly bits bits, that is a prime where
+// (p-1)/2 is also prime.  Safe primes are what we want for Diffie-Hellman parameters
+func GenerateSafePrime(bits int, rand io.Reader) (*big.Int, error) {
+	g := &Generator{Rand: rand}
+	return g.SafePrime(bits)
+}
+
+// NewSeededReader returns a deterministic random source built on the ChaCha8 generator.  The same
+// seed will always produce the same stream of bytes, which lets us write reproducible tests and
+// lets everyone in a lab session generate the same parameters.  This must never be used for real keys
+func NewSeededReader(seed uint64) io.Reader {
+	var s [32]byte
+	binary.BigEndian.PutUint64(s[:8], seed)
+	return rand.NewChaCha8(s)
+}
+
+// ProbablePrime returns a random prime of exactly bits bits.  We read a random odd number with the top
+// bit set, then walk forward in steps of two.  Rather than dividing every step by every small prime,
+// we work out the remainder of the starting point once and then just add the step to each remainder
+func (g *Generator) ProbablePrime(bits int) (*big.Int, error) {
+	if bits < 2 {
+		return nil, fmt.Errorf("prime size must be at least 2 bits")
+	}
+	primes := g.sievePrimes(bits)
+	residues := make([]uint64, len(primes))
+	for {
+		base, err := g.randomOdd(bits)
+		if err != nil {
+			return nil, err
+		}
+		g.residues(base, primes, residues)
+	NextDelta:
+		for delta := uint64(0); delta < maxDelta; delta += 2 {
+			// Any candidate that divides cleanly by a small prime can be thrown away without further testing
+			for i, p := range primes {
+				if (residues[i]+delta)%uint64(p) == 0 {
+					continue NextDelta
+				}
+			}
+			candidate := new(big.Int).Add(base, new(big.Int).SetUint64(delta))
+			if candidate.BitLen() != bits {
+				break
+			}
+			if candidate.ProbablyPrime(20) {
+				g.report("+\n")
+				return candidate, nil
+			}
+			g.report(".")
+		}
+	}
+}
+
+// SafePrime returns a random safe prime p = 2q+1 of exactly bits bits.  We search for q in the same way
+// as ProbablePrime, except that the sieve also throws away any q where 2q+1 divides by a small prime.
+// 2q+1 is divisible by p exactly when q mod p == (p-1)/2, so we can check both from the same remainder
+func (g *Generator) SafePrime(bits int) (*big.Int, error) {
+	if bits < 3 {
+		return nil, fmt.Errorf("safe prime size must be at least 3 bits")
+	}
+	primes := g.sievePrimes(bits - 1)
+	residues := make([]uint64, len(primes))
+	for {
+		q, err := g.randomOdd(bits - 1)
+		if err != nil {
+			return nil, err
+		}
+		g.residues(q, primes, residues)
+	NextDelta:
+		for delta := uint64(0); delta < maxDelta; delta += 2 {
+			for i, p := range primes {
+				r := (residues[i] + delta) % uint64(p)
+				if r == 0 || r == uint64(p-1)/2 {
+					continue NextDelta
+				}
+			}
+			candidate := new(big.Int).Add(q, new(big.Int).SetUint64(delta))
+			if candidate.BitLen() != bits-1 {
+				break
+			}
+			if !candidate.ProbablyPrime(20) {
+				g.report(".")
+				continue
+			}
+			g.report("+")
+			p := new(big.Int).Lsh(candidate, 1)
+			p.Add(p, big.NewInt(1))
+			if p.ProbablyPrime(20) {
+				g.report("*\n")
+				return p, nil
+			}
+		}
+	}
+}
+
+// sievePrimes returns the odd small primes from Sieve that are smaller than any candidate of the given
+// size.  Without this restriction a small candidate could be thrown away for dividing by itself
+func (g *Generator) sievePrimes(bits int) []int {
+	if g.smallPrimes == nil {
+		g.smallPrimes = Sieve(sieveLimit)
+	}
+	var res []int
+	for p := range g.smallPrimes.All() {
+		if p == 2 {
+			continue
+		}
+		// Any prime below 2^11 is smaller than every candidate above 12 bits, so we only need to compare
+		// for small sizes (which also avoids overflowing the shift)
+		if bits <= 12 && p >= 1<<(bits-1) {
+			break
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// randomOdd reads a random number of exactly bits bits from the generator source and sets the top bit
+// (so the size is right) and the bottom bit (so it is odd)
+func (g *Generator) randomOdd(bits int) (*big.Int, error) {
+	if g.Rand == nil {
+		return nil, fmt.Errorf("no random source configured")
+	}
+	buf := make([]byte, (bits+7)/8)
+	if _, err := io.ReadFull(g.Rand, buf); err != nil {
+		return nil, fmt.Errorf("error reading random source: %v", err)
+	}
+	// Clear any bits above the size we want in the first byte
+	if extra := len(buf)*8 - bits; extra > 0 {
+		buf[0] &= 0xFF >> extra
+	}
+	n := new(big.Int).SetBytes(buf)
+	n.SetBit(n, bits-1, 1)
+	n.SetBit(n, 0, 1)
+	return n, nil
+}
+
+// residues fills res with n modulo each of the given primes
+func (g *Generator) residues(n *big.Int, primes []int, res []uint64) {
+	m := new(big.Int)
+	for i, p := range primes {
+		res[i] = m.Mod(n, big.NewInt(int64(p))).Uint64()
+	}
+}
+
+// report writes progress output if a progress writer is configured
+func (g *Generator) report(s string) {
+	if g.Progress != nil {
+		_, _ = io.WriteString(g.Progress, s)
+	}
+}
@@ -0,0 +1,84 @@
+// Prime generation test routines
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestGenerateProbablePrime(t *testing.T) {
+	tests := []int{2, 3, 8, 16, 64, 128, 256, 512}
+	for _, bits := range tests {
+		testName := fmt.Sprintf("Bits: %d", bits)
+		t.Run(testName, func(t *testing.T) {
+			p, err := GenerateProbablePrime(bits, NewSeededReader(uint64(bits)))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if p.BitLen() != bits {
+				t.Errorf("got %d bit prime %v, wanted %d bits", p.BitLen(), p, bits)
+			}
+			if !p.ProbablyPrime(20) {
+				t.Errorf("%v is not prime", p)
+			}
+		})
+	}
+}
+
+func TestGenerateSafePrime(t *testing.T) {
+	tests := []int{3, 5, 16, 64, 128}
+	for _, bits := range tests {
+		testName := fmt.Sprintf("Bits: %d", bits)
+		t.Run(testName, func(t *testing.T) {
+			p, err := GenerateSafePrime(bits, NewSeededReader(uint64(bits)))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if p.BitLen() != bits {
+				t.Errorf("got %d bit prime %v, wanted %d bits", p.BitLen(), p, bits)
+			}
+			q := new(big.Int).Rsh(p, 1)
+			if !p.ProbablyPrime(20) || !q.ProbablyPrime(20) {
+				t.Errorf("%v is not a safe prime", p)
+			}
+		})
+	}
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	a, _ := GenerateProbablePrime(256, NewSeededReader(42))
+	b, _ := GenerateProbablePrime(256, NewSeededReader(42))
+	c, _ := GenerateProbablePrime(256, NewSeededReader(43))
+	if a.Cmp(b) != 0 {
+		t.Errorf("same seed gave different primes %v and %v", a, b)
+	}
+	if a.Cmp(c) == 0 {
+		t.Errorf("different seeds gave the same prime %v", a)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	if _, err := GenerateProbablePrime(1, NewSeededReader(1)); err == nil {
+		t.Errorf("expected an error for a 1 bit prime")
+	}
+	if _, err := GenerateSafePrime(2, NewSeededReader(1)); err == nil {
+		t.Errorf("expected an error for a 2 bit safe prime")
+	}
+	if _, err := GenerateProbablePrime(64, bytes.NewReader([]byte{1, 2})); err == nil {
+		t.Errorf("expected an error for a short random source")
+	}
+}
+
+func TestGenerateProgress(t *testing.T) {
+	var buf bytes.Buffer
+	g := &Generator{Rand: NewSeededReader(7), Progress: &buf}
+	if _, err := g.SafePrime(64); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out := buf.String()
+	if len(out) == 0 || out[len(out)-2:] != "*\n" {
+		t.Errorf("unexpected progress output %q", out)
+	}
+}
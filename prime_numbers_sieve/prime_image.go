@@ -0,0 +1,152 @@
+// Prime number visualisation, renders the output of Sieve as an image
+// Andrew Alston
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+)
+
+// maxColourGap is the gap length at which the colour scale tops out, anything larger is drawn in the
+// same colour as this
+const maxColourGap = 36
+
+// ImageOptions describes how we want the primes drawn.  Layout is one of ulam, sacks or rows, Size is
+// the width and height of the square image in pixels, and if ColourGaps is set each prime is coloured
+// by the gap between it and the previous prime rather than just being drawn in white
+type ImageOptions struct {
+	Layout     string
+	Size       int
+	ColourGaps bool
+}
+
+// RenderPrimes draws the primes from Sieve on to a new image using the layout in opts
+func RenderPrimes(opts ImageOptions) (*image.RGBA, error) {
+	if opts.Size < 2 {
+		return nil, fmt.Errorf("image size must be at least 2 pixels")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, opts.Size, opts.Size))
+	// Fill the background in black, every pixel is 4 bytes of red, green, blue and alpha
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+
+	var plot func(n int) (int, int)
+	var max int
+	switch opts.Layout {
+	case "ulam":
+		max = opts.Size * opts.Size
+		plot = ulamPositions(opts.Size)
+	case "sacks":
+		// The number n sits at a distance of sqrt(n) from the centre, so this is as far as we can go
+		// before we fall off the edge of the image
+		max = (opts.Size / 2) * (opts.Size / 2)
+		plot = func(n int) (int, int) {
+			r := math.Sqrt(float64(n))
+			theta := 2 * math.Pi * r
+			return opts.Size/2 + int(r*math.Cos(theta)), opts.Size/2 - int(r*math.Sin(theta))
+		}
+	case "rows":
+		// Numbers run left to right and wrap on to the next row, starting at 1 in the top left
+		max = opts.Size * opts.Size
+		plot = func(n int) (int, int) {
+			return (n - 1) % opts.Size, (n - 1) / opts.Size
+		}
+	default:
+		return nil, fmt.Errorf("unknown layout %q, expected ulam, sacks or rows", opts.Layout)
+	}
+
+	prev := 0
+	for p := range Sieve(max).All() {
+		c := color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+		if opts.ColourGaps && prev != 0 {
+			c = gapColour(p - prev)
+		}
+		x, y := plot(p)
+		img.SetRGBA(x, y, c)
+		prev = p
+	}
+	return img, nil
+}
+
+// ulamPositions returns a function giving the position of n on an Ulam spiral.  We start with 1 in the
+// centre and walk outwards, going right, up, left and down, with the length of each run growing by
+// one every second turn (1, 1, 2, 2, 3, 3...).  Walking the spiral is easiest done once up front
+func ulamPositions(size int) func(n int) (int, int) {
+	xs := make([]int, size*size+1)
+	ys := make([]int, size*size+1)
+	// For even sizes there is no true centre, so we start just up and left of it
+	x, y := (size-1)/2, size/2
+	dx, dy := 1, 0
+	run, steps, turns := 1, 0, 0
+	for n := 1; n <= size*size; n++ {
+		xs[n], ys[n] = x, y
+		x, y = x+dx, y+dy
+		steps++
+		if steps == run {
+			// Turn left, remembering that y runs down the image
+			dx, dy = dy, -dx
+			steps = 0
+			turns++
+			if turns%2 == 0 {
+				run++
+			}
+		}
+	}
+	return func(n int) (int, int) {
+		return xs[n], ys[n]
+	}
+}
+
+// gapColour maps a gap length on to a colour running from red for twin primes through the spectrum
+// to violet for gaps of maxColourGap or more
+func gapColour(gap int) color.RGBA {
+	hue := float64(min(gap, maxColourGap)-2) / float64(maxColourGap-2) * 300
+	// Standard HSV to RGB conversion with full saturation and value
+	h := max(hue, 0) / 60
+	x := 1 - math.Abs(math.Mod(h, 2)-1)
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	return color.RGBA{R: uint8(r * 0xFF), G: uint8(g * 0xFF), B: uint8(b * 0xFF), A: 0xFF}
+}
+
+// WritePrimeImage renders the primes and encodes them as a PNG to w
+func WritePrimeImage(w io.Writer, opts ImageOptions) error {
+	img, err := RenderPrimes(opts)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// writePrimePNG handles the -png flag, writing the rendered image to the named file
+func writePrimePNG(file string, opts ImageOptions) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := WritePrimeImage(f, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
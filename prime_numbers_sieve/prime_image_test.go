@@ -0,0 +1,89 @@
+// Prime visualisation test routines
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"testing"
+)
+
+func TestUlamPositions(t *testing.T) {
+	// On a 5x5 spiral 1 sits in the middle, and we walk right, up, left and down from there
+	tests := []struct {
+		n    int
+		x, y int
+	}{
+		{1, 2, 2},
+		{2, 3, 2},
+		{3, 3, 1},
+		{4, 2, 1},
+		{5, 1, 1},
+		{7, 1, 3},
+		{10, 4, 3},
+		{13, 4, 0},
+		{25, 4, 4},
+	}
+	pos := ulamPositions(5)
+	for _, tt := range tests {
+		testName := fmt.Sprintf("n: %d", tt.n)
+		t.Run(testName, func(t *testing.T) {
+			x, y := pos(tt.n)
+			if x != tt.x || y != tt.y {
+				t.Errorf("got %d,%d instead of %d,%d", x, y, tt.x, tt.y)
+			}
+		})
+	}
+}
+
+func TestRenderPrimes(t *testing.T) {
+	tests := []struct {
+		opts ImageOptions
+		err  bool
+	}{
+		{ImageOptions{Layout: "ulam", Size: 64}, false},
+		{ImageOptions{Layout: "ulam", Size: 65, ColourGaps: true}, false},
+		{ImageOptions{Layout: "sacks", Size: 100, ColourGaps: true}, false},
+		{ImageOptions{Layout: "rows", Size: 10}, false},
+		{ImageOptions{Layout: "hexagon", Size: 10}, true},
+		{ImageOptions{Layout: "ulam", Size: 1}, true},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("%s %d", tt.opts.Layout, tt.opts.Size)
+		t.Run(testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WritePrimeImage(&buf, tt.opts)
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error state %v", err)
+			}
+			if err != nil {
+				return
+			}
+			img, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("could not decode image: %v", err)
+			}
+			if img.Bounds().Dx() != tt.opts.Size || img.Bounds().Dy() != tt.opts.Size {
+				t.Errorf("got image bounds %v", img.Bounds())
+			}
+		})
+	}
+}
+
+func TestRenderRows(t *testing.T) {
+	// With rows of 10, primes sit at (p-1)%10, (p-1)/10, so 2 is at 1,0 and 97 is at 6,9
+	img, _ := RenderPrimes(ImageOptions{Layout: "rows", Size: 10})
+	lit := func(x, y int) bool { return img.RGBAAt(x, y).R == 0xFF }
+	if !lit(1, 0) || !lit(6, 9) || lit(0, 0) || lit(3, 0) {
+		t.Errorf("primes not drawn in the expected positions")
+	}
+}
+
+func TestGapColour(t *testing.T) {
+	if c := gapColour(2); c.R != 0xFF || c.G != 0 || c.B != 0 {
+		t.Errorf("twin primes should be red, got %v", c)
+	}
+	if gapColour(maxColourGap) != gapColour(maxColourGap*2) {
+		t.Errorf("gaps beyond %d should share a colour", maxColourGap)
+	}
+}
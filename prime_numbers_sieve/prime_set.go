@@ -0,0 +1,191 @@
+// Prime set, a queryable bitmap of sieve results with a rank/select index
+// Andrew Alston
+
+package main
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// wordsPerBlock is the number of 64 bit words covered by each entry in the rank index.  Each block
+// covers 512 numbers, so answering a rank query never needs more than 8 population counts
+const wordsPerBlock = 8
+
+// selectSample is how many primes apart the entries in the select index are
+const selectSample = 512
+
+// PrimeSet holds the result of a sieve as a bitmap, where bit n is set if n is prime.  Alongside the
+// bitmap we keep a rank index, which records how many primes come before the start of each block.
+// This costs an extra 32 bits per 512 numbers and lets us answer "how many primes are <= n" without
+// walking the whole bitmap.  A select index records the word holding every 512th prime, so finding the
+// k-th prime starts close by rather than searching the rank index
+type PrimeSet struct {
+	max     int
+	words   []uint64
+	ranks   []uint32
+	selects []uint32
+	count   int
+}
+
+// newPrimeSet builds a PrimeSet from the bitmap of composite numbers Sieve marks up to max.  We flip the
+// bitmap so that the set bits are the primes, and then build the rank and select indexes
+func newPrimeSet(max int, composite []uint64) *PrimeSet {
+	ps := &PrimeSet{max: max, words: composite}
+	// Flip the bitmap so set bits mean prime, then clear 0, 1 and anything past max in the last word
+	for i := range ps.words {
+		ps.words[i] = ^ps.words[i]
+	}
+	ps.words[0] &^= 3
+	ps.words[len(ps.words)-1] &= (1 << (max%64 + 1)) - 1
+
+	// Build the rank index, ranks[b] is the number of primes before block b, and the select samples,
+	// selects[j] is the word holding prime number j*selectSample+1
+	ps.ranks = make([]uint32, (len(ps.words)+wordsPerBlock-1)/wordsPerBlock+1)
+	next := 1
+	for i, w := range ps.words {
+		if i%wordsPerBlock == 0 {
+			ps.ranks[i/wordsPerBlock] = uint32(ps.count)
+		}
+		ps.count += bits.OnesCount64(w)
+		for ; next <= ps.count; next += selectSample {
+			ps.selects = append(ps.selects, uint32(i))
+		}
+	}
+	ps.ranks[len(ps.ranks)-1] = uint32(ps.count)
+	return ps
+}
+
+// Max returns the largest number the set was sieved up to
+func (ps *PrimeSet) Max() int {
+	return ps.max
+}
+
+// Len returns the number of primes in the set
+func (ps *PrimeSet) Len() int {
+	return ps.count
+}
+
+// Contains returns true if n is prime.  Numbers outside of the sieved range always return false
+func (ps *PrimeSet) Contains(n int) bool {
+	if n < 0 || n > ps.max {
+		return false
+	}
+	return ps.words[n/64]&(1<<(n%64)) != 0
+}
+
+// Rank returns the number of primes less than or equal to n.  We start from the count stored for the
+// block n sits in, add the population count of the whole words between the block start and n, and then
+// mask off the bits above n in the final word
+func (ps *PrimeSet) Rank(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n >= ps.max {
+		return ps.count
+	}
+	w := n / 64
+	res := int(ps.ranks[w/wordsPerBlock])
+	for i := w - w%wordsPerBlock; i < w; i++ {
+		res += bits.OnesCount64(ps.words[i])
+	}
+	mask := uint64(1)<<(n%64+1) - 1
+	return res + bits.OnesCount64(ps.words[w]&mask)
+}
+
+// Select returns the k-th prime, counting from 1 (so Select(1) is 2), and false if the set holds fewer
+// than k primes.  The select index gives us the word holding the nearest sampled prime at or below the
+// k-th, from there we step forward through the rank index to the block holding the k-th prime, count
+// through that block a word at a time, and finally strip set bits from the word until we reach it
+func (ps *PrimeSet) Select(k int) (int, bool) {
+	if k < 1 || k > ps.count {
+		return 0, false
+	}
+	// Skip whole blocks until the next one starts with k or more primes before it
+	b := int(ps.selects[(k-1)/selectSample]) / wordsPerBlock
+	for int(ps.ranks[b+1]) < k {
+		b++
+	}
+	k -= int(ps.ranks[b])
+	for w := b * wordsPerBlock; w < len(ps.words); w++ {
+		c := bits.OnesCount64(ps.words[w])
+		if k > c {
+			k -= c
+			continue
+		}
+		word := ps.words[w]
+		for ; k > 1; k-- {
+			// Clear the lowest set bit
+			word &= word - 1
+		}
+		return w*64 + bits.TrailingZeros64(word), true
+	}
+	return 0, false
+}
+
+// Next returns the smallest prime greater than n, and false if there isn't one in the set
+func (ps *PrimeSet) Next(n int) (int, bool) {
+	n++
+	if n < 0 {
+		n = 0
+	}
+	if n > ps.max {
+		return 0, false
+	}
+	// Mask off the bits below n in the first word, then skip forward over empty words
+	w := n / 64
+	word := ps.words[w] &^ (1<<(n%64) - 1)
+	for word == 0 {
+		w++
+		if w == len(ps.words) {
+			return 0, false
+		}
+		word = ps.words[w]
+	}
+	return w*64 + bits.TrailingZeros64(word), true
+}
+
+// Prev returns the largest prime less than n, and false if there isn't one in the set
+func (ps *PrimeSet) Prev(n int) (int, bool) {
+	n--
+	if n < 2 {
+		return 0, false
+	}
+	if n > ps.max {
+		n = ps.max
+	}
+	// Mask off the bits above n in the first word, then skip backwards over empty words
+	w := n / 64
+	word := ps.words[w] & (uint64(1)<<(n%64+1) - 1)
+	for word == 0 {
+		w--
+		if w < 0 {
+			return 0, false
+		}
+		word = ps.words[w]
+	}
+	return w*64 + 63 - bits.LeadingZeros64(word), true
+}
+
+// All returns an iterator over the primes in the set in ascending order, for use with range
+func (ps *PrimeSet) All() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for w, word := range ps.words {
+			for word != 0 {
+				if !yield(w*64 + bits.TrailingZeros64(word)) {
+					return
+				}
+				word &= word - 1
+			}
+		}
+	}
+}
+
+// Slice returns the primes in the set as a slice
+func (ps *PrimeSet) Slice() []int {
+	res := make([]int, 0, ps.count)
+	for p := range ps.All() {
+		res = append(res, p)
+	}
+	return res
+}
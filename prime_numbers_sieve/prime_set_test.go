@@ -0,0 +1,114 @@
+// Prime set test routines
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// trialDivision returns the primes up to max by testing each number against the primes before it, a
+// slow but independent check on the sieve
+func trialDivision(max int) []int {
+	var primes []int
+NextNumber:
+	for n := 2; n <= max; n++ {
+		for _, p := range primes {
+			if p*p > n {
+				break
+			}
+			if n%p == 0 {
+				continue NextNumber
+			}
+		}
+		primes = append(primes, n)
+	}
+	return primes
+}
+
+func TestPrimeSetAgainstTrialDivision(t *testing.T) {
+	// Cover sizes either side of the word and block boundaries
+	tests := []int{0, 1, 2, 3, 63, 64, 65, 511, 512, 513, 1000, 4096, 10007, 100000}
+	for _, max := range tests {
+		testName := fmt.Sprintf("Max: %d", max)
+		t.Run(testName, func(t *testing.T) {
+			want := trialDivision(max)
+			ps := Sieve(max)
+			got := ps.Slice()
+			if len(got) != len(want) || ps.Len() != len(want) {
+				t.Fatalf("got %d primes [len %d] instead of %d", len(got), ps.Len(), len(want))
+			}
+			isPrime := make(map[int]bool)
+			for i, p := range want {
+				isPrime[p] = true
+				if got[i] != p {
+					t.Fatalf("prime %d was %d instead of %d", i, got[i], p)
+				}
+				if s, ok := ps.Select(i + 1); !ok || s != p {
+					t.Errorf("Select(%d) gave %d [%v] instead of %d", i+1, s, ok, p)
+				}
+			}
+			rank := 0
+			for n := -1; n <= max+1; n++ {
+				if isPrime[n] {
+					rank++
+				}
+				if ps.Contains(n) != isPrime[n] {
+					t.Errorf("Contains(%d) gave %v", n, ps.Contains(n))
+				}
+				if ps.Rank(n) != rank {
+					t.Errorf("Rank(%d) gave %d instead of %d", n, ps.Rank(n), rank)
+				}
+			}
+			if _, ok := ps.Select(len(want) + 1); ok {
+				t.Errorf("Select past the last prime should fail")
+			}
+		})
+	}
+}
+
+func TestPrimeSetNextPrev(t *testing.T) {
+	ps := Sieve(200)
+	tests := []struct {
+		n                    int
+		next, prev           int
+		nextFound, prevFound bool
+	}{
+		{-5, 2, 0, true, false},
+		{0, 2, 0, true, false},
+		{2, 3, 0, true, false},
+		{3, 5, 2, true, true},
+		{24, 29, 23, true, true},
+		{127, 131, 113, true, true},
+		{128, 131, 127, true, true},
+		{197, 199, 193, true, true},
+		{199, 0, 197, false, true},
+		{500, 0, 199, false, true},
+	}
+	for _, tt := range tests {
+		testName := fmt.Sprintf("n: %d", tt.n)
+		t.Run(testName, func(t *testing.T) {
+			next, ok := ps.Next(tt.n)
+			if next != tt.next || ok != tt.nextFound {
+				t.Errorf("Next gave %d [%v] instead of %d", next, ok, tt.next)
+			}
+			prev, ok := ps.Prev(tt.n)
+			if prev != tt.prev || ok != tt.prevFound {
+				t.Errorf("Prev gave %d [%v] instead of %d", prev, ok, tt.prev)
+			}
+		})
+	}
+}
+
+func TestPrimeSetAll(t *testing.T) {
+	var got []int
+	for p := range Sieve(1000).All() {
+		if p > 20 {
+			break
+		}
+		got = append(got, p)
+	}
+	want := []int{2, 3, 5, 7, 11, 13, 17, 19}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v instead of %v", got, want)
+	}
+}
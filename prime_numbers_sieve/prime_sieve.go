@@ -4,36 +4,63 @@
 package main
 
 import (
+	"crypto/rand"
 	"flag"
 	"fmt"
+	"io"
+	"math/big"
+	"os"
 	"time"
 )
 
-func Sieve(max int) []int {
-	// Initialize this as an array of uint8's to save memory and save a loop
-	// needed to flip the array, this will be initialized as everything 0
-	intArray := make([]uint8, max+1)
+// Sieve finds every prime up to max and returns them as a PrimeSet
+func Sieve(max int) *PrimeSet {
+	if max < 0 {
+		max = 0
+	}
+	// Initialize this as a bitmap, one bit per number, to save memory.  A set bit marks a composite
+	// number, and everything starts out as 0
+	composite := make([]uint64, max/64+1)
 
 	// Start with 2, and keep going until the number being tested squared is greater than the maximum number
 	for p := 2; p*p <= max; p++ {
-		// Check if the array position referenced by the prime we are testing is unchanged, if it is,
-		// the number is a prime number
-		if intArray[p] == 0 {
-			// Change all multiples of the number to state that they arent prime numbers
-			for i := p * 2; i <= max; i += p {
-				intArray[i] = 1
+		// Check if the bit referenced by the prime we are testing is unchanged, if it is, the number is a
+		// prime number
+		if composite[p/64]&(1<<(p%64)) == 0 {
+			// Change all multiples of the number to state that they arent prime numbers, anything below p*p
+			// has already been marked by a smaller prime
+			for i := p * p; i <= max; i += p {
+				composite[i/64] |= 1 << (i % 64)
 			}
 		}
 	}
-	// Iterate through the array adding anything that is a prime to our results array
-	var primes []int
-	for p := 2; p <= max; p++ {
-		if intArray[p] == 0 {
-			primes = append(primes, p)
-		}
+	// Hand the bitmap over to the PrimeSet, which flips it so that the set bits are the primes
+	return newPrimeSet(max, composite)
+}
+
+// generatePrime generates and prints a single probable or safe prime for the -prime-bits flag
+func generatePrime(bits int, safe bool, seed uint64, progress bool) {
+	var src io.Reader = rand.Reader
+	if seed != 0 {
+		src = NewSeededReader(seed)
+	}
+	g := &Generator{Rand: src}
+	if progress {
+		g.Progress = os.Stderr
+	}
+
+	var p *big.Int
+	var err error
+	if safe {
+		p, err = g.SafePrime(bits)
+	} else {
+		p, err = g.ProbablePrime(bits)
+	}
+	if err != nil {
+		fmt.Printf("Error generating prime: %v\n", err)
+		return
 	}
-	// Return the resulting array
-	return primes
+	fmt.Printf("Generated %d bit prime:\n%s\n0x%X\n", p.BitLen(), p.String(), p)
 }
 
 func main() {
@@ -42,8 +69,53 @@ func main() {
 	Maximum := flag.Int("max", 4000, "Maximum number in range to search for primes")
 	DumpPrimes := flag.Bool("dump-prime", false, "Dump the list of prime numbers located")
 	TimeExecution := flag.Bool("timing", false, "Dump the execution and prime the results")
+	PrimeBits := flag.Int("prime-bits", 0, "Generate a random probable prime of this many bits instead of sieving")
+	SafePrime := flag.Bool("safe", false, "When generating a prime, generate a safe prime (p = 2q+1)")
+	Seed := flag.Uint64("seed", 0, "Seed for a deterministic random source when generating primes (0 uses crypto/rand)")
+	Progress := flag.Bool("progress", false, "Show progress while generating primes")
+	Modular := flag.String("mod", "", "Run a modular arithmetic operation on the remaining arguments (-mod help for a list)")
+	PNGFile := flag.String("png", "", "Render the primes as an image and write it to this PNG file")
+	Layout := flag.String("layout", "ulam", "Image layout to use with -png: ulam, sacks or rows")
+	ImageSize := flag.Int("size", 512, "Width and height in pixels of the image written with -png")
+	ColourGaps := flag.Bool("colour-gaps", false, "Colour each prime in the image by the gap to the previous prime")
+	Bench := flag.Bool("bench", false, "Benchmark the prime algorithms over a range of sizes instead of sieving")
+	BenchOpts := RegisterBenchFlags(flag.CommandLine, 1<<10, 1<<20)
 	flag.Parse()
 
+	// Benchmarking runs every algorithm over its own range of sizes
+	if *Bench {
+		runBench(BenchOpts)
+		return
+	}
+
+	// Rendering an image works out how many primes it needs from the image size, so we skip the maximum
+	if *PNGFile != "" {
+		opts := ImageOptions{Layout: *Layout, Size: *ImageSize, ColourGaps: *ColourGaps}
+		if err := writePrimePNG(*PNGFile, opts); err != nil {
+			fmt.Printf("Error writing image: %v\n", err)
+			return
+		}
+		fmt.Printf("Wrote %dx%d %s image of primes to %s\n", *ImageSize, *ImageSize, *Layout, *PNGFile)
+		return
+	}
+
+	// The modular arithmetic toolkit takes its arguments from whatever is left on the command line
+	if *Modular != "" {
+		res, err := runModular(*Modular, flag.Args())
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("%s\n", res)
+		return
+	}
+
+	// Prime generation is a separate mode and doesn't need the sieve maximum at all
+	if *PrimeBits > 0 {
+		generatePrime(*PrimeBits, *SafePrime, *Seed, *Progress)
+		return
+	}
+
 	// Note: flags are always pointers, so we have to de-reference them, hence the asterix
 	if *Maximum < 2 {
 		fmt.Printf("Maximum must be a number larger than 1\n")
@@ -63,17 +135,19 @@ func main() {
 	if *TimeExecution {
 		fmt.Printf("Took us %s to find all primes in a range of %d numbers\n", time.Since(StartTime), *Maximum-2)
 	}
-	fmt.Printf("Found %d prime numbers between 2 and %d\n", len(results), *Maximum)
+	fmt.Printf("Found %d prime numbers between 2 and %d\n", results.Len(), *Maximum)
 
 	// If our dump flag is set - dump the list of located prime numbers
 	if *DumpPrimes {
 		fmt.Printf("Located the following prime numbers in the range 2 -> %d\n", *Maximum)
-		for i, p := range results {
+		i := 0
+		for p := range results.All() {
 			// \t is an escape code for a tab
 			// \n is a new line character
 			// %d tells us that the variable or constant being referenced is an integer
 			// If we wanted to print a float, that would be a %f - more about number formatting later
 			fmt.Printf("\t[%d]: %d\n", i, p)
+			i++
 		}
 	}
 }
@@ -35,7 +35,7 @@ func TestSieve(t *testing.T) {
 	for _, tt := range tests {
 		testName := fmt.Sprintf("Max: %2d", tt.a)
 		t.Run(testName, func(t *testing.T) {
-			ans := Sieve(tt.a)
+			ans := Sieve(tt.a).Slice()
 			if len(ans) != len(tt.want) {
 				t.Errorf("got %v instead of %v", ans, tt.want)
 			}